@@ -11,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -21,6 +22,7 @@ const SecretKey = "federated_secret_2024"
 // Metadata carries everything the server needs to evaluate a hospital's update.
 type Metadata struct {
 	HospitalID   string  `json:"hospital_id"`
+	FederationID string  `json:"federation_id,omitempty"` // "" means the server's default federation
 	DataSize     int     `json:"data_size"`
 	Loss         float64 `json:"loss"`
 	RoundID      int     `json:"round_id"`
@@ -47,6 +49,46 @@ func signPacket(p *UpdatePacket) {
 type GlobalModelResponse struct {
 	Weights      []float64 `json:"weights"`
 	ModelVersion int       `json:"model_version"`
+	ProxMu       float64   `json:"prox_mu"`
+}
+
+// PartitionReport is the subset of step-01's partition_report.json the
+// simulator needs: which hospitals exist and how much data each holds.
+type PartitionReport struct {
+	Mode  string `json:"mode"`
+	Parts []struct {
+		ID   string `json:"id"`
+		Rows int    `json:"rows"`
+	} `json:"parts"`
+}
+
+// simHospital is one simulated hospital: its ID and local dataset size.
+type simHospital struct {
+	ID       string
+	DataSize int
+}
+
+// loadHospitals returns the three demonstration hospitals, or one hospital per
+// partition when dir holds a partition_report.json written by step-01's
+// cmd/partition tool.
+func loadHospitals(dir string) ([]simHospital, error) {
+	if dir == "" {
+		return []simHospital{{"H1", 100}, {"H2", 200}, {"H3", 300}}, nil
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "partition_report.json"))
+	if err != nil {
+		return nil, err
+	}
+	var report PartitionReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("parse partition report: %w", err)
+	}
+	var hospitals []simHospital
+	for _, p := range report.Parts {
+		hospitals = append(hospitals, simHospital{ID: p.ID, DataSize: p.Rows})
+	}
+	log.Printf("[partitions] %d hospitals from %s (%s split)", len(hospitals), dir, report.Mode)
+	return hospitals, nil
 }
 
 // LocalClientState tracks the simulated client's current model knowledge.
@@ -54,13 +96,61 @@ type GlobalModelResponse struct {
 type LocalClientState struct {
 	ModelVersion int
 	Weights      []float64
+	ProxMu       float64 // FedProx mu the server asked hospitals to train with
+}
+
+// bearerToken authenticates reads from a server started with a token file
+// (-token); "" sends none.
+var bearerToken string
+
+// hospitalTokens maps a hospital ID to its principal's bearer token
+// (-hospital-tokens). A server with a token file accepts a hospital's
+// updates only under that hospital's own token.
+var hospitalTokens map[string]string
+
+// loadHospitalTokens reads a JSON object of hospital ID to token.
+func loadHospitalTokens(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, fmt.Errorf("parse hospital tokens %s: %w", path, err)
+	}
+	return tokens, nil
+}
+
+// postUpdate sends a signed packet to /submit_update under its hospital's token.
+func postUpdate(baseURL string, packet UpdatePacket) (*http.Response, error) {
+	body, _ := json.Marshal(packet)
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/submit_update", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := hospitalTokens[packet.Metadata.HospitalID]; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
 }
 
 // fetchGlobalModel calls GET /global_model on the server.
 // Returns (response, true) on success, or (zero value, false) when no model is
 // available yet (404) or any other error occurs.
 func fetchGlobalModel(baseURL string) (GlobalModelResponse, bool) {
-	resp, err := http.Get(baseURL + "/global_model")
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/global_model", nil)
+	if err != nil {
+		log.Printf("[model-sync] ERROR: %v", err)
+		return GlobalModelResponse{}, false
+	}
+	if bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Printf("[model-sync] ERROR: could not reach server: %v", err)
 		return GlobalModelResponse{}, false
@@ -101,14 +191,15 @@ func syncModel(baseURL string, state *LocalClientState) bool {
 			serverModel.ModelVersion, state.ModelVersion)
 		state.ModelVersion = serverModel.ModelVersion
 		state.Weights = serverModel.Weights
+		state.ProxMu = serverModel.ProxMu
 		
 		// Client Model Cache (DS concept)
 		cacheName := fmt.Sprintf("model_v%d.pkl", state.ModelVersion)
 		cacheData, _ := json.Marshal(serverModel)
 		os.WriteFile(cacheName, cacheData, 0644)
 		
-		log.Printf("[model-sync] Local model updated to version %d | weights: %v",
-			state.ModelVersion, state.Weights)
+		log.Printf("[model-sync] Local model updated to version %d | weights: %v | prox_mu: %g",
+			state.ModelVersion, state.Weights, state.ProxMu)
 		return true
 	}
 
@@ -118,9 +209,28 @@ func syncModel(baseURL string, state *LocalClientState) bool {
 
 func main() {
 	serverFlag := flag.String("server", "http://localhost:8080", "Server base URL")
+	federationFlag := flag.String("federation", "", "Federation to join (default: the server's default federation)")
+	partitionsFlag := flag.String("partitions", "", "Partition directory from step-01/cmd/partition (default: 3 demo hospitals)")
+	tokenFlag := flag.String("token", os.Getenv("FL_TOKEN"), "Bearer token for servers started with -tokens (a principal of the federation)")
+	hospitalTokensFlag := flag.String("hospital-tokens", "", "JSON object of hospital ID to that hospital's bearer token, sent with its updates")
 	flag.Parse()
+	bearerToken = *tokenFlag
+	tokens, err := loadHospitalTokens(*hospitalTokensFlag)
+	if err != nil {
+		log.Fatalf("[tokens] %v", err)
+	}
+	hospitalTokens = tokens
 
+	// Each federation is served under /federations/{id}; the default one is
+	// also served unprefixed, which keeps older servers working.
 	baseURL := *serverFlag
+	if *federationFlag != "" {
+		baseURL += "/federations/" + *federationFlag
+	}
+	hospitals, err := loadHospitals(*partitionsFlag)
+	if err != nil {
+		log.Fatalf("[partitions] %v", err)
+	}
 	fmt.Printf("=== Federated Client Simulator (Connecting to: %s) ===\n", baseURL)
 
 	// Initialise local state. ModelVersion -1 signals "never synced from server".
@@ -146,7 +256,8 @@ func main() {
 	// ── Submit hospital updates ─────────────────────────────────────
 	fmt.Printf("\nSubmitting round %d updates (model_version=%d)...\n", roundID, roundID)
 
-	for i := 1; i <= 3; i++ {
+	for n, h := range hospitals {
+		i := n + 1
 		// Use downloaded global weights as the base if available; otherwise fall
 		// back to hard-coded demonstration values so the simulator runs standalone.
 		var weights []float64
@@ -163,8 +274,9 @@ func main() {
 		packet := UpdatePacket{
 			Weights: weights,
 			Metadata: Metadata{
-				HospitalID:   fmt.Sprintf("H%d", i),
-				DataSize:     100 * i,
+				HospitalID:   h.ID,
+				FederationID: *federationFlag,
+				DataSize:     h.DataSize,
 				Loss:         0.5 / float64(i),
 				RoundID:      roundID,
 				ModelVersion: roundID,
@@ -180,16 +292,15 @@ func main() {
 		checkpointData, _ := json.Marshal(packet)
 		os.WriteFile(checkpointName, checkpointData, 0644)
 
-		body, _ := json.Marshal(packet)
-		resp, err := http.Post(baseURL+"/submit_update", "application/json", bytes.NewBuffer(body))
+		resp, err := postUpdate(baseURL, packet)
 		if err != nil {
 			log.Printf("[submit] ERROR reaching server: %v", err)
 			return
 		}
 		respBody, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		fmt.Printf("[submit] H%d — weights: %v | model_version: %d | HTTP %d | %s",
-			i, weights, roundID, resp.StatusCode, string(respBody))
+		fmt.Printf("[submit] %s — weights: %v | model_version: %d | HTTP %d | %s",
+			h.ID, weights, roundID, resp.StatusCode, string(respBody))
 	}
 
 	// ── Wait for aggregation, then pull the new global model ─────────
@@ -0,0 +1,767 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The admin API lets people run the federation without restarting the server.
+// Callers authenticate with "Authorization: Bearer <token>"; each token maps
+// to a principal with one role:
+//
+//	operator  change settings, pause/resume/abort rounds, approve enrollment, roll back
+//	auditor   read settings, enrollment and the audit log
+//	hospital  request enrollment and submit updates, statistics and mask keys
+//	          for its own hospital ID
+//
+// A principal may be limited to some federations; it is refused everywhere
+// else. Every admin action, including refused ones, is written to the audit
+// log with the principal's name as Actor.
+
+// Roles.
+const (
+	RoleOperator = "operator"
+	RoleAuditor  = "auditor"
+	RoleHospital = "hospital"
+)
+
+// Principal is one entry of the token file. Token is the bearer token in
+// clear; TokenSHA256 is its hex SHA-256, so the file need not hold secrets.
+//
+//	[
+//	  {"name": "alice", "role": "operator", "token_sha256": "9f86d0…"},
+//	  {"name": "h1-bot", "role": "hospital", "hospital_id": "H1", "token": "h1-secret", "federations": ["cardiac"]}
+//	]
+type Principal struct {
+	Name        string   `json:"name"`
+	Role        string   `json:"role"`
+	HospitalID  string   `json:"hospital_id,omitempty"` // required for the hospital role
+	Token       string   `json:"token,omitempty"`
+	TokenSHA256 string   `json:"token_sha256,omitempty"`
+	Federations []string `json:"federations,omitempty"` // empty = every federation
+}
+
+// mayAccess reports whether the principal may act on federation id.
+func (p Principal) mayAccess(id string) bool {
+	return len(p.Federations) == 0 || contains(p.Federations, id)
+}
+
+// actsFor reports whether the principal may submit as hospitalID. Without a
+// token file handlers get the zero Principal, which acts for any hospital:
+// the packet's signature is then the only check.
+func (p Principal) actsFor(hospitalID string) bool {
+	return p.Role == "" || p.HospitalID == hospitalID
+}
+
+var (
+	adminMu sync.Mutex
+	// adminTokens maps SHA-256(token) to its principal. Empty disables the admin API.
+	adminTokens map[string]Principal
+)
+
+// Enrollment states.
+const (
+	EnrollPending  = "pending"
+	EnrollApproved = "approved"
+)
+
+// loadTokens reads a token file and installs it.
+func loadTokens(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read tokens: %w", err)
+	}
+	var principals []Principal
+	if err := json.Unmarshal(b, &principals); err != nil {
+		return fmt.Errorf("parse tokens %s: %w", path, err)
+	}
+	tokens := make(map[string]Principal, len(principals))
+	for i, p := range principals {
+		if p.Name == "" {
+			return fmt.Errorf("tokens %s: entry %d has no name", path, i)
+		}
+		switch p.Role {
+		case RoleOperator, RoleAuditor:
+		case RoleHospital:
+			if p.HospitalID == "" {
+				return fmt.Errorf("tokens %s: hospital principal %q needs hospital_id", path, p.Name)
+			}
+		default:
+			return fmt.Errorf("tokens %s: principal %q has unknown role %q", path, p.Name, p.Role)
+		}
+		hash := strings.ToLower(p.TokenSHA256)
+		if p.Token != "" {
+			hash = tokenHash(p.Token)
+		}
+		if len(hash) != 64 {
+			return fmt.Errorf("tokens %s: principal %q needs token or a 64-digit token_sha256", path, p.Name)
+		}
+		if _, dup := tokens[hash]; dup {
+			return fmt.Errorf("tokens %s: principal %q reuses another principal's token", path, p.Name)
+		}
+		p.Token = ""
+		tokens[hash] = p
+	}
+
+	adminMu.Lock()
+	adminTokens = tokens
+	adminMu.Unlock()
+	log.Printf("[admin] Loaded %d principals from %s", len(tokens), path)
+	return nil
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate returns the principal for the request's bearer token.
+func authenticate(r *http.Request) (Principal, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Principal{}, false
+	}
+	hash := tokenHash(token)
+
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	for stored, p := range adminTokens {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			return p, true
+		}
+	}
+	return Principal{}, false
+}
+
+// Requests without a valid token are audited once per source, federation and
+// path in each authRefusalInterval: the first is written at once, and the
+// rest are counted and written as one entry when the interval ends (see
+// flushDue, run by the server every authRefusalFlush, and flushAll at
+// shutdown). A caller with no token cannot make the server fsync an audit
+// entry per request; authenticated refusals are still audited one by one.
+const (
+	authRefusalInterval = time.Minute
+	authRefusalFlush    = 10 * time.Second
+	maxRefusalSources   = 1024 // past this many counters in an interval, further sources share "other"
+)
+
+// authRefusals counts unauthenticated requests per refusalKey.
+type authRefusals struct {
+	mu       sync.Mutex
+	clock    Clock
+	counters map[refusalKey]*refusalCount
+}
+
+// refusalKey is what an unauthenticated caller attempted: from where, on
+// which federation ("" for server-wide endpoints) and which path.
+type refusalKey struct {
+	source     string
+	federation string
+	action     string
+}
+
+// refusalCount is one key's refusals since its last audit entry.
+type refusalCount struct {
+	f        *Federation
+	since    time.Time
+	repeated int // refusals not yet written
+}
+
+func newAuthRefusals(clock Clock) *authRefusals {
+	return &authRefusals{clock: clock, counters: make(map[refusalKey]*refusalCount)}
+}
+
+var refusals = newAuthRefusals(systemClock{})
+
+// record notes an unauthenticated request to f. The first for its key in an
+// interval is audited at once; later ones are only counted.
+func (a *authRefusals) record(f *Federation, r *http.Request) {
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	key := refusalKey{source: source, action: r.URL.Path}
+	if f != nil {
+		key.federation = f.ID
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.flush(false)
+	if _, seen := a.counters[key]; !seen && len(a.counters) >= maxRefusalSources {
+		key.source = "other"
+	}
+	if c, seen := a.counters[key]; seen {
+		c.repeated++
+		return
+	}
+	a.counters[key] = &refusalCount{f: f, since: a.clock.Now()}
+	auditAdmin(f, Principal{}, key.action, "", "unauthenticated", map[string]interface{}{"source": key.source})
+}
+
+// flushDue writes the merged count of every key whose interval has ended.
+func (a *authRefusals) flushDue() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.flush(false)
+}
+
+// flushAll writes every pending count, ended interval or not; the server
+// calls it at shutdown.
+func (a *authRefusals) flushAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.flush(true)
+}
+
+// flushEvery runs flushDue every interval until stop is closed.
+func (a *authRefusals) flushEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flushDue()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// flush writes and forgets the counters whose interval has ended, or all of
+// them. The caller holds a.mu.
+func (a *authRefusals) flush(all bool) {
+	now := a.clock.Now()
+	for key, c := range a.counters {
+		if !all && now.Sub(c.since) < authRefusalInterval {
+			continue
+		}
+		if c.repeated > 0 {
+			auditAdmin(c.f, Principal{}, key.action, "", fmt.Sprintf("%d more unauthenticated requests", c.repeated),
+				map[string]interface{}{"source": key.source, "since": c.since.UTC(), "repeated": c.repeated})
+		}
+		delete(a.counters, key)
+	}
+}
+
+// requireRole wraps a handler so only principals holding one of roles, and
+// with access to federation f, reach it. f is nil for server-wide endpoints.
+// Refusals are audited, those without a valid token merged per source (see
+// authRefusals); without a token file the admin API is disabled.
+func requireRole(f *Federation, handler func(http.ResponseWriter, *http.Request, Principal), roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminEnabled() {
+			http.Error(w, "Admin API disabled: start the server with -tokens", http.StatusServiceUnavailable)
+			return
+		}
+		p, ok := authorise(f, w, r)
+		if !ok {
+			return
+		}
+		for _, role := range roles {
+			if p.Role == role {
+				handler(w, r, p)
+				return
+			}
+		}
+		auditAdmin(f, p, r.URL.Path, "", "forbidden for role "+p.Role, nil)
+		http.Error(w, fmt.Sprintf("Role %s may not use %s", p.Role, r.URL.Path), http.StatusForbidden)
+	}
+}
+
+// requireReader guards a federation's read endpoints. Without a token file
+// they stay open; with one, any principal with access to f may read them, so
+// one tenant cannot read another's models, contributions or data quality.
+func requireReader(f *Federation, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminEnabled() {
+			handler(w, r)
+			return
+		}
+		if _, ok := authorise(f, w, r); ok {
+			handler(w, r)
+		}
+	}
+}
+
+// requireHospital guards endpoints hospitals write to. GETs are reads
+// (requireReader); any other method needs a hospital principal of f once a
+// token file is configured, and the handler checks that what it writes is
+// the principal's own (Principal.actsFor). Without a token file both stay
+// open and the handler gets the zero Principal.
+func requireHospital(f *Federation, handler func(http.ResponseWriter, *http.Request, Principal)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminEnabled() {
+			handler(w, r, Principal{})
+			return
+		}
+		p, ok := authorise(f, w, r)
+		if !ok {
+			return
+		}
+		if r.Method != http.MethodGet && p.Role != RoleHospital {
+			auditAdmin(f, p, r.URL.Path, "", "forbidden for role "+p.Role, nil)
+			http.Error(w, fmt.Sprintf("Role %s may not use %s", p.Role, r.URL.Path), http.StatusForbidden)
+			return
+		}
+		handler(w, r, p)
+	}
+}
+
+// adminEnabled reports whether a token file has been loaded.
+func adminEnabled() bool {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	return len(adminTokens) > 0
+}
+
+// authorise authenticates the request and checks the principal may access f
+// (nil for server-wide endpoints). On refusal it audits, answers the request
+// and returns false.
+func authorise(f *Federation, w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	p, ok := authenticate(r)
+	if !ok {
+		refusals.record(f, r)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="federation"`)
+		http.Error(w, "Missing or invalid bearer token", http.StatusUnauthorized)
+		return p, false
+	}
+	if f != nil && !p.mayAccess(f.ID) {
+		auditAdmin(f, p, r.URL.Path, "", "no access to federation "+f.ID, nil)
+		http.Error(w, fmt.Sprintf("%s has no access to federation %s", p.Name, f.ID), http.StatusForbidden)
+		return p, false
+	}
+	return p, true
+}
+
+// auditAdmin records one admin action on federation f (nil for server-wide
+// endpoints). reason is empty for successful actions.
+func auditAdmin(f *Federation, p Principal, action, hospitalID, reason string, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["action"] = action
+	e := AuditEntry{
+		Event:      AuditAdmin,
+		Actor:      actorName(p),
+		HospitalID: hospitalID,
+		Reason:     reason,
+	}
+	if f != nil {
+		e.Federation = f.ID
+		e.Round, _, _, _ = f.roundManager.Status()
+		f.aggregationMutex.Lock()
+		e.Version = f.currentVersion
+		f.aggregationMutex.Unlock()
+	}
+	recordAudit(e, details)
+}
+
+func actorName(p Principal) string {
+	if p.Name == "" {
+		return "anonymous"
+	}
+	return p.Role + ":" + p.Name
+}
+
+// writeJSON sends v as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleWhoAmI serves GET /admin/whoami.
+func handleWhoAmI(w http.ResponseWriter, r *http.Request, p Principal) {
+	writeJSON(w, map[string]interface{}{"name": p.Name, "role": p.Role, "hospital_id": p.HospitalID, "federations": p.Federations})
+}
+
+// currentSettings returns the settings operators can change at runtime.
+func (f *Federation) currentSettings() map[string]interface{} {
+	round, expected, received, state := f.roundManager.Status()
+	f.mu.Lock()
+	q := f.qParam
+	schedule := f.qSchedule.Type
+	f.mu.Unlock()
+	if schedule == "" {
+		schedule = QConstant
+	}
+	f.aggregationMutex.Lock()
+	prox := f.proxMu
+	version := f.currentVersion
+	f.aggregationMutex.Unlock()
+	f.accessMu.Lock()
+	enforced := f.requireEnrollment
+	f.accessMu.Unlock()
+	return map[string]interface{}{
+		"federation":         f.ID,
+		"quorum":             expected,
+		"q":                  q,
+		"q_schedule":         schedule,
+		"prox_mu":            prox,
+		"require_enrollment": enforced,
+		"paused":             f.roundManager.IsPaused(),
+		"current_round":      round,
+		"received_clients":   received,
+		"state":              state.String(),
+		"model_version":      version,
+	}
+}
+
+// settingsChange is the body of POST /admin/settings. Absent fields are unchanged.
+type settingsChange struct {
+	Quorum            *int     `json:"quorum"`
+	Q                 *float64 `json:"q"`
+	ProxMu            *float64 `json:"prox_mu"`
+	RequireEnrollment *bool    `json:"require_enrollment"`
+}
+
+// handleAdminSettings serves GET /admin/settings (operator, auditor) and
+// POST /admin/settings (operator only).
+func (f *Federation) handleAdminSettings(w http.ResponseWriter, r *http.Request, p Principal) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, f.currentSettings())
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p.Role != RoleOperator {
+		auditAdmin(f, p, "settings", "", "forbidden for role "+p.Role, nil)
+		http.Error(w, "Only operators may change settings", http.StatusForbidden)
+		return
+	}
+
+	var c settingsChange
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case c.Quorum != nil && *c.Quorum < 1:
+		http.Error(w, "quorum must be at least 1", http.StatusBadRequest)
+		return
+	case c.Q != nil && *c.Q < 0:
+		http.Error(w, "q must be non-negative", http.StatusBadRequest)
+		return
+	case c.ProxMu != nil && *c.ProxMu < 0:
+		http.Error(w, "prox_mu must be non-negative", http.StatusBadRequest)
+		return
+	}
+
+	before := f.currentSettings()
+	changes := map[string]interface{}{}
+	if c.Q != nil {
+		f.mu.Lock()
+		f.qParam = *c.Q
+		f.qBase, f.qStep = *c.Q, 0 // a schedule restarts from the operator's q
+		f.mu.Unlock()
+		changes["q"] = []interface{}{before["q"], *c.Q}
+	}
+	if c.ProxMu != nil {
+		f.aggregationMutex.Lock()
+		f.proxMu = *c.ProxMu
+		f.aggregationMutex.Unlock()
+		changes["prox_mu"] = []interface{}{before["prox_mu"], *c.ProxMu}
+	}
+	if c.RequireEnrollment != nil {
+		f.accessMu.Lock()
+		f.requireEnrollment = *c.RequireEnrollment
+		f.accessMu.Unlock()
+		changes["require_enrollment"] = []interface{}{before["require_enrollment"], *c.RequireEnrollment}
+	}
+	quorumMet := false
+	if c.Quorum != nil {
+		quorumMet = f.roundManager.SetQuorum(*c.Quorum)
+		changes["quorum"] = []interface{}{before["quorum"], *c.Quorum}
+	}
+	log.Printf("[admin] %s changed %s settings: %v", actorName(p), f.ID, changes)
+	auditAdmin(f, p, "settings", "", "", map[string]interface{}{"changes": changes})
+
+	// Lowering the quorum may complete the round immediately.
+	if quorumMet {
+		go f.aggregateUpdates()
+	}
+	writeJSON(w, f.currentSettings())
+}
+
+// handleRoundControl serves POST /admin/round/{pause,resume,abort,extend}.
+// extend takes {"seconds": N} and moves the current round's timeout back.
+func (f *Federation) handleRoundControl(w http.ResponseWriter, r *http.Request, p Principal) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	action := path.Base(r.URL.Path)
+	details := map[string]interface{}{}
+	switch action {
+	case "pause":
+		f.roundManager.SetPaused(true)
+	case "resume":
+		f.roundManager.SetPaused(false)
+	case "abort":
+		details["discarded"] = f.abortRound()
+	case "extend":
+		var req struct {
+			Seconds float64 `json:"seconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !(req.Seconds > 0) {
+			auditAdmin(f, p, "round_extend", "", "invalid request", nil)
+			http.Error(w, `Body must be {"seconds": N} with N > 0`, http.StatusBadRequest)
+			return
+		}
+		details["seconds"] = req.Seconds
+		details["timeout_in_s"] = f.roundManager.Extend(secondsToDuration(req.Seconds)).Seconds()
+	default:
+		http.Error(w, "Unknown round action "+action, http.StatusNotFound)
+		return
+	}
+	log.Printf("[admin] %s: %s round %s", actorName(p), f.ID, action)
+	auditAdmin(f, p, "round_"+action, "", "", details)
+	writeJSON(w, f.currentSettings())
+}
+
+// abortRound drops the buffered updates of the current round and restarts it.
+// It returns the hospitals whose updates were discarded.
+func (f *Federation) abortRound() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.abortRoundLocked("abort")
+}
+
+// abortRoundLocked is abortRound for callers holding f.mu; reason is
+// journaled with the discarded packets.
+func (f *Federation) abortRoundLocked(reason string) []string {
+	discarded := f.discardBufferedLocked(reason)
+	round, _, _, _ := f.roundManager.Status()
+	age := f.roundManager.RoundAge()
+	f.roundManager.Abort()
+	now := time.Now().UTC()
+	f.recordRound(RoundRecord{Round: round, Outcome: OutcomeAborted, Started: now.Add(-age), Closed: now, Discarded: discarded})
+	return discarded
+}
+
+// discardBufferedLocked empties f.receivedUpdates, journaling the discarded
+// packets so replay does not count them as pending. Caller holds f.mu.
+func (f *Federation) discardBufferedLocked(reason string) []string {
+	var hospitals, hashes []string
+	for _, p := range f.receivedUpdates {
+		hospitals = append(hospitals, p.Metadata.HospitalID)
+		if hash, _, err := packetHash(p); err == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+	f.receivedUpdates = nil
+	if len(hashes) > 0 {
+		appendJournal(f.journalPath, JournalEntry{Type: JournalDiscard, Reason: reason, Updates: hashes})
+	}
+	return hospitals
+}
+
+// handleEnroll serves POST /admin/enroll: a hospital principal asks to join
+// the federation under its own hospital ID. A body naming another hospital
+// ({"hospital_id": ...}) is refused rather than silently enrolling the caller.
+func (f *Federation) handleEnroll(w http.ResponseWriter, r *http.Request, p Principal) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		HospitalID string `json:"hospital_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Body must be empty or {\"hospital_id\": ...}", http.StatusBadRequest)
+		return
+	}
+	if req.HospitalID != "" && !p.actsFor(req.HospitalID) {
+		auditAdmin(f, p, "enroll_request", req.HospitalID, "principal is hospital "+p.HospitalID, nil)
+		http.Error(w, fmt.Sprintf("%s may not enroll hospital %s", p.Name, req.HospitalID), http.StatusForbidden)
+		return
+	}
+	if !f.isAllowed(p.HospitalID) {
+		auditAdmin(f, p, "enroll_request", p.HospitalID, "not on the allowed list", nil)
+		http.Error(w, fmt.Sprintf("Hospital %s is not allowed in this federation", p.HospitalID), http.StatusForbidden)
+		return
+	}
+	f.accessMu.Lock()
+	status, known := f.enrollment[p.HospitalID]
+	if !known {
+		status = EnrollPending
+		f.enrollment[p.HospitalID] = status
+	}
+	f.accessMu.Unlock()
+	if !known {
+		log.Printf("[admin] Enrollment in %s requested by %s", f.ID, p.HospitalID)
+		auditAdmin(f, p, "enroll_request", p.HospitalID, "", nil)
+	}
+	writeJSON(w, map[string]interface{}{"hospital_id": p.HospitalID, "status": status})
+}
+
+// enrollmentRequest is the body of POST /admin/enrollments.
+type enrollmentRequest struct {
+	HospitalID string `json:"hospital_id"`
+	Action     string `json:"action"` // approve or revoke
+}
+
+// handleEnrollments serves GET /admin/enrollments (operator, auditor) and
+// POST /admin/enrollments {"hospital_id": "H1", "action": "approve"|"revoke"} (operator).
+// Only a hospital whose own principal requested enrollment can be approved,
+// so an approval always belongs to the token that will submit for it.
+func (f *Federation) handleEnrollments(w http.ResponseWriter, r *http.Request, p Principal) {
+	switch r.Method {
+	case http.MethodGet:
+		f.accessMu.Lock()
+		ids := make([]string, 0, len(f.enrollment))
+		for id := range f.enrollment {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		list := make([]map[string]string, len(ids))
+		for i, id := range ids {
+			list[i] = map[string]string{"hospital_id": id, "status": f.enrollment[id]}
+		}
+		enforced := f.requireEnrollment
+		f.accessMu.Unlock()
+		writeJSON(w, map[string]interface{}{"require_enrollment": enforced, "hospitals": list})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p.Role != RoleOperator {
+		auditAdmin(f, p, "enrollment", "", "forbidden for role "+p.Role, nil)
+		http.Error(w, "Only operators may approve enrollment", http.StatusForbidden)
+		return
+	}
+
+	var req enrollmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.HospitalID == "" {
+		http.Error(w, "Body must be {\"hospital_id\": ..., \"action\": \"approve\"|\"revoke\"}", http.StatusBadRequest)
+		return
+	}
+	f.accessMu.Lock()
+	switch req.Action {
+	case "approve":
+		if _, requested := f.enrollment[req.HospitalID]; !requested {
+			f.accessMu.Unlock()
+			auditAdmin(f, p, "enrollment_approve", req.HospitalID, "no enrollment request", nil)
+			http.Error(w, fmt.Sprintf("%s has not requested enrollment; its hospital principal must POST /admin/enroll first", req.HospitalID), http.StatusConflict)
+			return
+		}
+		f.enrollment[req.HospitalID] = EnrollApproved
+	case "revoke":
+		delete(f.enrollment, req.HospitalID)
+	default:
+		f.accessMu.Unlock()
+		http.Error(w, "action must be approve or revoke", http.StatusBadRequest)
+		return
+	}
+	f.accessMu.Unlock()
+	log.Printf("[admin] %s: %s enrollment of %s in %s", actorName(p), req.Action, req.HospitalID, f.ID)
+	auditAdmin(f, p, "enrollment_"+req.Action, req.HospitalID, "", nil)
+	writeJSON(w, map[string]interface{}{"hospital_id": req.HospitalID, "action": req.Action})
+}
+
+// isAllowed reports whether a hospital is on the configured allow-list.
+func (f *Federation) isAllowed(hospitalID string) bool {
+	return f.allowedHospitals == nil || f.allowedHospitals[hospitalID]
+}
+
+// isEnrolled reports whether a hospital may submit updates.
+func (f *Federation) isEnrolled(hospitalID string) bool {
+	f.accessMu.Lock()
+	defer f.accessMu.Unlock()
+	return !f.requireEnrollment || f.enrollment[hospitalID] == EnrollApproved
+}
+
+// handleRollback serves POST /admin/rollback {"version": N}. The weights of
+// snapshot N are republished as a new version (current + 1), so versions stay
+// monotonic for hospitals, the journal and the audit log. Updates buffered for
+// the current round were trained on the rejected model and are discarded.
+func (f *Federation) handleRollback(w http.ResponseWriter, r *http.Request, p Principal) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Body must be {\"version\": N}", http.StatusBadRequest)
+		return
+	}
+
+	newVersion, discarded, err := f.rollbackTo(req.Version)
+	if err != nil {
+		auditAdmin(f, p, "rollback", "", err.Error(), map[string]interface{}{"to_version": req.Version})
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	round, _, _, _ := f.roundManager.Status()
+	recordAudit(AuditEntry{Event: AuditRollback, Federation: f.ID, Actor: actorName(p), Round: round, Version: newVersion},
+		map[string]interface{}{"restored_version": req.Version, "discarded": discarded})
+	writeJSON(w, map[string]interface{}{
+		"restored_version": req.Version,
+		"model_version":    newVersion,
+		"discarded":        discarded,
+	})
+}
+
+// rollbackTo restores the weights of the version snapshot as version
+// currentVersion+1 and opens a fresh round for it.
+func (f *Federation) rollbackTo(version int) (newVersion int, discarded []string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aggregationMutex.Lock()
+	defer f.aggregationMutex.Unlock()
+
+	if version < 1 || version >= f.currentVersion {
+		return 0, nil, fmt.Errorf("can only roll back to an earlier version (1..%d)", f.currentVersion-1)
+	}
+	weights, err := f.readSnapshot(version)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(f.globalWeights) > 0 && len(weights) != len(f.globalWeights) {
+		return 0, nil, fmt.Errorf("snapshot of version %d has %d parameters, model has %d", version, len(weights), len(f.globalWeights))
+	}
+
+	discarded = f.discardBufferedLocked("rollback")
+	f.globalWeights = weights
+	f.currentVersion++
+	round, _, _, _ := f.roundManager.Status()
+	appendJournal(f.journalPath, JournalEntry{
+		Type: JournalRollback, Round: round, Version: f.currentVersion,
+		BaseVersion: version, ModelHash: modelHash(f.globalWeights),
+	})
+	snapshotData, _ := json.Marshal(map[string]interface{}{
+		"weights": f.globalWeights,
+		"version": f.currentVersion,
+	})
+	os.WriteFile(f.snapshotPath(f.currentVersion), snapshotData, 0644)
+
+	log.Printf("[admin] %s rolled back to version %d; republished as version %d", f.ID, version, f.currentVersion)
+	age := f.roundManager.RoundAge()
+	now := time.Now().UTC()
+	f.recordRound(RoundRecord{Round: round, Outcome: OutcomeRollback, Version: f.currentVersion, BaseVersion: version,
+		Started: now.Add(-age), Closed: now, Discarded: discarded})
+	f.roundManager.AdvanceRound()
+	return f.currentVersion, discarded, nil
+}
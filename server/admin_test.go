@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupAdmin installs an operator, an auditor and hospitals H1-H4 (tokens
+// "op-token", "audit-token", hospitalToken) and a fresh default federation whose
+// audit log, journal and snapshots live in a temporary directory.
+func setupAdmin(t *testing.T) *Federation {
+	t.Helper()
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+
+	oldAudit, oldSeq, oldHead, oldKey := auditPath, auditSeq, auditHead, auditKey
+	auditPath, auditSeq, auditHead, auditKey = "audit.jsonl", 0, auditGenesis, []byte(testAuditKey)
+	refusals = newAuthRefusals(systemClock{})
+	f := NewFederation(DefaultConfig().DefaultFederation(), StorageConfig{Journal: "journal.jsonl", UpdateLog: "update_log.json", SnapshotDir: dir})
+	registerFederations([]*Federation{f})
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		auditPath, auditSeq, auditHead, auditKey = oldAudit, oldSeq, oldHead, oldKey
+		adminTokens = nil
+		registerFederations(nil)
+	})
+
+	tokens := `[
+	  {"name": "alice", "role": "operator", "token": "op-token"},
+	  {"name": "bob", "role": "auditor", "token_sha256": "` + tokenHash("audit-token") + `"},
+	  {"name": "h1-bot", "role": "hospital", "hospital_id": "H1", "token": "h1-token"},
+	  {"name": "h2-bot", "role": "hospital", "hospital_id": "H2", "token": "h2-token"},
+	  {"name": "h3-bot", "role": "hospital", "hospital_id": "H3", "token": "h3-token"},
+	  {"name": "h4-bot", "role": "hospital", "hospital_id": "H4", "token": "h4-token"}
+	]`
+	os.WriteFile(filepath.Join(dir, "tokens.json"), []byte(tokens), 0644)
+	if err := loadTokens(filepath.Join(dir, "tokens.json")); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// call sends one request through requireRole and returns the recorder.
+func call(handler http.HandlerFunc, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// hospitalToken is the test token of hospital id's principal.
+func hospitalToken(id string) string {
+	return strings.ToLower(id) + "-token"
+}
+
+func TestAdminRolesAndSettings(t *testing.T) {
+	f := setupAdmin(t)
+	settings := requireRole(f, f.handleAdminSettings, RoleOperator, RoleAuditor)
+
+	if rec := call(settings, "GET", "/admin/settings", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: got %d", rec.Code)
+	}
+	if rec := call(settings, "GET", "/admin/settings", "h1-token", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("hospital reading settings: got %d", rec.Code)
+	}
+	if rec := call(settings, "POST", "/admin/settings", "audit-token", `{"q": 2}`); rec.Code != http.StatusForbidden {
+		t.Errorf("auditor changing settings: got %d", rec.Code)
+	}
+	if rec := call(settings, "POST", "/admin/settings", "op-token", `{"quorum": 0}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid quorum: got %d", rec.Code)
+	}
+	rec := call(settings, "POST", "/admin/settings", "op-token", `{"quorum": 2, "q": 0.5}`)
+	if rec.Code != http.StatusOK || f.qParam != 0.5 {
+		t.Fatalf("operator change: %d %s (q=%g)", rec.Code, rec.Body, f.qParam)
+	}
+	if _, expected, _, _ := f.roundManager.Status(); expected != 2 {
+		t.Errorf("quorum = %d, want 2", expected)
+	}
+
+	// Every attempt, allowed or not, is in the audit log under its actor.
+	entries, err := readAudit(auditPath)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("audit: %d entries, %v", len(entries), err)
+	}
+	if entries[0].Actor != "anonymous" || entries[2].Actor != "auditor:bob" || entries[2].Reason == "" {
+		t.Errorf("refusals not audited with actor: %+v", entries[:3])
+	}
+	if last := entries[3]; last.Actor != "operator:alice" || last.Event != AuditAdmin || last.Reason != "" {
+		t.Errorf("settings change audited as %+v", last)
+	}
+}
+
+func TestUnauthenticatedRefusalsAreMergedPerSource(t *testing.T) {
+	f := setupAdmin(t)
+	clock := NewVirtualClock(time.Now())
+	refusals = newAuthRefusals(clock)
+	settings := requireRole(f, f.handleAdminSettings, RoleOperator)
+	from := func(addr, token string) int {
+		req := httptest.NewRequest("GET", "/admin/settings", nil)
+		req.RemoteAddr = addr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		settings(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 50; i++ {
+		if code := from("198.51.100.7:4000", "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("request %d: %d", i, code)
+		}
+	}
+	from("203.0.113.9:5000", "")
+	for i := 0; i < 3; i++ {
+		from("198.51.100.7:4001", "h1-token") // authenticated, wrong role
+	}
+	entries, _ := readAudit(auditPath)
+	if len(entries) != 5 {
+		t.Fatalf("%d audit entries before the interval ended, want 2 unauthenticated + 3 forbidden", len(entries))
+	}
+
+	clock.Advance(authRefusalInterval)
+	from("198.51.100.7:4002", "")
+	entries, _ = readAudit(auditPath)
+	if len(entries) != 7 {
+		t.Fatalf("%d audit entries after the interval, want 7", len(entries))
+	}
+	var merged AuditEntry
+	for _, e := range entries[5:] {
+		if e.Reason != "unauthenticated" {
+			merged = e
+		}
+	}
+	if merged.Reason != "49 more unauthenticated requests" || merged.Actor != "anonymous" {
+		t.Errorf("merged refusals audited as %+v", merged)
+	}
+	if _, err := verifyAudit(auditPath, ""); err != nil {
+		t.Errorf("audit chain: %v", err)
+	}
+}
+
+func TestUnauthenticatedRefusalsFlushWithoutLaterTraffic(t *testing.T) {
+	def, sepsis := setupFederations(t)
+	clock := NewVirtualClock(time.Now())
+	refusals = newAuthRefusals(clock)
+	from := func(f *Federation, path string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "198.51.100.7:4000"
+		refusals.record(f, req)
+	}
+	merged := func() map[string]AuditEntry {
+		entries, _ := readAudit(auditPath)
+		out := map[string]AuditEntry{}
+		for _, e := range entries {
+			if strings.HasSuffix(e.Reason, "more unauthenticated requests") {
+				var d map[string]interface{}
+				json.Unmarshal(e.Details, &d)
+				out[e.Federation+" "+d["action"].(string)] = e
+			}
+		}
+		return out
+	}
+
+	// One source probing two federations and two paths: each keeps its own count.
+	for i := 0; i < 4; i++ {
+		from(def, "/federations/default/admin/settings")
+		from(sepsis, "/federations/sepsis/admin/settings")
+		from(sepsis, "/federations/sepsis/global_model")
+	}
+	clock.Advance(authRefusalInterval / 2)
+	refusals.flushDue()
+	if m := merged(); len(m) != 0 {
+		t.Fatalf("counts written before the interval ended: %v", m)
+	}
+
+	// A burst followed by silence still reaches the log.
+	clock.Advance(authRefusalInterval)
+	refusals.flushDue()
+	m := merged()
+	for _, key := range []string{"default /federations/default/admin/settings", "sepsis /federations/sepsis/admin/settings", "sepsis /federations/sepsis/global_model"} {
+		if m[key].Reason != "3 more unauthenticated requests" {
+			t.Errorf("%s: merged entry %+v", key, m[key])
+		}
+	}
+
+	// Counts pending at shutdown are written by flushAll.
+	from(sepsis, "/federations/sepsis/contributions")
+	from(sepsis, "/federations/sepsis/contributions")
+	refusals.flushAll()
+	if e := merged()["sepsis /federations/sepsis/contributions"]; e.Reason != "1 more unauthenticated requests" {
+		t.Errorf("pending count lost at shutdown: %+v", e)
+	}
+	if _, err := verifyAudit(auditPath, ""); err != nil {
+		t.Errorf("audit chain: %v", err)
+	}
+}
+
+func TestAdminPauseAbortAndEnrollment(t *testing.T) {
+	f := setupAdmin(t)
+	round := requireRole(f, f.handleRoundControl, RoleOperator)
+
+	if ok, _ := f.roundManager.RecordUpdate("H1", 0); !ok {
+		t.Fatal("first update refused")
+	}
+	f.receivedUpdates = []UpdatePacket{{Weights: []float64{1}, Metadata: Metadata{HospitalID: "H1", DataSize: 1}}}
+
+	call(round, "POST", "/admin/round/pause", "op-token", "")
+	if ok, _ := f.roundManager.RecordUpdate("H2", 0); ok {
+		t.Error("update accepted while paused")
+	}
+	call(round, "POST", "/admin/round/resume", "op-token", "")
+	call(round, "POST", "/admin/round/abort", "op-token", "")
+	if _, _, received, _ := f.roundManager.Status(); received != 0 || f.receivedUpdates != nil {
+		t.Errorf("abort kept %d clients / %d updates", received, len(f.receivedUpdates))
+	}
+	if ok, _ := f.roundManager.RecordUpdate("H1", 0); !ok {
+		t.Error("H1 could not resubmit after abort")
+	}
+
+	f.requireEnrollment = true
+	if f.isEnrolled("H1") {
+		t.Fatal("H1 enrolled before approval")
+	}
+	rec := call(requireRole(f, f.handleEnroll, RoleHospital), "POST", "/admin/enroll", "h1-token", "")
+	if !strings.Contains(rec.Body.String(), EnrollPending) {
+		t.Errorf("enroll request: %s", rec.Body)
+	}
+	enrollments := requireRole(f, f.handleEnrollments, RoleOperator, RoleAuditor)
+	if rec := call(enrollments, "POST", "/admin/enrollments", "audit-token", `{"hospital_id": "H1", "action": "approve"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("auditor approving: got %d", rec.Code)
+	}
+	call(enrollments, "POST", "/admin/enrollments", "op-token", `{"hospital_id": "H1", "action": "approve"}`)
+	if !f.isEnrolled("H1") || f.isEnrolled("H2") {
+		t.Error("approval did not admit exactly H1")
+	}
+	if n, err := verifyAudit(auditPath, ""); err != nil || n != 6 {
+		t.Errorf("audit chain: %d entries, %v", n, err)
+	}
+}
+
+func TestEnrollmentBelongsToTheHospitalsPrincipal(t *testing.T) {
+	f := setupAdmin(t)
+	f.requireEnrollment = true
+	enroll := requireRole(f, f.handleEnroll, RoleHospital)
+	enrollments := requireRole(f, f.handleEnrollments, RoleOperator, RoleAuditor)
+
+	if rec := call(enrollments, "POST", "/admin/enrollments", "op-token", `{"hospital_id": "H2", "action": "approve"}`); rec.Code != http.StatusConflict {
+		t.Errorf("approval without a request: %d", rec.Code)
+	}
+	if rec := call(enroll, "POST", "/admin/enroll", "h1-token", `{"hospital_id": "H2"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("H1 enrolling H2: %d", rec.Code)
+	}
+	if _, requested := f.enrollment["H2"]; requested {
+		t.Fatal("H2 enrolled by another hospital's principal")
+	}
+	if rec := call(enroll, "POST", "/admin/enroll", "h1-token", `{"hospital_id": "H1"}`); rec.Code != http.StatusOK {
+		t.Fatalf("H1 enrolling itself: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(enrollments, "POST", "/admin/enrollments", "op-token", `{"hospital_id": "H1", "action": "approve"}`); rec.Code != http.StatusOK {
+		t.Fatalf("approve H1: %d %s", rec.Code, rec.Body)
+	}
+
+	// The approval admits H1's principal, not whoever holds the packet key.
+	if rec := call(handleFederations, "POST", "/federations/default/submit_update", "h2-token", signedUpdate(t, "", "H1")); rec.Code != http.StatusForbidden {
+		t.Errorf("H1's update under H2's token: %d", rec.Code)
+	}
+	if rec := call(handleFederations, "POST", "/federations/default/submit_update", "h2-token", signedUpdate(t, "", "H2")); rec.Code != http.StatusForbidden {
+		t.Errorf("unenrolled H2: %d", rec.Code)
+	}
+	if rec := call(handleFederations, "POST", "/federations/default/submit_update", "h1-token", signedUpdate(t, "", "H1")); rec.Code != http.StatusOK {
+		t.Errorf("enrolled H1: %d %s", rec.Code, rec.Body)
+	}
+	if len(f.rejections) != 2 || f.rejections[0].Code != "wrong_hospital" || f.rejections[1].Code != "not_enrolled" {
+		t.Errorf("rejections: %+v", f.rejections)
+	}
+}
+
+func TestAdminExtendRound(t *testing.T) {
+	f := setupAdmin(t)
+	round := requireRole(f, f.handleRoundControl, RoleOperator)
+	before := f.roundManager.Remaining()
+
+	if rec := call(round, "POST", "/admin/round/extend", "op-token", `{"seconds": 0}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("zero extension: %d", rec.Code)
+	}
+	if rec := call(round, "POST", "/admin/round/extend", "op-token", `{"seconds": 60}`); rec.Code != http.StatusOK {
+		t.Fatalf("extend: %d %s", rec.Code, rec.Body)
+	}
+	if gained := f.roundManager.Remaining() - before; gained < 59*time.Second || gained > 60*time.Second {
+		t.Errorf("extension gained %s", gained)
+	}
+	entries, _ := readAudit(auditPath)
+	if last := entries[len(entries)-1]; !strings.Contains(string(last.Details), `"action":"round_extend","seconds":60`) {
+		t.Errorf("extension audited as %+v", last)
+	}
+
+	f.roundManager.AdvanceRound()
+	if f.roundManager.Extension != 0 {
+		t.Error("extension carried into the next round")
+	}
+}
+
+func TestAdminRollbackRepublishesAndReplays(t *testing.T) {
+	f := setupAdmin(t)
+	f.roundManager.SetQuorum(1)
+	for v, w := range [][]float64{{1, 2}, {9, 9}} {
+		f.roundManager.RecordUpdate("H1", v)
+		f.receivedUpdates = []UpdatePacket{{Weights: w, Metadata: Metadata{HospitalID: "H1", DataSize: 10, Loss: 0.5, ModelVersion: v}}}
+		f.journalUpdate(f.receivedUpdates[0])
+		f.aggregateUpdates()
+	}
+	f.receivedUpdates = []UpdatePacket{{Weights: []float64{7, 7}, Metadata: Metadata{HospitalID: "H2", DataSize: 10, ModelVersion: 2}}}
+	f.journalUpdate(f.receivedUpdates[0])
+
+	rollback := requireRole(f, f.handleRollback, RoleOperator)
+	if rec := call(rollback, "POST", "/admin/rollback", "op-token", `{"version": 2}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("rollback to the current version: got %d", rec.Code)
+	}
+	rec := call(rollback, "POST", "/admin/rollback", "op-token", `{"version": 1}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rollback: %d %s", rec.Code, rec.Body)
+	}
+	if f.currentVersion != 3 || f.globalWeights[0] != 1 || f.globalWeights[1] != 2 || f.receivedUpdates != nil {
+		t.Errorf("after rollback: version %d weights %v, %d buffered", f.currentVersion, f.globalWeights, len(f.receivedUpdates))
+	}
+	if round, _, _, _ := f.roundManager.Status(); round != 3 {
+		t.Errorf("round = %d, want 3", round)
+	}
+
+	var out bytes.Buffer
+	if err := replayJournal(f.journalPath, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "model version 3 reproduced exactly (0 updates pending)") {
+		t.Errorf("replay after rollback:\n%s", out.String())
+	}
+	entries, _ := readAudit(auditPath)
+	if last := entries[len(entries)-1]; last.Event != AuditRollback || last.Actor != "operator:alice" || last.Version != 3 {
+		t.Errorf("rollback audited as %+v", last)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"math"
+	"net/http"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestQFedAvgWeighting(t *testing.T) {
@@ -93,3 +97,126 @@ func TestAggregationLogic(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateShapeRejectsMismatchedUpdates(t *testing.T) {
+	f := NewFederation(DefaultConfig().DefaultFederation(), StorageConfig{})
+
+	first := UpdatePacket{
+		Weights:  []float64{1, 2, 3},
+		Metadata: Metadata{HospitalID: "H1", ModelSpec: []byte(`{"type":"logistic","input_size":2}`)},
+	}
+	if err := f.validateShapeLocked(first); err != nil {
+		t.Fatalf("first update of a fresh federation rejected: %v", err)
+	}
+	f.receivedUpdates = []UpdatePacket{first}
+
+	short := UpdatePacket{Weights: []float64{1, 2}, Metadata: Metadata{HospitalID: "H2"}}
+	if err := f.validateShapeLocked(short); err == nil {
+		t.Error("expected length mismatch to be rejected")
+	}
+
+	otherSpec := UpdatePacket{
+		Weights:  []float64{1, 2, 3},
+		Metadata: Metadata{HospitalID: "H3", ModelSpec: []byte(`{"type":"mlp","input_size":2}`)},
+	}
+	if err := f.validateShapeLocked(otherSpec); err == nil {
+		t.Error("expected spec mismatch to be rejected")
+	}
+
+	f.receivedUpdates = nil
+	f.globalWeights = []float64{0, 0, 0}
+	if err := f.validateShapeLocked(first); err != nil {
+		t.Errorf("update matching the global model rejected: %v", err)
+	}
+}
+
+func TestConcurrentFirstUpdatesAgreeOnTheShape(t *testing.T) {
+	packet := func(id string, n int) string {
+		p := UpdatePacket{
+			Weights:  make([]float64, n),
+			Metadata: Metadata{HospitalID: id, DataSize: 10, Timestamp: time.Now().Unix()},
+		}
+		var err error
+		if p.Signature, err = signMetadata(p.Metadata); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(p)
+		return string(b)
+	}
+	// All four reach an empty buffer at once; the shape check and the record
+	// must not let more than one take the empty buffer as its reference.
+	for i := 0; i < 50; i++ {
+		f := setupAdmin(t)
+		bodies := map[string]string{"H1": packet("H1", 2), "H2": packet("H2", 3), "H3": packet("H3", 4), "H4": packet("H4", 5)}
+		codes := make(chan int, len(bodies))
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for id, body := range bodies {
+			wg.Add(1)
+			go func(id, body string) {
+				defer wg.Done()
+				<-start
+				codes <- call(f.routes()["submit_update"], "POST", "/submit_update", hospitalToken(id), body).Code
+			}(id, body)
+		}
+		close(start)
+		wg.Wait()
+		close(codes)
+		accepted := 0
+		for code := range codes {
+			if code == http.StatusOK {
+				accepted++
+			}
+		}
+		if accepted != 1 || len(f.receivedUpdates) != 1 {
+			t.Fatalf("%d of four differently shaped first updates accepted, %d buffered", accepted, len(f.receivedUpdates))
+		}
+	}
+}
+
+func TestLabelVocabMergesUntilFrozen(t *testing.T) {
+	f := NewFederation(DefaultConfig().DefaultFederation(), StorageConfig{})
+
+	f.mergeLabels([]string{"urgent", "routine"})
+	f.mergeLabels([]string{"emergent", "routine", " "})
+	want := []string{"emergent", "routine", "urgent"}
+	if len(f.labelVocab) != len(want) {
+		t.Fatalf("expected %v, got %v", want, f.labelVocab)
+	}
+	for i, l := range want {
+		if f.labelVocab[i] != l {
+			t.Fatalf("expected %v, got %v", want, f.labelVocab)
+		}
+	}
+
+	f.freezeLabels()
+	if f.mergeLabels([]string{"deferred"}) {
+		t.Error("merge accepted after vocabulary was frozen")
+	}
+	if len(f.labelVocab) != 3 {
+		t.Errorf("frozen vocabulary changed: %v", f.labelVocab)
+	}
+}
+
+func TestLabelRegistrationNeedsTheHospitalsPrincipal(t *testing.T) {
+	f := setupAdmin(t)
+	register := func(token, hospitalID string) int {
+		body := `{"hospital_id": "` + hospitalID + `", "labels": ["sepsis"]}`
+		return call(handleFederations, "POST", "/federations/default/label_vocab", token, body).Code
+	}
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "audit-token": http.StatusForbidden, "op-token": http.StatusForbidden, "h2-token": http.StatusForbidden} {
+		if code := register(token, "H1"); code != want {
+			t.Errorf("registering H1's labels with %q: got %d, want %d", token, code, want)
+		}
+	}
+	if len(f.labelVocab) != 0 {
+		t.Fatalf("labels added by another principal: %v", f.labelVocab)
+	}
+	if code := register("h1-token", "H1"); code != http.StatusOK || len(f.labelVocab) != 1 {
+		t.Errorf("H1 registering its own labels: %d %v", code, f.labelVocab)
+	}
+	if rec := call(handleFederations, "GET", "/federations/default/label_vocab", "audit-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("auditor reading the vocabulary: %d", rec.Code)
+	}
+}
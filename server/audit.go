@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The audit log is the governance record of the federation: an append-only
+// JSON Lines file in which every entry carries the hash of the one before it.
+// Hashes are HMAC-SHA256 keyed with auditKey, a secret only the server holds
+// (security.audit_key_file) and never SecretKey, which every hospital signs
+// packets with. Editing, reordering or deleting an entry breaks the chain and
+// cannot be papered over without the key.
+//
+// The latest sequence number and hash are mirrored to the head file, which
+// catches truncation of the tail. Whoever can write the log can also
+// rewrite a head kept beside it, so storage.audit_head should point outside
+// the log directory (a separately mounted or append-only volume), or the
+// X-Audit-Head value of /audit should be copied somewhere the server cannot
+// write.
+var (
+	auditMu       sync.Mutex
+	auditPath     = "audit.jsonl" // "" disables auditing
+	auditHeadPath string          // "" means <auditPath>.head
+	auditKey      []byte
+	auditSeq      int
+	auditHead     = auditGenesis
+)
+
+// minAuditKeyLen is the shortest audit key the server accepts.
+const minAuditKeyLen = 16
+
+// auditGenesis is the PrevHash of the first entry.
+var auditGenesis = strings.Repeat("0", 64)
+
+// Audit event types.
+const (
+	AuditSubmission  = "submission"
+	AuditRejection   = "rejection"
+	AuditAggregation = "aggregation"
+	AuditRollback    = "rollback"
+	AuditAdmin       = "admin"
+)
+
+// AuditEntry is one line of the audit log.
+// Hash = HMAC-SHA256(auditKey, json(entry with Hash = "")).
+type AuditEntry struct {
+	Seq        int             `json:"seq"`
+	Time       string          `json:"time"` // RFC 3339, UTC
+	Event      string          `json:"event"`
+	Federation string          `json:"federation,omitempty"`  // "" in logs written before multi-tenancy means default
+	HospitalID string          `json:"hospital_id,omitempty"` // submitter, or subject of an admin action
+	Hospitals  []string        `json:"hospitals,omitempty"`   // aggregation participants
+	Actor      string          `json:"actor,omitempty"`       // who performed an admin action
+	Round      int             `json:"round"`
+	Version    int             `json:"version"`
+	Reason     string          `json:"reason,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash,omitempty"`
+}
+
+// auditHeadFile is the content of the head file.
+type auditHeadFile struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// computeHash returns the entry's chained HMAC.
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	body, _ := json.Marshal(e)
+	mac := hmac.New(sha256.New, auditKey)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// headFor is the head file of the log at path.
+func headFor(path, head string) string {
+	if head != "" {
+		return head
+	}
+	return path + ".head"
+}
+
+// readAuditKey loads the audit HMAC key from path. Surrounding whitespace is
+// ignored. The key must be long enough and must not be the hospitals'
+// shared SecretKey.
+func readAuditKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimSpace(string(b))
+	switch {
+	case len(key) < minAuditKeyLen:
+		return nil, fmt.Errorf("%s: audit key must be at least %d characters", path, minAuditKeyLen)
+	case key == SecretKey:
+		return nil, fmt.Errorf("%s: audit key must not be the hospitals' shared packet key", path)
+	}
+	return []byte(key), nil
+}
+
+// loadAuditKey reads the audit key, creating a random one at path on the
+// server's first start so that auditing works without setup.
+func loadAuditKey(path string) ([]byte, error) {
+	key, err := readAuditKey(path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return key, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key = []byte(hex.EncodeToString(b))
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(append(key, '\n')); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	log.Printf("[audit] Created audit key %s; keep it secret and backed up, the log cannot be verified without it", path)
+	return key, nil
+}
+
+// openAudit resumes the chain from an existing log. A log that fails
+// verification is reported loudly; new entries still chain onto its last
+// entry, so the break stays visible to the verifier.
+func openAudit() {
+	if auditPath == "" {
+		return
+	}
+	entries, err := readAudit(auditPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[audit] ERROR reading %s: %v", auditPath, err)
+		return
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		auditSeq, auditHead = last.Seq, last.Hash
+	}
+	if _, err := verifyAudit(auditPath, auditHeadPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("[audit] WARNING: %s failed verification: %v", auditPath, err)
+	}
+}
+
+// recordAudit appends an entry, filling in Seq, Time and the chain hashes.
+// details may be nil. Errors are logged and returned, never ignored.
+func recordAudit(e AuditEntry, details map[string]interface{}) error {
+	if auditPath == "" {
+		return nil
+	}
+	if details != nil {
+		raw, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("[audit] ERROR encoding details for %s: %v", e.Event, err)
+			return err
+		}
+		e.Details = raw
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	e.Seq = auditSeq + 1
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.PrevHash = auditHead
+	e.Hash = e.computeHash()
+
+	line, err := json.Marshal(e)
+	if err == nil {
+		err = appendLine(auditPath, line)
+	}
+	if err == nil {
+		head, _ := json.Marshal(auditHeadFile{Seq: e.Seq, Hash: e.Hash})
+		err = os.WriteFile(headFor(auditPath, auditHeadPath), head, 0644)
+	}
+	if err != nil {
+		log.Printf("[audit] ERROR writing %s entry %d: %v", e.Event, e.Seq, err)
+		return err
+	}
+	auditSeq, auditHead = e.Seq, e.Hash
+	return nil
+}
+
+// appendLine appends line and a newline to path and syncs it to disk.
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rejectUpdate audits, counts and records a refused submission and sends the
+// HTTP error. code is the fixed reason label of fl_submissions_rejected_total.
+func (f *Federation) rejectUpdate(w http.ResponseWriter, packet UpdatePacket, code, reason string, status int) {
+	metricSubmissionsRejected.Inc(f.ID, code)
+	f.recordRejection(packet, code, reason)
+	recordAudit(AuditEntry{
+		Event:      AuditRejection,
+		Federation: f.ID,
+		HospitalID: packet.Metadata.HospitalID,
+		Round:      packet.Metadata.RoundID,
+		Version:    packet.Metadata.ModelVersion,
+		Reason:     reason,
+	}, nil)
+	http.Error(w, reason, status)
+}
+
+// readAudit parses every entry of the log.
+func readAudit(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return entries, &AuditViolation{Line: line, Reason: "unreadable entry: " + err.Error()}
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// AuditViolation locates the first entry at which the chain is broken.
+type AuditViolation struct {
+	Line   int
+	Seq    int
+	Reason string
+}
+
+func (v *AuditViolation) Error() string {
+	return fmt.Sprintf("audit log line %d (seq %d): %s", v.Line, v.Seq, v.Reason)
+}
+
+// verifyAudit checks every hash, link and sequence number in the log, then
+// compares its tail with the head file (head, or <path>.head if ""). It
+// returns the number of valid entries.
+func verifyAudit(path, head string) (int, error) {
+	entries, err := readAudit(path)
+	if err != nil {
+		return len(entries), err
+	}
+	prev := auditGenesis
+	for i, e := range entries {
+		violation := func(reason string, args ...interface{}) error {
+			return &AuditViolation{Line: i + 1, Seq: e.Seq, Reason: fmt.Sprintf(reason, args...)}
+		}
+		switch {
+		case e.Seq != i+1:
+			return i, violation("expected seq %d (entries removed or reordered)", i+1)
+		case e.PrevHash != prev:
+			return i, violation("prev_hash does not match the previous entry")
+		case !hmac.Equal([]byte(e.computeHash()), []byte(e.Hash)):
+			return i, violation("hash mismatch (entry edited)")
+		}
+		prev = e.Hash
+	}
+
+	b, err := os.ReadFile(headFor(path, head))
+	if os.IsNotExist(err) {
+		if len(entries) > 0 {
+			return len(entries), &AuditViolation{Line: len(entries), Seq: len(entries), Reason: "head file missing"}
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return len(entries), err
+	}
+	var hf auditHeadFile
+	if err := json.Unmarshal(b, &hf); err != nil {
+		return len(entries), fmt.Errorf("read audit head: %w", err)
+	}
+	if hf.Seq != len(entries) || hf.Hash != prev {
+		return len(entries), &AuditViolation{Line: len(entries), Seq: len(entries),
+			Reason: fmt.Sprintf("log ends at seq %d but head records seq %d (truncated)", len(entries), hf.Seq)}
+	}
+	return len(entries), nil
+}
+
+// AuditFilter selects entries for export. Zero fields match everything; Until is exclusive.
+type AuditFilter struct {
+	Federation string
+	HospitalID string
+	Since      time.Time
+	Until      time.Time
+}
+
+// Match reports whether e passes the filter. A hospital matches entries it
+// submitted, was the subject of, or took part in (aggregations).
+func (f AuditFilter) Match(e AuditEntry) bool {
+	if f.Federation != "" && e.Federation != f.Federation &&
+		!(e.Federation == "" && f.Federation == defaultFederationID) {
+		return false
+	}
+	if f.HospitalID != "" && e.HospitalID != f.HospitalID && !contains(e.Hospitals, f.HospitalID) {
+		return false
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, e.Time)
+		if err != nil {
+			return false
+		}
+		if !f.Since.IsZero() && t.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && !t.Before(f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAuditTime accepts RFC 3339 or a date. A date used as an upper bound
+// covers that whole day.
+func parseAuditTime(s string, upper bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want YYYY-MM-DD or RFC 3339)", s)
+	}
+	if upper {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, nil
+}
+
+// exportAudit writes the matching entries as JSON Lines, unchanged so each
+// can be checked against the full log.
+func exportAudit(path string, filter AuditFilter, w io.Writer) (int, error) {
+	entries, err := readAudit(path)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if filter.Match(e) {
+			if err := enc.Encode(e); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// handleAudit serves GET /audit?hospital=H1&since=2024-01-01&until=2024-01-31
+// as JSON Lines, plus the current chain head in X-Audit-Head. federation
+// limits the export to one federation ("" exports every federation).
+func handleAudit(w http.ResponseWriter, r *http.Request, federation string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	since, err := parseAuditTime(q.Get("since"), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseAuditTime(q.Get("until"), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Audit-Head", fmt.Sprintf("%d:%s", auditSeq, auditHead))
+	if _, err := exportAudit(auditPath, AuditFilter{Federation: federation, HospitalID: q.Get("hospital"), Since: since, Until: until}, w); err != nil && !os.IsNotExist(err) {
+		log.Printf("[audit] ERROR exporting: %v", err)
+	}
+}
+
+// handleAuditAll serves the server-wide GET /audit, optionally filtered with
+// ?federation=. Principals limited to some federations use
+// /federations/{id}/audit instead.
+func handleAuditAll(w http.ResponseWriter, r *http.Request, p Principal) {
+	if len(p.Federations) > 0 {
+		auditAdmin(nil, p, r.URL.Path, "", "limited to federations "+strings.Join(p.Federations, ","), nil)
+		http.Error(w, "Use /federations/{id}/audit for the federations you may access", http.StatusForbidden)
+		return
+	}
+	handleAudit(w, r, r.URL.Query().Get("federation"))
+}
+
+// handleFederationAudit serves GET /federations/{id}/audit.
+func (f *Federation) handleFederationAudit(w http.ResponseWriter, r *http.Request, p Principal) {
+	handleAudit(w, r, f.ID)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAuditKey is the audit key the tests sign their logs with.
+const testAuditKey = "test-audit-key-0123456789"
+
+// writeTestAudit records a small federation history into a fresh audit log.
+func writeTestAudit(t *testing.T) string {
+	t.Helper()
+	oldPath, oldSeq, oldHead, oldKey := auditPath, auditSeq, auditHead, auditKey
+	auditPath, auditSeq, auditHead, auditKey = filepath.Join(t.TempDir(), "audit.jsonl"), 0, auditGenesis, []byte(testAuditKey)
+	t.Cleanup(func() { auditPath, auditSeq, auditHead, auditKey = oldPath, oldSeq, oldHead, oldKey })
+
+	records := []AuditEntry{
+		{Event: AuditSubmission, HospitalID: "H1"},
+		{Event: AuditRejection, HospitalID: "H2", Reason: "Invalid packet signature"},
+		{Event: AuditSubmission, HospitalID: "H2"},
+		{Event: AuditAggregation, Version: 1, Hospitals: []string{"H1", "H2"}},
+		{Event: AuditSubmission, HospitalID: "H3", Round: 1, Version: 1},
+	}
+	for _, e := range records {
+		if err := recordAudit(e, map[string]interface{}{"note": "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return auditPath
+}
+
+func TestAuditChainVerifiesAndExports(t *testing.T) {
+	path := writeTestAudit(t)
+	if n, err := verifyAudit(path, ""); err != nil || n != 5 {
+		t.Fatalf("verify: %d entries, %v", n, err)
+	}
+
+	var out bytes.Buffer
+	n, err := exportAudit(path, AuditFilter{HospitalID: "H2"}, &out)
+	if err != nil || n != 3 { // rejection, submission, aggregation it took part in
+		t.Fatalf("export H2: %d entries, %v\n%s", n, err, out.String())
+	}
+	if !strings.Contains(out.String(), "Invalid packet signature") {
+		t.Errorf("rejection reason missing from export:\n%s", out.String())
+	}
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	if n, _ := exportAudit(path, AuditFilter{Since: tomorrow}, &bytes.Buffer{}); n != 0 {
+		t.Errorf("future date range exported %d entries", n)
+	}
+}
+
+func TestAuditVerifierDetectsTampering(t *testing.T) {
+	tamper := func(t *testing.T, edit func(lines []string) []string) error {
+		path := writeTestAudit(t)
+		b, _ := os.ReadFile(path)
+		lines := edit(strings.Split(strings.TrimSpace(string(b)), "\n"))
+		os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+		_, err := verifyAudit(path, "")
+		return err
+	}
+	cases := []struct {
+		name   string
+		edit   func([]string) []string
+		line   int
+		reason string
+	}{
+		{"edit", func(l []string) []string {
+			l[1] = strings.Replace(l[1], "Invalid packet signature", "Accepted", 1)
+			return l
+		}, 2, "edited"},
+		{"delete", func(l []string) []string { return append(l[:2], l[3:]...) }, 3, "seq"},
+		{"truncate", func(l []string) []string { return l[:4] }, 4, "truncated"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var v *AuditViolation
+			err := tamper(t, c.edit)
+			if !errors.As(err, &v) || v.Line != c.line || !strings.Contains(v.Reason, c.reason) {
+				t.Errorf("got %v, want a violation at line %d mentioning %q", err, c.line, c.reason)
+			}
+		})
+	}
+}
+
+func TestAuditChainNeedsTheServerKey(t *testing.T) {
+	path := writeTestAudit(t)
+
+	// A hospital knows SecretKey, so a chain rebuilt with it must not verify.
+	auditKey = []byte(SecretKey)
+	var v *AuditViolation
+	if _, err := verifyAudit(path, ""); !errors.As(err, &v) || v.Line != 1 {
+		t.Errorf("chain verified under the hospitals' key: %v", err)
+	}
+
+	dir := t.TempDir()
+	for name, key := range map[string]string{"short": "secret", "shared": SecretKey} {
+		keyFile := filepath.Join(dir, name)
+		os.WriteFile(keyFile, []byte(key+"\n"), 0600)
+		if _, err := readAuditKey(keyFile); err == nil {
+			t.Errorf("%s audit key accepted", name)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Security.AuditKeyFile = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "security.audit_key_file") {
+		t.Errorf("auditing without a key accepted: %v", err)
+	}
+	cfg.Storage.Audit = ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("disabled auditing still needs a key: %v", err)
+	}
+}
+
+func TestDefaultConfigCreatesTheAuditKey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Security.AuditKeyFile = filepath.Join(t.TempDir(), "audit.key")
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults refused before the first start: %v", err)
+	}
+	key, err := loadAuditKey(cfg.Security.AuditKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := readAuditKey(cfg.Security.AuditKeyFile)
+	if err != nil || string(again) != string(key) {
+		t.Errorf("created key not kept: %v", err)
+	}
+	if info, err := os.Stat(cfg.Security.AuditKeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("audit key file mode: %v %v", info, err)
+	}
+}
+
+func TestAuditHeadOutsideLogDirectory(t *testing.T) {
+	path := writeTestAudit(t)
+	head := filepath.Join(t.TempDir(), "anchor.head")
+	auditHeadPath = head
+	t.Cleanup(func() { auditHeadPath = "" })
+
+	if err := recordAudit(AuditEntry{Event: AuditAdmin, Actor: "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := verifyAudit(path, head); err != nil || n != 6 {
+		t.Errorf("verify against the anchored head: %d entries, %v", n, err)
+	}
+	var v *AuditViolation
+	if _, err := verifyAudit(path, ""); !errors.As(err, &v) || !strings.Contains(v.Reason, "truncated") {
+		t.Errorf("stale head beside the log not reported: %v", err)
+	}
+}
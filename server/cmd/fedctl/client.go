@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// client talks to one federation on the server.
+type client struct {
+	server string // base URL, e.g. http://localhost:8080
+	fedURL string // the federation's API root: server, or server/federations/{id}
+	token  string
+	http   *http.Client
+}
+
+func newClient(server, token, federation string) *client {
+	server = strings.TrimRight(server, "/")
+	c := &client{server: server, fedURL: server, token: token, http: &http.Client{Timeout: 30 * time.Second}}
+	if federation != "" {
+		c.fedURL = server + "/federations/" + url.PathEscape(federation)
+	}
+	return c
+}
+
+// do sends a request and decodes a JSON response into out (unless nil). A
+// non-2xx status is an error carrying the server's message.
+func (c *client) do(method, rawURL string, body, out interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, rawURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, rawURL, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return resp.Header, nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = b
+		return resp.Header, nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, fmt.Errorf("%s %s: decode response: %w", method, rawURL, err)
+	}
+	return resp.Header, nil
+}
+
+// get fetches an endpoint of the federation.
+func (c *client) get(endpoint string, out interface{}) error {
+	_, err := c.do(http.MethodGet, c.fedURL+"/"+endpoint, nil, out)
+	return err
+}
+
+// post sends body to an endpoint of the federation.
+func (c *client) post(endpoint string, body, out interface{}) error {
+	_, err := c.do(http.MethodPost, c.fedURL+"/"+endpoint, body, out)
+	return err
+}
+
+// printer writes a command's result as an aligned table or as JSON.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// emit prints v as JSON, or header and rows as a table.
+func (p *printer) emit(v interface{}, header []string, rows [][]string) error {
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// fields prints key/value pairs, in order, as a two-column table.
+func (p *printer) fields(v interface{}, pairs ...string) error {
+	var rows [][]string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rows = append(rows, []string{pairs[i] + ":", pairs[i+1]})
+	}
+	return p.emit(v, nil, rows)
+}
+
+func num(v float64) string {
+	return fmt.Sprintf("%.6g", v)
+}
+
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// roundStatus is GET /round_status.
+type roundStatus struct {
+	CurrentRound    int     `json:"current_round"`
+	ExpectedClients int     `json:"expected_clients"`
+	ReceivedClients int     `json:"received_clients"`
+	State           string  `json:"state"`
+	Paused          bool    `json:"paused"`
+	AgeS            float64 `json:"age_s"`
+	TimeoutInS      float64 `json:"timeout_in_s"`
+}
+
+func cmdStatus(c *client, out *printer) error {
+	var s roundStatus
+	if err := c.get("round_status", &s); err != nil {
+		return err
+	}
+	timeout := fmt.Sprintf("in %s", seconds(s.TimeoutInS))
+	if s.TimeoutInS <= 0 {
+		timeout = "passed; the next submission aggregates"
+	}
+	return out.fields(s,
+		"Round", strconv.Itoa(s.CurrentRound),
+		"State", s.State,
+		"Paused", strconv.FormatBool(s.Paused),
+		"Submitted", fmt.Sprintf("%d / %d", s.ReceivedClients, s.ExpectedClients),
+		"Open for", seconds(s.AgeS),
+		"Timeout", timeout,
+	)
+}
+
+func cmdFederations(c *client, out *printer) error {
+	var resp struct {
+		Federations []struct {
+			ID              string `json:"id"`
+			Algorithm       string `json:"algorithm"`
+			ModelVersion    int    `json:"model_version"`
+			CurrentRound    int    `json:"current_round"`
+			ExpectedClients int    `json:"expected_clients"`
+			ReceivedClients int    `json:"received_clients"`
+			State           string `json:"state"`
+		} `json:"federations"`
+	}
+	if _, err := c.do("GET", c.server+"/federations", nil, &resp); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, f := range resp.Federations {
+		rows = append(rows, []string{f.ID, f.Algorithm, strconv.Itoa(f.ModelVersion), strconv.Itoa(f.CurrentRound),
+			fmt.Sprintf("%d/%d", f.ReceivedClients, f.ExpectedClients), f.State})
+	}
+	return out.emit(resp.Federations, []string{"ID", "ALGORITHM", "VERSION", "ROUND", "SUBMITTED", "STATE"}, rows)
+}
+
+// modelVersion is an entry of GET /models.
+type modelVersion struct {
+	Version    int     `json:"version"`
+	Parameters int     `json:"parameters"`
+	Norm       float64 `json:"norm"`
+	ModelHash  string  `json:"model_hash"`
+	Created    string  `json:"created"`
+	Current    bool    `json:"current,omitempty"`
+}
+
+// model is GET /models?version=N.
+type model struct {
+	Version    int             `json:"version"`
+	Weights    []float64       `json:"weights"`
+	Parameters int             `json:"parameters"`
+	Norm       float64         `json:"norm"`
+	ModelHash  string          `json:"model_hash"`
+	ModelSpec  json.RawMessage `json:"model_spec,omitempty"`
+}
+
+func listModels(c *client) ([]modelVersion, error) {
+	var resp struct {
+		Versions []modelVersion `json:"versions"`
+	}
+	err := c.get("models", &resp)
+	return resp.Versions, err
+}
+
+func cmdModels(c *client, out *printer) error {
+	versions, err := listModels(c)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, v := range versions {
+		current := ""
+		if v.Current {
+			current = "*"
+		}
+		rows = append(rows, []string{strconv.Itoa(v.Version), strconv.Itoa(v.Parameters), num(v.Norm), short(v.ModelHash), v.Created, current})
+	}
+	return out.emit(versions, []string{"VERSION", "PARAMS", "NORM", "HASH", "CREATED", "CURRENT"}, rows)
+}
+
+// fetchModel downloads a version ("latest" for the newest stored one) and
+// checks its weights against the hash the server reports.
+func fetchModel(c *client, arg string) (*model, error) {
+	version, err := resolveVersion(c, arg)
+	if err != nil {
+		return nil, err
+	}
+	var m model
+	if err := c.get(fmt.Sprintf("models?version=%d", version), &m); err != nil {
+		return nil, err
+	}
+	if got := modelHash(m.Weights); got != m.ModelHash {
+		return nil, fmt.Errorf("version %d: weights hash to %s, server reports %s", version, short(got), short(m.ModelHash))
+	}
+	return &m, nil
+}
+
+func resolveVersion(c *client, arg string) (int, error) {
+	if arg != "latest" {
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return 0, fmt.Errorf("version must be a number or latest, got %q", arg)
+		}
+		return v, nil
+	}
+	versions, err := listModels(c)
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("the server has no stored model versions yet")
+	}
+	return versions[len(versions)-1].Version, nil
+}
+
+func cmdModelShow(c *client, out *printer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: fedctl models show <version|latest>")
+	}
+	m, err := fetchModel(c, args[0])
+	if err != nil {
+		return err
+	}
+	spec := string(m.ModelSpec)
+	if spec == "" || spec == "null" {
+		spec = "-"
+	}
+	return out.fields(m,
+		"Version", strconv.Itoa(m.Version),
+		"Parameters", strconv.Itoa(m.Parameters),
+		"Norm", num(m.Norm),
+		"Hash", m.ModelHash,
+		"Spec", spec,
+	)
+}
+
+func cmdModelDownload(c *client, out *printer, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fedctl models download <version|latest> [-out file]")
+	}
+	fs := newFlagSet("models download")
+	path := fs.String("out", "", "Output file (default model_v<N>.json)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	m, err := fetchModel(c, args[0])
+	if err != nil {
+		return err
+	}
+	if *path == "" {
+		*path = fmt.Sprintf("model_v%d.json", m.Version)
+	}
+	if err := writeJSONFile(*path, m); err != nil {
+		return err
+	}
+	result := map[string]interface{}{"version": m.Version, "file": *path, "parameters": m.Parameters, "model_hash": m.ModelHash}
+	return out.fields(result, "Version", strconv.Itoa(m.Version), "Saved to", *path, "Hash", m.ModelHash)
+}
+
+func cmdModelDiff(c *client, out *printer, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: fedctl models diff <a> <b> [-top n]")
+	}
+	fs := newFlagSet("models diff")
+	top := fs.Int("top", 10, "Number of most-changed parameters to list")
+	if err := fs.Parse(args[2:]); err != nil {
+		return err
+	}
+	a, err := fetchModel(c, args[0])
+	if err != nil {
+		return err
+	}
+	b, err := fetchModel(c, args[1])
+	if err != nil {
+		return err
+	}
+	d, err := diffModels(a, b, *top)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.emit(d, nil, nil)
+	}
+	if err := out.fields(d,
+		"Versions", fmt.Sprintf("%d → %d", d.From, d.To),
+		"L2 distance", num(d.L2Distance),
+		"Relative change", num(d.RelativeChange),
+		"Cosine similarity", num(d.Cosine),
+		"Max |change|", fmt.Sprintf("%s at index %d", num(d.MaxAbsChange), d.MaxIndex),
+		"Changed params", fmt.Sprintf("%d of %d", d.Changed, d.Parameters),
+	); err != nil {
+		return err
+	}
+	fmt.Fprintln(out.w)
+	var rows [][]string
+	for _, p := range d.Top {
+		rows = append(rows, []string{strconv.Itoa(p.Index), num(p.From), num(p.To), num(p.Change)})
+	}
+	return out.emit(nil, []string{"INDEX", "FROM", "TO", "CHANGE"}, rows)
+}
+
+// hospitalState is GET /dashboard_state, reduced to what fedctl shows.
+type hospitalState struct {
+	Hospitals []struct {
+		HospitalID string    `json:"hospital_id"`
+		Submitted  bool      `json:"submitted"`
+		Enrollment string    `json:"enrollment"`
+		LastSeen   time.Time `json:"last_seen"`
+		LastRound  *int      `json:"last_round"`
+		LastLoss   *float64  `json:"last_loss"`
+		Updates    int       `json:"updates"`
+	} `json:"hospitals"`
+	History []struct {
+		Round   int    `json:"round"`
+		Outcome string `json:"outcome"`
+		Version int    `json:"version"`
+		Updates []struct {
+			HospitalID string  `json:"hospital_id"`
+			Loss       float64 `json:"loss"`
+			Staleness  int     `json:"staleness"`
+			Weight     float64 `json:"weight"`
+		} `json:"updates"`
+	} `json:"history"`
+}
+
+// hospitalSummary is one row of fedctl hospitals.
+type hospitalSummary struct {
+	HospitalID string   `json:"hospital_id"`
+	Submitted  bool     `json:"submitted_this_round"`
+	Updates    int      `json:"updates"`
+	Rounds     int      `json:"rounds"`        // aggregated rounds it took part in
+	Aggregated int      `json:"recent_rounds"` // aggregated rounds the server remembers
+	MeanWeight float64  `json:"mean_weight"`
+	LastLoss   *float64 `json:"last_loss,omitempty"`
+	LastSeen   string   `json:"last_seen,omitempty"`
+	Enrollment string   `json:"enrollment,omitempty"`
+}
+
+func cmdHospitals(c *client, out *printer) error {
+	var st hospitalState
+	if err := c.get("dashboard_state", &st); err != nil {
+		return err
+	}
+	aggregations := 0
+	rounds, weights := map[string]int{}, map[string]float64{}
+	for _, h := range st.History {
+		if h.Outcome != "aggregated" {
+			continue
+		}
+		aggregations++
+		for _, u := range h.Updates {
+			rounds[u.HospitalID]++
+			weights[u.HospitalID] += u.Weight
+		}
+	}
+
+	var list []hospitalSummary
+	var rows [][]string
+	for _, h := range st.Hospitals {
+		s := hospitalSummary{HospitalID: h.HospitalID, Submitted: h.Submitted, Updates: h.Updates, Rounds: rounds[h.HospitalID],
+			Aggregated: aggregations, LastLoss: h.LastLoss, Enrollment: h.Enrollment}
+		if s.Rounds > 0 {
+			s.MeanWeight = weights[h.HospitalID] / float64(s.Rounds)
+		}
+		if !h.LastSeen.IsZero() {
+			s.LastSeen = h.LastSeen.Format(time.RFC3339)
+		}
+		list = append(list, s)
+
+		submitted, loss := "waiting", "-"
+		if s.Submitted {
+			submitted = "submitted"
+		}
+		if s.LastLoss != nil {
+			loss = num(*s.LastLoss)
+		}
+		rows = append(rows, []string{s.HospitalID, submitted, strconv.Itoa(s.Updates), fmt.Sprintf("%d/%d", s.Rounds, aggregations),
+			num(s.MeanWeight), loss, dash(s.LastSeen), dash(s.Enrollment)})
+	}
+	return out.emit(list, []string{"HOSPITAL", "THIS ROUND", "UPDATES", "ROUNDS", "MEAN WEIGHT", "LAST LOSS", "LAST SEEN", "ENROLLMENT"}, rows)
+}
+
+// participation is one row of fedctl hospitals history.
+type participation struct {
+	Round     int      `json:"round"`
+	Version   int      `json:"version"`
+	Took      bool     `json:"participated"`
+	Loss      *float64 `json:"loss,omitempty"`
+	Staleness *int     `json:"staleness,omitempty"`
+	Weight    *float64 `json:"weight,omitempty"`
+}
+
+func cmdHospitalHistory(c *client, out *printer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: fedctl hospitals history <hospital-id>")
+	}
+	id := args[0]
+	var st hospitalState
+	if err := c.get("dashboard_state", &st); err != nil {
+		return err
+	}
+	list := []participation{}
+	var rows [][]string
+	for _, h := range st.History {
+		if h.Outcome != "aggregated" {
+			continue
+		}
+		p := participation{Round: h.Round, Version: h.Version}
+		row := []string{strconv.Itoa(h.Round), strconv.Itoa(h.Version), "absent", "-", "-", "-"}
+		for _, u := range h.Updates {
+			if u.HospitalID == id {
+				u := u
+				p.Took, p.Loss, p.Staleness, p.Weight = true, &u.Loss, &u.Staleness, &u.Weight
+				row = []string{strconv.Itoa(h.Round), strconv.Itoa(h.Version), "yes", num(u.Loss), strconv.Itoa(u.Staleness), num(u.Weight)}
+			}
+		}
+		list = append(list, p)
+		rows = append(rows, row)
+	}
+	return out.emit(list, []string{"ROUND", "VERSION", "PARTICIPATED", "LOSS", "STALENESS", "WEIGHT"}, rows)
+}
+
+func cmdRound(c *client, out *printer, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fedctl round pause|resume|abort|extend <seconds>")
+	}
+	var body interface{}
+	switch args[0] {
+	case "pause", "resume", "abort":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: fedctl round %s", args[0])
+		}
+	case "extend":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: fedctl round extend <seconds>")
+		}
+		s, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || s <= 0 {
+			return fmt.Errorf("seconds must be a positive number, got %q", args[1])
+		}
+		body = map[string]float64{"seconds": s}
+	default:
+		return fmt.Errorf("unknown round action %q", args[0])
+	}
+	var settings map[string]interface{}
+	if err := c.post("admin/round/"+args[0], body, &settings); err != nil {
+		return err
+	}
+	if args[0] == "extend" {
+		return cmdStatus(c, out)
+	}
+	return printSettings(out, settings)
+}
+
+// settingKeys are the settings fedctl shows and may change, in display order.
+var settingKeys = []string{"quorum", "q", "prox_mu", "require_enrollment", "paused", "current_round", "received_clients", "state", "model_version"}
+
+func cmdSettings(c *client, out *printer, assignments []string) error {
+	var settings map[string]interface{}
+	if len(assignments) == 0 {
+		if err := c.get("admin/settings", &settings); err != nil {
+			return err
+		}
+		return printSettings(out, settings)
+	}
+	change := map[string]interface{}{}
+	for _, a := range assignments {
+		key, value, ok := strings.Cut(a, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", a)
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return fmt.Errorf("%s: %q is not a number or true/false", key, value)
+		}
+		change[key] = v
+	}
+	if err := c.post("admin/settings", change, &settings); err != nil {
+		return err
+	}
+	return printSettings(out, settings)
+}
+
+func printSettings(out *printer, settings map[string]interface{}) error {
+	var pairs []string
+	for _, k := range settingKeys {
+		if v, ok := settings[k]; ok {
+			pairs = append(pairs, k, fmt.Sprint(v))
+		}
+	}
+	return out.fields(settings, pairs...)
+}
+
+func seconds(s float64) string {
+	return (time.Duration(s * float64(time.Second))).Round(time.Second).String()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
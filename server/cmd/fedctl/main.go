@@ -0,0 +1,127 @@
+// Command fedctl is the operator's command line for a running federation
+// server: round status and control, model versions, hospitals, aggregation
+// settings and audit log verification. Every command prints a table, or JSON
+// with -o json for scripting.
+//
+//	go run ./cmd/fedctl status
+//	go run ./cmd/fedctl -federation sepsis models diff 3 4
+//	FEDCTL_TOKEN=op-token go run ./cmd/fedctl settings set q=0.5 quorum=3
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const usage = `usage: fedctl [flags] <command> [args]
+
+Commands:
+  status                          current round of the federation
+  federations                     every federation hosted by the server
+  models [list]                   stored model versions
+  models show <version|latest>    one version's size, norm and hash
+  models download <version|latest> [-out file]
+                                  save a version's weights as JSON
+  models diff <a> <b> [-top n]    compare two versions parameter by parameter
+  hospitals                       hospitals and their participation
+  hospitals history <id>          one hospital's part in every recent round
+  round pause|resume|abort        control the current round (operator)
+  round extend <seconds>          give the current round more time (operator)
+  settings                        aggregation and round settings
+  settings set key=value ...      change quorum, q, prox_mu or require_enrollment (operator)
+  audit verify -key-file path [-file path]
+                                  check the audit log's hash chain, from the server
+                                  (operator, auditor) or a local copy
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("fedctl", flag.ExitOnError)
+	server := flags.String("server", envOr("FEDCTL_SERVER", "http://localhost:8080"), "Server base URL (env FEDCTL_SERVER)")
+	token := flags.String("token", os.Getenv("FEDCTL_TOKEN"), "Admin bearer token (env FEDCTL_TOKEN)")
+	federation := flags.String("federation", os.Getenv("FEDCTL_FEDERATION"), "Federation ID; empty for the default federation (env FEDCTL_FEDERATION)")
+	output := flags.String("o", "table", "Output format: table or json")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if *output != "table" && *output != "json" {
+		fatalf("-o must be table or json, got %q", *output)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c := newClient(*server, *token, *federation)
+	out := &printer{w: os.Stdout, json: *output == "json"}
+	if err := run(c, out, flags.Args()); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// run dispatches one command.
+func run(c *client, out *printer, args []string) error {
+	cmd, rest := args[0], args[1:]
+	sub := ""
+	if len(rest) > 0 {
+		sub = rest[0]
+	}
+	switch cmd {
+	case "status":
+		return cmdStatus(c, out)
+	case "federations":
+		return cmdFederations(c, out)
+	case "models":
+		switch sub {
+		case "", "list":
+			return cmdModels(c, out)
+		case "show":
+			return cmdModelShow(c, out, rest[1:])
+		case "download":
+			return cmdModelDownload(c, out, rest[1:])
+		case "diff":
+			return cmdModelDiff(c, out, rest[1:])
+		}
+	case "hospitals":
+		switch sub {
+		case "", "list":
+			return cmdHospitals(c, out)
+		case "history":
+			return cmdHospitalHistory(c, out, rest[1:])
+		}
+	case "round":
+		return cmdRound(c, out, rest)
+	case "settings":
+		switch sub {
+		case "":
+			return cmdSettings(c, out, nil)
+		case "set":
+			return cmdSettings(c, out, rest[1:])
+		}
+	case "audit":
+		if sub == "verify" {
+			return cmdAuditVerify(c, out, rest[1:])
+		}
+	default:
+		return fmt.Errorf("unknown command %q (run fedctl -h)", cmd)
+	}
+	return fmt.Errorf("unknown %s subcommand %q (run fedctl -h)", cmd, strings.Join(rest, " "))
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "fedctl: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeServer serves two model versions and records posted bodies.
+func fakeServer(t *testing.T, posted map[string]string) *httptest.Server {
+	t.Helper()
+	weights := map[int][]float64{1: {1, 2, 3, 4}, 2: {1, 2.5, 3, 1}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/federations/sepsis/models", func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("version")
+		if v == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"versions": []map[string]interface{}{
+				{"version": 1, "parameters": 4, "model_hash": modelHash(weights[1])},
+				{"version": 2, "parameters": 4, "model_hash": modelHash(weights[2]), "current": true},
+			}})
+			return
+		}
+		var n int
+		fmt.Sscan(v, &n)
+		ws, ok := weights[n]
+		if !ok {
+			http.Error(w, "no such version", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"version": n, "weights": ws, "parameters": len(ws), "model_hash": modelHash(ws)})
+	})
+	mux.HandleFunc("/federations/sepsis/admin/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer op-token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		b := new(bytes.Buffer)
+		b.ReadFrom(r.Body)
+		posted[strings.TrimPrefix(r.URL.Path, "/federations/sepsis/")] = b.String()
+		json.NewEncoder(w).Encode(map[string]interface{}{"quorum": 3, "q": 0.5})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestModelCommands(t *testing.T) {
+	srv := fakeServer(t, map[string]string{})
+	c := newClient(srv.URL, "", "sepsis")
+	var buf bytes.Buffer
+
+	out := &printer{w: &buf, json: true}
+	if err := run(c, out, []string{"models", "diff", "1", "latest", "-top", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	var d modelDiff
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if d.From != 1 || d.To != 2 || d.Changed != 2 || d.MaxIndex != 3 || math.Abs(d.L2Distance-math.Sqrt(9.25)) > 1e-12 ||
+		len(d.Top) != 1 || d.Top[0].Index != 3 || d.Top[0].Change != -3 {
+		t.Errorf("diff: %+v", d)
+	}
+
+	path := filepath.Join(t.TempDir(), "m.json")
+	if err := run(c, &printer{w: &buf}, []string{"models", "download", "2", "-out", path}); err != nil {
+		t.Fatal(err)
+	}
+	var m model
+	if b, err := os.ReadFile(path); err != nil || json.Unmarshal(b, &m) != nil || m.Version != 2 || len(m.Weights) != 4 {
+		t.Errorf("download: %+v (%v)", m, err)
+	}
+
+	if err := run(c, out, []string{"models", "show", "7"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing version: %v", err)
+	}
+}
+
+func TestRoundAndSettingsCommandsPost(t *testing.T) {
+	posted := map[string]string{}
+	srv := fakeServer(t, posted)
+	out := &printer{w: new(bytes.Buffer)}
+
+	if err := run(newClient(srv.URL, "", "sepsis"), out, []string{"round", "abort"}); err == nil {
+		t.Error("round abort without a token should fail")
+	}
+	c := newClient(srv.URL, "op-token", "sepsis")
+	if err := run(c, out, []string{"settings", "set", "q=0.5", "require_enrollment=true"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := posted["admin/settings"]; got != `{"q":0.5,"require_enrollment":true}` {
+		t.Errorf("settings body: %s", got)
+	}
+	if err := run(c, out, []string{"settings", "set", "q"}); err == nil {
+		t.Error("an assignment without = should fail")
+	}
+	if err := run(c, out, []string{"round", "extend", "-5"}); err == nil {
+		t.Error("a negative extension should fail before reaching the server")
+	}
+}
+
+func TestAuditVerify(t *testing.T) {
+	body, err := os.ReadFile("testdata/audit.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _ := os.ReadFile("testdata/audit.jsonl.head")
+	var h struct {
+		Seq  int    `json:"seq"`
+		Hash string `json:"hash"`
+	}
+	json.Unmarshal(head, &h)
+	headerValue := fmt.Sprintf("%d:%s", h.Seq, h.Hash)
+
+	r, err := verifyChain(body, headerValue, "federated_secret_2024")
+	if err != nil || !r.Intact || r.Entries != 4 {
+		t.Fatalf("intact log: %+v %v", r, err)
+	}
+	if err := run(newClient("http://unused", "", ""), &printer{w: new(bytes.Buffer)}, []string{"audit", "verify", "-file", "testdata/audit.jsonl", "-key", "federated_secret_2024"}); err != nil {
+		t.Errorf("verify file: %v", err)
+	}
+	if err := run(newClient("http://unused", "", ""), &printer{w: new(bytes.Buffer)}, []string{"audit", "verify", "-file", "testdata/audit.jsonl"}); err == nil {
+		t.Error("verify without the audit key should fail")
+	}
+
+	lines := strings.SplitAfter(string(body), "\n")
+	for name, tc := range map[string]struct {
+		body, head, key string
+		verified        int
+	}{
+		"edited":    {strings.Replace(string(body), `"H3"`, `"H4"`, 1), headerValue, "federated_secret_2024", 2},
+		"removed":   {lines[0] + lines[2] + lines[3], headerValue, "federated_secret_2024", 1},
+		"truncated": {strings.Join(lines[:3], ""), headerValue, "federated_secret_2024", 3},
+		"wrong key": {string(body), headerValue, "other", 0},
+	} {
+		r, err := verifyChain([]byte(tc.body), tc.head, tc.key)
+		if err != nil || r.Intact || r.Verified != tc.verified {
+			t.Errorf("%s: %+v %v", name, r, err)
+		}
+	}
+}
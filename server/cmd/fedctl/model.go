@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// modelHash matches the server's: SHA-256 over the little-endian float64 bits.
+func modelHash(weights []float64) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, w := range weights {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(w))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// paramChange is one parameter's move between two versions.
+type paramChange struct {
+	Index  int     `json:"index"`
+	From   float64 `json:"from"`
+	To     float64 `json:"to"`
+	Change float64 `json:"change"`
+}
+
+// modelDiff compares two versions of the same architecture.
+type modelDiff struct {
+	From           int           `json:"from"`
+	To             int           `json:"to"`
+	Parameters     int           `json:"parameters"`
+	Changed        int           `json:"changed"`
+	L2Distance     float64       `json:"l2_distance"`
+	RelativeChange float64       `json:"relative_change"` // ‖b−a‖ / ‖a‖
+	Cosine         float64       `json:"cosine_similarity"`
+	MaxAbsChange   float64       `json:"max_abs_change"`
+	MaxIndex       int           `json:"max_index"`
+	Top            []paramChange `json:"top"`
+}
+
+func diffModels(a, b *model, top int) (*modelDiff, error) {
+	if len(a.Weights) != len(b.Weights) {
+		return nil, fmt.Errorf("versions %d and %d have %d and %d parameters; only same-shaped models can be compared",
+			a.Version, b.Version, len(a.Weights), len(b.Weights))
+	}
+	d := &modelDiff{From: a.Version, To: b.Version, Parameters: len(a.Weights)}
+	var dist, normA, normB, dot float64
+	changes := make([]paramChange, 0, len(a.Weights))
+	for i := range a.Weights {
+		x, y := a.Weights[i], b.Weights[i]
+		delta := y - x
+		dist += delta * delta
+		normA += x * x
+		normB += y * y
+		dot += x * y
+		if delta != 0 {
+			d.Changed++
+			changes = append(changes, paramChange{Index: i, From: x, To: y, Change: delta})
+		}
+		if math.Abs(delta) > d.MaxAbsChange {
+			d.MaxAbsChange, d.MaxIndex = math.Abs(delta), i
+		}
+	}
+	d.L2Distance = math.Sqrt(dist)
+	if normA > 0 {
+		d.RelativeChange = d.L2Distance / math.Sqrt(normA)
+	}
+	if normA > 0 && normB > 0 {
+		d.Cosine = dot / (math.Sqrt(normA) * math.Sqrt(normB))
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return math.Abs(changes[i].Change) > math.Abs(changes[j].Change) })
+	if top < len(changes) {
+		changes = changes[:top]
+	}
+	d.Top = changes
+	return d, nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("fedctl "+name, flag.ContinueOnError)
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
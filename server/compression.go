@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"step01/hospital"
+)
+
+// Hospitals may send their update as a delta through a codec from
+// step-01/hospital (metadata.compression) instead of the full weights. The
+// server adds the decoded delta back onto the global model the hospital
+// trained on before anything else sees the packet, so aggregation, the
+// journal and replay only ever handle weights.
+
+// packetCodec is the codec a packet advertises: "none" for full weights.
+func packetCodec(packet UpdatePacket) string {
+	if len(packet.Metadata.Compression) == 0 {
+		return "none"
+	}
+	var c hospital.Compression
+	if err := json.Unmarshal(packet.Metadata.Compression, &c); err != nil || c.Codec == "" {
+		return "invalid"
+	}
+	return c.Codec
+}
+
+// decodeUpdate replaces a compressed packet's delta by the weights it
+// encodes. models returns the weights of a published version, as for an
+// aggregator. On failure reason is the rejection reason: invalid_delta for
+// a malformed delta, unknown_base when the version it was trained on is no
+// longer available.
+func decodeUpdate(packet *UpdatePacket, models func(int) []float64) (reason string, err error) {
+	compressed := len(packet.Metadata.Compression) > 0
+	switch {
+	case !compressed && packet.Delta == nil:
+		return "", nil
+	case !compressed:
+		return "invalid_delta", fmt.Errorf("packet carries a delta but advertises no compression")
+	case len(packet.Weights) > 0:
+		return "invalid_delta", fmt.Errorf("compressed packet also carries weights")
+	}
+
+	var c hospital.Compression
+	if err := json.Unmarshal(packet.Metadata.Compression, &c); err != nil {
+		return "invalid_delta", fmt.Errorf("compression: %v", err)
+	}
+	version := packet.Metadata.ModelVersion
+	base := aggregator{models: models}.model(version, *packet)
+	if base == nil {
+		return "unknown_base", fmt.Errorf("model version %d the delta was trained on is not available; send full weights or retrain on the current model", version)
+	}
+	delta, err := c.Decode(packet.Delta, len(base))
+	if err != nil {
+		return "invalid_delta", fmt.Errorf("model version %d: %v", version, err)
+	}
+	weights := make([]float64, len(base))
+	for i, d := range delta {
+		weights[i] = base[i] + d
+	}
+	packet.Weights, packet.Delta = weights, nil
+	return "", nil
+}
+
+// byteCounter counts the bytes read through it.
+type byteCounter struct {
+	r io.Reader
+	n int
+}
+
+func (b *byteCounter) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += n
+	return n, err
+}
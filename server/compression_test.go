@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"step01/hospital"
+)
+
+func TestSubmitDecodesCompressedDeltas(t *testing.T) {
+	f := setupAdmin(t)
+	f.roundManager.SetQuorum(3)
+	spec := hospital.ModelSpec{Type: hospital.ModelMLP, InputSize: 2, Hidden: []int{4}}
+	global, _ := hospital.NewClassifier(spec)
+
+	build := func(id string, version int, c *hospital.Compression) *hospital.UpdatePacket {
+		hp, err := hospital.BuildUpdatePacket(global, hospital.HospitalConfig{ID: id, ModelVersion: version, Compression: c}, simData(0), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hp
+	}
+	submit := func(hp *hospital.UpdatePacket) (int, string) {
+		b, _ := json.Marshal(hp)
+		rec := call(handleFederations, "POST", "/federations/default/submit_update", hospitalToken(hp.Metadata.HospitalID), string(b))
+		return rec.Code, rec.Body.String()
+	}
+
+	// Version 0 is the initial model of the packet's spec, which the hospital trained from.
+	full := build("H1", 0, nil)
+	if code, body := submit(build("H1", 0, &hospital.Compression{Codec: hospital.CodecQ4})); code != http.StatusOK {
+		t.Fatalf("q4: %d %s", code, body)
+	}
+	got := f.receivedUpdates[0]
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, w := range full.Weights {
+		d := w - global.Flatten()[i]
+		lo, hi = math.Min(lo, d), math.Max(hi, d)
+	}
+	for i, w := range full.Weights {
+		if math.Abs(got.Weights[i]-w) > (hi-lo)/15 {
+			t.Fatalf("weight %d decoded to %g, trained %g", i, got.Weights[i], w)
+		}
+	}
+	if got.Delta != nil || !verifySignature(got) {
+		t.Error("decoded packet keeps its delta or loses its signature")
+	}
+
+	// A delta against a version the server never published cannot be decoded.
+	if code, body := submit(build("H2", 5, &hospital.Compression{Codec: hospital.CodecTopK})); code != http.StatusConflict || !strings.Contains(body, "version 5") {
+		t.Errorf("unknown base: %d %s", code, body)
+	}
+	unadvertised := build("H2", 0, &hospital.Compression{Codec: hospital.CodecFloat32})
+	unadvertised.Metadata.Compression = nil
+	unadvertised.SignPacket()
+	if code, _ := submit(unadvertised); code != http.StatusBadRequest {
+		t.Errorf("delta without compression: %d", code)
+	}
+	forged := build("H2", 0, &hospital.Compression{Codec: hospital.CodecTopK})
+	forged.Delta = &hospital.EncodedDelta{Length: 1 << 26}
+	if code, body := submit(forged); code != http.StatusBadRequest || !strings.Contains(body, "the model") {
+		t.Errorf("forged delta length: %d %s", code, body)
+	}
+	if n := len(f.receivedUpdates); n != 1 {
+		t.Errorf("%d updates buffered, want 1", n)
+	}
+}
+
+func TestSimulationCompressesUpdates(t *testing.T) {
+	base := SimConfig{Rounds: 8, Quorum: 3, Seed: 1, Model: hospital.ModelMLP, Hidden: []int{32}, Hospitals: []SimHospital{
+		{ID: "H1", LatencyS: 1},
+		{ID: "H2", LatencyS: 2},
+		{ID: "H3", LatencyS: 3},
+	}}
+	final := func(c *hospital.Compression) RoundMetrics {
+		cfg := base
+		cfg.Hospitals = append([]SimHospital(nil), base.Hospitals...)
+		cfg.Compression = c
+		result := runSim(t, cfg)
+		if len(result.Rounds) != cfg.Rounds {
+			t.Fatalf("%+v: %d rounds", c, len(result.Rounds))
+		}
+		return result.Rounds[len(result.Rounds)-1]
+	}
+	full := final(nil)
+	for _, c := range []hospital.Compression{{Codec: hospital.CodecQ8}, {Codec: hospital.CodecTopK, TopK: 0.1}} {
+		m := final(&c)
+		if m.UploadBytes >= full.UploadBytes/2 {
+			t.Errorf("%s sent %d bytes, full weights %d", c.Codec, m.UploadBytes, full.UploadBytes)
+		}
+		if math.Abs(m.Loss-full.Loss) > 0.05 {
+			t.Errorf("%s final loss %.4f, full weights %.4f", c.Codec, m.Loss, full.Loss)
+		}
+	}
+	if _, err := NewSimulation(SimConfig{Compression: &hospital.Compression{Codec: "zip"}, Hospitals: base.Hospitals}); err == nil {
+		t.Error("unknown codec accepted")
+	}
+}
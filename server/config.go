@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the server's configuration file (-config server.json). Every
+// field is optional; absent fields keep their defaults (DefaultConfig).
+// Precedence, lowest first: defaults, file, FL_* environment variables,
+// command-line flags. The result is validated before the server starts.
+//
+// The top-level aggregation, rounds, labels, normalisation and enrollment
+// settings configure the default federation. Each entry of Federations adds
+// another one, starting from the top-level aggregation, rounds,
+// normalisation method and require_enrollment as overridden by the
+// environment and flags.
+type Config struct {
+	Port          string              `json:"port"`
+	Aggregation   AggregationConfig   `json:"aggregation"`
+	Rounds        RoundConfig         `json:"rounds"`
+	Staleness     StalenessConfig     `json:"staleness"`
+	Labels        LabelConfig         `json:"labels"`
+	Normalisation NormalisationConfig `json:"normalisation"`
+	Security      SecurityConfig      `json:"security"`
+	Storage       StorageConfig       `json:"storage"`
+	Federations   []FederationConfig  `json:"federations,omitempty"`
+
+	// federationsJSON keeps each federation as written in the file, so it can
+	// be derived again once env and flags have changed what it inherits.
+	federationsJSON []json.RawMessage
+}
+
+// FederationConfig is one federation's settings.
+type FederationConfig struct {
+	ID                string              `json:"id"` // used in /federations/{id}/… and packets' federation_id
+	Aggregation       AggregationConfig   `json:"aggregation"`
+	Rounds            RoundConfig         `json:"rounds"`
+	Staleness         StalenessConfig     `json:"staleness"`
+	Labels            LabelConfig         `json:"labels"`
+	Normalisation     NormalisationConfig `json:"normalisation"`
+	RequireEnrollment bool                `json:"require_enrollment"`
+	AllowedHospitals  []string            `json:"allowed_hospitals,omitempty"` // empty allows any hospital
+}
+
+// LabelConfig seeds the shared label vocabulary.
+type LabelConfig struct {
+	Initial    []string `json:"initial,omitempty"`
+	MultiLabel bool     `json:"multi_label"`
+}
+
+// NormalisationConfig configures the pre-training statistics round.
+type NormalisationConfig struct {
+	Method string   `json:"method"`           // minmax or zscore
+	Roster []string `json:"roster,omitempty"` // hospitals whose masked statistics must all arrive
+}
+
+// AggregationConfig selects the aggregation rule and its parameters.
+type AggregationConfig struct {
+	Algorithm string  `json:"algorithm"` // see aggregationAlgorithms
+	Q         float64 `json:"q"`         // QFedAvg fairness exponent
+	ProxMu    float64 `json:"prox_mu"`   // FedProx mu sent with the global model
+	Lipschitz float64 `json:"lipschitz"` // qffl's Lipschitz estimate L
+
+	Schedule QScheduleConfig `json:"q_schedule"` // how q changes from round to round
+}
+
+// RoundConfig is the round policy.
+type RoundConfig struct {
+	Quorum   int     `json:"quorum"`    // updates that close a round
+	TimeoutS float64 `json:"timeout_s"` // wait for quorum before a submission forces aggregation
+}
+
+// SecurityConfig covers packet freshness and who may take part.
+type SecurityConfig struct {
+	MaxTimestampAgeS  int64    `json:"max_timestamp_age_s"`
+	TokensFile        string   `json:"tokens_file,omitempty"`       // admin API principals; "" disables /admin and /audit
+	AuditKeyFile      string   `json:"audit_key_file,omitempty"`    // server-only HMAC key of the audit log; created on first start
+	RequireEnrollment bool     `json:"require_enrollment"`          // only operator-approved hospitals may submit
+	AllowedHospitals  []string `json:"allowed_hospitals,omitempty"` // empty allows any hospital
+}
+
+// StorageConfig holds the server's file paths. "" disables the journal,
+// contributions file or audit log. Federations other than the default derive their own journal,
+// update log and snapshot directory from these (see FederationStorage); the
+// audit log is shared.
+type StorageConfig struct {
+	Journal       string `json:"journal"`
+	Contributions string `json:"contributions"` // per-aggregation contribution records (contributions.go)
+	Audit         string `json:"audit"`
+	AuditHead     string `json:"audit_head,omitempty"` // "" means <audit>.head; keep it outside the log directory
+	UpdateLog     string `json:"update_log"`
+	SnapshotDir   string `json:"snapshot_dir"`
+}
+
+// aggregationAlgorithms lists the accepted aggregation.algorithm values.
+var aggregationAlgorithms = []string{AlgorithmQFedAvg, AlgorithmQFFL}
+
+// normalisationMethods lists the accepted normalisation.method values.
+var normalisationMethods = []string{"minmax", "zscore"}
+
+// federationIDChars are the characters allowed in a federation ID.
+const federationIDChars = "abcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// DefaultConfig returns the settings the server uses without a config file.
+func DefaultConfig() Config {
+	return Config{
+		Port:          "8080",
+		Aggregation:   AggregationConfig{Algorithm: AlgorithmQFedAvg, Q: 1.0, Lipschitz: defaultLipschitz, Schedule: QScheduleConfig{Type: QConstant}},
+		Rounds:        RoundConfig{Quorum: 2, TimeoutS: RoundTimeout.Seconds()},
+		Staleness:     StalenessConfig{Function: StalenessHyperbolic, Alpha: 1},
+		Normalisation: NormalisationConfig{Method: "minmax"},
+		Security:      SecurityConfig{MaxTimestampAgeS: 30, AuditKeyFile: "audit.key"},
+		Storage: StorageConfig{
+			Journal:       "journal.jsonl",
+			Contributions: "contributions.jsonl",
+			Audit:         "audit.jsonl",
+			UpdateLog:     "update_log.json",
+			SnapshotDir:   ".",
+		},
+	}
+}
+
+// LoadConfig reads a config file over the defaults. Unknown keys are errors,
+// so a misspelt setting cannot be silently ignored.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read config: %w", err)
+	}
+	if err := decodeStrict(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse config %s: %w", path, err)
+	}
+
+	var raw struct {
+		Federations []json.RawMessage `json:"federations"`
+	}
+	json.Unmarshal(b, &raw)
+	cfg.federationsJSON = raw.Federations
+	if err := cfg.deriveFederations(); err != nil {
+		return cfg, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// deriveFederations decodes each federation of the file again over the
+// top-level settings it inherits. It runs after loading and again after env
+// and flag overrides, which would otherwise reach only the default federation.
+func (c *Config) deriveFederations() error {
+	for i, fb := range c.federationsJSON {
+		fc := c.inherited()
+		if err := decodeStrict(fb, &fc); err != nil {
+			return fmt.Errorf("federations[%d]: %w", i, err)
+		}
+		c.Federations[i] = fc
+	}
+	return nil
+}
+
+func decodeStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// inherited is the starting point of every non-default federation.
+func (c Config) inherited() FederationConfig {
+	return FederationConfig{
+		Aggregation:       c.Aggregation,
+		Rounds:            c.Rounds,
+		Staleness:         c.Staleness,
+		Normalisation:     NormalisationConfig{Method: c.Normalisation.Method},
+		RequireEnrollment: c.Security.RequireEnrollment,
+	}
+}
+
+// DefaultFederation is the default federation's configuration.
+func (c Config) DefaultFederation() FederationConfig {
+	return FederationConfig{
+		ID:                defaultFederationID,
+		Aggregation:       c.Aggregation,
+		Rounds:            c.Rounds,
+		Staleness:         c.Staleness,
+		Labels:            c.Labels,
+		Normalisation:     c.Normalisation,
+		RequireEnrollment: c.Security.RequireEnrollment,
+		AllowedHospitals:  c.Security.AllowedHospitals,
+	}
+}
+
+// AllFederations is the default federation followed by the configured ones.
+func (c Config) AllFederations() []FederationConfig {
+	return append([]FederationConfig{c.DefaultFederation()}, c.Federations...)
+}
+
+// configEnv maps each FL_* environment variable to the setting it overrides.
+var configEnv = []struct {
+	name string
+	set  func(c *Config, v string) error
+}{
+	{"FL_PORT", func(c *Config, v string) error { c.Port = v; return nil }},
+	{"FL_AGGREGATION_ALGORITHM", func(c *Config, v string) error { c.Aggregation.Algorithm = v; return nil }},
+	{"FL_Q", func(c *Config, v string) error { return parseFloatInto(&c.Aggregation.Q, v) }},
+	{"FL_PROX_MU", func(c *Config, v string) error { return parseFloatInto(&c.Aggregation.ProxMu, v) }},
+	{"FL_LIPSCHITZ", func(c *Config, v string) error { return parseFloatInto(&c.Aggregation.Lipschitz, v) }},
+	{"FL_QUORUM", func(c *Config, v string) (err error) { c.Rounds.Quorum, err = strconv.Atoi(v); return }},
+	{"FL_ROUND_TIMEOUT_S", func(c *Config, v string) error { return parseFloatInto(&c.Rounds.TimeoutS, v) }},
+	{"FL_STALENESS_FUNCTION", func(c *Config, v string) error { c.Staleness.Function = v; return nil }},
+	{"FL_MAX_STALENESS", func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		c.Staleness.MaxStaleness = &n
+		return err
+	}},
+	{"FL_MAX_TIMESTAMP_AGE_S", func(c *Config, v string) (err error) {
+		c.Security.MaxTimestampAgeS, err = strconv.ParseInt(v, 10, 64)
+		return
+	}},
+	{"FL_TOKENS_FILE", func(c *Config, v string) error { c.Security.TokensFile = v; return nil }},
+	{"FL_REQUIRE_ENROLLMENT", func(c *Config, v string) (err error) {
+		c.Security.RequireEnrollment, err = strconv.ParseBool(v)
+		return
+	}},
+	{"FL_ALLOWED_HOSPITALS", func(c *Config, v string) error { c.Security.AllowedHospitals = splitList(v); return nil }},
+	{"FL_JOURNAL", func(c *Config, v string) error { c.Storage.Journal = v; return nil }},
+	{"FL_CONTRIBUTIONS", func(c *Config, v string) error { c.Storage.Contributions = v; return nil }},
+	{"FL_AUDIT", func(c *Config, v string) error { c.Storage.Audit = v; return nil }},
+	{"FL_AUDIT_HEAD", func(c *Config, v string) error { c.Storage.AuditHead = v; return nil }},
+	{"FL_AUDIT_KEY_FILE", func(c *Config, v string) error { c.Security.AuditKeyFile = v; return nil }},
+	{"FL_UPDATE_LOG", func(c *Config, v string) error { c.Storage.UpdateLog = v; return nil }},
+	{"FL_SNAPSHOT_DIR", func(c *Config, v string) error { c.Storage.SnapshotDir = v; return nil }},
+}
+
+func parseFloatInto(dst *float64, v string) (err error) {
+	*dst, err = strconv.ParseFloat(v, 64)
+	return
+}
+
+// splitList splits a comma-separated list, dropping blanks.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ApplyEnv overrides settings from FL_* variables found by lookup
+// (os.LookupEnv in the server) and returns the names it applied. Federations
+// inherit the overridden top-level settings unless their own entry sets them.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) ([]string, error) {
+	var applied []string
+	for _, e := range configEnv {
+		v, ok := lookup(e.name)
+		if !ok {
+			continue
+		}
+		if err := e.set(c, v); err != nil {
+			return applied, fmt.Errorf("%s=%q: %w", e.name, v, err)
+		}
+		applied = append(applied, e.name)
+	}
+	return applied, c.deriveFederations()
+}
+
+// ConfigError lists every problem found by Validate.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c Config) Validate() error {
+	var problems []string
+	bad := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		bad("port %q must be a number between 1 and 65535", c.Port)
+	}
+	if c.Security.MaxTimestampAgeS < 1 {
+		bad("security.max_timestamp_age_s must be at least 1, got %d", c.Security.MaxTimestampAgeS)
+	}
+	if c.Security.TokensFile != "" {
+		if _, err := os.Stat(c.Security.TokensFile); err != nil {
+			bad("security.tokens_file: %v", err)
+		}
+	}
+	if c.Storage.Audit != "" {
+		if c.Security.AuditKeyFile == "" {
+			bad("security.audit_key_file must name the file holding the audit log's key (or set storage.audit to \"\" to disable auditing)")
+		} else if _, err := readAuditKey(c.Security.AuditKeyFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			bad("security.audit_key_file: %v", err)
+		}
+	}
+	if c.Storage.UpdateLog == "" {
+		bad("storage.update_log must be set")
+	}
+	if c.Storage.SnapshotDir == "" {
+		bad("storage.snapshot_dir must be set (use \".\" for the working directory)")
+	} else if info, err := os.Stat(c.Storage.SnapshotDir); err == nil && !info.IsDir() {
+		bad("storage.snapshot_dir %s is not a directory", c.Storage.SnapshotDir)
+	}
+
+	c.DefaultFederation().validate("", "security.", c.Security.TokensFile != "", bad)
+	ids := map[string]bool{}
+	for i, fc := range c.Federations {
+		prefix := fmt.Sprintf("federations[%d].", i)
+		switch {
+		case fc.ID == "":
+			bad("%sid must be set", prefix)
+		case fc.ID == defaultFederationID:
+			bad("%sid %q is reserved for the top-level settings", prefix, fc.ID)
+		case strings.Trim(fc.ID, federationIDChars) != "":
+			bad("%sid %q may only use lower-case letters, digits, '-' and '_'", prefix, fc.ID)
+		case ids[fc.ID]:
+			bad("%sid %q is used twice", prefix, fc.ID)
+		default:
+			prefix = "federations[" + fc.ID + "]."
+		}
+		ids[fc.ID] = true
+		fc.validate(prefix, prefix, c.Security.TokensFile != "", bad)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// validate checks one federation's settings. Keys are reported under prefix,
+// except require_enrollment and allowed_hospitals, which live under
+// accessPrefix ("security." for the default federation).
+func (fc FederationConfig) validate(prefix, accessPrefix string, haveTokens bool, bad func(string, ...interface{})) {
+	if !contains(aggregationAlgorithms, fc.Aggregation.Algorithm) {
+		bad("%saggregation.algorithm %q must be one of %v", prefix, fc.Aggregation.Algorithm, aggregationAlgorithms)
+	}
+	if fc.Aggregation.Q < 0 || math.IsNaN(fc.Aggregation.Q) || math.IsInf(fc.Aggregation.Q, 0) {
+		bad("%saggregation.q must be a finite number ≥ 0, got %g", prefix, fc.Aggregation.Q)
+	}
+	if fc.Aggregation.ProxMu < 0 || math.IsNaN(fc.Aggregation.ProxMu) || math.IsInf(fc.Aggregation.ProxMu, 0) {
+		bad("%saggregation.prox_mu must be a finite number ≥ 0, got %g", prefix, fc.Aggregation.ProxMu)
+	}
+	if fc.Aggregation.Algorithm == AlgorithmQFFL && (!(fc.Aggregation.Lipschitz > 0) || math.IsInf(fc.Aggregation.Lipschitz, 0)) {
+		bad("%saggregation.lipschitz must be a finite number > 0 for qffl, got %g", prefix, fc.Aggregation.Lipschitz)
+	}
+	fc.Aggregation.Schedule.validate(prefix+"aggregation.q_schedule.", bad)
+	if fc.Rounds.Quorum < 1 {
+		bad("%srounds.quorum must be at least 1, got %d", prefix, fc.Rounds.Quorum)
+	}
+	if !(fc.Rounds.TimeoutS > 0) || math.IsInf(fc.Rounds.TimeoutS, 0) {
+		bad("%srounds.timeout_s must be > 0, got %g", prefix, fc.Rounds.TimeoutS)
+	}
+	fc.Staleness.validate(prefix+"staleness.", bad)
+	if !contains(normalisationMethods, fc.Normalisation.Method) {
+		bad("%snormalisation.method %q must be one of %v", prefix, fc.Normalisation.Method, normalisationMethods)
+	}
+	if fc.RequireEnrollment && !haveTokens {
+		bad("%srequire_enrollment needs security.tokens_file so operators can approve hospitals", accessPrefix)
+	}
+	seen := map[string]bool{}
+	for _, id := range fc.AllowedHospitals {
+		if strings.TrimSpace(id) == "" {
+			bad("%sallowed_hospitals contains an empty hospital ID", accessPrefix)
+		} else if seen[id] {
+			bad("%sallowed_hospitals lists %q twice", accessPrefix, id)
+		}
+		seen[id] = true
+	}
+	if len(fc.AllowedHospitals) > 0 && len(fc.AllowedHospitals) < fc.Rounds.Quorum {
+		bad("%srounds.quorum %d can never be met by %d allowed hospitals", prefix, fc.Rounds.Quorum, len(fc.AllowedHospitals))
+	}
+}
+
+// Effective configuration, as applied at startup, and where it came from.
+var (
+	activeConfig = DefaultConfig()
+	configSource = map[string]interface{}{}
+)
+
+// applyConfig installs a validated configuration: server-wide settings into
+// globals, and one Federation per configured federation. It runs once at
+// startup, before the listener opens.
+func applyConfig(c Config) error {
+	var list []*Federation
+	for _, fc := range c.AllFederations() {
+		storage := FederationStorage(c.Storage, fc.ID)
+		if err := os.MkdirAll(storage.SnapshotDir, 0755); err != nil {
+			return fmt.Errorf("storage.snapshot_dir: %w", err)
+		}
+		list = append(list, NewFederation(fc, storage))
+		log.Printf("[config] federation %s: algorithm=%s q=%g quorum=%d timeout=%gs",
+			fc.ID, fc.Aggregation.Algorithm, fc.Aggregation.Q, fc.Rounds.Quorum, fc.Rounds.TimeoutS)
+	}
+	registerFederations(list)
+
+	MaxTimestampAge = c.Security.MaxTimestampAgeS
+	auditPath, auditHeadPath = c.Storage.Audit, c.Storage.AuditHead
+	if auditPath != "" {
+		key, err := loadAuditKey(c.Security.AuditKeyFile)
+		if err != nil {
+			return fmt.Errorf("security.audit_key_file: %w", err)
+		}
+		auditKey = key
+	}
+	if c.Security.TokensFile != "" {
+		if err := loadTokens(c.Security.TokensFile); err != nil {
+			return err
+		}
+	}
+	activeConfig = c
+	log.Printf("[config] port=%s federations=%d max_timestamp_age=%ds", c.Port, len(list), c.Security.MaxTimestampAgeS)
+	return nil
+}
+
+// secondsToDuration converts a configured number of seconds.
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// effectiveConfig is activeConfig with the settings operators may change at
+// runtime (see /admin/settings) read back from the live federations.
+func effectiveConfig() Config {
+	c := activeConfig
+	c.Federations = append([]FederationConfig(nil), c.Federations...)
+	for _, f := range federationList() {
+		live := f.liveConfig()
+		if f.ID == defaultFederationID {
+			c.Aggregation, c.Rounds = live.Aggregation, live.Rounds
+			c.Security.RequireEnrollment = live.RequireEnrollment
+			continue
+		}
+		for i := range c.Federations {
+			if c.Federations[i].ID == f.ID {
+				c.Federations[i] = live
+			}
+		}
+	}
+	return c
+}
+
+// liveConfig is the federation's configuration with runtime changes applied.
+func (f *Federation) liveConfig() FederationConfig {
+	c := f.Config
+	_, c.Rounds.Quorum, _, _ = f.roundManager.Status()
+	f.mu.Lock()
+	c.Aggregation.Q = f.qParam
+	f.mu.Unlock()
+	f.aggregationMutex.Lock()
+	c.Aggregation.ProxMu = f.proxMu
+	f.aggregationMutex.Unlock()
+	f.accessMu.Lock()
+	c.RequireEnrollment = f.requireEnrollment
+	f.accessMu.Unlock()
+	return c
+}
+
+// handleConfig serves GET /config: the effective configuration and its sources.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"config":  effectiveConfig(),
+		"sources": configSource,
+	})
+}
+
+// loadServerConfig builds the startup configuration: defaults, then the file
+// at path (if any), then FL_* variables, then the command-line flags the
+// operator set explicitly (flagOverrides, keyed by flag name).
+func loadServerConfig(path string, flagOverrides map[string]func(*Config)) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		var err error
+		if cfg, err = LoadConfig(path); err != nil {
+			return cfg, err
+		}
+		configSource["file"] = path
+	}
+	env, err := cfg.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return cfg, err
+	}
+	if len(env) > 0 {
+		configSource["env"] = env
+	}
+	var flags []string
+	for name, set := range flagOverrides {
+		set(&cfg)
+		flags = append(flags, "-"+name)
+	}
+	if err := cfg.deriveFederations(); err != nil {
+		return cfg, err
+	}
+	if len(flags) > 0 {
+		sort.Strings(flags)
+		configSource["flags"] = flags
+	}
+	return cfg, cfg.Validate()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExampleConfigIsValid(t *testing.T) {
+	cfg, err := LoadConfig("server.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Security.AuditKeyFile = filepath.Join(t.TempDir(), "audit.key")
+	os.WriteFile(cfg.Security.AuditKeyFile, []byte(testAuditKey), 0600)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Rounds.Quorum != 3 || cfg.Aggregation.ProxMu != 0.01 || len(cfg.Security.AllowedHospitals) != 3 {
+		t.Errorf("example not loaded over defaults: %+v", cfg)
+	}
+	if cfg.Security.MaxTimestampAgeS != 30 || cfg.Storage.UpdateLog != "update_log.json" {
+		t.Errorf("defaults lost: %+v", cfg)
+	}
+
+	// Federations inherit the top-level settings they do not override.
+	if len(cfg.Federations) != 1 {
+		t.Fatalf("federations: %+v", cfg.Federations)
+	}
+	if fc := cfg.Federations[0]; fc.ID != "sepsis" || fc.Aggregation.Q != 2 || fc.Aggregation.ProxMu != 0.01 ||
+		fc.Rounds.Quorum != 2 || fc.Rounds.TimeoutS != 15 || len(fc.AllowedHospitals) != 2 {
+		t.Errorf("sepsis not inherited: %+v", fc)
+	}
+}
+
+func TestConfigValidationReportsEveryProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(path, []byte(`{
+		"port": "http",
+		"aggregation": {"algorithm": "fedsgd", "q": -1},
+		"rounds": {"quorum": 3, "timeout_s": 0},
+		"security": {"require_enrollment": true, "allowed_hospitals": ["H1", "H1"]},
+		"federations": [{"id": "default"}, {"id": "Sepsis!", "rounds": {"quorum": 0}}, {"id": "icu", "aggregation": {"q_schedule": {"type": "anneal"}}}, {"id": "icu"}]
+	}`), 0644)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce *ConfigError
+	if err := cfg.Validate(); !errors.As(err, &ce) {
+		t.Fatalf("expected ConfigError, got %v", err)
+	}
+	for _, want := range []string{"port", "aggregation.algorithm", "aggregation.q", "rounds.timeout_s",
+		"require_enrollment needs", "lists \"H1\" twice", "can never be met",
+		"federations[0].id \"default\" is reserved", "federations[1].id \"Sepsis!\"", "federations[1].rounds.quorum",
+		"federations[icu].aggregation.q_schedule.rounds",
+		"federations[3].id \"icu\" is used twice"} {
+		if !strings.Contains(ce.Error(), want) {
+			t.Errorf("missing problem %q in:\n%v", want, ce)
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"rounds": {"quorom": 3}}`), 0644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "quorom") {
+		t.Errorf("misspelt key accepted: %v", err)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	os.WriteFile(path, []byte(`{"port": "9000", "aggregation": {"q": 2}, "rounds": {"quorum": 4}}`), 0644)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"FL_Q": "0.5", "FL_ALLOWED_HOSPITALS": "H1, H2,,H3"}
+	applied, err := cfg.ApplyEnv(func(k string) (string, bool) { v, ok := env[k]; return v, ok })
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("env: %v %v", applied, err)
+	}
+	if cfg.Port != "9000" || cfg.Aggregation.Q != 0.5 || cfg.Rounds.Quorum != 4 ||
+		strings.Join(cfg.Security.AllowedHospitals, ",") != "H1,H2,H3" {
+		t.Errorf("file/env precedence: %+v", cfg)
+	}
+
+	env = map[string]string{"FL_QUORUM": "three"}
+	if _, err := cfg.ApplyEnv(func(k string) (string, bool) { v, ok := env[k]; return v, ok }); err == nil ||
+		!strings.Contains(err.Error(), "FL_QUORUM") {
+		t.Errorf("bad env value accepted: %v", err)
+	}
+}
+
+func TestEnvOverridesReachInheritingFederations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	os.WriteFile(path, []byte(`{
+		"aggregation": {"q": 2},
+		"federations": [{"id": "sepsis"}, {"id": "icu", "aggregation": {"q": 5}, "rounds": {"quorum": 4}}]
+	}`), 0644)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"FL_Q": "0.5", "FL_QUORUM": "3", "FL_PROX_MU": "0.1"}
+	if _, err := cfg.ApplyEnv(func(k string) (string, bool) { v, ok := env[k]; return v, ok }); err != nil {
+		t.Fatal(err)
+	}
+	sepsis, icu := cfg.Federations[0], cfg.Federations[1]
+	if cfg.Aggregation.Q != 0.5 || sepsis.Aggregation.Q != 0.5 || sepsis.Rounds.Quorum != 3 || sepsis.Aggregation.ProxMu != 0.1 {
+		t.Errorf("env not inherited: default %+v, sepsis %+v", cfg.Aggregation, sepsis)
+	}
+	// A federation's own settings still win over the environment.
+	if icu.Aggregation.Q != 5 || icu.Rounds.Quorum != 4 || icu.Aggregation.ProxMu != 0.1 {
+		t.Errorf("icu: %+v %+v", icu.Aggregation, icu.Rounds)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Contribution accounting: every aggregation records how much each hospital
+// shaped the new model version and how evenly the federation is serving its
+// hospitals. Records are kept in memory for the API and appended to the
+// federation's contributions file (StorageConfig.Contributions) as JSON Lines.
+
+// HospitalContribution is one hospital's part in an aggregation. Weight is
+// RawWeight normalised over the round, so a round's weights sum to 1. Under
+// qffl it is the hospital's share of the step from the global model.
+type HospitalContribution struct {
+	HospitalID   string   `json:"hospital_id"`
+	ModelVersion int      `json:"model_version"` // version the hospital trained on
+	DataSize     int      `json:"data_size"`
+	Loss         float64  `json:"loss"`                  // after local training
+	GlobalLoss   float64  `json:"global_loss,omitempty"` // of ModelVersion on the hospital's data, if reported
+	Accuracy     *float64 `json:"accuracy,omitempty"`    // of ModelVersion on the hospital's data, if reported
+	MacroF1      *float64 `json:"macro_f1,omitempty"`
+	Staleness    int      `json:"staleness"`
+	LossFactor   float64  `json:"loss_factor"`        // loss^q
+	Discount     float64  `json:"staleness_discount"` // discount from the federation's staleness policy
+	RawWeight    float64  `json:"raw_weight"`         // loss_factor × data_size × staleness_discount (× L under qffl)
+	Weight       float64  `json:"weight"`
+}
+
+// FairnessStats summarises how well the federated model serves each
+// hospital in one round. Losses are those of the global model each hospital
+// received, on its own data (global_loss); a hospital that did not report one
+// is counted with its post-training loss instead and listed in
+// LocalLossHospitals. Loss statistics are unweighted across hospitals;
+// accuracy statistics cover the hospitals that reported it and are nil when
+// none did.
+type FairnessStats struct {
+	Hospitals             int      `json:"hospitals"`
+	LossSource            string   `json:"loss_source"` // global, local, or mixed
+	LocalLossHospitals    []string `json:"local_loss_hospitals,omitempty"`
+	MeanLoss              float64  `json:"mean_loss"`
+	LossVariance          float64  `json:"loss_variance"`
+	WorstLoss             float64  `json:"worst_loss"`
+	WorstHospital         string   `json:"worst_hospital"`
+	BestLoss              float64  `json:"best_loss"`
+	WeightedLoss          float64  `json:"weighted_loss"` // what the aggregate optimises: Σ weight × loss
+	MeanAccuracy          *float64 `json:"mean_accuracy,omitempty"`
+	AccuracyVariance      *float64 `json:"accuracy_variance,omitempty"`
+	WorstAccuracy         *float64 `json:"worst_accuracy,omitempty"`
+	WorstAccuracyHospital string   `json:"worst_accuracy_hospital,omitempty"`
+}
+
+// ContributionRecord is the accounting of one aggregation.
+type ContributionRecord struct {
+	Round       int                    `json:"round"`
+	Version     int                    `json:"version"`      // model version produced
+	BaseVersion int                    `json:"base_version"` // version staleness was measured against
+	Q           float64                `json:"q"`
+	Time        time.Time              `json:"time"`
+	Hospitals   []HospitalContribution `json:"hospitals"`
+	Fairness    FairnessStats          `json:"fairness"`
+}
+
+// contributionRecord accounts for an aggregation of updates on top of version.
+func contributionRecord(round, version int, a aggregator, updates []UpdatePacket) ContributionRecord {
+	rec := ContributionRecord{Round: round, Version: version + 1, BaseVersion: version, Q: a.q, Time: time.Now().UTC()}
+	terms, total := a.terms(updates, version)
+	for k, p := range updates {
+		staleness := version - p.Metadata.ModelVersion
+		if staleness < 0 {
+			staleness = 0
+		}
+		c := HospitalContribution{
+			HospitalID:   p.Metadata.HospitalID,
+			ModelVersion: p.Metadata.ModelVersion,
+			DataSize:     p.Metadata.DataSize,
+			Loss:         p.Metadata.Loss,
+			GlobalLoss:   p.Metadata.GlobalLoss,
+			Staleness:    staleness,
+			LossFactor:   terms[k].lossFactor,
+			Discount:     terms[k].discount,
+			RawWeight:    terms[k].weight,
+		}
+		c.Accuracy, c.MacroF1 = reportedMetrics(p.Metadata.GlobalMetrics)
+		rec.Hospitals = append(rec.Hospitals, c)
+	}
+	for k := range rec.Hospitals {
+		rec.Hospitals[k].Weight = rec.Hospitals[k].RawWeight / total
+	}
+	rec.Fairness = fairnessStats(rec.Hospitals)
+	return rec
+}
+
+// reportedMetrics reads accuracy and macro-F1 from a packet's global_metrics.
+func reportedMetrics(raw json.RawMessage) (accuracy, macroF1 *float64) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var m struct {
+		Accuracy *float64 `json:"accuracy"`
+		MacroF1  *float64 `json:"macro_f1"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, nil
+	}
+	return m.Accuracy, m.MacroF1
+}
+
+// servedLoss is the loss fairness is measured by: the global model's on the
+// hospital's data, or the local loss when the hospital did not report it.
+func (h HospitalContribution) servedLoss() (loss float64, global bool) {
+	if h.GlobalLoss != 0 {
+		return h.GlobalLoss, true
+	}
+	return h.Loss, false
+}
+
+// fairnessStats computes the spread of loss and accuracy across hospitals.
+func fairnessStats(hospitals []HospitalContribution) FairnessStats {
+	s := FairnessStats{Hospitals: len(hospitals)}
+	if len(hospitals) == 0 {
+		return s
+	}
+	var losses, accuracies []float64
+	s.BestLoss = math.Inf(1)
+	for _, h := range hospitals {
+		loss, global := h.servedLoss()
+		if !global {
+			s.LocalLossHospitals = append(s.LocalLossHospitals, h.HospitalID)
+		}
+		losses = append(losses, loss)
+		s.WeightedLoss += h.Weight * loss
+		if loss > s.WorstLoss || s.WorstHospital == "" {
+			s.WorstLoss, s.WorstHospital = loss, h.HospitalID
+		}
+		s.BestLoss = math.Min(s.BestLoss, loss)
+		if h.Accuracy != nil {
+			accuracies = append(accuracies, *h.Accuracy)
+			if s.WorstAccuracy == nil || *h.Accuracy < *s.WorstAccuracy {
+				worst := *h.Accuracy
+				s.WorstAccuracy, s.WorstAccuracyHospital = &worst, h.HospitalID
+			}
+		}
+	}
+	switch len(s.LocalLossHospitals) {
+	case 0:
+		s.LossSource = "global"
+	case len(hospitals):
+		s.LossSource = "local"
+	default:
+		s.LossSource = "mixed"
+	}
+	s.MeanLoss, s.LossVariance = meanVariance(losses)
+	if len(accuracies) > 0 {
+		mean, variance := meanVariance(accuracies)
+		s.MeanAccuracy, s.AccuracyVariance = &mean, &variance
+	}
+	return s
+}
+
+// meanVariance returns the mean and population variance of v.
+func meanVariance(v []float64) (mean, variance float64) {
+	for _, x := range v {
+		mean += x
+	}
+	mean /= float64(len(v))
+	for _, x := range v {
+		variance += (x - mean) * (x - mean)
+	}
+	return mean, variance / float64(len(v))
+}
+
+// recordContribution keeps an aggregation's accounting, appends it to the
+// contributions file and updates the fairness metrics.
+func (f *Federation) recordContribution(rec ContributionRecord) {
+	f.contributionsMu.Lock()
+	f.contributions = append(f.contributions, rec)
+	f.contributionsMu.Unlock()
+
+	for _, h := range rec.Hospitals {
+		metricHospitalWeight.Set(h.Weight, f.ID, h.HospitalID)
+	}
+	metricLossVariance.Set(rec.Fairness.LossVariance, f.ID)
+	metricWorstLoss.Set(rec.Fairness.WorstLoss, f.ID)
+
+	if f.contributionsPath == "" {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err == nil {
+		err = appendLine(f.contributionsPath, line)
+	}
+	if err != nil {
+		log.Printf("[%s] ERROR recording contributions of version %d: %v", f.ID, rec.Version, err)
+	}
+}
+
+// TrajectoryPoint is a hospital's outcome in one aggregation.
+type TrajectoryPoint struct {
+	Round      int      `json:"round"`
+	Version    int      `json:"version"`
+	Loss       float64  `json:"loss"`
+	GlobalLoss float64  `json:"global_loss,omitempty"`
+	Accuracy   *float64 `json:"accuracy,omitempty"`
+	MacroF1    *float64 `json:"macro_f1,omitempty"`
+	Staleness  int      `json:"staleness"`
+	Weight     float64  `json:"weight"`
+}
+
+// HospitalReport is one hospital's contribution over every aggregation.
+// Share averages its weight over all aggregations, counting 0 for those it
+// missed; MeanWeight averages over the ones it took part in.
+type HospitalReport struct {
+	HospitalID string            `json:"hospital_id"`
+	Rounds     int               `json:"rounds"`
+	MeanWeight float64           `json:"mean_weight"`
+	Share      float64           `json:"share"`
+	FirstLoss  float64           `json:"first_loss"`
+	LastLoss   float64           `json:"last_loss"`
+	Trajectory []TrajectoryPoint `json:"trajectory"`
+}
+
+// FairnessTrend compares the first and latest aggregation.
+type FairnessTrend struct {
+	FromVersion        int     `json:"from_version"`
+	ToVersion          int     `json:"to_version"`
+	LossVarianceChange float64 `json:"loss_variance_change"` // negative: hospitals' losses drew closer
+	WorstLossChange    float64 `json:"worst_loss_change"`
+}
+
+// contributionReport builds the report over every recorded aggregation.
+func (f *Federation) contributionReport() map[string]interface{} {
+	f.contributionsMu.Lock()
+	records := append([]ContributionRecord{}, f.contributions...)
+	f.contributionsMu.Unlock()
+
+	byHospital := map[string]*HospitalReport{}
+	for _, rec := range records {
+		for _, h := range rec.Hospitals {
+			r := byHospital[h.HospitalID]
+			if r == nil {
+				r = &HospitalReport{HospitalID: h.HospitalID, FirstLoss: h.Loss}
+				byHospital[h.HospitalID] = r
+			}
+			r.Rounds++
+			r.MeanWeight += h.Weight
+			r.Share += h.Weight
+			r.LastLoss = h.Loss
+			r.Trajectory = append(r.Trajectory, TrajectoryPoint{Round: rec.Round, Version: rec.Version, Loss: h.Loss,
+				GlobalLoss: h.GlobalLoss, Accuracy: h.Accuracy, MacroF1: h.MacroF1, Staleness: h.Staleness, Weight: h.Weight})
+		}
+	}
+	hospitals := make([]HospitalReport, 0, len(byHospital))
+	for _, r := range byHospital {
+		r.MeanWeight /= float64(r.Rounds)
+		r.Share /= float64(len(records))
+		hospitals = append(hospitals, *r)
+	}
+	sort.Slice(hospitals, func(i, j int) bool { return hospitals[i].HospitalID < hospitals[j].HospitalID })
+
+	report := map[string]interface{}{
+		"federation":   f.ID,
+		"aggregations": len(records),
+		"rounds":       records,
+		"hospitals":    hospitals,
+	}
+	if len(records) > 0 {
+		first, last := records[0], records[len(records)-1]
+		report["trend"] = FairnessTrend{
+			FromVersion:        first.Version,
+			ToVersion:          last.Version,
+			LossVarianceChange: last.Fairness.LossVariance - first.Fairness.LossVariance,
+			WorstLossChange:    last.Fairness.WorstLoss - first.Fairness.WorstLoss,
+		}
+	}
+	return report
+}
+
+// handleContributions serves GET /contributions: every aggregation's
+// per-hospital weights and fairness statistics, and each hospital's trajectory.
+func (f *Federation) handleContributions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, f.contributionReport())
+}
+
+// handleContributionReport serves GET /contributions/report?format=csv|json
+// as a download: one CSV row per hospital per aggregation, or the full
+// report as JSON.
+func (f *Federation) handleContributionReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := "contributions_" + f.ID
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		f.contributionsMu.Lock()
+		records := append([]ContributionRecord{}, f.contributions...)
+		f.contributionsMu.Unlock()
+		if err := writeContributionCSV(w, records); err != nil {
+			log.Printf("[%s] ERROR writing contribution report: %v", f.ID, err)
+		}
+	case "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		writeJSON(w, f.contributionReport())
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q (use csv or json)", format), http.StatusBadRequest)
+	}
+}
+
+// globalLoss is h.GlobalLoss, or nil when the hospital did not report one.
+func globalLoss(h HospitalContribution) *float64 {
+	if h.GlobalLoss == 0 {
+		return nil
+	}
+	return &h.GlobalLoss
+}
+
+// contributionColumns is the header of the CSV report.
+var contributionColumns = []string{
+	"round", "version", "q", "hospital_id", "model_version", "data_size", "loss", "accuracy", "macro_f1",
+	"staleness", "loss_factor", "staleness_discount", "raw_weight", "weight",
+	"round_loss_variance", "round_worst_loss", "round_worst_hospital",
+	"global_loss", "round_loss_source",
+}
+
+func writeContributionCSV(w http.ResponseWriter, records []ContributionRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write(contributionColumns)
+	g := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	opt := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return g(*v)
+	}
+	for _, rec := range records {
+		for _, h := range rec.Hospitals {
+			cw.Write([]string{
+				strconv.Itoa(rec.Round), strconv.Itoa(rec.Version), g(rec.Q), h.HospitalID, strconv.Itoa(h.ModelVersion),
+				strconv.Itoa(h.DataSize), g(h.Loss), opt(h.Accuracy), opt(h.MacroF1),
+				strconv.Itoa(h.Staleness), g(h.LossFactor), g(h.Discount), g(h.RawWeight), g(h.Weight),
+				g(rec.Fairness.LossVariance), g(rec.Fairness.WorstLoss), rec.Fairness.WorstHospital,
+				opt(globalLoss(h)), rec.Fairness.LossSource,
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"step01/hospital"
+)
+
+func TestContributionsAccountForEveryWeightTerm(t *testing.T) {
+	f := setupAdmin(t)
+	f.contributionsPath = "contributions.jsonl"
+	f.qParam = 2
+
+	// H1: loss 0.5, 10 rows, current. H2: loss 1, 20 rows, one version stale.
+	f.currentVersion = 1
+	f.receivedUpdates = []UpdatePacket{
+		{Weights: []float64{1}, Metadata: Metadata{HospitalID: "H1", DataSize: 10, Loss: 0.5, ModelVersion: 1,
+			GlobalMetrics: json.RawMessage(`{"accuracy":0.9,"macro_f1":0.8,"per_class":[]}`)}},
+		{Weights: []float64{2}, Metadata: Metadata{HospitalID: "H2", DataSize: 20, Loss: 1, ModelVersion: 0,
+			GlobalMetrics: json.RawMessage(`{"accuracy":0.6,"macro_f1":0.5,"per_class":[]}`)}},
+	}
+	f.aggregateUpdates()
+
+	var report struct {
+		Aggregations int                  `json:"aggregations"`
+		Rounds       []ContributionRecord `json:"rounds"`
+		Hospitals    []HospitalReport     `json:"hospitals"`
+		Trend        FairnessTrend        `json:"trend"`
+	}
+	rec := call(handleFederations, "GET", "/federations/default/contributions", "audit-token", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || report.Aggregations != 1 {
+		t.Fatalf("report: %v\n%s", err, rec.Body)
+	}
+	h1, h2 := report.Rounds[0].Hospitals[0], report.Rounds[0].Hospitals[1]
+	// Raw weights 0.25×10 = 2.5 and 1×20/2 = 10 → shares 0.2 and 0.8.
+	if h1.LossFactor != 0.25 || h1.Discount != 1 || h1.RawWeight != 2.5 || math.Abs(h1.Weight-0.2) > 1e-12 ||
+		h2.Staleness != 1 || h2.Discount != 0.5 || h2.RawWeight != 10 || math.Abs(h2.Weight-0.8) > 1e-12 {
+		t.Errorf("contributions: %+v %+v", h1, h2)
+	}
+	fair := report.Rounds[0].Fairness
+	if fair.MeanLoss != 0.75 || fair.LossVariance != 0.0625 || fair.WorstHospital != "H2" || fair.BestLoss != 0.5 ||
+		math.Abs(fair.WeightedLoss-0.9) > 1e-12 || *fair.WorstAccuracy != 0.6 || fair.WorstAccuracyHospital != "H2" ||
+		math.Abs(*fair.AccuracyVariance-0.0225) > 1e-12 || fair.LossSource != "local" || len(fair.LocalLossHospitals) != 2 {
+		t.Errorf("fairness: %+v", fair)
+	}
+	if len(report.Hospitals) != 2 || report.Hospitals[1].Share != report.Hospitals[1].MeanWeight || len(report.Hospitals[1].Trajectory) != 1 ||
+		*report.Hospitals[1].Trajectory[0].Accuracy != 0.6 {
+		t.Errorf("hospitals: %+v", report.Hospitals)
+	}
+
+	rec = call(handleFederations, "GET", "/federations/default/contributions/report", "audit-token", "")
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(contributionColumns, ",") || rows[2][3] != "H2" || rows[2][13] != "0.8" {
+		t.Errorf("csv report (%v): %v", err, rows)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "contributions_default.csv") {
+		t.Errorf("Content-Disposition: %q", cd)
+	}
+	if rec := call(handleFederations, "GET", "/federations/default/contributions/report?format=xml", "audit-token", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: %d", rec.Code)
+	}
+
+	b, _ := os.ReadFile("contributions.jsonl")
+	var stored ContributionRecord
+	if err := json.Unmarshal(b, &stored); err != nil || stored.Version != 2 || len(stored.Hospitals) != 2 {
+		t.Errorf("contributions file: %v %s", err, b)
+	}
+}
+
+func TestFairnessUsesTheGlobalModelsLoss(t *testing.T) {
+	hospitals := []HospitalContribution{
+		{HospitalID: "H1", Loss: 0.1, GlobalLoss: 0.4, Weight: 0.5},
+		{HospitalID: "H2", Loss: 0.2, GlobalLoss: 0.8, Weight: 0.5},
+	}
+	fair := fairnessStats(hospitals)
+	if fair.LossSource != "global" || fair.LocalLossHospitals != nil || math.Abs(fair.MeanLoss-0.6) > 1e-12 ||
+		fair.WorstLoss != 0.8 || fair.BestLoss != 0.4 || math.Abs(fair.WeightedLoss-0.6) > 1e-12 {
+		t.Errorf("global fairness: %+v", fair)
+	}
+
+	// H2 predates global_loss: its local loss stands in, and the report says so.
+	hospitals[1].GlobalLoss = 0
+	fair = fairnessStats(hospitals)
+	if fair.LossSource != "mixed" || len(fair.LocalLossHospitals) != 1 || fair.LocalLossHospitals[0] != "H2" ||
+		fair.WorstHospital != "H1" || math.Abs(fair.MeanLoss-0.3) > 1e-12 {
+		t.Errorf("mixed fairness: %+v", fair)
+	}
+}
+
+func TestHospitalPacketsCarrySignedGlobalMetrics(t *testing.T) {
+	setupAdmin(t)
+	spec := hospital.ModelSpec{Type: "logistic", InputSize: 2}
+	global, err := hospital.NewClassifier(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := hospital.BuildUpdatePacket(global, hospital.HospitalConfig{ID: "H1"}, simData(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Metadata.GlobalMetrics == nil {
+		t.Fatal("packet has no global_metrics")
+	}
+	body, _ := json.Marshal(packet)
+	if rec := call(handleFederations, "POST", "/federations/default/submit_update", "h1-token", string(body)); rec.Code != http.StatusOK {
+		t.Fatalf("submit: %d %s", rec.Code, rec.Body)
+	}
+	def, _ := lookupFederation(defaultFederationID)
+	if acc, _ := reportedMetrics(def.receivedUpdates[0].Metadata.GlobalMetrics); acc == nil || *acc != packet.Metadata.GlobalMetrics.Accuracy {
+		t.Errorf("accuracy not carried: %v", acc)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The dashboard is a static page embedded in the binary (dashboard/) that
+// polls GET /federations/{id}/dashboard_state. Each federation keeps what it
+// shows in memory: recent rounds, recent rejections and every hospital's
+// latest activity. It is lost on restart; the journal and audit log remain
+// the durable record.
+
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// Limits on the in-memory history.
+const (
+	dashboardRounds     = 200
+	dashboardRejections = 100
+)
+
+// Round outcomes in the timeline.
+const (
+	OutcomeAggregated = "aggregated"
+	OutcomeAborted    = "aborted"
+	OutcomeRollback   = "rollback"
+)
+
+// RoundRecord is one closed round (or admin intervention) in the timeline.
+type RoundRecord struct {
+	Round         int                 `json:"round"`
+	Outcome       string              `json:"outcome"`
+	Version       int                 `json:"version"`                // model version published (0 for an abort)
+	BaseVersion   int                 `json:"base_version,omitempty"` // rollback: the version restored
+	Started       time.Time           `json:"started"`
+	Closed        time.Time           `json:"closed"`
+	DurationS     float64             `json:"duration_s"`
+	Q             float64             `json:"q,omitempty"`
+	MeanLoss      float64             `json:"mean_loss,omitempty"`      // weighted by aggregation weight
+	WorstLoss     float64             `json:"worst_loss,omitempty"`     // highest hospital loss
+	Late          int                 `json:"late,omitempty"`           // updates trained on an older version
+	MeanStaleness float64             `json:"mean_staleness,omitempty"` // versions behind, averaged over the updates
+	StaleWeight   float64             `json:"stale_weight,omitempty"`   // share of the aggregation weight carried by stale updates
+	StaleRejected int                 `json:"stale_rejected,omitempty"` // updates refused as too_stale while the round was open
+	Updates       []ParticipantRecord `json:"updates,omitempty"`
+	Discarded     []string            `json:"discarded,omitempty"`
+}
+
+// ParticipantRecord is one hospital's update within an aggregation.
+type ParticipantRecord struct {
+	HospitalID string  `json:"hospital_id"`
+	Loss       float64 `json:"loss"`
+	DataSize   int     `json:"data_size"`
+	Staleness  int     `json:"staleness"`             // versions behind the global model
+	RoundsLate int     `json:"rounds_late,omitempty"` // rounds behind the aggregating round
+	Discount   float64 `json:"staleness_discount"`
+	Weight     float64 `json:"weight"` // normalised aggregation weight; the round's weights sum to 1
+}
+
+// RejectionRecord is one refused submission.
+type RejectionRecord struct {
+	Time       time.Time `json:"time"`
+	HospitalID string    `json:"hospital_id,omitempty"`
+	Round      int       `json:"round"`
+	Code       string    `json:"code"`
+	Reason     string    `json:"reason"`
+}
+
+// HospitalActivity is a hospital's latest accepted update.
+type HospitalActivity struct {
+	LastSeen  time.Time `json:"last_seen"`
+	LastRound int       `json:"last_round"`
+	LastLoss  float64   `json:"last_loss"`
+	Updates   int       `json:"updates"`
+}
+
+// recordRound appends a closed round to the timeline.
+func (f *Federation) recordRound(rec RoundRecord) {
+	rec.DurationS = rec.Closed.Sub(rec.Started).Seconds()
+	f.historyMu.Lock()
+	defer f.historyMu.Unlock()
+	rec.StaleRejected, f.staleRejected = f.staleRejected, 0
+	f.history = append(f.history, rec)
+	if len(f.history) > dashboardRounds {
+		f.history = f.history[len(f.history)-dashboardRounds:]
+	}
+}
+
+// aggregationRecord describes an aggregation of updates on top of version.
+func aggregationRecord(round, version int, a aggregator, updates []UpdatePacket) RoundRecord {
+	rec := RoundRecord{Round: round, Outcome: OutcomeAggregated, Version: version + 1, Q: a.q}
+	terms, total := a.terms(updates, version)
+	for k, p := range updates {
+		staleness := stalenessOf(p, version)
+		share := terms[k].weight / total
+		if staleness > 0 {
+			rec.Late++
+			rec.StaleWeight += share
+		}
+		rec.MeanStaleness += float64(staleness) / float64(len(updates))
+		rec.MeanLoss += share * p.Metadata.Loss
+		if p.Metadata.Loss > rec.WorstLoss {
+			rec.WorstLoss = p.Metadata.Loss
+		}
+		rec.Updates = append(rec.Updates, ParticipantRecord{
+			HospitalID: p.Metadata.HospitalID,
+			Loss:       p.Metadata.Loss,
+			DataSize:   p.Metadata.DataSize,
+			Staleness:  staleness,
+			RoundsLate: round - p.Metadata.RoundID,
+			Discount:   terms[k].discount,
+			Weight:     share,
+		})
+	}
+	return rec
+}
+
+// recordRejection remembers a refused submission.
+func (f *Federation) recordRejection(packet UpdatePacket, code, reason string) {
+	f.historyMu.Lock()
+	defer f.historyMu.Unlock()
+	if code == "too_stale" {
+		f.staleRejected++
+	}
+	f.rejections = append(f.rejections, RejectionRecord{
+		Time:       time.Now().UTC(),
+		HospitalID: packet.Metadata.HospitalID,
+		Round:      packet.Metadata.RoundID,
+		Code:       code,
+		Reason:     reason,
+	})
+	if len(f.rejections) > dashboardRejections {
+		f.rejections = f.rejections[len(f.rejections)-dashboardRejections:]
+	}
+}
+
+// recordActivity notes an accepted update from a hospital.
+func (f *Federation) recordActivity(packet UpdatePacket) {
+	f.historyMu.Lock()
+	defer f.historyMu.Unlock()
+	a := f.activity[packet.Metadata.HospitalID]
+	if a == nil {
+		a = &HospitalActivity{}
+		f.activity[packet.Metadata.HospitalID] = a
+	}
+	a.LastSeen = time.Now().UTC()
+	a.LastRound = packet.Metadata.RoundID
+	a.LastLoss = packet.Metadata.Loss
+	a.Updates++
+}
+
+// dashboardState is everything the dashboard shows for a federation.
+func (f *Federation) dashboardState() map[string]interface{} {
+	round, expected, received, state := f.roundManager.Status()
+	submitted := f.roundManager.Submitted()
+
+	f.aggregationMutex.Lock()
+	version := f.currentVersion
+	f.aggregationMutex.Unlock()
+
+	// Every hospital the federation knows of: allowed, enrolled or seen.
+	f.historyMu.Lock()
+	known := map[string]bool{}
+	for id := range f.activity {
+		known[id] = true
+	}
+	f.accessMu.Lock()
+	for id := range f.allowedHospitals {
+		known[id] = true
+	}
+	enrollment := make(map[string]string, len(f.enrollment))
+	for id, status := range f.enrollment {
+		known[id] = true
+		enrollment[id] = status
+	}
+	f.accessMu.Unlock()
+
+	ids := make([]string, 0, len(known))
+	for id := range known {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	hospitals := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		h := map[string]interface{}{"hospital_id": id, "submitted": submitted[id]}
+		if status, ok := enrollment[id]; ok {
+			h["enrollment"] = status
+		}
+		if a := f.activity[id]; a != nil {
+			h["last_seen"], h["last_round"], h["last_loss"], h["updates"] = a.LastSeen, a.LastRound, a.LastLoss, a.Updates
+		}
+		hospitals = append(hospitals, h)
+	}
+	history := append([]RoundRecord{}, f.history...)
+	rejections := append([]RejectionRecord{}, f.rejections...)
+	f.historyMu.Unlock()
+
+	return map[string]interface{}{
+		"federation":    f.ID,
+		"model_version": version,
+		"round": map[string]interface{}{
+			"current_round":    round,
+			"expected_clients": expected,
+			"received_clients": received,
+			"state":            state.String(),
+			"paused":           f.roundManager.IsPaused(),
+			"age_s":            f.roundManager.RoundAge().Seconds(),
+		},
+		"hospitals":  hospitals,
+		"history":    history,
+		"rejections": rejections,
+	}
+}
+
+// handleDashboard serves GET /federations/{id}/dashboard_state.
+func (f *Federation) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, f.dashboardState())
+}
+
+// dashboardHandler serves the embedded page and its assets under /dashboard/.
+func dashboardHandler() http.Handler {
+	assets, _ := fs.Sub(dashboardAssets, "dashboard")
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(assets)))
+}
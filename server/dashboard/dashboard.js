@@ -0,0 +1,199 @@
+// Federation dashboard: polls /federations/{id}/dashboard_state and redraws.
+// No external libraries; charts are plain SVG.
+"use strict";
+
+const POLL_MS = 2000;
+const OUTCOME_COLOURS = { aggregated: "#3ebd93", aborted: "#e12d39", rollback: "#f0b429" };
+const PALETTE = ["#2680c2", "#3ebd93", "#f0b429", "#e12d39", "#9446ed", "#0c6b58", "#ef8e58", "#647acb", "#a2a5b9", "#c63d5e"];
+
+const select = document.getElementById("federation");
+let current = new URLSearchParams(location.search).get("federation") || "";
+
+// A server with a token file needs a bearer token for every read. Open the
+// page as /dashboard/#token=… once; the token is kept for the browser tab
+// and removed from the address bar.
+const fragmentToken = new URLSearchParams(location.hash.slice(1)).get("token");
+if (fragmentToken) {
+  sessionStorage.setItem("fl-token", fragmentToken);
+  history.replaceState(null, "", location.pathname + location.search);
+}
+
+function api(path) {
+  const token = sessionStorage.getItem("fl-token");
+  return fetch(path, token ? { headers: { Authorization: "Bearer " + token } } : {});
+}
+
+function el(id) { return document.getElementById(id); }
+
+function text(value) {
+  const span = document.createElement("span");
+  span.textContent = value;
+  return span.innerHTML;
+}
+
+function fmt(n, digits = 4) {
+  return typeof n === "number" ? n.toFixed(digits) : "–";
+}
+
+function ago(iso) {
+  if (!iso) return "–";
+  const s = Math.max(0, (Date.now() - new Date(iso).getTime()) / 1000);
+  return duration(s) + " ago";
+}
+
+function duration(s) {
+  if (s < 60) return s.toFixed(0) + " s";
+  if (s < 3600) return (s / 60).toFixed(1) + " min";
+  return (s / 3600).toFixed(1) + " h";
+}
+
+function svg(width, height, body) {
+  return `<svg width="${width}" height="${height}" viewBox="0 0 ${width} ${height}">${body}</svg>`;
+}
+
+function colourFor(id, ids) {
+  return PALETTE[ids.indexOf(id) % PALETTE.length];
+}
+
+async function loadFederations() {
+  const res = await api("../federations");
+  if (!res.ok) throw new Error(res.status + " " + res.statusText);
+  const data = await res.json();
+  const ids = (data.federations || []).map(f => f.id);
+  select.innerHTML = ids.map(id => `<option>${text(id)}</option>`).join("");
+  if (!ids.includes(current)) current = ids.includes("default") ? "default" : ids[0];
+  select.value = current;
+}
+
+select.addEventListener("change", () => {
+  current = select.value;
+  history.replaceState(null, "", "?federation=" + encodeURIComponent(current));
+  refresh();
+});
+
+async function refresh() {
+  if (!current) return;
+  try {
+    const res = await api("../federations/" + encodeURIComponent(current) + "/dashboard_state");
+    if (!res.ok) throw new Error(res.status + " " + res.statusText);
+    render(await res.json());
+    el("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    el("updated").textContent = "Update failed: " + err.message;
+  }
+}
+
+function render(d) {
+  const r = d.round;
+  el("round").textContent = r.current_round;
+  el("state").innerHTML = r.paused ? `<span class="paused">PAUSED</span>` : text(r.state);
+  el("received").textContent = `${r.received_clients} / ${r.expected_clients}`;
+  el("age").textContent = duration(r.age_s);
+  el("version").textContent = d.model_version;
+
+  renderHospitals(d.hospitals);
+  renderTimeline(d.history);
+  renderLoss(d.history.filter(h => h.outcome === "aggregated"));
+  renderWeights(d.history.filter(h => h.outcome === "aggregated"));
+  renderRejections(d.rejections);
+}
+
+function renderHospitals(hospitals) {
+  el("hospitals").innerHTML = hospitals.map(h => `<tr>
+    <td>${text(h.hospital_id)}</td>
+    <td>${h.submitted ? '<span class="ok">submitted</span>' : '<span class="missing">waiting</span>'}</td>
+    <td>${ago(h.last_seen)}</td>
+    <td class="num">${h.last_round ?? "–"}</td>
+    <td class="num">${fmt(h.last_loss)}</td>
+    <td class="num">${h.updates ?? 0}</td>
+    <td>${text(h.enrollment || "–")}</td>
+  </tr>`).join("") || `<tr><td colspan="7" class="muted">No hospitals yet</td></tr>`;
+}
+
+// renderTimeline draws one bar per closed round, its length the round's duration.
+function renderTimeline(history) {
+  if (!history.length) {
+    el("timeline").innerHTML = `<span class="muted">No rounds closed yet</span>`;
+    return;
+  }
+  const rows = history.slice(-30);
+  const longest = Math.max(...rows.map(h => h.duration_s), 1);
+  const width = 720, left = 150, row = 18;
+  let body = "";
+  rows.forEach((h, i) => {
+    const y = i * row;
+    const w = Math.max(2, (h.duration_s / longest) * (width - left - 90));
+    const label = h.outcome === "aggregated" ? `round ${h.round} → v${h.version}`
+      : h.outcome === "rollback" ? `round ${h.round} rollback → v${h.version}` : `round ${h.round} aborted`;
+    const late = h.late ? `, ${h.late} late (${fmt(100 * (h.stale_weight || 0), 0)}% of weight)` : "";
+    const refused = h.stale_rejected ? `, ${h.stale_rejected} too stale` : "";
+    const parts = h.updates ? `${h.updates.length} updates${late}${refused}` : "";
+    body += `<text x="0" y="${y + 12}">${text(label)}</text>`;
+    body += `<rect x="${left}" y="${y + 3}" width="${w}" height="${row - 6}" fill="${OUTCOME_COLOURS[h.outcome]}"><title>${text(new Date(h.closed).toLocaleString())}</title></rect>`;
+    body += `<text x="${left + w + 6}" y="${y + 12}">${duration(h.duration_s)} ${text(parts)}</text>`;
+  });
+  el("timeline").innerHTML = svg(width, rows.length * row, body);
+}
+
+// renderLoss plots the weighted mean and the worst hospital loss per version.
+function renderLoss(rounds) {
+  if (!rounds.length) {
+    el("loss").innerHTML = `<span class="muted">No aggregations yet</span>`;
+    return;
+  }
+  const width = 420, height = 220, pad = 36;
+  const maxLoss = Math.max(...rounds.map(h => h.worst_loss), 1e-9);
+  const x = i => pad + (rounds.length === 1 ? 0 : (i / (rounds.length - 1)) * (width - 2 * pad));
+  const y = v => height - pad - (v / maxLoss) * (height - 2 * pad);
+  const line = (key, colour) =>
+    `<polyline fill="none" stroke="${colour}" stroke-width="2" points="${rounds.map((h, i) => `${x(i)},${y(h[key])}`).join(" ")}"/>` +
+    rounds.map((h, i) => `<circle cx="${x(i)}" cy="${y(h[key])}" r="3" fill="${colour}"><title>v${h.version}: ${fmt(h[key])}</title></circle>`).join("");
+  let body = `<line x1="${pad}" y1="${height - pad}" x2="${width - pad}" y2="${height - pad}" stroke="#bcccdc"/>`;
+  body += `<line x1="${pad}" y1="${pad}" x2="${pad}" y2="${height - pad}" stroke="#bcccdc"/>`;
+  body += `<text x="2" y="${pad}">${fmt(maxLoss, 3)}</text><text x="2" y="${height - pad}">0</text>`;
+  body += `<text x="${pad}" y="${height - 12}">v${rounds[0].version}</text>`;
+  body += `<text x="${width - pad - 20}" y="${height - 12}">v${rounds[rounds.length - 1].version}</text>`;
+  body += line("worst_loss", "#e12d39") + line("mean_loss", "#2680c2");
+  el("loss").innerHTML = svg(width, height, body) +
+    `<div class="legend"><span><i style="background:#2680c2"></i>weighted mean</span><span><i style="background:#e12d39"></i>worst hospital</span></div>`;
+}
+
+// renderWeights stacks each version's normalised aggregation weights by hospital.
+function renderWeights(rounds) {
+  if (!rounds.length) {
+    el("weights").innerHTML = `<span class="muted">No aggregations yet</span>`;
+    return;
+  }
+  const recent = rounds.slice(-20);
+  const ids = [...new Set(recent.flatMap(h => h.updates.map(u => u.hospital_id)))].sort();
+  const width = 420, height = 220, pad = 24;
+  const bar = (width - 2 * pad) / recent.length;
+  let body = "";
+  recent.forEach((h, i) => {
+    let top = height - pad;
+    h.updates.forEach(u => {
+      const len = u.weight * (height - 2 * pad);
+      top -= len;
+      body += `<rect x="${pad + i * bar + 1}" y="${top}" width="${Math.max(1, bar - 2)}" height="${len}" fill="${colourFor(u.hospital_id, ids)}">` +
+        `<title>v${h.version} ${text(u.hospital_id)}: weight ${fmt(u.weight, 3)}, loss ${fmt(u.loss)}, staleness ${u.staleness}</title></rect>`;
+    });
+    body += `<text x="${pad + i * bar + 1}" y="${height - 8}">v${h.version}</text>`;
+  });
+  el("weights").innerHTML = svg(width, height, body) +
+    `<div class="legend">${ids.map(id => `<span><i style="background:${colourFor(id, ids)}"></i>${text(id)}</span>`).join("")}</div>`;
+}
+
+function renderRejections(rejections) {
+  el("rejections").innerHTML = rejections.slice().reverse().slice(0, 50).map(r => `<tr>
+    <td>${new Date(r.time).toLocaleTimeString()}</td>
+    <td>${text(r.hospital_id || "–")}</td>
+    <td class="num">${r.round}</td>
+    <td>${text(r.code)}</td>
+    <td>${text(r.reason)}</td>
+  </tr>`).join("") || `<tr><td colspan="5" class="muted">None</td></tr>`;
+}
+
+loadFederations().then(refresh, err => {
+  el("updated").textContent = "Could not list federations: " + err.message;
+});
+setInterval(refresh, POLL_MS);
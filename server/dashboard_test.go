@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDashboardStateTracksRoundsAndRejections(t *testing.T) {
+	def, _ := setupFederations(t)
+
+	call(handleFederations, "POST", "/federations/default/submit_update", "h1-token", signedUpdate(t, "", "H1"))
+	call(handleFederations, "POST", "/federations/default/submit_update", "h2-token", signedUpdate(t, "sepsis", "H2"))
+
+	rec := call(handleFederations, "GET", "/federations/default/dashboard_state", "op-token", "")
+	var d struct {
+		Hospitals []struct {
+			HospitalID string `json:"hospital_id"`
+			Submitted  bool   `json:"submitted"`
+			Updates    int    `json:"updates"`
+		} `json:"hospitals"`
+		Rejections []RejectionRecord `json:"rejections"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+		t.Fatalf("dashboard: %v\n%s", err, rec.Body)
+	}
+	if len(d.Hospitals) != 1 || d.Hospitals[0].HospitalID != "H1" || !d.Hospitals[0].Submitted || d.Hospitals[0].Updates != 1 {
+		t.Errorf("hospitals: %+v", d.Hospitals)
+	}
+	if len(d.Rejections) != 1 || d.Rejections[0].Code != "wrong_federation" || d.Rejections[0].HospitalID != "H2" {
+		t.Errorf("rejections: %+v", d.Rejections)
+	}
+
+	// Aggregate H1 (loss 0.5) with a one-version-stale H3 (loss 1.0).
+	def.roundManager.RecordUpdate("H3", 0)
+	def.mu.Lock()
+	def.receivedUpdates[0].Metadata.Loss = 0.5
+	def.receivedUpdates = append(def.receivedUpdates, UpdatePacket{Weights: []float64{3, 4}, Metadata: Metadata{HospitalID: "H3", DataSize: 20, Loss: 1, ModelVersion: -1}})
+	def.mu.Unlock()
+	def.aggregateUpdates()
+
+	history := def.dashboardState()["history"].([]RoundRecord)
+	if len(history) != 1 {
+		t.Fatalf("history: %+v", history)
+	}
+	h := history[0]
+	// Weights 0.5×10 and 1×20/2 → shares 1/3 and 2/3.
+	if h.Outcome != OutcomeAggregated || h.Version != 1 || h.Late != 1 || len(h.Updates) != 2 ||
+		math.Abs(h.Updates[0].Weight-1.0/3) > 1e-9 || math.Abs(h.MeanLoss-(0.5/3+2.0/3)) > 1e-9 || h.WorstLoss != 1 {
+		t.Errorf("round record: %+v", h)
+	}
+
+	call(requireRole(def, def.handleRoundControl, RoleOperator), "POST", "/admin/round/abort", "op-token", "")
+	if history := def.dashboardState()["history"].([]RoundRecord); len(history) != 2 || history[1].Outcome != OutcomeAborted {
+		t.Errorf("abort not in timeline: %+v", history)
+	}
+}
+
+func TestDashboardPageIsEmbedded(t *testing.T) {
+	for _, asset := range []string{"/dashboard/", "/dashboard/dashboard.js", "/dashboard/dashboard.css"} {
+		rec := call(dashboardHandler().ServeHTTP, "GET", asset, "", "")
+		if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+			t.Errorf("%s: %d", asset, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "https://") {
+			t.Errorf("%s references an external resource", asset)
+		}
+	}
+}
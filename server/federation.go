@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"step01/hospital"
+)
+
+// defaultFederationID names the federation configured by the top-level
+// settings. It is served on the unprefixed API (/submit_update, /global_model,
+// …) and is assumed for packets without a federation_id.
+const defaultFederationID = "default"
+
+// Federation is one study hosted by the server — its own global model and
+// schema, round policy, aggregation parameters, label vocabulary,
+// normalisation, participants and storage. Federations share nothing but the
+// process, the admin principals and the audit log.
+type Federation struct {
+	ID     string
+	Config FederationConfig // as configured at startup
+
+	// Updates buffered for the current round and the QFedAvg exponent with
+	// its schedule; guarded by mu. qBase is where the schedule started and
+	// qStep the aggregations since.
+	mu              sync.Mutex
+	receivedUpdates []UpdatePacket
+	qParam          float64
+	qSchedule       QScheduleConfig
+	qBase           float64
+	qStep           int
+
+	// Global model state; guarded by aggregationMutex.
+	aggregationMutex sync.Mutex
+	globalWeights    []float64
+	globalSpec       json.RawMessage
+	currentVersion   int
+	proxMu           float64 // FedProx mu distributed with the model (0 = plain FedAvg objective)
+
+	// roundManager is the single source of truth for the round lifecycle.
+	roundManager *RoundManager
+
+	// Shared label vocabulary (labels.go); guarded by labelMu.
+	labelMu         sync.Mutex
+	labelVocab      []string
+	labelMultiLabel bool
+	labelsFrozen    bool
+
+	// Pre-training statistics round (normalisation.go); guarded by statsMu.
+	statsMu          sync.Mutex
+	statsRoster      []string // hospitals whose masks must all be present; empty = first quorum
+	statsMethod      hospital.NormMethod
+	statsReceived    map[string]hospital.FeatureStats
+	statsMasked      bool              // whether the received statistics are masked; roster packets must all agree
+	maskKeys         map[string][]byte // roster member -> X25519 public mask key
+	globalNormaliser *hospital.Normaliser
+
+	// Latest data-quality summary per hospital (quality.go); guarded by qualityMu.
+	qualityMu      sync.Mutex
+	qualityReports map[string]json.RawMessage
+
+	// Participation (admin.go); guarded by accessMu. enrollment maps hospital
+	// ID to EnrollPending or EnrollApproved; allowedHospitals is the configured
+	// allow-list, nil allowing any hospital.
+	accessMu          sync.Mutex
+	enrollment        map[string]string
+	requireEnrollment bool
+	allowedHospitals  map[string]bool
+
+	// Rounds, rejections and hospital activity shown on the dashboard
+	// (dashboard.go), and the too_stale rejections of the open round;
+	// guarded by historyMu.
+	historyMu     sync.Mutex
+	history       []RoundRecord
+	rejections    []RejectionRecord
+	activity      map[string]*HospitalActivity
+	staleRejected int
+
+	// Every aggregation's contribution accounting (contributions.go);
+	// guarded by contributionsMu.
+	contributionsMu sync.Mutex
+	contributions   []ContributionRecord
+
+	// Storage.
+	journalPath       string // "" disables journaling
+	contributionsPath string // "" keeps contributions in memory only
+	updateLogPath     string
+	snapshotDir       string
+}
+
+// NewFederation creates a federation at version 0 from its configuration.
+// storage holds the federation's own paths (see FederationStorage).
+func NewFederation(c FederationConfig, storage StorageConfig) *Federation {
+	f := &Federation{
+		ID:                c.ID,
+		Config:            c,
+		qParam:            c.Aggregation.Q,
+		qSchedule:         c.Aggregation.Schedule,
+		qBase:             c.Aggregation.Q,
+		proxMu:            c.Aggregation.ProxMu,
+		roundManager:      NewRoundManager(c.Rounds.Quorum),
+		labelMultiLabel:   c.Labels.MultiLabel,
+		statsRoster:       c.Normalisation.Roster,
+		statsMethod:       hospital.NormMethod(c.Normalisation.Method),
+		statsReceived:     make(map[string]hospital.FeatureStats),
+		maskKeys:          make(map[string][]byte),
+		qualityReports:    make(map[string]json.RawMessage),
+		enrollment:        make(map[string]string),
+		activity:          make(map[string]*HospitalActivity),
+		requireEnrollment: c.RequireEnrollment,
+		journalPath:       storage.Journal,
+		contributionsPath: storage.Contributions,
+		updateLogPath:     storage.UpdateLog,
+		snapshotDir:       storage.SnapshotDir,
+	}
+	if c.Rounds.TimeoutS > 0 {
+		f.roundManager.Timeout = secondsToDuration(c.Rounds.TimeoutS)
+	}
+	if f.statsMethod == "" {
+		f.statsMethod = hospital.NormMinMax
+	}
+	if len(c.AllowedHospitals) > 0 {
+		f.allowedHospitals = make(map[string]bool, len(c.AllowedHospitals))
+		for _, id := range c.AllowedHospitals {
+			f.allowedHospitals[id] = true
+		}
+	}
+	f.mergeLabels(c.Labels.Initial)
+	return f
+}
+
+// FederationStorage derives a federation's file paths from the server's.
+// The default federation uses them unchanged; federation "sepsis" gets
+// journal.sepsis.jsonl, contributions.sepsis.jsonl, update_log.sepsis.json
+// and <snapshot_dir>/sepsis/.
+func FederationStorage(s StorageConfig, id string) StorageConfig {
+	if id == defaultFederationID {
+		return s
+	}
+	withID := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		ext := filepath.Ext(path)
+		return strings.TrimSuffix(path, ext) + "." + id + ext
+	}
+	return StorageConfig{
+		Journal:       withID(s.Journal),
+		Contributions: withID(s.Contributions),
+		Audit:         s.Audit,
+		UpdateLog:     withID(s.UpdateLog),
+		SnapshotDir:   filepath.Join(s.SnapshotDir, id),
+	}
+}
+
+// The federations hosted by this server, keyed by ID.
+var (
+	federationsMu sync.RWMutex
+	federations   = map[string]*Federation{}
+)
+
+// registerFederations replaces the hosted federations.
+func registerFederations(list []*Federation) {
+	federationsMu.Lock()
+	defer federationsMu.Unlock()
+	federations = make(map[string]*Federation, len(list))
+	for _, f := range list {
+		federations[f.ID] = f
+	}
+}
+
+// lookupFederation returns the federation with the given ID.
+func lookupFederation(id string) (*Federation, bool) {
+	federationsMu.RLock()
+	defer federationsMu.RUnlock()
+	f, ok := federations[id]
+	return f, ok
+}
+
+// federationList returns every hosted federation, sorted by ID.
+func federationList() []*Federation {
+	federationsMu.RLock()
+	defer federationsMu.RUnlock()
+	list := make([]*Federation, 0, len(federations))
+	for _, f := range federations {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// packetFederation is the federation a packet was signed for.
+func packetFederation(m Metadata) string {
+	if m.FederationID == "" {
+		return defaultFederationID
+	}
+	return m.FederationID
+}
+
+// routes maps each federation-scoped endpoint, relative to the federation's
+// base path, to its handler. The same table serves /federations/{id}/… and,
+// for the default federation, the unprefixed API. Once a token file is
+// configured, submissions need the submitting hospital's own principal
+// (requireHospital) and everything else a principal of f (requireReader).
+func (f *Federation) routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"submit_update":        requireHospital(f, f.handleSubmitUpdate),
+		"submit_stats":         requireHospital(f, f.handleSubmitStats),
+		"updates_count":        requireReader(f, f.handleUpdatesCount),
+		"global_model":         requireReader(f, f.handleGetGlobalModel),
+		"round_status":         requireReader(f, f.handleRoundStatus),
+		"label_vocab":          requireHospital(f, f.handleLabelVocab),
+		"mask_keys":            requireHospital(f, f.handleMaskKeys),
+		"normalisation":        requireReader(f, f.handleNormalisation),
+		"data_quality":         requireReader(f, f.handleDataQuality),
+		"dashboard_state":      requireReader(f, f.handleDashboard),
+		"contributions":        requireReader(f, f.handleContributions),
+		"contributions/report": requireReader(f, f.handleContributionReport),
+		"models":               requireReader(f, f.handleModels),
+
+		"audit":              requireRole(f, f.handleFederationAudit, RoleOperator, RoleAuditor),
+		"admin/settings":     requireRole(f, f.handleAdminSettings, RoleOperator, RoleAuditor),
+		"admin/round/pause":  requireRole(f, f.handleRoundControl, RoleOperator),
+		"admin/round/resume": requireRole(f, f.handleRoundControl, RoleOperator),
+		"admin/round/abort":  requireRole(f, f.handleRoundControl, RoleOperator),
+		"admin/round/extend": requireRole(f, f.handleRoundControl, RoleOperator),
+		"admin/enroll":       requireRole(f, f.handleEnroll, RoleHospital),
+		"admin/enrollments":  requireRole(f, f.handleEnrollments, RoleOperator, RoleAuditor),
+		"admin/rollback":     requireRole(f, f.handleRollback, RoleOperator),
+	}
+}
+
+// handleFederations serves GET /federations (a summary of every federation)
+// and dispatches /federations/{id}/{endpoint} to that federation.
+func handleFederations(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/federations"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// With a token file, callers see only the federations they may access.
+		var p Principal
+		if adminEnabled() {
+			var ok bool
+			if p, ok = authorise(nil, w, r); !ok {
+				return
+			}
+		}
+		var list []map[string]interface{}
+		for _, f := range federationList() {
+			if p.mayAccess(f.ID) {
+				list = append(list, f.summary())
+			}
+		}
+		writeJSON(w, map[string]interface{}{"federations": list})
+		return
+	}
+
+	id, endpoint, _ := strings.Cut(rest, "/")
+	f, ok := lookupFederation(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown federation %q", id), http.StatusNotFound)
+		return
+	}
+	if endpoint == "" {
+		instrument("/federations/{id}", requireReader(f, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, f.summary())
+		}))(w, r)
+		return
+	}
+	handler, ok := f.routes()[endpoint]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	instrument("/federations/{id}/"+endpoint, handler)(w, r)
+}
+
+// summary describes a federation for GET /federations.
+func (f *Federation) summary() map[string]interface{} {
+	round, expected, received, state := f.roundManager.Status()
+	f.aggregationMutex.Lock()
+	version := f.currentVersion
+	f.aggregationMutex.Unlock()
+	return map[string]interface{}{
+		"id":               f.ID,
+		"algorithm":        f.Config.Aggregation.Algorithm,
+		"model_version":    version,
+		"current_round":    round,
+		"expected_clients": expected,
+		"received_clients": received,
+		"state":            state.String(),
+	}
+}
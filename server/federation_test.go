@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"step01/hospital"
+)
+
+// setupFederations hosts the default federation and "sepsis", each with its
+// own storage, and adds an operator limited to sepsis.
+func setupFederations(t *testing.T) (def, sepsis *Federation) {
+	t.Helper()
+	def = setupAdmin(t)
+	c := DefaultConfig().DefaultFederation()
+	c.ID = "sepsis"
+	sepsis = NewFederation(c, FederationStorage(StorageConfig{Journal: "journal.jsonl", UpdateLog: "update_log.json", SnapshotDir: "."}, "sepsis"))
+	registerFederations([]*Federation{def, sepsis})
+
+	tokens := `[
+	  {"name": "alice", "role": "operator", "token": "op-token"},
+	  {"name": "carol", "role": "operator", "token": "sepsis-token", "federations": ["sepsis"]},
+	  {"name": "h1-bot", "role": "hospital", "hospital_id": "H1", "token": "h1-token"},
+	  {"name": "h2-bot", "role": "hospital", "hospital_id": "H2", "token": "h2-token"}
+	]`
+	os.WriteFile("tokens.json", []byte(tokens), 0644)
+	if err := loadTokens("tokens.json"); err != nil {
+		t.Fatal(err)
+	}
+	return def, sepsis
+}
+
+// signedUpdate builds a fresh, correctly signed packet for federation id.
+func signedUpdate(t *testing.T, id, hospitalID string) string {
+	t.Helper()
+	packet := UpdatePacket{
+		Weights:  []float64{1, 2},
+		Metadata: Metadata{HospitalID: hospitalID, FederationID: id, DataSize: 10, Timestamp: time.Now().Unix()},
+	}
+	var err error
+	if packet.Signature, err = signMetadata(packet.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(packet)
+	return string(b)
+}
+
+func TestFederationsAreIsolated(t *testing.T) {
+	def, sepsis := setupFederations(t)
+
+	if rec := call(handleFederations, "POST", "/federations/sepsis/submit_update", "h1-token", signedUpdate(t, "sepsis", "H1")); rec.Code != http.StatusOK {
+		t.Fatalf("sepsis update: %d %s", rec.Code, rec.Body)
+	}
+	// A packet signed for one federation cannot be replayed into another.
+	if rec := call(def.routes()["submit_update"], "POST", "/submit_update", "h2-token", signedUpdate(t, "sepsis", "H2")); rec.Code != http.StatusBadRequest {
+		t.Errorf("sepsis packet accepted by default: %d", rec.Code)
+	}
+	if rec := call(handleFederations, "POST", "/federations/sepsis/submit_update", "h2-token", signedUpdate(t, "", "H2")); rec.Code != http.StatusBadRequest {
+		t.Errorf("default packet accepted by sepsis: %d", rec.Code)
+	}
+	// Packets without a federation_id keep working on the unprefixed API.
+	if rec := call(def.routes()["submit_update"], "POST", "/submit_update", "h1-token", signedUpdate(t, "", "H1")); rec.Code != http.StatusOK {
+		t.Errorf("legacy update: %d %s", rec.Code, rec.Body)
+	}
+
+	if len(def.receivedUpdates) != 1 || len(sepsis.receivedUpdates) != 1 {
+		t.Errorf("buffered updates: default %d, sepsis %d", len(def.receivedUpdates), len(sepsis.receivedUpdates))
+	}
+	if _, err := os.Stat("journal.sepsis.jsonl"); err != nil {
+		t.Errorf("sepsis journal: %v", err)
+	}
+	if rec := call(handleFederations, "GET", "/federations/cardiac/round_status", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown federation: got %d", rec.Code)
+	}
+
+	entries, _ := readAudit(auditPath)
+	n, _ := exportAudit(auditPath, AuditFilter{Federation: "sepsis"}, &strings.Builder{})
+	if len(entries) != 4 || n != 2 {
+		t.Errorf("audit: %d entries, %d for sepsis", len(entries), n)
+	}
+}
+
+func TestPrincipalLimitedToFederations(t *testing.T) {
+	setupFederations(t)
+
+	if rec := call(handleFederations, "POST", "/federations/default/admin/settings", "sepsis-token", `{"q": 3}`); rec.Code != http.StatusForbidden {
+		t.Errorf("sepsis operator changing default: got %d", rec.Code)
+	}
+	if rec := call(handleFederations, "POST", "/federations/sepsis/admin/settings", "sepsis-token", `{"q": 3}`); rec.Code != http.StatusOK {
+		t.Errorf("sepsis operator changing sepsis: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(requireRole(nil, handleAuditAll, RoleOperator), "GET", "/audit", "sepsis-token", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("sepsis operator exporting every federation: got %d", rec.Code)
+	}
+	if rec := call(requireRole(nil, handleAuditAll, RoleOperator), "GET", "/audit", "op-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("unrestricted operator exporting: got %d", rec.Code)
+	}
+
+	def, _ := lookupFederation(defaultFederationID)
+	sepsis, _ := lookupFederation("sepsis")
+	if def.qParam != 1 || sepsis.qParam != 3 {
+		t.Errorf("q: default %g, sepsis %g", def.qParam, sepsis.qParam)
+	}
+}
+
+func TestFederationReadsAreLimitedToTheirPrincipals(t *testing.T) {
+	setupFederations(t)
+
+	for _, endpoint := range []string{"global_model", "contributions", "contributions/report", "dashboard_state", "data_quality", "models", "round_status", ""} {
+		path := strings.TrimSuffix("/federations/default/"+endpoint, "/")
+		if rec := call(handleFederations, "GET", path, "sepsis-token", ""); rec.Code != http.StatusForbidden {
+			t.Errorf("sepsis principal reading %s: got %d", path, rec.Code)
+		}
+		if rec := call(handleFederations, "GET", path, "", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous read of %s: got %d", path, rec.Code)
+		}
+		if rec := call(handleFederations, "GET", path, "op-token", ""); rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+			t.Errorf("unrestricted operator reading %s: got %d", path, rec.Code)
+		}
+	}
+	if rec := call(handleFederations, "GET", "/federations/sepsis/dashboard_state", "sepsis-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("sepsis principal reading sepsis: %d %s", rec.Code, rec.Body)
+	}
+
+	var listed struct {
+		Federations []struct{ ID string } `json:"federations"`
+	}
+	rec := call(handleFederations, "GET", "/federations", "sepsis-token", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed.Federations) != 1 || listed.Federations[0].ID != "sepsis" {
+		t.Errorf("federations listed to the sepsis principal: %v %s", err, rec.Body)
+	}
+
+	// Without a token file the read API stays open.
+	adminTokens = nil
+	if rec := call(handleFederations, "GET", "/federations/sepsis/round_status", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("open read without tokens: got %d", rec.Code)
+	}
+}
+
+func TestSubmissionsNeedTheHospitalsOwnPrincipal(t *testing.T) {
+	def, sepsis := setupFederations(t)
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "op-token": http.StatusForbidden, "h2-token": http.StatusForbidden} {
+		if rec := call(handleFederations, "POST", "/federations/sepsis/submit_update", token, signedUpdate(t, "sepsis", "H1")); rec.Code != want {
+			t.Errorf("H1's update sent with %q: got %d, want %d", token, rec.Code, want)
+		}
+	}
+	if len(sepsis.receivedUpdates) != 0 {
+		t.Fatalf("update recorded for another hospital: %+v", sepsis.receivedUpdates)
+	}
+	if rec := call(handleFederations, "POST", "/federations/sepsis/submit_update", "h1-token", signedUpdate(t, "sepsis", "H1")); rec.Code != http.StatusOK {
+		t.Errorf("H1's own update: %d %s", rec.Code, rec.Body)
+	}
+
+	def.statsRoster = []string{"H1", "H2"}
+	key, _ := hospital.NewMaskKey()
+	keyPacket, _ := hospital.NewMaskKeyPacket("H1", key)
+	b, _ := json.Marshal(keyPacket)
+	if rec := call(handleFederations, "POST", "/federations/default/mask_keys", "h2-token", string(b)); rec.Code != http.StatusForbidden {
+		t.Errorf("H1's mask key published by H2: %d", rec.Code)
+	}
+	stats, _ := hospital.NewStatsPacket("H1", hospital.FeatureStats{Count: []float64{1}, Sum: []float64{1}, SumSq: []float64{1}, Min: []float64{1}, Max: []float64{1}}, nil, nil)
+	b, _ = json.Marshal(stats)
+	if rec := call(handleFederations, "POST", "/federations/default/submit_stats", "h2-token", string(b)); rec.Code != http.StatusForbidden {
+		t.Errorf("H1's statistics submitted by H2: %d", rec.Code)
+	}
+	if rec := call(handleFederations, "GET", "/federations/default/mask_keys", "op-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("listing mask keys is a read: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRoutesRegisterWithoutConflicts(t *testing.T) {
+	setupFederations(t)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("route registration: %v", r)
+		}
+	}()
+	registerRoutes(http.NewServeMux())
+}
@@ -1,3 +1,7 @@
 module server
 
 go 1.21
+
+require step01 v0.0.0
+
+replace step01 => ../step-01
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// The journal is an append-only JSON Lines file, one per federation
+// (Federation.journalPath), holding every accepted packet verbatim and every
+// aggregation decision, enough to re-run the federation offline (-replay) and
+// prove it reaches the same model versions.
+var journalMu sync.Mutex
+
+// Journal entry types.
+const (
+	JournalUpdate    = "update"
+	JournalAggregate = "aggregate"
+	JournalDiscard   = "discard"
+	JournalRollback  = "rollback"
+)
+
+// JournalEntry is one line of the journal.
+//
+//	update:    Hash = SHA256(Packet), Packet = the accepted UpdatePacket as JSON
+//	aggregate: Updates = hashes in aggregation order, BaseVersion = global
+//	           version the staleness was measured against, ModelHash = modelHash(result)
+//	discard:   Updates = hashes of buffered packets dropped by an abort or rollback
+//	rollback:  the weights of BaseVersion republished as Version, ModelHash = their hash
+type JournalEntry struct {
+	Type string `json:"type"`
+	Time int64  `json:"time"`
+
+	Hash   string          `json:"hash,omitempty"`
+	Packet json.RawMessage `json:"packet,omitempty"`
+
+	Round       int              `json:"round,omitempty"`
+	Version     int              `json:"version,omitempty"`
+	BaseVersion int              `json:"base_version,omitempty"`
+	Q           float64          `json:"q,omitempty"`
+	Algorithm   string           `json:"algorithm,omitempty"` // "" for journals written before qffl: qfedavg
+	Lipschitz   float64          `json:"lipschitz,omitempty"` // qffl only
+	Staleness   *StalenessConfig `json:"staleness,omitempty"` // discount policy; absent in older journals: hyperbolic
+	Updates     []string         `json:"updates,omitempty"`
+	ModelHash   string           `json:"model_hash,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+}
+
+// packetHash returns the content hash of a packet and the bytes it covers.
+func packetHash(packet UpdatePacket) (string, []byte, error) {
+	body, err := json.Marshal(packet)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), body, nil
+}
+
+// modelHash is SHA256 over the little-endian IEEE-754 bits of the weights, so
+// two models hash equal only if every parameter is bit-for-bit identical.
+func modelHash(weights []float64) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, w := range weights {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(w))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// appendJournal writes one entry to the journal at path ("" disables it).
+// Failures are logged, never fatal: the journal must not take the federation down.
+func appendJournal(path string, e JournalEntry) {
+	if path == "" {
+		return
+	}
+	e.Time = time.Now().Unix()
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[journal] ERROR encoding %s entry: %v", e.Type, err)
+		return
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("[journal] ERROR opening %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("[journal] ERROR writing %s: %v", path, err)
+	}
+}
+
+// journalUpdate records an accepted packet and returns its hash.
+func (f *Federation) journalUpdate(packet UpdatePacket) string {
+	hash, body, err := packetHash(packet)
+	if err != nil {
+		log.Printf("[journal] ERROR hashing packet from %s: %v", packet.Metadata.HospitalID, err)
+		return ""
+	}
+	appendJournal(f.journalPath, JournalEntry{Type: JournalUpdate, Hash: hash, Packet: body})
+	return hash
+}
+
+// journalAggregation records the inputs and result of one aggregation.
+func (f *Federation) journalAggregation(round, baseVersion int, a aggregator, updates []UpdatePacket, weights []float64) {
+	e := JournalEntry{
+		Type: JournalAggregate, Round: round, Version: baseVersion + 1,
+		BaseVersion: baseVersion, Q: a.q, Algorithm: a.algorithm, ModelHash: modelHash(weights),
+		Staleness: &StalenessConfig{Function: a.staleness.Function, Alpha: a.staleness.Alpha, Grace: a.staleness.Grace},
+	}
+	if a.algorithm == AlgorithmQFFL {
+		e.Lipschitz = a.lipschitz
+	}
+	for _, p := range updates {
+		hash, _, err := packetHash(p)
+		if err != nil {
+			log.Printf("[journal] ERROR hashing packet from %s: %v", p.Metadata.HospitalID, err)
+			return
+		}
+		e.Updates = append(e.Updates, hash)
+	}
+	appendJournal(f.journalPath, e)
+}
+
+// journalAggregator is the rule an aggregate entry was made with, taking
+// deltas against the versions in models.
+func journalAggregator(e JournalEntry, models map[int][]float64) aggregator {
+	a := aggregator{algorithm: e.Algorithm, q: e.Q, lipschitz: e.Lipschitz, models: func(v int) []float64 { return models[v] }}
+	if e.Staleness != nil {
+		a.staleness = *e.Staleness
+	}
+	return a
+}
+
+// ReplayDivergence is the first point where a replay disagrees with the journal.
+type ReplayDivergence struct {
+	Line    int
+	Round   int
+	Version int
+	Reason  string
+}
+
+func (d *ReplayDivergence) Error() string {
+	return fmt.Sprintf("divergence at journal line %d (round %d, version %d): %s", d.Line, d.Round, d.Version, d.Reason)
+}
+
+// replayJournal re-runs every aggregation in the journal from the stored
+// packets and checks each resulting model hash. It stops at the first
+// divergence, returned as a *ReplayDivergence.
+func replayJournal(path string, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	packets := make(map[string]UpdatePacket)
+	available := make(map[string]int) // journaled but not yet aggregated, per hash
+	models := make(map[int][]float64) // replayed weights per version, for rollbacks
+	version, aggregations := 0, 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var e JournalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return &ReplayDivergence{Line: line, Round: -1, Version: version, Reason: "unreadable entry: " + err.Error()}
+		}
+		switch e.Type {
+		case JournalUpdate:
+			sum := sha256.Sum256(e.Packet)
+			if hex.EncodeToString(sum[:]) != e.Hash {
+				return &ReplayDivergence{Line: line, Round: -1, Version: version, Reason: "stored packet does not match its hash " + e.Hash}
+			}
+			var p UpdatePacket
+			if err := json.Unmarshal(e.Packet, &p); err != nil {
+				return &ReplayDivergence{Line: line, Round: -1, Version: version, Reason: "stored packet: " + err.Error()}
+			}
+			packets[e.Hash] = p
+			available[e.Hash]++
+
+		case JournalAggregate:
+			diverge := func(reason string, args ...interface{}) error {
+				return &ReplayDivergence{Line: line, Round: e.Round, Version: e.Version, Reason: fmt.Sprintf(reason, args...)}
+			}
+			if e.BaseVersion != version || e.Version != version+1 {
+				return diverge("expected aggregation of version %d → %d, journal has %d → %d", version, version+1, e.BaseVersion, e.Version)
+			}
+			var updates []UpdatePacket
+			for _, h := range e.Updates {
+				if available[h] == 0 {
+					return diverge("update %s was never journaled or was already aggregated", h)
+				}
+				available[h]--
+				updates = append(updates, packets[h])
+			}
+			if len(updates) == 0 {
+				return diverge("aggregation has no updates")
+			}
+			weights, err := journalAggregator(e, models).aggregate(updates, e.BaseVersion)
+			if err != nil {
+				return diverge("replayed aggregation failed: %v", err)
+			}
+			if got := modelHash(weights); got != e.ModelHash {
+				return diverge("model hash %s, journal recorded %s", got, e.ModelHash)
+			}
+			models[e.Version] = weights
+			version = e.Version
+			aggregations++
+			fmt.Fprintf(out, "round %d → version %d: %d updates, q=%g, model %s OK\n",
+				e.Round, e.Version, len(updates), e.Q, e.ModelHash[:16])
+
+		case JournalDiscard:
+			for _, h := range e.Updates {
+				if available[h] == 0 {
+					return &ReplayDivergence{Line: line, Round: -1, Version: version, Reason: "discarded update " + h + " is not pending"}
+				}
+				available[h]--
+			}
+
+		case JournalRollback:
+			diverge := func(reason string, args ...interface{}) error {
+				return &ReplayDivergence{Line: line, Round: e.Round, Version: e.Version, Reason: fmt.Sprintf(reason, args...)}
+			}
+			if e.Version != version+1 {
+				return diverge("expected rollback to publish version %d, journal has %d", version+1, e.Version)
+			}
+			weights, ok := models[e.BaseVersion]
+			if !ok {
+				return diverge("rollback to version %d, which was never replayed", e.BaseVersion)
+			}
+			if got := modelHash(weights); got != e.ModelHash {
+				return diverge("model hash %s, journal recorded %s", got, e.ModelHash)
+			}
+			models[e.Version] = weights
+			version = e.Version
+			fmt.Fprintf(out, "rollback → version %d: weights of version %d, model %s OK\n",
+				e.Version, e.BaseVersion, e.ModelHash[:16])
+
+		default:
+			return &ReplayDivergence{Line: line, Round: -1, Version: version, Reason: fmt.Sprintf("unknown entry type %q", e.Type)}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read journal: %w", err)
+	}
+	pending := 0
+	for _, n := range available {
+		pending += n
+	}
+	fmt.Fprintf(out, "Replayed %d aggregations: model version %d reproduced exactly (%d updates pending)\n",
+		aggregations, version, pending)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Shared label vocabulary for multi-class and multi-label federations
+// (Federation.labelVocab). Output unit k of every hospital's model must mean
+// the same outcome, so hospitals register the labels they see locally and all
+// train against the merged, sorted vocabulary. The vocabulary freezes as soon
+// as the first update is accepted, because from then on the output layer size
+// is fixed.
+
+// LabelRegistration is the body of POST /label_vocab.
+type LabelRegistration struct {
+	HospitalID string   `json:"hospital_id"`
+	Labels     []string `json:"labels"`
+}
+
+// mergeLabels adds labels to the vocabulary. Returns false if it is frozen.
+func (f *Federation) mergeLabels(labels []string) bool {
+	f.labelMu.Lock()
+	defer f.labelMu.Unlock()
+
+	if f.labelsFrozen {
+		return false
+	}
+	seen := make(map[string]bool, len(f.labelVocab))
+	for _, l := range f.labelVocab {
+		seen[l] = true
+	}
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l != "" && !seen[l] {
+			seen[l] = true
+			f.labelVocab = append(f.labelVocab, l)
+		}
+	}
+	sort.Strings(f.labelVocab)
+	return true
+}
+
+// freezeLabels fixes the vocabulary for the rest of the federation's life.
+func (f *Federation) freezeLabels() {
+	f.labelMu.Lock()
+	f.labelsFrozen = true
+	f.labelMu.Unlock()
+}
+
+// handleLabelVocab serves GET (read the shared vocabulary) and POST
+// (register a hospital's local labels before training starts). With a token
+// file only the hospital's own principal may register its labels.
+func (f *Federation) handleLabelVocab(w http.ResponseWriter, r *http.Request, p Principal) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var reg LabelRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if reg.HospitalID == "" {
+			http.Error(w, "Missing hospital_id", http.StatusBadRequest)
+			return
+		}
+		if !p.actsFor(reg.HospitalID) {
+			auditAdmin(f, p, "label_registration", reg.HospitalID, "principal is hospital "+p.HospitalID, nil)
+			http.Error(w, fmt.Sprintf("%s may not register labels for hospital %s", p.Name, reg.HospitalID), http.StatusForbidden)
+			return
+		}
+		if !f.mergeLabels(reg.Labels) {
+			http.Error(w, "Label vocabulary is frozen: training has already started", http.StatusConflict)
+			return
+		}
+		log.Printf("[labels] %s registered %d labels", reg.HospitalID, len(reg.Labels))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f.labelMu.Lock()
+	defer f.labelMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"labels":      f.labelVocab,
+		"multi_label": f.labelMultiLabel,
+		"frozen":      f.labelsFrozen,
+	})
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -8,137 +10,357 @@ import (
 	"math"
 	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"step01/hospital"
 )
 
 // Metadata carries everything the server needs to evaluate and weight
 // a hospital's update.
 type Metadata struct {
 	HospitalID   string  `json:"hospital_id"`
+	FederationID string  `json:"federation_id,omitempty"` // "" means the default federation
 	DataSize     int     `json:"data_size"`
 	Loss         float64 `json:"loss"`
 	RoundID      int     `json:"round_id"`
 	ModelVersion int     `json:"model_version"`
 	Timestamp    int64   `json:"timestamp"`
-}
 
-// UpdatePacket is the complete hand-off from a hospital to the server.
-type UpdatePacket struct {
-	Weights   []float64 `json:"weights"`
-	Metadata  Metadata  `json:"metadata"`
-	Signature string    `json:"signature"`
-}
+	// ModelSpec is the hospital's description of the weight layout. The server
+	// never interprets it; it is kept verbatim and redistributed with the model.
+	ModelSpec json.RawMessage `json:"model_spec,omitempty"`
 
-// In-memory storage for received updates.
-var (
-	receivedUpdates []UpdatePacket
-	mu              sync.Mutex
+	// DataQuality is an optional aggregate-only data-quality summary, kept
+	// verbatim per hospital and served from /data_quality.
+	DataQuality json.RawMessage `json:"data_quality,omitempty"`
 
-	// Global model state
-	globalWeights    []float64
-	currentVersion   int
-	aggregationMutex sync.Mutex
+	// GlobalMetrics is the hospital's evaluation of the global model it
+	// received, on its local data; kept verbatim for contribution accounting.
+	GlobalMetrics json.RawMessage `json:"global_metrics,omitempty"`
 
-	// roundManager is the single source of truth for round lifecycle.
-	// Quorum is set to 2: aggregation fires after 2 distinct hospitals submit.
-	roundManager = NewRoundManager(2)
+	// GlobalLoss is the loss of the global model the hospital received, on
+	// its local data; qffl's F_k.
+	GlobalLoss float64 `json:"global_loss,omitempty"`
 
-	// QFedAvg parameter (Turn 7)
-	qParam = 1.0
-)
+	// Compression names the codec of a delta sent instead of the weights;
+	// kept verbatim so the signature still verifies once it is decoded.
+	Compression json.RawMessage `json:"compression,omitempty"`
+}
+
+// UpdatePacket is the complete hand-off from a hospital to the server.
+// Delta, when present, is decoded into Weights on arrival (decodeUpdate).
+type UpdatePacket struct {
+	Weights   []float64              `json:"weights"`
+	Delta     *hospital.EncodedDelta `json:"delta,omitempty"`
+	Metadata  Metadata               `json:"metadata"`
+	Signature string                 `json:"signature"`
+}
 
 func main() {
+	configFlag := flag.String("config", os.Getenv("FL_CONFIG"), "JSON server configuration file (see server.example.json)")
 	portFlag := flag.String("port", "8080", "Server port")
+	proxMuFlag := flag.Float64("prox-mu", 0.0, "FedProx mu sent to hospitals with the global model")
+	labelsFlag := flag.String("labels", "", "Comma-separated initial label vocabulary (multi-class / multi-label)")
+	multiLabelFlag := flag.Bool("multi-label", false, "Samples may carry several labels at once")
+	normFlag := flag.String("norm", "minmax", "Global normalisation from the statistics round: minmax or zscore")
+	rosterFlag := flag.String("stats-roster", "", "Comma-separated hospitals whose masked statistics must all arrive")
+	simulateFlag := flag.String("simulate", "", "Run the in-process federation simulator with this JSON config and exit")
+	simulateOut := flag.String("simulate-out", "", "Write the simulator's per-round metrics and events to this JSON file")
+	qSweepFlag := flag.String("q-sweep", "", "With -simulate: comma-separated q values; run the simulation once per q and compare accuracy and fairness")
+	verbose := flag.Bool("v", false, "Keep server logging during -simulate")
+	journalFlag := flag.String("journal", DefaultConfig().Storage.Journal, "Append-only journal of accepted packets and aggregations (\"\" disables)")
+	replayFlag := flag.String("replay", "", "Re-run every aggregation in this journal, verify the model hashes and exit")
+	shapleyFlag := flag.String("shapley", "", "Value every hospital's updates in this journal by leave-one-out and Shapley, print the tables and exit")
+	shapleyValidation := flag.String("shapley-validation", "", "With -shapley: CSV validation set the coalition models are scored on")
+	shapleyRows := flag.String("shapley-rows", "", "With -shapley: validation rows start:end (0-based, end exclusive); default all")
+	shapleyMetric := flag.String("shapley-metric", "accuracy", "With -shapley: score coalitions by accuracy, macro_f1 or loss")
+	shapleyPermutations := flag.Int("shapley-permutations", 200, "With -shapley: orderings sampled per round (exact when the round has no more)")
+	shapleySeed := flag.Int64("shapley-seed", 1, "With -shapley: seed for sampling orderings")
+	shapleyOut := flag.String("shapley-out", "", "With -shapley: also write the per-round and cumulative analysis to this JSON file")
+	auditFlag := flag.String("audit", auditPath, "Hash-chained audit log (\"\" disables)")
+	auditHeadFlag := flag.String("audit-head", "", "Head file of the audit log (default <audit>.head); keep it outside the log directory")
+	auditKeyFlag := flag.String("audit-key-file", DefaultConfig().Security.AuditKeyFile, "File holding the audit log's HMAC key, known only to the server (created on first start)")
+	auditVerify := flag.String("audit-verify", "", "Verify the hash chain of this audit log and exit")
+	auditExport := flag.String("audit-export", "", "Write entries of this audit log as JSON Lines to stdout and exit")
+	auditFederation := flag.String("audit-federation", "", "With -audit-export: only entries for this federation")
+	auditHospital := flag.String("audit-hospital", "", "With -audit-export: only entries for this hospital")
+	auditSince := flag.String("audit-since", "", "With -audit-export: entries at or after this date (YYYY-MM-DD or RFC 3339)")
+	auditUntil := flag.String("audit-until", "", "With -audit-export: entries up to this date (a date includes the whole day)")
+	tokensFlag := flag.String("tokens", "", "JSON file of admin API principals (name, role, token or token_sha256); enables /admin and /audit")
+	enrollmentFlag := flag.Bool("require-enrollment", false, "Only accept updates from hospitals an operator has approved")
 	flag.Parse()
 
-	// POST /submit_update
-	http.HandleFunc("/submit_update", handleSubmitUpdate)
+	if *auditVerify != "" {
+		key, err := readAuditKey(*auditKeyFlag)
+		if err != nil {
+			log.Fatalf("-audit-key-file: %v", err)
+		}
+		auditKey = key
+		n, err := verifyAudit(*auditVerify, *auditHeadFlag)
+		if err != nil {
+			log.Fatalf("audit: %v (%d entries verified before the break)", err, n)
+		}
+		fmt.Printf("Audit log %s verified: %d entries, chain intact\n", *auditVerify, n)
+		return
+	}
+	if *auditExport != "" {
+		since, err := parseAuditTime(*auditSince, false)
+		if err != nil {
+			log.Fatalf("-audit-since: %v", err)
+		}
+		until, err := parseAuditTime(*auditUntil, true)
+		if err != nil {
+			log.Fatalf("-audit-until: %v", err)
+		}
+		if _, err := exportAudit(*auditExport, AuditFilter{Federation: *auditFederation, HospitalID: *auditHospital, Since: since, Until: until}, os.Stdout); err != nil {
+			log.Fatalf("audit export: %v", err)
+		}
+		return
+	}
+
+	if *replayFlag != "" {
+		if err := replayJournal(*replayFlag, os.Stdout); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+		return
+	}
 
-	// GET /updates_count
-	http.HandleFunc("/updates_count", handleUpdatesCount)
+	if *shapleyFlag != "" {
+		opts := ShapleyOptions{Metric: *shapleyMetric, Permutations: *shapleyPermutations, Seed: *shapleySeed}
+		if err := runShapley(*shapleyFlag, *shapleyValidation, *shapleyRows, *shapleyOut, opts); err != nil {
+			log.Fatalf("shapley: %v", err)
+		}
+		return
+	}
 
-	// GET /global_model
-	http.HandleFunc("/global_model", handleGetGlobalModel)
+	if *simulateFlag != "" {
+		sweep, err := parseQSweep(*qSweepFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runSimulation(*simulateFlag, *simulateOut, sweep, *verbose); err != nil {
+			log.Fatalf("simulation: %v", err)
+		}
+		return
+	}
 
-	// GET /round_status — inspect current round state (Turn 4 addition)
-	http.HandleFunc("/round_status", handleRoundStatus)
+	// Flags the operator set explicitly override the file and environment.
+	overrides := map[string]func(*Config){}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			overrides[f.Name] = func(c *Config) { c.Port = *portFlag }
+		case "prox-mu":
+			overrides[f.Name] = func(c *Config) { c.Aggregation.ProxMu = *proxMuFlag }
+		case "journal":
+			overrides[f.Name] = func(c *Config) { c.Storage.Journal = *journalFlag }
+		case "audit":
+			overrides[f.Name] = func(c *Config) { c.Storage.Audit = *auditFlag }
+		case "audit-head":
+			overrides[f.Name] = func(c *Config) { c.Storage.AuditHead = *auditHeadFlag }
+		case "audit-key-file":
+			overrides[f.Name] = func(c *Config) { c.Security.AuditKeyFile = *auditKeyFlag }
+		case "tokens":
+			overrides[f.Name] = func(c *Config) { c.Security.TokensFile = *tokensFlag }
+		case "require-enrollment":
+			overrides[f.Name] = func(c *Config) { c.Security.RequireEnrollment = *enrollmentFlag }
+		case "labels":
+			overrides[f.Name] = func(c *Config) { c.Labels.Initial = splitList(*labelsFlag) }
+		case "multi-label":
+			overrides[f.Name] = func(c *Config) { c.Labels.MultiLabel = *multiLabelFlag }
+		case "norm":
+			overrides[f.Name] = func(c *Config) { c.Normalisation.Method = *normFlag }
+		case "stats-roster":
+			overrides[f.Name] = func(c *Config) { c.Normalisation.Roster = splitList(*rosterFlag) }
+		}
+	})
+	cfg, err := loadServerConfig(*configFlag, overrides)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := applyConfig(cfg); err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	port := ":" + *portFlag
-	fmt.Printf("Server starting on port %s...\n", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
+	openAudit()
+
+	registerRoutes(http.DefaultServeMux)
+
+	// Merged refusal counts are written as their interval ends, and whatever
+	// is still pending when the server is stopped.
+	stop := make(chan struct{})
+	go refusals.flushEvery(authRefusalFlush, stop)
+	server := &http.Server{Addr: ":" + cfg.Port}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	fmt.Printf("Server starting on port %s...\n", server.Addr)
+	err = server.ListenAndServe()
+	close(stop)
+	refusals.flushAll()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
 
-func handleSubmitUpdate(w http.ResponseWriter, r *http.Request) {
+// registerRoutes installs every endpoint on mux. The default federation is
+// served on the unprefixed API; every federation, the default included, also
+// under /federations/{id}/….
+func registerRoutes(mux *http.ServeMux) {
+	defaultFed, _ := lookupFederation(defaultFederationID)
+	for endpoint, handler := range defaultFed.routes() {
+		if endpoint != "audit" {
+			mux.HandleFunc("/"+endpoint, instrument("/"+endpoint, handler))
+		}
+	}
+	mux.HandleFunc("/federations", instrument("/federations", handleFederations))
+	mux.HandleFunc("/federations/", handleFederations) // instrumented per endpoint
+
+	// GET /audit — the whole hash-chained audit log, filterable by federation,
+	// hospital and date range
+	mux.HandleFunc("/audit", instrument("/audit", requireRole(nil, handleAuditAll, RoleOperator, RoleAuditor)))
+
+	// GET /admin/whoami — the authenticated principal
+	mux.HandleFunc("/admin/whoami", instrument("/admin/whoami", requireRole(nil, handleWhoAmI, RoleOperator, RoleAuditor, RoleHospital)))
+
+	// GET /config — effective configuration and where it came from
+	mux.HandleFunc("/config", instrument("/config", handleConfig))
+
+	// GET /metrics — Prometheus text exposition
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	// GET /dashboard/ — embedded monitoring page
+	mux.Handle("/dashboard/", dashboardHandler())
+	mux.Handle("/dashboard", http.RedirectHandler("/dashboard/", http.StatusMovedPermanently))
+}
+
+func (f *Federation) handleSubmitUpdate(w http.ResponseWriter, r *http.Request, p Principal) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// The body is JSON or the binary wire format, optionally gzipped.
 	var packet UpdatePacket
-	if err := json.NewDecoder(r.Body).Decode(&packet); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+	limit := f.maxUpdateBody()
+	body := &byteCounter{r: http.MaxBytesReader(w, r.Body, limit)}
+	if reason, status, err := readPacket(r, body, limit, &packet); err != nil {
+		f.rejectUpdate(w, packet, reason, err.Error(), status)
 		return
 	}
 
 	// ── Security pipeline ─────────────────────────────────────────────────
-	// Step 1: Verify cryptographic signature.
-	if !verifySignature(packet) {
-		http.Error(w, "Invalid packet signature", http.StatusForbidden)
+	// Step 1: Every hospital holds SecretKey, so with a token file the
+	// signature alone does not say who sent the packet: the caller's
+	// principal must be the hospital it names.
+	if !p.actsFor(packet.Metadata.HospitalID) {
+		f.rejectUpdate(w, packet, "wrong_hospital", fmt.Sprintf("%s may not submit for hospital %s", p.Name, packet.Metadata.HospitalID), http.StatusForbidden)
 		return
 	}
 
-	// Step 2: Validate timestamp freshness.
-	if !validateTimestamp(packet) {
-		http.Error(w, "Packet timestamp is stale or invalid", http.StatusRequestTimeout)
+	// Step 2: Only allowed hospitals may contribute, and only enrolled ones
+	// when enrollment is enforced. Enrollment needs a token file, so the
+	// hospital checked here is the caller's own principal (step 1).
+	if !f.isAllowed(packet.Metadata.HospitalID) {
+		f.rejectUpdate(w, packet, "not_allowed", fmt.Sprintf("Hospital %s is not allowed in this federation", packet.Metadata.HospitalID), http.StatusForbidden)
+		return
+	}
+	if !f.isEnrolled(packet.Metadata.HospitalID) {
+		f.rejectUpdate(w, packet, "not_enrolled", fmt.Sprintf("Hospital %s is not enrolled in this federation", packet.Metadata.HospitalID), http.StatusForbidden)
+		return
+	}
+
+	// Step 3: Signature, federation, timestamp, delta decoding, required
+	// fields and max_staleness, exactly as the simulator checks them.
+	codec := packetCodec(packet)
+	if rej := f.updateCheck().check(&packet); rej != nil {
+		f.rejectUpdate(w, packet, rej.code, rej.err.Error(), rej.status)
+		return
+	}
+
+	if err := f.checkQFFLBase(packet); err != nil {
+		f.rejectUpdate(w, packet, "no_base_model", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if f.roundManager.IsPaused() {
+		f.rejectUpdate(w, packet, "paused", "Round is paused by an operator; retry later", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Step 3: Validate required fields.
-	if len(packet.Weights) == 0 ||
-		packet.Metadata.HospitalID == "" ||
-		packet.Metadata.DataSize <= 0 {
-		http.Error(w, "Missing or invalid required fields", http.StatusBadRequest)
+	// Step 4: The server is model-agnostic, but every update must share the
+	// shape of the global model (or of the round's first update). The check,
+	// RoundManager's record and storing the packet happen under one hold of
+	// f.mu, so two differently shaped first updates cannot both pass against
+	// an empty buffer, and an aggregation cannot drain the buffer between
+	// the record and the store.
+	f.mu.Lock()
+	if err := f.validateShapeLocked(packet); err != nil {
+		f.mu.Unlock()
+		f.rejectUpdate(w, packet, "shape_mismatch", err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// RoundManager validates this submission: checks round_id, prevents duplicates,
 	// and decides whether quorum has been reached.
-	accepted, quorumMet := roundManager.RecordUpdate(
+	accepted, quorumMet := f.roundManager.RecordUpdate(
 		packet.Metadata.HospitalID,
 		packet.Metadata.RoundID,
 	)
 	if !accepted {
-		http.Error(w, "Update rejected by RoundManager (wrong round, duplicate, or round closed)", http.StatusConflict)
+		f.mu.Unlock()
+		f.rejectUpdate(w, packet, "round_manager", "Update rejected by RoundManager (wrong round, duplicate, or round closed)", http.StatusConflict)
 		return
 	}
 
+	// Output units are now fixed; hospitals can no longer add labels.
+	f.freezeLabels()
+	f.recordQuality(packet)
+	f.recordAccepted(packet)
+	metricUpdateBytes.Add(float64(body.n), f.ID, codec)
+
 	// Store the packet only after RoundManager has accepted it.
-	mu.Lock()
-	receivedUpdates = append(receivedUpdates, packet)
-	count := len(receivedUpdates)
-	
+	f.receivedUpdates = append(f.receivedUpdates, packet)
+	count := len(f.receivedUpdates)
+
 	// Distributed Logging
 	logEntry, _ := json.Marshal(map[string]interface{}{
 		"hospital_id": packet.Metadata.HospitalID,
 		"round":       packet.Metadata.RoundID,
 		"timestamp":   packet.Metadata.Timestamp,
 	})
-	if f, err := os.OpenFile("update_log.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
-		f.Write(append(logEntry, '\n'))
-		f.Close()
+	if lf, err := os.OpenFile(f.updateLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		lf.Write(append(logEntry, '\n'))
+		lf.Close()
 	}
-	
-	mu.Unlock()
+	hash := f.journalUpdate(packet)
+	recordAudit(AuditEntry{
+		Event:      AuditSubmission,
+		Federation: f.ID,
+		HospitalID: packet.Metadata.HospitalID,
+		Round:      packet.Metadata.RoundID,
+		Version:    packet.Metadata.ModelVersion,
+	}, map[string]interface{}{"packet_hash": hash, "data_size": packet.Metadata.DataSize, "loss": packet.Metadata.Loss})
+
+	f.mu.Unlock()
 
 	// Trigger aggregation only when RoundManager signals quorum.
 	if quorumMet {
-		go aggregateUpdates()
+		go f.aggregateUpdates()
 	}
 
 	// Return success response
-	_, _, received, state := roundManager.Status()
+	_, _, received, state := f.roundManager.Status()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":         "accepted",
@@ -149,56 +371,149 @@ func handleSubmitUpdate(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func aggregateUpdates() {
-	mu.Lock()
-	defer mu.Unlock()
+// updateCheck is the shared submit check against f's current state.
+func (f *Federation) updateCheck() updateCheck {
+	f.aggregationMutex.Lock()
+	version := f.currentVersion
+	f.aggregationMutex.Unlock()
+	round, _, _, _ := f.roundManager.Status()
+	return updateCheck{federation: f.ID, now: time.Now(), models: f.publishedWeights,
+		version: version, round: round, staleness: f.Config.Staleness}
+}
+
+// validateShapeLocked rejects a packet whose weight vector length or model
+// spec differs from the current global model, or from updates already
+// buffered. The caller holds f.mu.
+func (f *Federation) validateShapeLocked(packet UpdatePacket) error {
+	f.aggregationMutex.Lock()
+	refLen, refSpec := len(f.globalWeights), f.globalSpec
+	f.aggregationMutex.Unlock()
+
+	if refLen == 0 && len(f.receivedUpdates) > 0 {
+		refLen, refSpec = len(f.receivedUpdates[0].Weights), f.receivedUpdates[0].Metadata.ModelSpec
+	}
+	if refLen == 0 {
+		return nil
+	}
 
-	if len(receivedUpdates) == 0 {
+	if len(packet.Weights) != refLen {
+		return fmt.Errorf("weight vector has %d parameters, expected %d", len(packet.Weights), refLen)
+	}
+	if len(refSpec) > 0 && len(packet.Metadata.ModelSpec) > 0 &&
+		!bytes.Equal(refSpec, packet.Metadata.ModelSpec) {
+		return fmt.Errorf("model_spec %s does not match federation model %s", packet.Metadata.ModelSpec, refSpec)
+	}
+	return nil
+}
+
+func (f *Federation) aggregateUpdates() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.receivedUpdates) == 0 {
 		return
 	}
 
-	log.Printf("Quorum met. Starting QFedAvg aggregation (q=%.2f)...", qParam)
+	f.qParam = f.qSchedule.nextQ(f.qBase, f.qParam, f.qStep, f.receivedUpdates)
+	f.qStep++
+	metricAggregationQ.Set(f.qParam, f.ID)
+	agg := f.aggregator(f.qParam)
+	log.Printf("[%s] Quorum met. Starting %s aggregation (q=%.2f)...", f.ID, f.Config.Aggregation.Algorithm, f.qParam)
+	start := time.Now()
+
+	f.aggregationMutex.Lock()
+	version := f.currentVersion
+	f.aggregationMutex.Unlock()
+	newWeights, err := agg.aggregate(f.receivedUpdates, version)
+	if err != nil {
+		// Left in AGGREGATING the round would turn every later submission
+		// away; restart it so the hospitals can resubmit.
+		discarded := f.abortRoundLocked("aggregation_failed")
+		log.Printf("[%s] Aggregation failed, round restarted and updates from %v discarded: %v", f.ID, discarded, err)
+		return
+	}
+	round, _, _, _ := f.roundManager.Status()
+	f.journalAggregation(round, version, agg, f.receivedUpdates, newWeights)
+	rec := aggregationRecord(round, version, agg, f.receivedUpdates)
+	contribution := contributionRecord(round, version, agg, f.receivedUpdates)
+	var participants []string
+	for _, p := range f.receivedUpdates {
+		participants = append(participants, p.Metadata.HospitalID)
+	}
+	recordAudit(AuditEntry{Event: AuditAggregation, Federation: f.ID, Round: round, Version: version + 1, Hospitals: participants},
+		map[string]interface{}{"q": f.qParam, "updates": len(f.receivedUpdates), "model_hash": modelHash(newWeights)})
 
-	numWeights := len(receivedUpdates[0].Weights)
-	sumWeightedWeights := make([]float64, numWeights)
-	totalWeight := 0.0
+	// Update global state
+	f.aggregationMutex.Lock()
+	metricModelUpdateNorm.Set(updateNorm(f.globalWeights, newWeights), f.ID)
+	f.globalWeights = newWeights
+	if f.globalSpec == nil {
+		f.globalSpec = f.receivedUpdates[0].Metadata.ModelSpec
+	}
+	f.currentVersion++
 
-	for _, packet := range receivedUpdates {
-		// QFedAvg Weighting: weight = (loss ^ q) * data_size
-		// We use math.Pow for the fairness exponent.
-		// To handle loss=0, we add a tiny epsilon for stability if needed,
-		// but standard QFedAvg usually handles positive loss.
-		lossTerm := packet.Metadata.Loss
-		if lossTerm < 0 {
-			lossTerm = 0 // Loss should not be negative
-		}
+	// Global Model Snapshot
+	snapshotName := f.snapshotPath(f.currentVersion)
+	snapshotData, _ := json.Marshal(map[string]interface{}{
+		"weights": f.globalWeights,
+		"version": f.currentVersion,
+	})
+	os.WriteFile(snapshotName, snapshotData, 0644)
 
-		// weight_i = (loss_i ^ q) * data_size_i
-		// For simplicity, if loss is 0 and q > 0, weight is 0.
-		// If q = 0, this reverts to data_size weighting.
-		var lossPower float64
-		if lossTerm == 0 && qParam > 0 {
-			lossPower = 0 // If loss is 0 and q > 0, loss^q is 0.
-		} else if lossTerm == 0 && qParam == 0 {
-			lossPower = 1 // If loss is 0 and q = 0, loss^0 is 1.
-		} else {
-			lossPower = math.Pow(lossTerm, qParam)
-		}
+	f.aggregationMutex.Unlock()
 
-		qfed_weight := lossPower * float64(packet.Metadata.DataSize)
+	// Clear received updates for next round
+	f.receivedUpdates = nil
 
-		// Staleness calculation
-		staleness := float64(currentVersion - packet.Metadata.ModelVersion)
-		if staleness < 0 {
-			staleness = 0
-		}
-		adjusted_weight := qfed_weight * (1.0 / (1.0 + staleness))
+	log.Printf("[%s] Aggregation successful. New Model Version: %d", f.ID, f.currentVersion)
+	age := f.roundManager.RoundAge()
+	metricAggregationDuration.Observe(time.Since(start).Seconds(), f.ID)
+	metricRoundDuration.Observe(age.Seconds(), f.ID)
+	rec.Started, rec.Closed = time.Now().Add(-age).UTC(), time.Now().UTC()
+	f.recordRound(rec)
+	f.recordContribution(contribution)
 
-		if adjusted_weight <= 0 {
-			adjusted_weight = 1e-6 // Avoid zero weight for participants to prevent division by zero or exclusion
-		}
+	// Advance RoundManager so the next round is open for submissions.
+	f.roundManager.AdvanceRound()
+}
+
+// recordAccepted updates the participation metrics for an accepted packet.
+func (f *Federation) recordAccepted(packet UpdatePacket) {
+	f.aggregationMutex.Lock()
+	staleness := f.currentVersion - packet.Metadata.ModelVersion
+	f.aggregationMutex.Unlock()
+	if staleness < 0 {
+		staleness = 0
+	}
+	id := packet.Metadata.HospitalID
+	metricSubmissionsAccepted.Inc(f.ID, id)
+	metricHospitalLastSubmission.Set(float64(time.Now().Unix()), f.ID, id)
+	metricHospitalStaleness.Set(float64(staleness), f.ID, id)
+	metricUpdateStaleness.Observe(float64(staleness), f.ID)
+	f.recordActivity(packet)
+}
+
+// snapshotPath is the snapshot file of a model version.
+func (f *Federation) snapshotPath(version int) string {
+	return filepath.Join(f.snapshotDir, fmt.Sprintf("snapshot_round_%d.pkl", version))
+}
 
-		for i, w := range packet.Weights {
+// aggregator is the federation's configured aggregation rule at q.
+func (f *Federation) aggregator(q float64) aggregator {
+	return aggregator{algorithm: f.Config.Aggregation.Algorithm, q: q, lipschitz: f.Config.Aggregation.Lipschitz,
+		staleness: f.Config.Staleness, models: f.publishedWeights}
+}
+
+// qfedAvg averages the updates' weight vectors with the weights of
+// qfedAvgWeights. ok is false when every weight is zero. The HTTP server and
+// the simulator share this function.
+func qfedAvg(updates []UpdatePacket, version int, q float64, policy StalenessConfig) (weights []float64, ok bool) {
+	numWeights := len(updates[0].Weights)
+	sumWeightedWeights := make([]float64, numWeights)
+	totalWeight := 0.0
+
+	for k, adjusted_weight := range qfedAvgWeights(updates, version, q, policy) {
+		for i, w := range updates[k].Weights {
 			sumWeightedWeights[i] += w * adjusted_weight
 		}
 		totalWeight += adjusted_weight
@@ -211,54 +526,80 @@ func aggregateUpdates() {
 			newWeights[i] = sum / totalWeight
 		}
 	} else {
-		log.Println("Warning: total weight is zero, skipping aggregation update")
-		return
+		return nil, false
 	}
+	return newWeights, true
+}
 
-	// Update global state
-	aggregationMutex.Lock()
-	globalWeights = newWeights
-	currentVersion++
-	
-	// Global Model Snapshot
-	snapshotName := fmt.Sprintf("snapshot_round_%d.pkl", currentVersion)
-	snapshotData, _ := json.Marshal(map[string]interface{}{
-		"weights": globalWeights,
-		"version": currentVersion,
-	})
-	os.WriteFile(snapshotName, snapshotData, 0644)
-	
-	aggregationMutex.Unlock()
+// qfedAvgWeights returns each update's unnormalised QFedAvg weight,
+// loss^q × data_size, discounted by the staleness policy for how far the
+// update's model_version is behind the global model version.
+func qfedAvgWeights(updates []UpdatePacket, version int, q float64, policy StalenessConfig) []float64 {
+	weights := make([]float64, len(updates))
+	for k, packet := range updates {
+		_, _, weights[k] = qfedAvgTerms(packet, version, q, policy)
+	}
+	return weights
+}
 
-	// Clear received updates for next round
-	receivedUpdates = nil
+// qfedAvgTerms breaks one update's QFedAvg weight into its loss factor
+// (loss^q), its staleness discount and the resulting weight.
+func qfedAvgTerms(packet UpdatePacket, version int, q float64, policy StalenessConfig) (lossPower, discount, weight float64) {
+	// QFedAvg Weighting: weight = (loss ^ q) * data_size
+	// We use math.Pow for the fairness exponent.
+	// To handle loss=0, we add a tiny epsilon for stability if needed,
+	// but standard QFedAvg usually handles positive loss.
+	lossTerm := packet.Metadata.Loss
+	if lossTerm < 0 {
+		lossTerm = 0 // Loss should not be negative
+	}
+
+	// weight_i = (loss_i ^ q) * data_size_i
+	// For simplicity, if loss is 0 and q > 0, weight is 0.
+	// If q = 0, this reverts to data_size weighting.
+	if lossTerm == 0 && q > 0 {
+		lossPower = 0 // If loss is 0 and q > 0, loss^q is 0.
+	} else if lossTerm == 0 && q == 0 {
+		lossPower = 1 // If loss is 0 and q = 0, loss^0 is 1.
+	} else {
+		lossPower = math.Pow(lossTerm, q)
+	}
 
-	log.Printf("Aggregation successful. New Model Version: %d", currentVersion)
+	qfed_weight := lossPower * float64(packet.Metadata.DataSize)
 
-	// Advance RoundManager so the next round is open for submissions.
-	roundManager.AdvanceRound()
+	// Staleness discount (staleness.go)
+	discount = policy.discount(stalenessOf(packet, version))
+	adjusted_weight := qfed_weight * discount
+
+	if adjusted_weight <= 0 {
+		adjusted_weight = 1e-6 // Avoid zero weight for participants to prevent division by zero or exclusion
+	}
+	return lossPower, discount, adjusted_weight
 }
 
-func handleGetGlobalModel(w http.ResponseWriter, r *http.Request) {
-	aggregationMutex.Lock()
-	defer aggregationMutex.Unlock()
+func (f *Federation) handleGetGlobalModel(w http.ResponseWriter, r *http.Request) {
+	f.aggregationMutex.Lock()
+	defer f.aggregationMutex.Unlock()
 
-	if globalWeights == nil {
-		http.Error(w, "Global model not yet initialised", http.StatusNotFound)
+	// Hospitals need the normaliser before round 0, when there is no model
+	// yet; /normalisation serves it on its own.
+	if f.globalWeights == nil {
+		http.Error(w, "Global model not yet initialised; the normaliser is served at /normalisation", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"weights":       globalWeights,
-		"model_version": currentVersion,
-	})
+	writeModel(w, r, f.currentVersion, map[string]interface{}{
+		"model_version": f.currentVersion,
+		"prox_mu":       f.proxMu,
+		"model_spec":    f.globalSpec,
+		"normalisation": f.currentNormaliser(),
+	}, f.globalWeights)
 }
 
-func handleUpdatesCount(w http.ResponseWriter, r *http.Request) {
-	mu.Lock()
-	count := len(receivedUpdates)
-	mu.Unlock()
+func (f *Federation) handleUpdatesCount(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	count := len(f.receivedUpdates)
+	f.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -267,8 +608,8 @@ func handleUpdatesCount(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleRoundStatus exposes the current RoundManager state for inspection.
-func handleRoundStatus(w http.ResponseWriter, r *http.Request) {
-	round, expected, received, state := roundManager.Status()
+func (f *Federation) handleRoundStatus(w http.ResponseWriter, r *http.Request) {
+	round, expected, received, state := f.roundManager.Status()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -276,5 +617,8 @@ func handleRoundStatus(w http.ResponseWriter, r *http.Request) {
 		"expected_clients": expected,
 		"received_clients": received,
 		"state":            state.String(),
+		"paused":           f.roundManager.IsPaused(),
+		"age_s":            f.roundManager.RoundAge().Seconds(),
+		"timeout_in_s":     f.roundManager.Remaining().Seconds(),
 	})
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GET /metrics exposes the server in the Prometheus text format (0.0.4). The
+// few metric types needed are implemented here so the server stays free of
+// third-party dependencies.
+
+// metricFamily is one metric name with its help text, type and a series per
+// combination of label values.
+type metricFamily struct {
+	name, help, kind string // kind: counter, gauge or histogram
+	labels           []string
+	buckets          []float64 // histogram upper bounds, ascending
+
+	mu     sync.Mutex
+	series map[string]*metricSeries // keyed by the joined label values
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64  // counter or gauge
+	counts      []uint64 // histogram: observations ≤ each bucket (cumulative on output)
+	sum         float64
+	count       uint64
+}
+
+// metricFamilies lists every metric in exposition order.
+var metricFamilies []*metricFamily
+
+func newMetric(kind, name, help string, buckets []float64, labels ...string) *metricFamily {
+	m := &metricFamily{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*metricSeries{}}
+	metricFamilies = append(metricFamilies, m)
+	return m
+}
+
+func newCounter(name, help string, labels ...string) *metricFamily {
+	return newMetric("counter", name, help, nil, labels...)
+}
+
+func newGauge(name, help string, labels ...string) *metricFamily {
+	return newMetric("gauge", name, help, nil, labels...)
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricFamily {
+	return newMetric("histogram", name, help, buckets, labels...)
+}
+
+// with returns the series for labelValues, creating it. Caller holds m.mu.
+func (m *metricFamily) with(labelValues []string) *metricSeries {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", m.name, len(labelValues), len(m.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Inc adds one to a counter.
+func (m *metricFamily) Inc(labelValues ...string) { m.Add(1, labelValues...) }
+
+// Add adds v to a counter or gauge.
+func (m *metricFamily) Add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(labelValues).value += v
+}
+
+// Set sets a gauge.
+func (m *metricFamily) Set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(labelValues).value = v
+}
+
+// Observe records one histogram observation.
+func (m *metricFamily) Observe(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.with(labelValues)
+	for i, upper := range m.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// write renders the family, its series sorted by label values.
+func (m *metricFamily) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+			continue
+		}
+		for i, upper := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", formatMetricValue(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+// formatLabels renders {a="x",b="y"}, plus extra="extraValue" when extra is set.
+func formatLabels(names, values []string, extra, extraValue string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, n, labelEscaper.Replace(values[i]))
+	}
+	if extra != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra, labelEscaper.Replace(extraValue))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Bucket layouts.
+var (
+	latencyBuckets       = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	roundDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+	stalenessBuckets     = []float64{0, 1, 2, 3, 5, 10}
+)
+
+// Metrics updated as events happen. Rejections carry a fixed reason code and
+// no hospital label: rejected packets are unauthenticated, so their hospital
+// IDs must not be able to grow the series set.
+var (
+	metricSubmissionsAccepted = newCounter("fl_submissions_accepted_total",
+		"Updates accepted into a round.", "federation", "hospital")
+	metricSubmissionsRejected = newCounter("fl_submissions_rejected_total",
+		"Updates refused, by reason.", "federation", "reason")
+	metricHospitalLastSubmission = newGauge("fl_hospital_last_submission_timestamp_seconds",
+		"Unix time of the hospital's latest accepted update.", "federation", "hospital")
+	metricHospitalStaleness = newGauge("fl_hospital_staleness",
+		"Model versions the hospital's latest accepted update was behind the global model.", "federation", "hospital")
+	metricUpdateBytes = newCounter("fl_update_bytes_total",
+		"Bytes of accepted update requests, by codec (none for full weights).", "federation", "codec")
+	metricUpdateStaleness = newHistogram("fl_update_staleness",
+		"Model versions each accepted update was behind the global model.", stalenessBuckets, "federation")
+	metricRoundDuration = newHistogram("fl_round_duration_seconds",
+		"Time from a round opening to its aggregation.", roundDurationBuckets, "federation")
+	metricAggregationDuration = newHistogram("fl_aggregation_duration_seconds",
+		"Time spent aggregating a round's updates.", latencyBuckets, "federation")
+	metricHospitalWeight = newGauge("fl_hospital_aggregation_weight",
+		"The hospital's normalised weight in the latest aggregation it took part in.", "federation", "hospital")
+	metricLossVariance = newGauge("fl_hospital_loss_variance",
+		"Variance of hospital losses in the latest aggregation; rising means the model serves hospitals less evenly.", "federation")
+	metricWorstLoss = newGauge("fl_hospital_worst_loss",
+		"Highest hospital loss in the latest aggregation.", "federation")
+	metricAggregationQ = newGauge("fl_aggregation_q",
+		"QFedAvg q used by the latest aggregation.", "federation")
+	metricModelUpdateNorm = newGauge("fl_model_update_norm",
+		"L2 norm of the change made to the global weights by the latest aggregation.", "federation")
+	metricRequestDuration = newHistogram("fl_http_request_duration_seconds",
+		"HTTP request latency by endpoint.", latencyBuckets, "endpoint", "method", "code")
+)
+
+// Gauges read from each federation at scrape time.
+var (
+	metricModelVersion    = newGauge("fl_model_version", "Current global model version.", "federation")
+	metricModelWeightNorm = newGauge("fl_model_weight_norm", "L2 norm of the global weights.", "federation")
+	metricRoundCurrent    = newGauge("fl_round_current", "Current round number.", "federation")
+	metricRoundExpected   = newGauge("fl_round_expected_clients", "Updates required to close the current round.", "federation")
+	metricRoundReceived   = newGauge("fl_round_received_clients", "Updates received in the current round.", "federation")
+	metricRoundPaused     = newGauge("fl_round_paused", "1 while an operator has paused submissions.", "federation")
+	metricRoundAge        = newGauge("fl_round_age_seconds", "Time since the current round opened; alert on this for stalled rounds.", "federation")
+	metricBufferedUpdates = newGauge("fl_buffered_updates", "Updates waiting for the next aggregation.", "federation")
+)
+
+// collect refreshes the scrape-time gauges of f.
+func (f *Federation) collect() {
+	round, expected, received, _ := f.roundManager.Status()
+	metricRoundCurrent.Set(float64(round), f.ID)
+	metricRoundExpected.Set(float64(expected), f.ID)
+	metricRoundReceived.Set(float64(received), f.ID)
+	metricRoundAge.Set(f.roundManager.RoundAge().Seconds(), f.ID)
+	paused := 0.0
+	if f.roundManager.IsPaused() {
+		paused = 1
+	}
+	metricRoundPaused.Set(paused, f.ID)
+
+	f.mu.Lock()
+	metricBufferedUpdates.Set(float64(len(f.receivedUpdates)), f.ID)
+	f.mu.Unlock()
+
+	f.aggregationMutex.Lock()
+	metricModelVersion.Set(float64(f.currentVersion), f.ID)
+	metricModelWeightNorm.Set(l2Norm(f.globalWeights), f.ID)
+	f.aggregationMutex.Unlock()
+}
+
+// l2Norm is the Euclidean norm of v.
+func l2Norm(v []float64) float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x * x
+	}
+	return math.Sqrt(sum)
+}
+
+// updateNorm is the L2 norm of next - prev; prev may be empty (a first model).
+func updateNorm(prev, next []float64) float64 {
+	if len(prev) != len(next) {
+		return l2Norm(next)
+	}
+	sum := 0.0
+	for i := range next {
+		d := next[i] - prev[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// handleMetrics serves GET /metrics.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	for _, f := range federationList() {
+		f.collect()
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metricFamilies {
+		m.write(w)
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// instrument records the latency of every request to handler under endpoint,
+// which must be a route pattern rather than the raw path.
+func instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		handler(rec, r)
+		metricRequestDuration.Observe(time.Since(start).Seconds(), endpoint, r.Method, strconv.Itoa(rec.code))
+	}
+}
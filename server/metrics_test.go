@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricExposition(t *testing.T) {
+	counter := &metricFamily{name: "c_total", help: "A counter.", kind: "counter", labels: []string{"reason"}, series: map[string]*metricSeries{}}
+	counter.Inc(`bad "quote"`)
+	counter.Add(2, `bad "quote"`)
+	hist := &metricFamily{name: "h", help: "A histogram.", kind: "histogram", buckets: []float64{1, 5}, series: map[string]*metricSeries{}}
+	for _, v := range []float64{0.5, 3, 7} {
+		hist.Observe(v)
+	}
+
+	var b strings.Builder
+	counter.write(&b)
+	hist.write(&b)
+	want := `# HELP c_total A counter.
+# TYPE c_total counter
+c_total{reason="bad \"quote\""} 3
+# HELP h A histogram.
+# TYPE h histogram
+h_bucket{le="1"} 1
+h_bucket{le="5"} 2
+h_bucket{le="+Inf"} 3
+h_sum 10.5
+h_count 3
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestMetricsEndpointReportsFederations(t *testing.T) {
+	setupFederations(t)
+	call(handleFederations, "POST", "/federations/sepsis/submit_update", "h1-token", signedUpdate(t, "sepsis", "H1"))
+	call(handleFederations, "POST", "/federations/sepsis/submit_update", "h2-token", signedUpdate(t, "", "H2"))
+
+	rec := call(handleMetrics, "GET", "/metrics", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("metrics: %d", rec.Code)
+	}
+	for _, want := range []string{
+		`fl_submissions_accepted_total{federation="sepsis",hospital="H1"}`,
+		`fl_submissions_rejected_total{federation="sepsis",reason="wrong_federation"}`,
+		`fl_hospital_staleness{federation="sepsis",hospital="H1"} 0`,
+		`fl_round_received_clients{federation="sepsis"} 1`,
+		`fl_round_received_clients{federation="default"} 0`,
+		`fl_model_version{federation="default"} 0`,
+		`fl_http_request_duration_seconds_count{endpoint="/federations/{id}/submit_update",method="POST",code="400"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
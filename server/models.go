@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Every published model version is kept as a snapshot (see snapshotPath);
+// GET /models lists them and GET /models?version=N returns one.
+
+// ModelVersionInfo describes one snapshot.
+type ModelVersionInfo struct {
+	Version    int     `json:"version"`
+	Parameters int     `json:"parameters"`
+	Norm       float64 `json:"norm"`
+	ModelHash  string  `json:"model_hash"`
+	Created    string  `json:"created"` // snapshot file time, RFC 3339
+	Current    bool    `json:"current,omitempty"`
+}
+
+// readSnapshot returns the weights stored for a model version.
+func (f *Federation) readSnapshot(version int) ([]float64, error) {
+	b, err := os.ReadFile(f.snapshotPath(version))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot of version %d: %w", version, err)
+	}
+	var snap struct {
+		Weights []float64 `json:"weights"`
+		Version int       `json:"version"`
+	}
+	if err := json.Unmarshal(b, &snap); err != nil || snap.Version != version || len(snap.Weights) == 0 {
+		return nil, fmt.Errorf("snapshot of version %d is unreadable", version)
+	}
+	return snap.Weights, nil
+}
+
+// publishedWeights returns the weights of a published version, from the
+// live model or its snapshot, or nil when neither is available.
+func (f *Federation) publishedWeights(version int) []float64 {
+	f.aggregationMutex.Lock()
+	if version == f.currentVersion && f.globalWeights != nil {
+		w := f.globalWeights
+		f.aggregationMutex.Unlock()
+		return w
+	}
+	f.aggregationMutex.Unlock()
+	w, err := f.readSnapshot(version)
+	if err != nil {
+		return nil
+	}
+	return w
+}
+
+// modelVersions describes every version from 1 to the current one whose
+// snapshot is still on disk.
+func (f *Federation) modelVersions() []ModelVersionInfo {
+	f.aggregationMutex.Lock()
+	current := f.currentVersion
+	f.aggregationMutex.Unlock()
+
+	list := []ModelVersionInfo{}
+	for v := 1; v <= current; v++ {
+		weights, err := f.readSnapshot(v)
+		if err != nil {
+			continue
+		}
+		info := ModelVersionInfo{Version: v, Parameters: len(weights), Norm: l2Norm(weights), ModelHash: modelHash(weights), Current: v == current}
+		if st, err := os.Stat(f.snapshotPath(v)); err == nil {
+			info.Created = st.ModTime().UTC().Format("2006-01-02T15:04:05Z")
+		}
+		list = append(list, info)
+	}
+	return list
+}
+
+// handleModels serves GET /models (every stored version) and
+// GET /models?version=N (that version's weights).
+func (f *Federation) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	v := r.URL.Query().Get("version")
+	if v == "" {
+		writeJSON(w, map[string]interface{}{"federation": f.ID, "versions": f.modelVersions()})
+		return
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		http.Error(w, "version must be an integer", http.StatusBadRequest)
+		return
+	}
+	weights, err := f.readSnapshot(version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	f.aggregationMutex.Lock()
+	spec := f.globalSpec
+	f.aggregationMutex.Unlock()
+	writeJSON(w, map[string]interface{}{
+		"version":    version,
+		"weights":    weights,
+		"parameters": len(weights),
+		"norm":       l2Norm(weights),
+		"model_hash": modelHash(weights),
+		"model_spec": spec,
+	})
+}
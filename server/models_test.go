@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestModelsListAndDownload(t *testing.T) {
+	f := setupAdmin(t)
+	f.roundManager.SetQuorum(1)
+	for v, w := range [][]float64{{3, 4}, {6, 8}} {
+		f.roundManager.RecordUpdate("H1", v)
+		f.receivedUpdates = []UpdatePacket{{Weights: w, Metadata: Metadata{HospitalID: "H1", DataSize: 10, Loss: 0.5, ModelVersion: v}}}
+		f.aggregateUpdates()
+	}
+
+	var list struct {
+		Versions []ModelVersionInfo `json:"versions"`
+	}
+	json.Unmarshal(call(f.handleModels, "GET", "/models", "", "").Body.Bytes(), &list)
+	if len(list.Versions) != 2 || list.Versions[0].Norm != 5 || list.Versions[0].Current || !list.Versions[1].Current {
+		t.Errorf("versions: %+v", list.Versions)
+	}
+
+	var m struct {
+		Version   int       `json:"version"`
+		Weights   []float64 `json:"weights"`
+		ModelHash string    `json:"model_hash"`
+	}
+	json.Unmarshal(call(f.handleModels, "GET", "/models?version=2", "", "").Body.Bytes(), &m)
+	if m.Version != 2 || len(m.Weights) != 2 || m.Weights[0] != 6 || m.ModelHash != modelHash(m.Weights) {
+		t.Errorf("version 2: %+v", m)
+	}
+	if rec := call(f.handleModels, "GET", "/models?version=9", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown version: %d", rec.Code)
+	}
+	if rec := call(f.handleModels, "GET", "/models?version=x", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad version: %d", rec.Code)
+	}
+}
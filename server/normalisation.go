@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"step01/hospital"
+)
+
+// Pre-training statistics round, one per federation (Federation.stats*).
+// Hospitals submit (optionally pairwise-masked) statistics once as a
+// hospital.StatsPacket; when every expected hospital has submitted, the
+// server sums them with hospital.AggregateStats into global normalisation
+// parameters and distributes those with the global model. Individual
+// submissions are never exposed.
+//
+// Masks come from pairwise X25519 secrets: before masking, every roster
+// member publishes a public key through /mask_keys and fetches the others'.
+// The server relays the keys but holds no private key, so it cannot rebuild
+// any mask. It is trusted to relay them faithfully; one that substituted its
+// own keys could unmask the hospitals it deceived.
+
+// verifyMaskKeySignature checks a MaskKeyPacket the way verifyStatsSignature
+// checks a StatsPacket.
+func verifyMaskKeySignature(packet hospital.MaskKeyPacket) bool {
+	sig := packet.Signature
+	packet.Signature = ""
+	body, err := json.Marshal(packet)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(append(body, []byte(SecretKey)...))
+	return hex.EncodeToString(hash[:]) == sig
+}
+
+// verifyStatsSignature recomputes SHA256( json(packet without signature) + SecretKey ).
+func verifyStatsSignature(packet hospital.StatsPacket) bool {
+	sig := packet.Signature
+	packet.Signature = ""
+	body, err := json.Marshal(packet)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(append(body, []byte(SecretKey)...))
+	return hex.EncodeToString(hash[:]) == sig
+}
+
+// recordStats stores one hospital's statistics and finalises the global
+// normaliser once the roster (or quorum) is complete.
+func (f *Federation) recordStats(packet hospital.StatsPacket) error {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+
+	if f.globalNormaliser != nil {
+		return fmt.Errorf("statistics round already closed")
+	}
+	if _, dup := f.statsReceived[packet.HospitalID]; dup {
+		return fmt.Errorf("duplicate statistics from %s", packet.HospitalID)
+	}
+	if len(f.statsRoster) > 0 && !contains(f.statsRoster, packet.HospitalID) {
+		return fmt.Errorf("%s is not in the statistics roster", packet.HospitalID)
+	}
+	if packet.Masked && len(f.statsRoster) == 0 {
+		return fmt.Errorf("masked statistics need a server-side roster (-stats-roster)")
+	}
+	// Pairwise masks cancel only if every roster member applied them, so a
+	// roster's submissions are either all masked or all in the clear.
+	if len(f.statsRoster) > 0 && len(f.statsReceived) > 0 && packet.Masked != f.statsMasked {
+		return fmt.Errorf("%s sent %s statistics but the roster's earlier submissions are %s",
+			packet.HospitalID, maskedWord(packet.Masked), maskedWord(f.statsMasked))
+	}
+	if packet.Masked && len(f.maskKeys) < len(f.statsRoster) {
+		return fmt.Errorf("masked statistics before every roster member published a mask key (%d/%d)", len(f.maskKeys), len(f.statsRoster))
+	}
+	for _, other := range f.statsReceived {
+		if len(other.Count) != len(packet.Stats.Count) {
+			return fmt.Errorf("statistics cover %d features, expected %d", len(packet.Stats.Count), len(other.Count))
+		}
+	}
+	f.statsReceived[packet.HospitalID] = packet.Stats
+	f.statsMasked = packet.Masked
+	if !packet.Masked {
+		log.Printf("[stats] WARNING: %s sent its statistics in the clear; only a roster (-stats-roster) lets hospitals mask them", packet.HospitalID)
+	}
+	log.Printf("[stats] %s submitted statistics (%d/%d)", packet.HospitalID, len(f.statsReceived), f.statsExpected())
+
+	if len(f.statsReceived) >= f.statsExpected() {
+		norm, err := aggregateStats(f.statsReceived, f.statsMethod)
+		if err != nil {
+			return err
+		}
+		f.globalNormaliser = &norm
+		log.Printf("[stats] Global %s normalisation ready from %d hospitals", f.statsMethod, len(f.statsReceived))
+	}
+	return nil
+}
+
+func maskedWord(masked bool) string {
+	if masked {
+		return "masked"
+	}
+	return "unmasked"
+}
+
+// recordMaskKey stores a roster member's public mask key. A key, once
+// published, cannot be replaced: peers may already have masked with it.
+func (f *Federation) recordMaskKey(packet hospital.MaskKeyPacket) error {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+
+	if len(f.statsRoster) == 0 {
+		return fmt.Errorf("mask keys need a server-side roster (-stats-roster)")
+	}
+	if !contains(f.statsRoster, packet.HospitalID) {
+		return fmt.Errorf("%s is not in the statistics roster", packet.HospitalID)
+	}
+	if old, ok := f.maskKeys[packet.HospitalID]; ok {
+		if string(old) == string(packet.PublicKey) {
+			return nil
+		}
+		return fmt.Errorf("%s already published a different mask key", packet.HospitalID)
+	}
+	f.maskKeys[packet.HospitalID] = append([]byte(nil), packet.PublicKey...)
+	log.Printf("[stats] %s published its mask key (%d/%d)", packet.HospitalID, len(f.maskKeys), len(f.statsRoster))
+	return nil
+}
+
+// statsExpected is the number of submissions that closes the round.
+func (f *Federation) statsExpected() int {
+	if len(f.statsRoster) > 0 {
+		return len(f.statsRoster)
+	}
+	_, expected, _, _ := f.roundManager.Status()
+	return expected
+}
+
+// aggregateStats sums the additive statistics (cancelling any pairwise masks),
+// combines min/max, and derives the normalisation parameters the way a
+// hospital would from its own data.
+func aggregateStats(received map[string]hospital.FeatureStats, method hospital.NormMethod) (hospital.Normaliser, error) {
+	parts := make([]hospital.FeatureStats, 0, len(received))
+	for _, s := range received {
+		parts = append(parts, s)
+	}
+	total, err := hospital.AggregateStats(parts...)
+	if err != nil {
+		return hospital.Normaliser{}, err
+	}
+	return total.Normaliser(method), nil
+}
+
+// currentNormaliser returns the global normaliser, or nil before the round closes.
+func (f *Federation) currentNormaliser() *hospital.Normaliser {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	return f.globalNormaliser
+}
+
+func (f *Federation) handleSubmitStats(w http.ResponseWriter, r *http.Request, p Principal) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var packet hospital.StatsPacket
+	if err := json.NewDecoder(r.Body).Decode(&packet); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !verifyStatsSignature(packet) {
+		http.Error(w, "Invalid packet signature", http.StatusForbidden)
+		return
+	}
+	if !p.actsFor(packet.HospitalID) {
+		http.Error(w, fmt.Sprintf("%s may not submit for hospital %s", p.Name, packet.HospitalID), http.StatusForbidden)
+		return
+	}
+	if age := time.Now().Unix() - packet.Timestamp; age > MaxTimestampAge || age < 0 {
+		http.Error(w, "Packet timestamp is stale or invalid", http.StatusRequestTimeout)
+		return
+	}
+	if packet.HospitalID == "" || len(packet.Stats.Count) == 0 {
+		http.Error(w, "Missing or invalid required fields", http.StatusBadRequest)
+		return
+	}
+	if err := f.recordStats(packet); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "accepted",
+		"ready":  f.currentNormaliser() != nil,
+	})
+}
+
+// handleMaskKeys relays the statistics round's public mask keys: POST
+// publishes one (signed, roster members only), GET lists them with
+// "complete" set once the whole roster has published.
+func (f *Federation) handleMaskKeys(w http.ResponseWriter, r *http.Request, p Principal) {
+	switch r.Method {
+	case http.MethodGet:
+		f.statsMu.Lock()
+		keys := make(map[string][]byte, len(f.maskKeys))
+		for id, k := range f.maskKeys {
+			keys[id] = k
+		}
+		complete := len(f.statsRoster) > 0 && len(keys) == len(f.statsRoster)
+		f.statsMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys, "complete": complete})
+	case http.MethodPost:
+		var packet hospital.MaskKeyPacket
+		if err := json.NewDecoder(r.Body).Decode(&packet); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if !verifyMaskKeySignature(packet) {
+			http.Error(w, "Invalid packet signature", http.StatusForbidden)
+			return
+		}
+		if !p.actsFor(packet.HospitalID) {
+			http.Error(w, fmt.Sprintf("%s may not publish a mask key for hospital %s", p.Name, packet.HospitalID), http.StatusForbidden)
+			return
+		}
+		if age := time.Now().Unix() - packet.Timestamp; age > MaxTimestampAge || age < 0 {
+			http.Error(w, "Packet timestamp is stale or invalid", http.StatusRequestTimeout)
+			return
+		}
+		if _, err := ecdh.X25519().NewPublicKey(packet.PublicKey); err != nil || packet.HospitalID == "" {
+			http.Error(w, "Missing hospital_id or invalid X25519 public_key", http.StatusBadRequest)
+			return
+		}
+		if err := f.recordMaskKey(packet); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleNormalisation returns the global normaliser once every expected
+// hospital has submitted its statistics.
+func (f *Federation) handleNormalisation(w http.ResponseWriter, r *http.Request) {
+	norm := f.currentNormaliser()
+	if norm == nil {
+		http.Error(w, "Statistics round still open", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(norm)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
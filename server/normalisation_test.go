@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+
+	"step01/hospital"
+)
+
+func TestStatsRoundCancelsMasksAndBuildsNormaliser(t *testing.T) {
+	c := DefaultConfig().DefaultFederation()
+	c.Normalisation = NormalisationConfig{Method: "zscore", Roster: []string{"H1", "H2"}}
+	f := NewFederation(c, StorageConfig{})
+
+	// H1 holds {1, 3}, H2 holds {5}; the pairwise mask 1000 cancels in the sum.
+	h1 := hospital.FeatureStats{Count: []float64{2 + 1000}, Sum: []float64{4 + 1000}, SumSq: []float64{10 + 1000}, Min: []float64{1}, Max: []float64{3}}
+	h2 := hospital.FeatureStats{Count: []float64{1 - 1000}, Sum: []float64{5 - 1000}, SumSq: []float64{25 - 1000}, Min: []float64{5}, Max: []float64{5}}
+
+	// Masked statistics wait for the roster's mask keys.
+	for _, id := range c.Normalisation.Roster {
+		key, _ := hospital.NewMaskKey()
+		if err := f.recordMaskKey(hospital.MaskKeyPacket{HospitalID: id, PublicKey: key.PublicKey()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.recordStats(hospital.StatsPacket{HospitalID: "H1", Stats: h1, Masked: true}); err != nil {
+		t.Fatal(err)
+	}
+	if f.currentNormaliser() != nil {
+		t.Fatal("normaliser published before the roster was complete")
+	}
+	if err := f.recordStats(hospital.StatsPacket{HospitalID: "H1", Stats: h1, Masked: true}); err == nil {
+		t.Error("duplicate statistics accepted")
+	}
+	if err := f.recordStats(hospital.StatsPacket{HospitalID: "H2", Stats: h2, Masked: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	norm := f.currentNormaliser()
+	if norm == nil {
+		t.Fatal("normaliser not published after the roster was complete")
+	}
+	// Values {1, 3, 5}: mean 3, population std sqrt(8/3).
+	if math.Abs(norm.Offset[0]-3) > 1e-9 || math.Abs(norm.Scale[0]-math.Sqrt(8.0/3.0)) > 1e-9 {
+		t.Errorf("unexpected normaliser %+v", norm)
+	}
+	if err := f.recordStats(hospital.StatsPacket{HospitalID: "H3", Stats: h2}); err == nil {
+		t.Error("statistics accepted after the round closed")
+	}
+}
+
+func TestMaskKeyExchangeGatesMaskedStats(t *testing.T) {
+	f := setupAdmin(t)
+	f.statsRoster = []string{"H1", "H2"}
+	post := func(path, id string, v interface{}) int {
+		b, _ := json.Marshal(v)
+		return call(handleFederations, "POST", "/federations/default/"+path, hospitalToken(id), string(b)).Code
+	}
+
+	keys := make(map[string]*hospital.MaskKey)
+	for _, id := range f.statsRoster {
+		keys[id], _ = hospital.NewMaskKey()
+	}
+	stats := map[string]hospital.FeatureStats{
+		"H1": {Count: []float64{2}, Sum: []float64{4}, SumSq: []float64{10}, Min: []float64{1}, Max: []float64{3}},
+		"H2": {Count: []float64{2}, Sum: []float64{12}, SumSq: []float64{74}, Min: []float64{5}, Max: []float64{7}},
+	}
+	early, _ := hospital.NewStatsPacket("H1", stats["H1"], keys["H1"], map[string][]byte{"H1": keys["H1"].PublicKey()})
+	if code := post("submit_stats", "H1", early); code != http.StatusConflict {
+		t.Errorf("masked stats before the key exchange: %d", code)
+	}
+
+	outsider, _ := hospital.NewMaskKey()
+	if p, _ := hospital.NewMaskKeyPacket("H3", outsider); post("mask_keys", "H3", p) != http.StatusConflict {
+		t.Error("mask key from outside the roster accepted")
+	}
+	bad, _ := hospital.NewMaskKeyPacket("H1", keys["H1"])
+	bad.PublicKey = []byte("short")
+	if post("mask_keys", "H1", bad) != http.StatusForbidden {
+		t.Error("mask key with a broken signature accepted")
+	}
+	for _, id := range f.statsRoster {
+		p, _ := hospital.NewMaskKeyPacket(id, keys[id])
+		if code := post("mask_keys", id, p); code != http.StatusOK {
+			t.Fatalf("%s mask key: %d", id, code)
+		}
+	}
+	if p, _ := hospital.NewMaskKeyPacket("H1", outsider); post("mask_keys", "H1", p) != http.StatusConflict {
+		t.Error("published mask key replaced")
+	}
+
+	var listed struct {
+		Keys     map[string][]byte `json:"keys"`
+		Complete bool              `json:"complete"`
+	}
+	rec := call(handleFederations, "GET", "/federations/default/mask_keys", "h1-token", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || !listed.Complete || len(listed.Keys) != 2 {
+		t.Fatalf("key list: %v %s", err, rec.Body)
+	}
+	for _, id := range f.statsRoster {
+		p, err := hospital.NewStatsPacket(id, stats[id], keys[id], listed.Keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := post("submit_stats", id, p); code != http.StatusOK {
+			t.Fatalf("%s stats: %d", id, code)
+		}
+	}
+	// Global range [1, 7] from masks that cancel.
+	norm := f.currentNormaliser()
+	if norm == nil || math.Abs(norm.Offset[0]-1) > 1e-9 || math.Abs(norm.Scale[0]-6) > 1e-9 {
+		t.Errorf("normaliser %+v", norm)
+	}
+}
+
+func TestRosterRefusesMixedMaskedAndUnmaskedStats(t *testing.T) {
+	c := DefaultConfig().DefaultFederation()
+	c.Normalisation = NormalisationConfig{Method: "minmax", Roster: []string{"H1", "H2", "H3"}}
+	f := NewFederation(c, StorageConfig{})
+	for _, id := range c.Normalisation.Roster {
+		key, _ := hospital.NewMaskKey()
+		if err := f.recordMaskKey(hospital.MaskKeyPacket{HospitalID: id, PublicKey: key.PublicKey()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := hospital.FeatureStats{Count: []float64{1}, Sum: []float64{1}, SumSq: []float64{1}, Min: []float64{0}, Max: []float64{1}}
+
+	if err := f.recordStats(hospital.StatsPacket{HospitalID: "H1", Stats: stats, Masked: true}); err != nil {
+		t.Fatal(err)
+	}
+	// H2's clear statistics would leave H1's masks against H2 uncancelled.
+	if err := f.recordStats(hospital.StatsPacket{HospitalID: "H2", Stats: stats}); err == nil {
+		t.Fatal("unmasked statistics accepted after masked ones")
+	}
+	if err := f.recordStats(hospital.StatsPacket{HospitalID: "H2", Stats: stats, Masked: true}); err != nil {
+		t.Fatalf("H2 could not resubmit masked statistics: %v", err)
+	}
+
+	g := NewFederation(c, StorageConfig{})
+	if err := g.recordStats(hospital.StatsPacket{HospitalID: "H1", Stats: stats}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.recordStats(hospital.StatsPacket{HospitalID: "H2", Stats: stats, Masked: true}); err == nil {
+		t.Error("masked statistics accepted after unmasked ones")
+	}
+	if g.currentNormaliser() != nil || f.currentNormaliser() != nil {
+		t.Error("normaliser published from an incomplete roster")
+	}
+}
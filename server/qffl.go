@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"step01/hospital"
+)
+
+// Aggregation algorithms (aggregation.algorithm).
+const (
+	AlgorithmQFedAvg = "qfedavg" // average of the hospitals' models weighted by loss^q × data_size
+	AlgorithmQFFL    = "qffl"    // q-FFL step on the hospitals' deltas, normalised by a Lipschitz estimate
+)
+
+// defaultLipschitz is 1 / step-01's learning rate, the estimate the q-FFL
+// paper uses for L.
+const defaultLipschitz = 20.0
+
+// minQFFLLoss keeps F^(q−1) finite for hospitals reporting zero loss.
+const minQFFLLoss = 1e-10
+
+// aggregator is the rule of one aggregation. models returns the weights of
+// a published global version, or nil when they are not available; only
+// qffl, which works on deltas, reads it.
+type aggregator struct {
+	algorithm string // "" means qfedavg
+	q         float64
+	lipschitz float64
+	staleness StalenessConfig
+	models    func(version int) []float64
+}
+
+// aggregationTerm is one update's part in an aggregation: its loss factor
+// (loss^q), its staleness discount and its unnormalised weight.
+type aggregationTerm struct {
+	lossFactor float64
+	discount   float64
+	weight     float64
+}
+
+// errZeroWeight is aggregate's error when the updates carry no weight.
+var errZeroWeight = errors.New("total weight is zero")
+
+// aggregate combines updates trained against versions up to version into
+// the next global model.
+func (a aggregator) aggregate(updates []UpdatePacket, version int) ([]float64, error) {
+	if a.algorithm == AlgorithmQFFL {
+		return a.qffl(updates, version)
+	}
+	weights, ok := qfedAvg(updates, version, a.q, a.staleness)
+	if !ok {
+		return nil, errZeroWeight
+	}
+	return weights, nil
+}
+
+// terms returns every update's aggregationTerm and their total weight; an
+// update's share of the aggregation is its weight over the total. For qffl
+// the share is of the step taken from the global model.
+func (a aggregator) terms(updates []UpdatePacket, version int) ([]aggregationTerm, float64) {
+	terms := make([]aggregationTerm, len(updates))
+	total := 0.0
+	for k, p := range updates {
+		if a.algorithm == AlgorithmQFFL {
+			terms[k], _ = qfflTerm(p, version, a.q, a.lipschitz, a.staleness)
+		} else {
+			terms[k].lossFactor, terms[k].discount, terms[k].weight = qfedAvgTerms(p, version, a.q, a.staleness)
+		}
+		total += terms[k].weight
+	}
+	return terms, total
+}
+
+// qffl is one q-FFL step (Li et al., "Fair Resource Allocation in Federated
+// Learning", ICLR 2020) from the global model w of version:
+//
+//	Δw_k = L (w_k⁰ − w_k)            w_k⁰ the version hospital k trained on
+//	h_k  = q F_k^(q−1) ‖Δw_k‖² + L F_k^q
+//	w'   = w − Σ p_k F_k^q Δw_k / Σ p_k h_k
+//
+// F_k is the loss of the model hospital k received, measured on its data
+// (global_loss; hospitals that do not send it are taken at their training
+// loss). Every update of a round takes part, so p_k is the data size, times
+// the staleness discount, where the paper samples hospitals with
+// probability p_k. With q = 0 and no stale updates this is FedAvg weighted
+// by data size.
+func (a aggregator) qffl(updates []UpdatePacket, version int) ([]float64, error) {
+	global, _ := a.start(updates[0], version)
+	if global == nil {
+		return nil, fmt.Errorf("qffl: no global model of version %d to step from (%s sent no model_spec)", version, updates[0].Metadata.HospitalID)
+	}
+	step := make([]float64, len(global))
+	total := 0.0
+	for _, p := range updates {
+		_, start := a.start(p, version)
+		if len(p.Weights) != len(global) || len(start) != len(global) {
+			return nil, fmt.Errorf("qffl: %s sent %d weights against a %d-weight model", p.Metadata.HospitalID, len(p.Weights), len(global))
+		}
+		t, loss := qfflTerm(p, version, a.q, a.lipschitz, a.staleness)
+		norm := 0.0
+		for i, w := range p.Weights {
+			step[i] += t.weight * (start[i] - w)
+			d := a.lipschitz * (start[i] - w)
+			norm += d * d
+		}
+		pk := float64(p.Metadata.DataSize) * t.discount
+		total += pk * (a.q*math.Pow(loss, a.q-1)*norm + a.lipschitz*t.lossFactor) // p_k h_k
+	}
+	if !(total > 0) || math.IsInf(total, 0) {
+		return nil, errZeroWeight
+	}
+	next := make([]float64, len(global))
+	for i, w := range global {
+		next[i] = w - step[i]/total
+	}
+	return next, nil
+}
+
+// qfflTerm returns an update's aggregationTerm, whose weight p_k F_k^q L
+// multiplies (w_k⁰ − w_k) in the q-FFL step, and the F_k it used.
+func qfflTerm(p UpdatePacket, version int, q, lipschitz float64, policy StalenessConfig) (aggregationTerm, float64) {
+	loss := p.Metadata.GlobalLoss
+	if loss == 0 {
+		loss = p.Metadata.Loss
+	}
+	loss = math.Max(loss, minQFFLLoss)
+
+	t := aggregationTerm{lossFactor: math.Pow(loss, q), discount: policy.discount(stalenessOf(p, version))}
+	pk := float64(p.Metadata.DataSize) * t.discount
+	t.weight = pk * t.lossFactor * lipschitz
+	return t, loss
+}
+
+// start returns the global model of version and the model p was trained
+// from. Version 0, when no weights were published, is the reproducible
+// initial model of p's layout. An update whose own version is no longer
+// available is taken against the global model.
+func (a aggregator) start(p UpdatePacket, version int) (global, start []float64) {
+	global = a.model(version, p)
+	from := p.Metadata.ModelVersion
+	if from >= version || from < 0 {
+		return global, global
+	}
+	if start = a.model(from, p); start == nil {
+		start = global
+	}
+	return global, start
+}
+
+// checkQFFLBase refuses, under qffl, an update that could not be stepped
+// from: before the first aggregation the global model is the initial model
+// of the update's model_spec, so an update without one would fail the round.
+func (f *Federation) checkQFFLBase(p UpdatePacket) error {
+	if f.Config.Aggregation.Algorithm != AlgorithmQFFL {
+		return nil
+	}
+	f.aggregationMutex.Lock()
+	version := f.currentVersion
+	f.aggregationMutex.Unlock()
+	global := f.aggregator(0).model(version, p)
+	if global == nil {
+		return fmt.Errorf("qffl needs the global model of version %d; send model_spec with updates to the initial model", version)
+	}
+	if len(global) != len(p.Weights) {
+		return fmt.Errorf("weight vector has %d parameters, the global model %d", len(p.Weights), len(global))
+	}
+	return nil
+}
+
+func (a aggregator) model(version int, p UpdatePacket) []float64 {
+	if a.models != nil {
+		if w := a.models(version); w != nil {
+			return w
+		}
+	}
+	if version != 0 {
+		return nil
+	}
+	spec, err := packetSpec(p)
+	if err != nil {
+		return nil
+	}
+	initial, err := hospital.NewClassifier(*spec)
+	if err != nil {
+		return nil
+	}
+	return initial.Flatten()
+}
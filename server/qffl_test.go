@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"step01/hospital"
+)
+
+func TestQFFLStepMatchesThePaper(t *testing.T) {
+	// Global w = (1, 1), L = 2, q = 1.
+	// H1: w = (0, 1), F = 0.5, n = 10 → Δw = (2, 0), h = 4 + 1 = 5.
+	// H2: w = (1, 3), F = 2, n = 30 → Δw = (0, −4), h = 16 + 4 = 20.
+	// w' = w − (10·0.5·(2, 0) + 30·2·(0, −4)) / (10·5 + 30·20).
+	a := aggregator{algorithm: AlgorithmQFFL, q: 1, lipschitz: 2, models: func(v int) []float64 { return []float64{1, 1} }}
+	updates := []UpdatePacket{
+		{Weights: []float64{0, 1}, Metadata: Metadata{HospitalID: "H1", DataSize: 10, Loss: 0.1, GlobalLoss: 0.5}},
+		{Weights: []float64{1, 3}, Metadata: Metadata{HospitalID: "H2", DataSize: 30, Loss: 0.1, GlobalLoss: 2}},
+	}
+	w, err := a.aggregate(updates, 0)
+	if err != nil || math.Abs(w[0]-(1-10.0/650)) > 1e-12 || math.Abs(w[1]-(1+240.0/650)) > 1e-12 {
+		t.Fatalf("q-FFL step: %v (%v)", w, err)
+	}
+	terms, total := a.terms(updates, 0)
+	if math.Abs(terms[0].weight/total-1.0/13) > 1e-12 || terms[1].lossFactor != 2 { // 10·0.5·2 of 10·0.5·2 + 30·2·2
+		t.Errorf("terms: %+v of %g", terms, total)
+	}
+}
+
+func TestQFFLWithoutFairnessIsDataSizeFedAvg(t *testing.T) {
+	history := [][]float64{{0, 0, 0}, {0.5, -1, 2}}
+	updates := []UpdatePacket{
+		{Weights: []float64{1, -2, 3}, Metadata: Metadata{DataSize: 10, Loss: 0.3, GlobalLoss: 0.7, ModelVersion: 1}},
+		{Weights: []float64{0.2, 0.4, 2.5}, Metadata: Metadata{DataSize: 25, Loss: 0.9, GlobalLoss: 0.4, ModelVersion: 1}},
+	}
+	a := aggregator{algorithm: AlgorithmQFFL, q: 0, lipschitz: 7, models: func(v int) []float64 { return history[v] }}
+	got, _ := a.aggregate(updates, 1)
+	want, _ := qfedAvg(updates, 1, 0, StalenessConfig{})
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Fatalf("q=0: qffl %v, qfedavg %v", got, want)
+		}
+	}
+
+	// A stale update's delta is taken against the version it trained on.
+	stale := []UpdatePacket{{Weights: []float64{1, 1, 1}, Metadata: Metadata{DataSize: 10, Loss: 1, ModelVersion: 0}}}
+	got, _ = a.aggregate(stale, 1)
+	if got[0] != 1.5 || got[1] != 0 || got[2] != 3 {
+		t.Errorf("stale update: %v", got)
+	}
+}
+
+func TestQFFLAgainstHeuristicOnSimulator(t *testing.T) {
+	// H3 sits far from the others with little data, so plain averaging neglects it.
+	run := func(algorithm string, q float64) RoundMetrics {
+		cfg := SimConfig{Rounds: 30, Seed: 1, Q: &q, Algorithm: algorithm, Lipschitz: 5,
+			Hospitals: []SimHospital{{ID: "H1", LatencyS: 1}, {ID: "H2", LatencyS: 2}, {ID: "H3", LatencyS: 3}}}
+		for i := range cfg.Hospitals {
+			cfg.Hospitals[i].Data = simData(float64(i) * 0.4)
+		}
+		cfg.Hospitals[2].Data = cfg.Hospitals[2].Data[:8]
+		sim, err := NewSimulation(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := sim.Run()
+		if err != nil || len(result.Rounds) != 30 {
+			t.Fatalf("%s q=%g: %v", algorithm, q, err)
+		}
+		// The models agree to rounding, so their JSON may differ in length.
+		m := result.Rounds[29]
+		m.UploadBytes = 0
+		return m
+	}
+
+	if fedavg, qffl := run(AlgorithmQFedAvg, 0), run(AlgorithmQFFL, 0); fedavg != qffl {
+		t.Errorf("q=0 should agree:\nqfedavg %+v\nqffl    %+v", fedavg, qffl)
+	}
+	heuristic, fair, plain := run(AlgorithmQFedAvg, 3), run(AlgorithmQFFL, 3), run(AlgorithmQFFL, 0)
+	t.Logf("q=3 worst loss: qfedavg %.4f, qffl %.4f (q=0: %.4f)", heuristic.WorstLoss, fair.WorstLoss, plain.WorstLoss)
+	if fair.WorstLoss >= plain.WorstLoss || fair.LossStd >= plain.LossStd {
+		t.Errorf("qffl q=3 did not serve the worst hospital better than q=0: %+v vs %+v", fair, plain)
+	}
+	if fair.WorstLoss >= heuristic.WorstLoss || fair.LossStd >= heuristic.LossStd {
+		t.Errorf("qffl q=3 is less fair than the heuristic: %+v vs %+v", fair, heuristic)
+	}
+}
+
+func TestQFFLJournalReplaysAndValues(t *testing.T) {
+	f := setupAdmin(t)
+	f.Config.Aggregation = AggregationConfig{Algorithm: AlgorithmQFFL, Q: 2, Lipschitz: 5}
+	journalRoundsOf(t, f, 3, "H1", "H2", "H3")
+
+	if err := replayJournal(f.journalPath, io.Discard); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	entries, err := readJournalAggregations(f.journalPath)
+	if err != nil || entries[0].Algorithm != AlgorithmQFFL || entries[0].Lipschitz != 5 {
+		t.Fatalf("journal: %v %+v", err, entries)
+	}
+	if _, err := analyseContributions(f.journalPath, simData(0), ShapleyOptions{Metric: "loss", Permutations: 10}); err != nil {
+		t.Errorf("shapley over qffl: %v", err)
+	}
+}
+
+func TestQFFLRefusesUpdatesWithoutABaseAndRestartsFailedRounds(t *testing.T) {
+	f := setupAdmin(t)
+	f.Config.Aggregation.Algorithm = AlgorithmQFFL
+	f.roundManager.SetQuorum(1)
+	spec := hospital.ModelSpec{Type: hospital.ModelMLP, InputSize: 2, Hidden: []int{4}}
+	global, _ := hospital.NewClassifier(spec)
+	packet := func(id string, withSpec bool) UpdatePacket {
+		hp, err := hospital.BuildUpdatePacket(global, hospital.HospitalConfig{ID: id}, simData(0), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(hp)
+		var p UpdatePacket
+		json.Unmarshal(b, &p)
+		if !withSpec {
+			p.Metadata.ModelSpec = nil
+			p.Signature, _ = signMetadata(p.Metadata)
+		}
+		return p
+	}
+	submit := func(p UpdatePacket) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(p)
+		return call(handleFederations, "POST", "/federations/default/submit_update", hospitalToken(p.Metadata.HospitalID), string(b))
+	}
+
+	// Version 0 has no published weights: without a model_spec there is
+	// nothing to take the update's step from.
+	if rec := submit(packet("H1", false)); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "model_spec") {
+		t.Fatalf("update without a base: %d %s", rec.Code, rec.Body)
+	}
+	if last := f.rejections[len(f.rejections)-1]; last.Code != "no_base_model" {
+		t.Errorf("rejection %+v", last)
+	}
+
+	// One that slips into the buffer fails the aggregation, which restarts
+	// the round instead of leaving it stuck in AGGREGATING.
+	f.receivedUpdates = append(f.receivedUpdates, packet("H1", false))
+	f.roundManager.RecordUpdate("H1", 0)
+	f.aggregateUpdates()
+	if round, _, received, state := f.roundManager.Status(); round != 0 || received != 0 || state != RoundWaiting || len(f.receivedUpdates) != 0 {
+		t.Fatalf("after a failed aggregation: round %d, %d received, %s, %d buffered", round, received, state, len(f.receivedUpdates))
+	}
+	f.roundManager.SetQuorum(2)
+	if rec := submit(packet("H1", true)); rec.Code != http.StatusOK {
+		t.Errorf("resubmission: %d %s", rec.Code, rec.Body)
+	}
+}
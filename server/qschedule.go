@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+)
+
+// q schedules choose the QFedAvg fairness exponent for each aggregation.
+// The q actually used is journalled with every aggregation, so -replay
+// reproduces scheduled runs exactly.
+
+// Q schedule types.
+const (
+	QConstant = "constant" // q stays at aggregation.q (or wherever an operator sets it)
+	QAnneal   = "anneal"   // q moves linearly from aggregation.q to q_end over rounds aggregations
+	QAdaptive = "adaptive" // q follows the spread of the hospitals' reported losses
+)
+
+// qScheduleTypes lists the accepted q_schedule.type values.
+var qScheduleTypes = []string{QConstant, QAnneal, QAdaptive}
+
+// QScheduleConfig is aggregation.q_schedule. An empty Type means constant.
+//
+// adaptive measures the spread of each round's reported losses as their
+// coefficient of variation (standard deviation / mean) and steps q towards
+// more fairness when hospitals' losses are further apart than TargetSpread:
+//
+//	q ← clamp(q + Gain × (spread − TargetSpread), QMin, QMax)
+type QScheduleConfig struct {
+	Type string `json:"type"`
+
+	QEnd   float64 `json:"q_end,omitempty"`  // anneal: final q
+	Rounds int     `json:"rounds,omitempty"` // anneal: aggregations taken to reach q_end
+
+	TargetSpread float64 `json:"target_spread,omitempty"` // adaptive: acceptable loss coefficient of variation
+	Gain         float64 `json:"gain,omitempty"`          // adaptive: q change per unit of excess spread
+	QMin         float64 `json:"q_min,omitempty"`         // adaptive: lower bound on q
+	QMax         float64 `json:"q_max,omitempty"`         // adaptive: upper bound on q
+}
+
+// Defaults for adaptive settings left at zero.
+const (
+	defaultQTargetSpread = 0.2
+	defaultQGain         = 2.0
+	defaultQMax          = 5.0
+)
+
+// nextQ returns the q for an aggregation of updates. base is the schedule's
+// starting q, current the q of the previous aggregation, and step the number
+// of aggregations since base was set.
+func (s QScheduleConfig) nextQ(base, current float64, step int, updates []UpdatePacket) float64 {
+	switch s.Type {
+	case QAnneal:
+		if s.Rounds <= 0 || step >= s.Rounds {
+			return s.QEnd
+		}
+		return base + (s.QEnd-base)*float64(step)/float64(s.Rounds)
+	case QAdaptive:
+		target, gain, qMax := s.TargetSpread, s.Gain, s.QMax
+		if target == 0 {
+			target = defaultQTargetSpread
+		}
+		if gain == 0 {
+			gain = defaultQGain
+		}
+		if qMax == 0 {
+			qMax = defaultQMax
+		}
+		return math.Min(qMax, math.Max(s.QMin, current+gain*(lossSpread(updates)-target)))
+	default:
+		return current
+	}
+}
+
+// lossSpread is the coefficient of variation of the updates' losses, 0 when
+// there are fewer than two updates or the mean loss is 0.
+func lossSpread(updates []UpdatePacket) float64 {
+	if len(updates) < 2 {
+		return 0
+	}
+	losses := make([]float64, len(updates))
+	for i, p := range updates {
+		losses[i] = p.Metadata.Loss
+	}
+	mean, variance := meanVariance(losses)
+	if mean <= 0 {
+		return 0
+	}
+	return math.Sqrt(variance) / mean
+}
+
+// validate reports problems under prefix (e.g. "aggregation.q_schedule.").
+func (s QScheduleConfig) validate(prefix string, bad func(string, ...interface{})) {
+	finite := func(name string, v float64) {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			bad("%s%s must be a finite number ≥ 0, got %g", prefix, name, v)
+		}
+	}
+	switch s.Type {
+	case "", QConstant:
+	case QAnneal:
+		finite("q_end", s.QEnd)
+		if s.Rounds < 1 {
+			bad("%srounds must be at least 1 for anneal, got %d", prefix, s.Rounds)
+		}
+	case QAdaptive:
+		finite("target_spread", s.TargetSpread)
+		finite("gain", s.Gain)
+		finite("q_min", s.QMin)
+		finite("q_max", s.QMax)
+		if s.QMax != 0 && s.QMax < s.QMin {
+			bad("%sq_max %g is below q_min %g", prefix, s.QMax, s.QMin)
+		}
+	default:
+		bad("%stype %q must be one of %v", prefix, s.Type, qScheduleTypes)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestQSchedules(t *testing.T) {
+	anneal := QScheduleConfig{Type: QAnneal, QEnd: 3, Rounds: 4}
+	for step, want := range []float64{1, 1.5, 2, 2.5, 3, 3} {
+		if q := anneal.nextQ(1, 0, step, nil); q != want {
+			t.Errorf("anneal step %d: q = %g, want %g", step, q, want)
+		}
+	}
+
+	// Losses 1 and 3: spread 0.5, 0.3 above the default target.
+	uneven := []UpdatePacket{{Metadata: Metadata{Loss: 1}}, {Metadata: Metadata{Loss: 3}}}
+	even := []UpdatePacket{{Metadata: Metadata{Loss: 2}}, {Metadata: Metadata{Loss: 2}}}
+	adaptive := QScheduleConfig{Type: QAdaptive, QMin: 0.5, QMax: 2}
+	if q := adaptive.nextQ(1, 1, 0, uneven); math.Abs(q-1.6) > 1e-12 {
+		t.Errorf("uneven losses: q = %g, want 1.6", q)
+	}
+	if q := adaptive.nextQ(1, 1.9, 0, uneven); q != 2 {
+		t.Errorf("q_max not enforced: %g", q)
+	}
+	if q := adaptive.nextQ(1, 0.6, 0, even); q != 0.5 {
+		t.Errorf("even losses should lower q to q_min, got %g", q)
+	}
+	if q := (QScheduleConfig{}).nextQ(1, 1.7, 9, uneven); q != 1.7 {
+		t.Errorf("constant schedule changed q to %g", q)
+	}
+}
+
+func TestFederationAppliesQScheduleEachAggregation(t *testing.T) {
+	f := setupAdmin(t)
+	f.qSchedule = QScheduleConfig{Type: QAnneal, QEnd: 0, Rounds: 2}
+	aggregate := func() float64 {
+		p := UpdatePacket{Weights: []float64{1}, Metadata: Metadata{HospitalID: "H1", DataSize: 1, Loss: 1}}
+		f.journalUpdate(p)
+		f.receivedUpdates = []UpdatePacket{p}
+		f.aggregateUpdates()
+		entries, err := readJournalAggregations(f.journalPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries[len(entries)-1].Q
+	}
+	if q := aggregate(); q != 1 {
+		t.Errorf("first aggregation used q %g, want the configured 1", q)
+	}
+	if q := aggregate(); q != 0.5 {
+		t.Errorf("second aggregation used q %g, want 0.5", q)
+	}
+
+	// An operator's q restarts the schedule from that value.
+	settings := requireRole(f, f.handleAdminSettings, RoleOperator)
+	if rec := call(settings, "POST", "/admin/settings", "op-token", `{"q": 4}`); rec.Code != http.StatusOK {
+		t.Fatalf("settings: %d %s", rec.Code, rec.Body)
+	}
+	if q := aggregate(); q != 4 {
+		t.Errorf("aggregation after the operator's change used q %g, want 4", q)
+	}
+	if s := f.currentSettings(); s["q"] != 4.0 || s["q_schedule"] != QAnneal {
+		t.Errorf("settings: %v", s)
+	}
+}
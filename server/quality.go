@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Latest data-quality summary per hospital (Federation.qualityReports).
+// Hospitals opt in to sending it inside their packet metadata; it holds only
+// counts and rates, never rows. The server stores it verbatim and does not
+// interpret it.
+
+// recordQuality keeps the most recent summary a hospital has shared.
+func (f *Federation) recordQuality(packet UpdatePacket) {
+	if len(packet.Metadata.DataQuality) == 0 {
+		return
+	}
+	f.qualityMu.Lock()
+	f.qualityReports[packet.Metadata.HospitalID] = packet.Metadata.DataQuality
+	f.qualityMu.Unlock()
+}
+
+// handleDataQuality returns the shared summaries keyed by hospital ID.
+func (f *Federation) handleDataQuality(w http.ResponseWriter, r *http.Request) {
+	f.qualityMu.Lock()
+	defer f.qualityMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.qualityReports)
+}
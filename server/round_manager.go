@@ -31,6 +31,11 @@ func (s RoundState) String() string {
 	}
 }
 
+// RoundTimeout is the default time a round waits for quorum (see
+// RoundManager.Timeout). Once it has elapsed, the next accepted submission
+// triggers aggregation with whatever has arrived.
+const RoundTimeout = 15 * time.Second
+
 // RoundManager tracks the state of the current federated learning round.
 // It is the single source of truth for whether aggregation should fire.
 //
@@ -46,16 +51,27 @@ type RoundManager struct {
 	ReceivedClients map[string]bool // keyed by hospital_id to avoid duplicate counting
 	State           RoundState
 	RoundStartTime  time.Time
+	Timeout         time.Duration // quorum wait before a submission forces aggregation
+	Extension       time.Duration // extra wait granted to the current round by an operator
+	Paused          bool          // set by operators; submissions are refused while paused
+	clock           Clock
 }
 
 // NewRoundManager creates a RoundManager for round 0 with the given quorum size.
 func NewRoundManager(quorum int) *RoundManager {
+	return NewRoundManagerWithClock(quorum, systemClock{})
+}
+
+// NewRoundManagerWithClock is NewRoundManager with an injected clock (see VirtualClock).
+func NewRoundManagerWithClock(quorum int, clock Clock) *RoundManager {
 	return &RoundManager{
 		CurrentRound:    0,
 		ExpectedClients: quorum,
 		ReceivedClients: make(map[string]bool),
 		State:           RoundWaiting,
-		RoundStartTime:  time.Now(),
+		RoundStartTime:  clock.Now(),
+		Timeout:         RoundTimeout,
+		clock:           clock,
 	}
 }
 
@@ -81,6 +97,11 @@ func (rm *RoundManager) RecordUpdate(hospitalID string, roundID int) (accepted b
 			hospitalID, roundID, rm.CurrentRound)
 	}
 
+	if rm.Paused {
+		log.Printf("[RoundManager] Rejected update from %s: round %d is paused", hospitalID, rm.CurrentRound)
+		return false, false
+	}
+
 	// Reject if aggregation already triggered for this round.
 	if rm.State != RoundWaiting {
 		log.Printf("[RoundManager] Rejected update from %s: round %d is in state %s",
@@ -100,7 +121,7 @@ func (rm *RoundManager) RecordUpdate(hospitalID string, roundID int) (accepted b
 	log.Printf("[RoundManager] Round %d — %s submitted (%d/%d)",
 		rm.CurrentRound, hospitalID, received, rm.ExpectedClients)
 
-	if received >= rm.ExpectedClients || (received > 0 && time.Since(rm.RoundStartTime) > 15*time.Second) {
+	if received >= rm.ExpectedClients || (received > 0 && rm.clock.Now().Sub(rm.RoundStartTime) > rm.Timeout+rm.Extension) {
 		rm.State = RoundAggregating
 		log.Printf("[RoundManager] Quorum or timeout met (received %d). Triggering aggregation for round %d.",
 			received, rm.CurrentRound)
@@ -119,7 +140,8 @@ func (rm *RoundManager) AdvanceRound() {
 	rm.CurrentRound++
 	rm.ReceivedClients = make(map[string]bool)
 	rm.State = RoundWaiting
-	rm.RoundStartTime = time.Now()
+	rm.RoundStartTime = rm.clock.Now()
+	rm.Extension = 0
 
 	log.Printf("[RoundManager] Advanced to round %d. Waiting for %d clients.",
 		rm.CurrentRound, rm.ExpectedClients)
@@ -131,3 +153,89 @@ func (rm *RoundManager) Status() (round, expected, received int, state RoundStat
 	defer rm.mu.Unlock()
 	return rm.CurrentRound, rm.ExpectedClients, len(rm.ReceivedClients), rm.State
 }
+
+// Submitted returns the hospitals that have submitted in the current round.
+func (rm *RoundManager) Submitted() map[string]bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	submitted := make(map[string]bool, len(rm.ReceivedClients))
+	for id := range rm.ReceivedClients {
+		submitted[id] = true
+	}
+	return submitted
+}
+
+// RoundAge is the time since the current round opened.
+func (rm *RoundManager) RoundAge() time.Duration {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.clock.Now().Sub(rm.RoundStartTime)
+}
+
+// SetQuorum changes the number of updates required to aggregate. It returns
+// true if the updates already received now meet the new quorum, in which case
+// the round moves to aggregation and the caller must aggregate.
+func (rm *RoundManager) SetQuorum(quorum int) (quorumMet bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.ExpectedClients = quorum
+	log.Printf("[RoundManager] Quorum set to %d for round %d", quorum, rm.CurrentRound)
+	if rm.State == RoundWaiting && !rm.Paused && len(rm.ReceivedClients) > 0 && len(rm.ReceivedClients) >= quorum {
+		rm.State = RoundAggregating
+		return true
+	}
+	return false
+}
+
+// SetPaused pauses or resumes submissions for the current round.
+func (rm *RoundManager) SetPaused(paused bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.Paused = paused
+	log.Printf("[RoundManager] Round %d paused=%v", rm.CurrentRound, paused)
+}
+
+// IsPaused reports whether submissions are currently refused.
+func (rm *RoundManager) IsPaused() bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.Paused
+}
+
+// Abort discards the current round's submissions and restarts it with the
+// same round number, so hospitals resubmit against the unchanged global model.
+// The caller must drop its buffered updates.
+func (rm *RoundManager) Abort() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.ReceivedClients = make(map[string]bool)
+	rm.State = RoundWaiting
+	rm.RoundStartTime = rm.clock.Now()
+	rm.Extension = 0
+	log.Printf("[RoundManager] Round %d aborted and restarted.", rm.CurrentRound)
+}
+
+// Remaining is the time until the current round's timeout; negative once it
+// has passed.
+func (rm *RoundManager) Remaining() time.Duration {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.remainingLocked()
+}
+
+func (rm *RoundManager) remainingLocked() time.Duration {
+	return rm.RoundStartTime.Add(rm.Timeout + rm.Extension).Sub(rm.clock.Now())
+}
+
+// Extend gives the current round d more time before the timeout lets a
+// submission force aggregation. It returns the time left until that deadline.
+func (rm *RoundManager) Extend(d time.Duration) (remaining time.Duration) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.Extension += d
+	remaining = rm.remainingLocked()
+	log.Printf("[RoundManager] Round %d extended by %s (%s left)", rm.CurrentRound, d, remaining.Round(time.Second))
+	return remaining
+}
@@ -4,7 +4,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
 	"time"
 )
 
@@ -13,22 +15,20 @@ import (
 const SecretKey = "federated_secret_2024"
 
 // MaxTimestampAge is the maximum age (in seconds) a packet's timestamp
-// may have before the server rejects it as stale.
-const MaxTimestampAge int64 = 30
+// may have before the server rejects it as stale. Set from the configuration
+// (security.max_timestamp_age_s).
+var MaxTimestampAge int64 = 30
 
 // verifySignature recomputes the SHA256 hash over the packet's metadata
 // and compares it against the signature carried in the packet.
 // Returns true if the signature is valid.
 func verifySignature(packet UpdatePacket) bool {
-	metaJSON, err := json.Marshal(packet.Metadata)
+	expected, err := signMetadata(packet.Metadata)
 	if err != nil {
 		log.Printf("[security] Failed to marshal metadata for verification: %v", err)
 		return false
 	}
 
-	hash := sha256.Sum256(append(metaJSON, []byte(SecretKey)...))
-	expected := hex.EncodeToString(hash[:])
-
 	if expected != packet.Signature {
 		log.Printf("[security] Signature mismatch for %s: expected %s, got %s",
 			packet.Metadata.HospitalID, expected, packet.Signature)
@@ -39,11 +39,26 @@ func verifySignature(packet UpdatePacket) bool {
 	return true
 }
 
+// signMetadata returns SHA256( json(metadata) + SecretKey ) as hex.
+func signMetadata(m Metadata) (string, error) {
+	metaJSON, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(append(metaJSON, []byte(SecretKey)...))
+	return hex.EncodeToString(hash[:]), nil
+}
+
 // validateTimestamp checks that the packet's timestamp is within the
 // acceptable freshness window (MaxTimestampAge seconds from now).
 // Returns true if the timestamp is valid (not stale).
 func validateTimestamp(packet UpdatePacket) bool {
-	now := time.Now().Unix()
+	return validateTimestampAt(packet, time.Now())
+}
+
+// validateTimestampAt is validateTimestamp against an explicit "now".
+func validateTimestampAt(packet UpdatePacket, at time.Time) bool {
+	now := at.Unix()
 	age := now - packet.Metadata.Timestamp
 
 	if age > MaxTimestampAge {
@@ -61,3 +76,57 @@ func validateTimestamp(packet UpdatePacket) bool {
 	log.Printf("[security] Timestamp valid for %s (age: %ds)", packet.Metadata.HospitalID, age)
 	return true
 }
+
+// updateCheck is the part of the submit pipeline that the server and the
+// simulator share. Its fields are what a packet is checked against at the
+// moment it arrives.
+type updateCheck struct {
+	federation string              // the packet must be signed for this federation
+	now        time.Time           // for the timestamp window
+	models     func(int) []float64 // published versions, to decode a delta
+	version    int                 // current global model version
+	round      int                 // open round
+	staleness  StalenessConfig
+}
+
+// updateRejection is why updateCheck refused a packet: code is the reason
+// label of fl_submissions_rejected_total and status the HTTP status.
+type updateRejection struct {
+	code   string
+	status int
+	err    error
+}
+
+// check verifies the signature, the federation and the timestamp, decodes a
+// compressed delta in place, checks the required fields and applies
+// max_staleness, in that order. It returns nil when packet passes them all.
+func (c updateCheck) check(packet *UpdatePacket) *updateRejection {
+	if !verifySignature(*packet) {
+		return &updateRejection{"signature", http.StatusForbidden, fmt.Errorf("Invalid packet signature")}
+	}
+	// A packet cannot be replayed into another study.
+	if id := packetFederation(packet.Metadata); id != c.federation {
+		return &updateRejection{"wrong_federation", http.StatusBadRequest, fmt.Errorf("Packet is signed for federation %s, not %s", id, c.federation)}
+	}
+	if !validateTimestampAt(*packet, c.now) {
+		return &updateRejection{"stale_timestamp", http.StatusRequestTimeout, fmt.Errorf("Packet timestamp is stale or invalid")}
+	}
+	// A compressed packet carries a delta against the model version it was
+	// trained on; add it back onto that version.
+	if reason, err := decodeUpdate(packet, c.models); err != nil {
+		status := http.StatusBadRequest
+		if reason == "unknown_base" {
+			status = http.StatusConflict
+		}
+		return &updateRejection{reason, status, err}
+	}
+	if len(packet.Weights) == 0 || packet.Metadata.HospitalID == "" || packet.Metadata.DataSize <= 0 {
+		return &updateRejection{"invalid_fields", http.StatusBadRequest, fmt.Errorf("Missing or invalid required fields")}
+	}
+	// Updates too far behind the global model or the open round are refused;
+	// the error says how far behind.
+	if err := c.staleness.admit(stalenessOf(*packet, c.version), c.round-packet.Metadata.RoundID); err != nil {
+		return &updateRejection{"too_stale", http.StatusConflict, err}
+	}
+	return nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"step01/hospital"
+)
+
+// Offline contribution analysis (-shapley): every aggregation in a journal is
+// a cooperative game whose players are the round's updates. The value of a
+// coalition is the validation score of the model that the aggregation rule the
+// journal recorded for the round (qfedavg or qffl, with its q, Lipschitz
+// estimate and staleness policy) builds from its updates alone; the empty
+// coalition scores the model the round started from.
+// Each update is credited with its leave-one-out value, v(all) − v(all
+// without it), and its Shapley value, its mean marginal contribution over
+// orderings of the players. Orderings are enumerated exactly when there are
+// no more of them than the permutation budget, and sampled otherwise.
+
+// ShapleyOptions configures the analysis.
+type ShapleyOptions struct {
+	Metric       string // accuracy, macro_f1 or loss (scored as −loss so higher is better)
+	Permutations int    // Monte-Carlo orderings sampled per round
+	Seed         int64
+}
+
+// shapleyMetrics lists the accepted ShapleyOptions.Metric values.
+var shapleyMetrics = []string{"accuracy", "macro_f1", "loss"}
+
+// HospitalValue is one update's credit for a round.
+type HospitalValue struct {
+	HospitalID    string  `json:"hospital_id"`
+	Weight        float64 `json:"weight"` // normalised aggregation weight in the published model
+	LeaveOneOut   float64 `json:"leave_one_out"`
+	Shapley       float64 `json:"shapley"`
+	ShapleyStdErr float64 `json:"shapley_stderr"` // 0 when exact
+}
+
+// RoundContribution is the analysis of one aggregation. The Shapley values
+// of a round sum to Full − Baseline.
+type RoundContribution struct {
+	Round        int             `json:"round"`
+	Version      int             `json:"version"`
+	BaseVersion  int             `json:"base_version"`
+	Q            float64         `json:"q"`
+	Baseline     float64         `json:"baseline"` // score of the model the round started from
+	Full         float64         `json:"full"`     // score of the published model
+	Exact        bool            `json:"exact"`
+	Permutations int             `json:"permutations"`
+	Hospitals    []HospitalValue `json:"hospitals"`
+}
+
+// CumulativeContribution totals a hospital's credit over every round.
+// Share is its part of all hospitals' summed Shapley values.
+type CumulativeContribution struct {
+	HospitalID  string  `json:"hospital_id"`
+	Rounds      int     `json:"rounds"`
+	LeaveOneOut float64 `json:"leave_one_out"`
+	Shapley     float64 `json:"shapley"`
+	Share       float64 `json:"share"`
+}
+
+// ContributionAnalysis is the result of -shapley.
+type ContributionAnalysis struct {
+	Journal        string                   `json:"journal"`
+	Metric         string                   `json:"metric"`
+	ValidationRows int                      `json:"validation_rows"`
+	Rounds         []RoundContribution      `json:"rounds"`
+	Cumulative     []CumulativeContribution `json:"cumulative"`
+}
+
+// journalAggregation is an aggregation or rollback read back from a journal.
+type journalAggregation struct {
+	JournalEntry
+	Packets []UpdatePacket // aggregate: the updates, in aggregation order
+}
+
+// readJournalAggregations returns the journal's aggregations and rollbacks
+// in order, with each aggregation's packets resolved from their hashes.
+// Use -replay to check a journal's integrity; this only reads it.
+func readJournalAggregations(path string) ([]journalAggregation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	packets := make(map[string]UpdatePacket)
+	var list []journalAggregation
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var e JournalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("journal line %d: %w", line, err)
+		}
+		switch e.Type {
+		case JournalUpdate:
+			var p UpdatePacket
+			if err := json.Unmarshal(e.Packet, &p); err != nil {
+				return nil, fmt.Errorf("journal line %d: stored packet: %w", line, err)
+			}
+			packets[e.Hash] = p
+		case JournalAggregate, JournalRollback:
+			a := journalAggregation{JournalEntry: e}
+			for _, h := range e.Updates {
+				p, ok := packets[h]
+				if !ok {
+					return nil, fmt.Errorf("journal line %d: update %s was never journaled", line, h)
+				}
+				a.Packets = append(a.Packets, p)
+			}
+			list = append(list, a)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	return list, nil
+}
+
+// analyseContributions values every update of every aggregation in the
+// journal against the validation samples.
+func analyseContributions(path string, validation []hospital.Sample, opts ShapleyOptions) (*ContributionAnalysis, error) {
+	if !contains(shapleyMetrics, opts.Metric) {
+		return nil, fmt.Errorf("metric must be one of %s, got %q", strings.Join(shapleyMetrics, ", "), opts.Metric)
+	}
+	if opts.Permutations < 1 {
+		return nil, fmt.Errorf("permutations must be at least 1")
+	}
+	if len(validation) == 0 {
+		return nil, fmt.Errorf("validation set is empty")
+	}
+	aggregations, err := readJournalAggregations(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec *hospital.ModelSpec
+	models := make(map[int][]float64) // published weights per version
+	rng := rand.New(rand.NewSource(opts.Seed))
+	result := &ContributionAnalysis{Journal: path, Metric: opts.Metric, ValidationRows: len(validation), Rounds: []RoundContribution{}}
+	for _, a := range aggregations {
+		if a.Type == JournalRollback {
+			models[a.Version] = models[a.BaseVersion]
+			continue
+		}
+		if len(a.Packets) == 0 {
+			return nil, fmt.Errorf("round %d: aggregate entry for version %d lists no updates", a.Round, a.Version)
+		}
+		if spec == nil {
+			if spec, err = packetSpec(a.Packets[0]); err != nil {
+				return nil, err
+			}
+			initial, err := hospital.NewClassifier(*spec)
+			if err != nil {
+				return nil, err
+			}
+			models[0] = initial.Flatten()
+		}
+		base, ok := models[a.BaseVersion]
+		if !ok {
+			return nil, fmt.Errorf("round %d builds on version %d, which the journal never published", a.Round, a.BaseVersion)
+		}
+		if len(a.Packets) > 62 {
+			return nil, fmt.Errorf("round %d has %d updates; at most 62 can be valued", a.Round, len(a.Packets))
+		}
+
+		agg := journalAggregator(a.JournalEntry, models)
+		g := &coalitionGame{updates: a.Packets, version: a.BaseVersion, agg: agg, spec: *spec, data: validation, metric: opts.Metric,
+			cache: make(map[uint64]float64)}
+		if g.cache[0], err = g.score(base); err != nil {
+			return nil, fmt.Errorf("round %d: %w", a.Round, err)
+		}
+		full, err := agg.aggregate(a.Packets, a.BaseVersion)
+		if err != nil || modelHash(full) != a.ModelHash {
+			return nil, fmt.Errorf("round %d: aggregation does not reproduce version %d (check the journal with -replay)", a.Round, a.Version)
+		}
+		models[a.Version] = full
+
+		rc, err := g.value(rng, opts.Permutations)
+		if err != nil {
+			return nil, fmt.Errorf("round %d: %w", a.Round, err)
+		}
+		rc.Round, rc.Version, rc.BaseVersion, rc.Q = a.Round, a.Version, a.BaseVersion, a.Q
+		result.Rounds = append(result.Rounds, *rc)
+	}
+	result.Cumulative = cumulativeContributions(result.Rounds)
+	return result, nil
+}
+
+// packetSpec reads the model layout a packet was trained with.
+func packetSpec(p UpdatePacket) (*hospital.ModelSpec, error) {
+	if len(p.Metadata.ModelSpec) == 0 {
+		return nil, fmt.Errorf("packets carry no model_spec, so their weights cannot be evaluated")
+	}
+	var spec hospital.ModelSpec
+	if err := json.Unmarshal(p.Metadata.ModelSpec, &spec); err != nil {
+		return nil, fmt.Errorf("model_spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// coalitionGame scores the journalled aggregation rule over subsets of a round's updates. Coalitions
+// are bit masks over updates; cache holds every score computed so far.
+type coalitionGame struct {
+	updates []UpdatePacket
+	version int
+	agg     aggregator
+	spec    hospital.ModelSpec
+	data    []hospital.Sample
+	metric  string
+	cache   map[uint64]float64
+}
+
+func (g *coalitionGame) score(weights []float64) (float64, error) {
+	model, err := hospital.ClassifierFromWeights(g.spec, weights)
+	if err != nil {
+		return 0, err
+	}
+	switch g.metric {
+	case "loss":
+		return -model.Loss(g.data), nil
+	case "macro_f1":
+		return hospital.Evaluate(model, g.data).MacroF1, nil
+	default:
+		return hospital.Evaluate(model, g.data).Accuracy, nil
+	}
+}
+
+// v is the score of the model aggregated from the coalition's updates.
+func (g *coalitionGame) v(mask uint64) (float64, error) {
+	if s, ok := g.cache[mask]; ok {
+		return s, nil
+	}
+	var subset []UpdatePacket
+	for i, p := range g.updates {
+		if mask&(1<<uint(i)) != 0 {
+			subset = append(subset, p)
+		}
+	}
+	weights, err := g.agg.aggregate(subset, g.version)
+	if err != nil {
+		return 0, fmt.Errorf("coalition %b: %w", mask, err)
+	}
+	s, err := g.score(weights)
+	if err != nil {
+		return 0, err
+	}
+	g.cache[mask] = s
+	return s, nil
+}
+
+// value computes every update's leave-one-out and Shapley value.
+func (g *coalitionGame) value(rng *rand.Rand, budget int) (*RoundContribution, error) {
+	n := len(g.updates)
+	all := uint64(1)<<uint(n) - 1
+	full, err := g.v(all)
+	if err != nil {
+		return nil, err
+	}
+	rc := &RoundContribution{Baseline: g.cache[0], Full: full}
+
+	sum, sumSq := make([]float64, n), make([]float64, n)
+	var walkErr error
+	walk := func(order []int) {
+		mask, prev := uint64(0), g.cache[0]
+		for _, i := range order {
+			mask |= 1 << uint(i)
+			cur, err := g.v(mask)
+			if err != nil && walkErr == nil {
+				walkErr = err
+			}
+			sum[i] += cur - prev
+			sumSq[i] += (cur - prev) * (cur - prev)
+			prev = cur
+		}
+		rc.Permutations++
+	}
+	if factorial(n) <= budget {
+		rc.Exact = true
+		permutations(n, walk)
+	} else {
+		for k := 0; k < budget; k++ {
+			walk(rng.Perm(n))
+		}
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	terms, total := g.agg.terms(g.updates, g.version)
+	for i, p := range g.updates {
+		without, err := g.v(all &^ (1 << uint(i))) // the empty coalition is cached as the baseline
+		if err != nil {
+			return nil, err
+		}
+		m := float64(rc.Permutations)
+		hv := HospitalValue{
+			HospitalID:  p.Metadata.HospitalID,
+			Weight:      terms[i].weight / total,
+			LeaveOneOut: full - without,
+			Shapley:     sum[i] / m,
+		}
+		if !rc.Exact && m > 1 {
+			variance := (sumSq[i] - sum[i]*sum[i]/m) / (m - 1)
+			hv.ShapleyStdErr = math.Sqrt(math.Max(variance, 0) / m)
+		}
+		rc.Hospitals = append(rc.Hospitals, hv)
+	}
+	return rc, nil
+}
+
+// factorial returns n!, capped once it exceeds any useful budget.
+func factorial(n int) int {
+	f := 1
+	for i := 2; i <= n; i++ {
+		if f > math.MaxInt32/i {
+			return math.MaxInt32
+		}
+		f *= i
+	}
+	return f
+}
+
+// permutations calls fn with every ordering of 0…n-1 (Heap's algorithm).
+func permutations(n int, fn func([]int)) {
+	p := make([]int, n)
+	for i := range p {
+		p[i] = i
+	}
+	var generate func(k int)
+	generate = func(k int) {
+		if k <= 1 {
+			fn(p)
+			return
+		}
+		for i := 0; i < k-1; i++ {
+			generate(k - 1)
+			if k%2 == 0 {
+				p[i], p[k-1] = p[k-1], p[i]
+			} else {
+				p[0], p[k-1] = p[k-1], p[0]
+			}
+		}
+		generate(k - 1)
+	}
+	generate(n)
+}
+
+// cumulativeContributions totals each hospital's credit across rounds.
+func cumulativeContributions(rounds []RoundContribution) []CumulativeContribution {
+	byHospital := make(map[string]*CumulativeContribution)
+	total := 0.0
+	for _, r := range rounds {
+		for _, h := range r.Hospitals {
+			c := byHospital[h.HospitalID]
+			if c == nil {
+				c = &CumulativeContribution{HospitalID: h.HospitalID}
+				byHospital[h.HospitalID] = c
+			}
+			c.Rounds++
+			c.LeaveOneOut += h.LeaveOneOut
+			c.Shapley += h.Shapley
+			total += h.Shapley
+		}
+	}
+	list := make([]CumulativeContribution, 0, len(byHospital))
+	for _, c := range byHospital {
+		if total != 0 {
+			c.Share = c.Shapley / total
+		}
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].HospitalID < list[j].HospitalID })
+	return list
+}
+
+// writeContributionTables prints the per-round and cumulative tables.
+func writeContributionTables(w io.Writer, a *ContributionAnalysis) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "round\tversion\thospital\tweight\tbaseline\tfull\tleave_one_out\tshapley\tstderr\t\n")
+	for _, r := range a.Rounds {
+		for _, h := range r.Hospitals {
+			stderr := "exact"
+			if !r.Exact {
+				stderr = fmt.Sprintf("%.4f", h.ShapleyStdErr)
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%.4f\t%.4f\t%.4f\t%+.4f\t%+.4f\t%s\t\n",
+				r.Round, r.Version, h.HospitalID, h.Weight, r.Baseline, r.Full, h.LeaveOneOut, h.Shapley, stderr)
+		}
+	}
+	fmt.Fprintf(tw, "\nhospital\trounds\tleave_one_out\tshapley\tshare\t\n")
+	for _, c := range a.Cumulative {
+		fmt.Fprintf(tw, "%s\t%d\t%+.4f\t%+.4f\t%.1f%%\t\n", c.HospitalID, c.Rounds, c.LeaveOneOut, c.Shapley, 100*c.Share)
+	}
+	return tw.Flush()
+}
+
+// runShapley loads the validation set, analyses the journal, prints the
+// tables and optionally writes the full analysis as JSON to outPath. rows is
+// "start:end" (0-based, end exclusive) or "" for every row.
+func runShapley(journalPath, validationPath, rows, outPath string, opts ShapleyOptions) error {
+	if validationPath == "" {
+		return fmt.Errorf("-shapley-validation is required")
+	}
+	start, end := 0, math.MaxInt32
+	if rows != "" {
+		from, to, ok := strings.Cut(rows, ":")
+		var err1, err2 error
+		start, err1 = strconv.Atoi(from)
+		end, err2 = strconv.Atoi(to)
+		if !ok || err1 != nil || err2 != nil || start < 0 || end <= start {
+			return fmt.Errorf("-shapley-rows must be start:end, got %q", rows)
+		}
+	}
+	data, _, err := hospital.LoadHospitalData(hospital.HospitalConfig{ID: "validation", CSVPath: validationPath, StartIdx: start, EndIdx: end})
+	if err != nil {
+		return err
+	}
+	analysis, err := analyseContributions(journalPath, data, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d aggregations valued on %d validation rows by %s\n\n", len(analysis.Rounds), len(data), opts.Metric)
+	if err := writeContributionTables(os.Stdout, analysis); err != nil {
+		return err
+	}
+	if outPath != "" {
+		out, err := json.MarshalIndent(analysis, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(outPath, out, 0644); err != nil {
+			return fmt.Errorf("write contribution analysis: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"step01/hospital"
+)
+
+// journalRounds journals rounds of updates trained by the step-01 hospitals
+// on data; H3's labels are flipped, so its updates harm the model.
+func journalRounds(t *testing.T, rounds int, ids ...string) *Federation {
+	t.Helper()
+	return journalRoundsOf(t, setupAdmin(t), rounds, ids...)
+}
+
+// journalRoundsOf is journalRounds on an already configured federation.
+func journalRoundsOf(t *testing.T, f *Federation, rounds int, ids ...string) *Federation {
+	t.Helper()
+	f.roundManager.SetQuorum(len(ids))
+	spec := hospital.ModelSpec{Type: "logistic", InputSize: 2}
+	global, _ := hospital.NewClassifier(spec)
+	for r := 0; r < rounds; r++ {
+		for i, id := range ids {
+			data := simData(float64(i) * 0.05)
+			if id == "H3" {
+				for k := range data {
+					data[k].Label = 1 - data[k].Label
+				}
+			}
+			hp, err := hospital.BuildUpdatePacket(global, hospital.HospitalConfig{ID: id, RoundID: r, ModelVersion: r}, data, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var p UpdatePacket
+			b, _ := json.Marshal(hp)
+			json.Unmarshal(b, &p)
+			f.roundManager.RecordUpdate(id, r)
+			f.receivedUpdates = append(f.receivedUpdates, p)
+			f.journalUpdate(p)
+		}
+		f.aggregateUpdates()
+		global, _ = hospital.ClassifierFromWeights(spec, f.globalWeights)
+	}
+	return f
+}
+
+func TestShapleyCreditsHelpfulHospitals(t *testing.T) {
+	f := journalRounds(t, 2, "H1", "H2", "H3")
+	validation := simData(0.02)
+
+	exact, err := analyseContributions(f.journalPath, validation, ShapleyOptions{Metric: "loss", Permutations: 200, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exact.Rounds) != 2 || !exact.Rounds[0].Exact || exact.Rounds[0].Permutations != 6 {
+		t.Fatalf("rounds: %+v", exact.Rounds)
+	}
+	for _, r := range exact.Rounds {
+		sum := 0.0
+		for _, h := range r.Hospitals {
+			sum += h.Shapley
+		}
+		if math.Abs(sum-(r.Full-r.Baseline)) > 1e-9 {
+			t.Errorf("round %d: Shapley values sum to %g, want %g", r.Round, sum, r.Full-r.Baseline)
+		}
+	}
+	byID := map[string]CumulativeContribution{}
+	shares := 0.0
+	for _, c := range exact.Cumulative {
+		byID[c.HospitalID] = c
+		shares += c.Share
+	}
+	if byID["H3"].Shapley >= 0 || byID["H1"].Shapley <= byID["H3"].Shapley || byID["H3"].LeaveOneOut >= 0 ||
+		byID["H1"].Rounds != 2 || math.Abs(shares-1) > 1e-9 {
+		t.Errorf("cumulative: %+v", exact.Cumulative)
+	}
+
+	// Two sampled orderings of three players: an estimate, still efficient.
+	sampled, err := analyseContributions(f.journalPath, validation, ShapleyOptions{Metric: "loss", Permutations: 2, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := sampled.Rounds[0]
+	sum := 0.0
+	for _, h := range r.Hospitals {
+		sum += h.Shapley
+	}
+	if r.Exact || r.Permutations != 2 || math.Abs(sum-(r.Full-r.Baseline)) > 1e-9 {
+		t.Errorf("sampled round: %+v", r)
+	}
+	if r.Hospitals[0].LeaveOneOut != exact.Rounds[0].Hospitals[0].LeaveOneOut {
+		t.Error("leave-one-out depends on sampling")
+	}
+
+	var out strings.Builder
+	writeContributionTables(&out, exact)
+	if !strings.Contains(out.String(), "exact") || !strings.Contains(out.String(), "share") {
+		t.Errorf("tables:\n%s", out.String())
+	}
+}
+
+func TestShapleyOfSingleUpdateIsItsImprovement(t *testing.T) {
+	f := journalRounds(t, 1, "H1")
+	a, err := analyseContributions(f.journalPath, simData(0), ShapleyOptions{Metric: "accuracy", Permutations: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := a.Rounds[0]
+	if h := r.Hospitals[0]; h.Shapley != r.Full-r.Baseline || h.LeaveOneOut != h.Shapley || h.Weight != 1 {
+		t.Errorf("single update: %+v", r)
+	}
+	if _, err := analyseContributions(f.journalPath, simData(0), ShapleyOptions{Metric: "auc", Permutations: 10}); err == nil {
+		t.Error("unknown metric accepted")
+	}
+}
+
+func TestShapleyRefusesAggregateWithoutUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	line, _ := json.Marshal(JournalEntry{Type: JournalAggregate, Round: 0, Version: 1, ModelHash: "x"})
+	os.WriteFile(path, append(line, '\n'), 0644)
+	_, err := analyseContributions(path, simData(0), ShapleyOptions{Metric: "accuracy", Permutations: 10})
+	if err == nil || !strings.Contains(err.Error(), "no updates") {
+		t.Errorf("empty aggregate: %v", err)
+	}
+}
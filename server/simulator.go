@@ -0,0 +1,719 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"step01/hospital"
+)
+
+// SimHospital configures one simulated hospital. Times are virtual seconds.
+type SimHospital struct {
+	ID    string `json:"id"`
+	Start int    `json:"start"` // record range in SimConfig.Data
+	End   int    `json:"end"`
+
+	LatencyS      float64 `json:"latency_s"`       // model download → signed update (training time)
+	JitterS       float64 `json:"jitter_s"`        // extra latency drawn uniformly from [0, jitter_s)
+	NetworkDelayS float64 `json:"network_delay_s"` // signed update → arrival; ages the packet timestamp
+	Dropout       float64 `json:"dropout"`         // probability that an attempt is abandoned
+	Staleness     int     `json:"staleness"`       // trains on the global model this many versions behind
+
+	// Train is the hospital's local training; the zero value uses
+	// step-01's DefaultTrainConfig.
+	Train hospital.TrainConfig `json:"train"`
+
+	// Data overrides the file-based dataset (used by tests).
+	Data []hospital.Sample `json:"-"`
+}
+
+// SimConfig describes a simulated federation. The simulator runs the server's
+// RoundManager and aggregation together with real step-01 trainers in
+// one process, driven by a VirtualClock instead of wall time.
+type SimConfig struct {
+	Rounds int      `json:"rounds"`
+	Quorum int      `json:"quorum"`
+	Q      *float64 `json:"q,omitempty"`       // nil means the server default (aggregation.q)
+	ProxMu *float64 `json:"prox_mu,omitempty"` // nil means the server default (aggregation.prox_mu)
+	Seed   int64    `json:"seed"`
+	Model  string   `json:"model,omitempty"`  // logistic (default) or mlp
+	Hidden []int    `json:"hidden,omitempty"` // mlp hidden layers
+
+	Algorithm string  `json:"algorithm,omitempty"` // aggregation.algorithm (default qfedavg)
+	Lipschitz float64 `json:"lipschitz,omitempty"` // qffl's L (default aggregation.lipschitz)
+
+	// Staleness is the server's staleness policy: the discount and the
+	// max_staleness refusal (outcome too_stale).
+	Staleness StalenessConfig `json:"staleness"`
+
+	// Compression sends every update as a delta through this codec, with
+	// error feedback per hospital; nil sends full weights.
+	Compression *hospital.Compression `json:"compression,omitempty"`
+
+	// QSchedule changes q between aggregations as aggregation.q_schedule does
+	// on the server. QSweep runs the whole simulation once per constant q
+	// instead (see runQSweep).
+	QSchedule QScheduleConfig `json:"q_schedule"`
+	QSweep    []float64       `json:"q_sweep,omitempty"`
+
+	// Data is a CSV read through the default schema with each hospital's
+	// start/end range. Partitions (a directory written by step-01's
+	// cmd/partition) instead gives each hospital its own file, matched by ID.
+	Data       string `json:"data,omitempty"`
+	Partitions string `json:"partitions,omitempty"`
+
+	RetryS   float64 `json:"retry_s,omitempty"`    // delay before a dropped-out hospital tries again (default RoundTimeout)
+	MaxTimeS float64 `json:"max_time_s,omitempty"` // virtual time budget (default 24h)
+
+	Hospitals []SimHospital `json:"hospitals"`
+}
+
+// RoundMetrics are the global-model metrics after one aggregation.
+// Loss and Accuracy pool every hospital's data; WorstLoss and LossStd
+// summarise the per-hospital losses, which is what q trades off.
+type RoundMetrics struct {
+	Round         int     `json:"round"`
+	Version       int     `json:"version"`
+	TimeS         float64 `json:"time_s"`
+	Updates       int     `json:"updates"`
+	Late          int     `json:"late"`    // updates trained for an earlier round
+	Timeout       bool    `json:"timeout"` // aggregated by RoundTimeout before quorum
+	MeanStaleness float64 `json:"mean_staleness"`
+	Q             float64 `json:"q"`            // q used by the aggregation
+	UploadBytes   int     `json:"upload_bytes"` // the aggregated updates as sent
+	Loss          float64 `json:"loss"`
+	Accuracy      float64 `json:"accuracy"`
+	WorstLoss     float64 `json:"worst_loss"`
+	LossStd       float64 `json:"loss_std"`
+}
+
+// SimEvent records what happened to one hospital attempt.
+type SimEvent struct {
+	TimeS    float64 `json:"time_s"`
+	Hospital string  `json:"hospital"`
+	Round    int     `json:"round"`   // round the update was trained for
+	Version  int     `json:"version"` // model version it was trained on
+	Outcome  string  `json:"outcome"` // dropout, accepted, late, stale_timestamp, too_stale, rejected
+}
+
+// Simulation outcomes recorded in SimEvent.Outcome.
+const (
+	SimDropout        = "dropout"
+	SimAccepted       = "accepted"
+	SimLate           = "late"
+	SimStaleTimestamp = "stale_timestamp"
+	SimTooStale       = "too_stale"
+	SimRejected       = "rejected"
+)
+
+// SimResult is the outcome of Simulation.Run.
+type SimResult struct {
+	Rounds  []RoundMetrics `json:"rounds"`
+	Events  []SimEvent     `json:"events"`
+	Stalled bool           `json:"stalled"` // stopped before Rounds aggregations
+	TimeS   float64        `json:"time_s"`
+}
+
+// simEvent is a scheduled attempt or arrival.
+type simEvent struct {
+	at     time.Time
+	seq    int // breaks ties in scheduling order, keeping runs deterministic
+	h      int
+	gen    int           // attempt generation; superseded attempts are skipped
+	packet *UpdatePacket // nil for an attempt
+	bytes  int           // size of packet as sent
+}
+
+type eventQueue []simEvent
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(simEvent)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Simulation is one deterministic run of a SimConfig.
+type Simulation struct {
+	cfg   SimConfig
+	q     float64
+	qBase float64
+	clock *VirtualClock
+	start time.Time
+	rm    *RoundManager
+	rng   *rand.Rand
+	spec  hospital.ModelSpec
+	data  [][]hospital.Sample
+	all   []hospital.Sample
+
+	history      [][]float64 // history[v] is global model version v
+	buffer       []UpdatePacket
+	bufferBytes  int
+	feedback     []*hospital.ErrorFeedback
+	busy         []bool
+	participated []int // last round each hospital counted towards
+	attempts     []int // current attempt generation per hospital
+	queue        eventQueue
+	seq          int
+	result       SimResult
+}
+
+// NewSimulation validates cfg and loads every hospital's data.
+func NewSimulation(cfg SimConfig) (*Simulation, error) {
+	if len(cfg.Hospitals) == 0 {
+		return nil, fmt.Errorf("simulation: no hospitals")
+	}
+	if cfg.Rounds <= 0 {
+		cfg.Rounds = 1
+	}
+	if cfg.Quorum <= 0 {
+		cfg.Quorum = len(cfg.Hospitals)
+	}
+	if cfg.RetryS <= 0 {
+		cfg.RetryS = RoundTimeout.Seconds()
+	}
+	if cfg.MaxTimeS <= 0 {
+		cfg.MaxTimeS = (24 * time.Hour).Seconds()
+	}
+	if cfg.Model == "" {
+		cfg.Model = hospital.ModelLogistic
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = DefaultConfig().Aggregation.Algorithm
+	}
+	if cfg.Lipschitz == 0 {
+		cfg.Lipschitz = DefaultConfig().Aggregation.Lipschitz
+	}
+	if cfg.ProxMu == nil {
+		mu := DefaultConfig().Aggregation.ProxMu
+		cfg.ProxMu = &mu
+	}
+	var problems []string
+	if !contains(aggregationAlgorithms, cfg.Algorithm) {
+		problems = append(problems, fmt.Sprintf("algorithm %q must be one of %v", cfg.Algorithm, aggregationAlgorithms))
+	}
+	if !(cfg.Lipschitz > 0) || math.IsInf(cfg.Lipschitz, 0) {
+		problems = append(problems, fmt.Sprintf("lipschitz must be a finite number > 0, got %g", cfg.Lipschitz))
+	}
+	if !(*cfg.ProxMu >= 0) || math.IsInf(*cfg.ProxMu, 0) {
+		problems = append(problems, fmt.Sprintf("prox_mu must be a finite number ≥ 0, got %g", *cfg.ProxMu))
+	}
+	collect := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	cfg.QSchedule.validate("q_schedule.", collect)
+	cfg.Staleness.validate("staleness.", collect)
+	if cfg.Compression != nil {
+		if err := cfg.Compression.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, h := range cfg.Hospitals {
+		if err := h.Train.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("hospital %s: %v", h.ID, err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("simulation: %s", strings.Join(problems, "; "))
+	}
+
+	start := time.Unix(1700000000, 0)
+	s := &Simulation{
+		cfg:   cfg,
+		q:     DefaultConfig().Aggregation.Q,
+		clock: NewVirtualClock(start),
+		start: start,
+		rng:   rand.New(rand.NewSource(cfg.Seed)),
+	}
+	if cfg.Q != nil {
+		s.q = *cfg.Q
+	}
+	s.qBase = s.q
+	s.rm = NewRoundManagerWithClock(cfg.Quorum, s.clock)
+
+	var report *hospital.PartitionReport
+	if cfg.Partitions != "" {
+		var err error
+		if report, err = hospital.LoadPartitionReport(cfg.Partitions); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, h := range cfg.Hospitals {
+		if h.ID == "" || seen[h.ID] {
+			return nil, fmt.Errorf("simulation: hospital IDs must be unique and non-empty (%q)", h.ID)
+		}
+		seen[h.ID] = true
+		data, err := s.loadData(h, report)
+		if err != nil {
+			return nil, err
+		}
+		s.data = append(s.data, data)
+		s.all = append(s.all, data...)
+	}
+
+	s.spec = hospital.ModelSpec{Type: cfg.Model, InputSize: len(s.all[0].Features), Hidden: cfg.Hidden}
+	initial, err := hospital.NewClassifier(s.spec)
+	if err != nil {
+		return nil, fmt.Errorf("simulation: %w", err)
+	}
+	s.history = [][]float64{initial.Flatten()}
+	s.busy = make([]bool, len(cfg.Hospitals))
+	s.participated = make([]int, len(cfg.Hospitals))
+	s.attempts = make([]int, len(cfg.Hospitals))
+	s.feedback = make([]*hospital.ErrorFeedback, len(cfg.Hospitals))
+	for i := range s.feedback {
+		s.feedback[i] = &hospital.ErrorFeedback{}
+	}
+	for i := range s.participated {
+		s.participated[i] = -1
+	}
+	return s, nil
+}
+
+// loadData returns a hospital's samples from its override, its partition file or its CSV range.
+func (s *Simulation) loadData(h SimHospital, report *hospital.PartitionReport) ([]hospital.Sample, error) {
+	if len(h.Data) > 0 {
+		return h.Data, nil
+	}
+	cfg := hospital.HospitalConfig{ID: h.ID, CSVPath: s.cfg.Data, StartIdx: h.Start, EndIdx: h.End}
+	if report != nil {
+		found := false
+		for _, c := range report.HospitalConfigs(s.cfg.Partitions) {
+			if c.ID == h.ID {
+				cfg, found = c, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("simulation: hospital %s not in %s", h.ID, filepath.Join(s.cfg.Partitions, hospital.PartitionReportFile))
+		}
+	} else if s.cfg.Data == "" {
+		return nil, fmt.Errorf("simulation: hospital %s has no data (set data, partitions or Data)", h.ID)
+	}
+	data, _, err := hospital.LoadHospitalData(cfg)
+	return data, err
+}
+
+// Run processes events until cfg.Rounds aggregations have happened, nothing
+// is left to do, or the virtual time budget runs out.
+func (s *Simulation) Run() (*SimResult, error) {
+	for h := range s.cfg.Hospitals {
+		s.schedule(s.start, h, nil, 0)
+	}
+	deadline := s.start.Add(time.Duration(s.cfg.MaxTimeS * float64(time.Second)))
+
+	for len(s.result.Rounds) < s.cfg.Rounds {
+		if s.queue.Len() == 0 {
+			s.result.Stalled = true
+			break
+		}
+		e := heap.Pop(&s.queue).(simEvent)
+		if e.at.After(deadline) {
+			s.result.Stalled = true
+			break
+		}
+		s.clock.Set(e.at)
+		var err error
+		if e.packet == nil {
+			if e.gen == s.attempts[e.h] {
+				err = s.attempt(e.h)
+			}
+		} else {
+			err = s.arrive(e.h, *e.packet, e.bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.result.TimeS = s.elapsed()
+	return &s.result, nil
+}
+
+// schedule queues an arrival of bytes, or (packet == nil) an attempt that
+// supersedes any attempt already queued for h.
+func (s *Simulation) schedule(at time.Time, h int, packet *UpdatePacket, bytes int) {
+	s.seq++
+	e := simEvent{at: at, seq: s.seq, h: h, packet: packet, bytes: bytes}
+	if packet == nil {
+		s.attempts[h]++
+		e.gen = s.attempts[h]
+	}
+	heap.Push(&s.queue, e)
+}
+
+func (s *Simulation) elapsed() float64 {
+	return s.clock.Now().Sub(s.start).Seconds()
+}
+
+func seconds(v float64) time.Duration {
+	return time.Duration(v * float64(time.Second))
+}
+
+// attempt starts (or abandons) one hospital's training for the open round.
+func (s *Simulation) attempt(h int) error {
+	round, _, _, _ := s.rm.Status()
+	if s.busy[h] || s.participated[h] >= round {
+		return nil
+	}
+	cfg := s.cfg.Hospitals[h]
+	version := len(s.history) - 1 - cfg.Staleness
+	if version < 0 {
+		version = 0
+	}
+	if s.rng.Float64() < cfg.Dropout {
+		s.log(cfg.ID, round, version, SimDropout)
+		s.schedule(s.clock.Now().Add(seconds(s.cfg.RetryS)), h, nil, 0)
+		return nil
+	}
+
+	global, err := hospital.ClassifierFromWeights(s.spec, s.history[version])
+	if err != nil {
+		return err
+	}
+	hcfg := hospital.HospitalConfig{ID: cfg.ID, RoundID: round, ModelVersion: version, ProxMu: s.cfg.ProxMu,
+		Train: cfg.Train, Compression: s.cfg.Compression, Feedback: s.feedback[h]}
+	hp, err := hospital.BuildUpdatePacket(global, hcfg, s.data[h], nil)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(hp)
+	if err != nil {
+		return err
+	}
+	var packet UpdatePacket
+	if err := json.Unmarshal(raw, &packet); err != nil {
+		return err
+	}
+
+	latency := cfg.LatencyS + cfg.JitterS*s.rng.Float64()
+	signed := s.clock.Now().Add(seconds(latency))
+	packet.Metadata.Timestamp = signed.Unix()
+	if packet.Signature, err = signMetadata(packet.Metadata); err != nil {
+		return err
+	}
+	s.busy[h] = true
+	s.schedule(signed.Add(seconds(cfg.NetworkDelayS)), h, &packet, len(raw))
+	return nil
+}
+
+// arrive runs the server's submit pipeline on an update at the current virtual time.
+func (s *Simulation) arrive(h int, packet UpdatePacket, bytes int) error {
+	s.busy[h] = false
+	id := packet.Metadata.HospitalID
+	round, _, _, _ := s.rm.Status()
+	c := updateCheck{federation: defaultFederationID, now: s.clock.Now(), models: s.model,
+		version: len(s.history) - 1, round: round, staleness: s.cfg.Staleness}
+
+	rej := c.check(&packet)
+	switch {
+	case rej != nil && rej.code == "stale_timestamp":
+		s.log(id, packet.Metadata.RoundID, packet.Metadata.ModelVersion, SimStaleTimestamp)
+	case rej != nil && rej.code == "too_stale":
+		s.log(id, packet.Metadata.RoundID, packet.Metadata.ModelVersion, SimTooStale)
+	case rej != nil:
+		// The simulated hospitals sign and encode correctly, so anything
+		// else is a bug rather than an outcome.
+		return fmt.Errorf("simulation: %s: %s: %v", id, rej.code, rej.err)
+	default:
+		accepted, quorumMet := s.rm.RecordUpdate(id, packet.Metadata.RoundID)
+		if !accepted {
+			s.log(id, packet.Metadata.RoundID, packet.Metadata.ModelVersion, SimRejected)
+			break
+		}
+		outcome := SimAccepted
+		if packet.Metadata.RoundID < round {
+			outcome = SimLate
+		}
+		s.log(id, packet.Metadata.RoundID, packet.Metadata.ModelVersion, outcome)
+		s.participated[h] = round
+		s.buffer = append(s.buffer, packet)
+		s.bufferBytes += bytes
+		if quorumMet {
+			return s.aggregate()
+		}
+	}
+	// Still idle in an open round it has not contributed to: try again now.
+	s.schedule(s.clock.Now(), h, nil, 0)
+	return nil
+}
+
+// model returns global model version v.
+func (s *Simulation) model(v int) []float64 {
+	if v < 0 || v >= len(s.history) {
+		return nil
+	}
+	return s.history[v]
+}
+
+// aggregate runs the configured aggregation over the buffered updates, records metrics and opens the next round.
+func (s *Simulation) aggregate() error {
+	round, expected, received, _ := s.rm.Status()
+	version := len(s.history) - 1
+	s.q = s.cfg.QSchedule.nextQ(s.qBase, s.q, len(s.result.Rounds), s.buffer)
+	agg := aggregator{algorithm: s.cfg.Algorithm, q: s.q, lipschitz: s.cfg.Lipschitz, staleness: s.cfg.Staleness, models: s.model}
+	weights, err := agg.aggregate(s.buffer, version)
+	if err != nil {
+		return fmt.Errorf("simulation: round %d: %w", round, err)
+	}
+
+	m := RoundMetrics{
+		Round:   round,
+		Version: version + 1,
+		TimeS:   s.elapsed(),
+		Updates: len(s.buffer),
+		Timeout: received < expected,
+		Q:       s.q,
+
+		UploadBytes: s.bufferBytes,
+	}
+	for _, p := range s.buffer {
+		if p.Metadata.RoundID < round {
+			m.Late++
+		}
+		m.MeanStaleness += float64(version-p.Metadata.ModelVersion) / float64(len(s.buffer))
+	}
+	if err := s.evaluate(weights, &m); err != nil {
+		return err
+	}
+
+	s.history = append(s.history, weights)
+	s.buffer, s.bufferBytes = nil, 0
+	s.result.Rounds = append(s.result.Rounds, m)
+	s.rm.AdvanceRound()
+
+	for h := range s.cfg.Hospitals {
+		if !s.busy[h] {
+			s.schedule(s.clock.Now(), h, nil, 0)
+		}
+	}
+	return nil
+}
+
+// evaluate fills the global-model metrics of m.
+func (s *Simulation) evaluate(weights []float64, m *RoundMetrics) error {
+	model, err := hospital.ClassifierFromWeights(s.spec, weights)
+	if err != nil {
+		return err
+	}
+	var losses []float64
+	total := 0.0
+	for _, data := range s.data {
+		loss := model.Loss(data)
+		losses = append(losses, loss)
+		total += loss * float64(len(data))
+		m.WorstLoss = math.Max(m.WorstLoss, loss)
+	}
+	m.Loss = total / float64(len(s.all))
+	m.Accuracy = hospital.Evaluate(model, s.all).Accuracy
+
+	mean := 0.0
+	for _, l := range losses {
+		mean += l / float64(len(losses))
+	}
+	for _, l := range losses {
+		m.LossStd += (l - mean) * (l - mean) / float64(len(losses))
+	}
+	m.LossStd = math.Sqrt(m.LossStd)
+	return nil
+}
+
+func (s *Simulation) log(id string, round, version int, outcome string) {
+	s.result.Events = append(s.result.Events, SimEvent{
+		TimeS: s.elapsed(), Hospital: id, Round: round, Version: version, Outcome: outcome,
+	})
+}
+
+// loadSimConfig reads a JSON SimConfig, resolving relative data paths
+// against the config file.
+func loadSimConfig(configPath string) (SimConfig, error) {
+	var cfg SimConfig
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return cfg, fmt.Errorf("read simulation config: %w", err)
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse simulation config %s: %w", configPath, err)
+	}
+	base := filepath.Dir(configPath)
+	for _, p := range []*string{&cfg.Data, &cfg.Partitions} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(base, *p)
+		}
+	}
+	return cfg, nil
+}
+
+// runSimulation is the -simulate entry point: it loads a JSON SimConfig, runs
+// it quietly and prints per-round metrics, optionally writing them as JSON.
+// With a q sweep (from -q-sweep or the config's q_sweep) it runs runQSweep
+// and prints one line per q instead.
+func runSimulation(configPath, outPath string, sweep []float64, verbose bool) error {
+	cfg, err := loadSimConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if len(sweep) == 0 {
+		sweep = cfg.QSweep
+	}
+
+	if !verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	var out interface{}
+	if len(sweep) > 0 {
+		points, err := runQSweep(cfg, sweep)
+		if err != nil {
+			return err
+		}
+		writeQSweep(os.Stdout, points)
+		out = points
+	} else {
+		sim, err := NewSimulation(cfg)
+		if err != nil {
+			return err
+		}
+		result, err := sim.Run()
+		if err != nil {
+			return err
+		}
+		writeSimResult(os.Stdout, result)
+		out = result
+	}
+
+	if outPath != "" {
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(outPath, b, 0644); err != nil {
+			return fmt.Errorf("write simulation metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeSimResult prints a simulation's per-round metrics and outcome counts.
+func writeSimResult(w io.Writer, result *SimResult) {
+	fmt.Fprintf(w, "%5s %7s %9s %7s %4s %7s %9s %6s %8s %8s %10s %8s %8s\n",
+		"round", "version", "time_s", "updates", "late", "timeout", "staleness", "q", "loss", "accuracy", "worst_loss", "loss_std", "bytes")
+	for _, m := range result.Rounds {
+		fmt.Fprintf(w, "%5d %7d %9.1f %7d %4d %7v %9.2f %6.2f %8.4f %8.4f %10.4f %8.4f %8d\n",
+			m.Round, m.Version, m.TimeS, m.Updates, m.Late, m.Timeout, m.MeanStaleness,
+			m.Q, m.Loss, m.Accuracy, m.WorstLoss, m.LossStd, m.UploadBytes)
+	}
+	counts := make(map[string]int)
+	for _, e := range result.Events {
+		counts[e.Outcome]++
+	}
+	fmt.Fprintf(w, "\n%d rounds in %.1f virtual seconds | outcomes: %v", len(result.Rounds), result.TimeS, counts)
+	if result.Stalled {
+		fmt.Fprint(w, " | STALLED")
+	}
+	fmt.Fprintln(w)
+}
+
+// QSweepPoint is the final global model of one run of a q sweep. Accuracy
+// and Loss measure the model overall; WorstLoss and LossStd how evenly it
+// serves the hospitals. Pareto marks runs no other q beats on both accuracy
+// and worst_loss.
+type QSweepPoint struct {
+	Q         float64 `json:"q"`
+	Rounds    int     `json:"rounds"`
+	Stalled   bool    `json:"stalled"`
+	Loss      float64 `json:"loss"`
+	Accuracy  float64 `json:"accuracy"`
+	WorstLoss float64 `json:"worst_loss"`
+	LossStd   float64 `json:"loss_std"`
+	Pareto    bool    `json:"pareto"`
+
+	Result *SimResult `json:"result"`
+}
+
+// runQSweep runs cfg once per q with a constant schedule. Every run uses the
+// same seed, so the runs differ only in q.
+func runQSweep(cfg SimConfig, qs []float64) ([]QSweepPoint, error) {
+	var points []QSweepPoint
+	for _, q := range qs {
+		run := cfg
+		run.Q = &q
+		run.QSchedule = QScheduleConfig{Type: QConstant}
+		run.QSweep = nil
+		sim, err := NewSimulation(run)
+		if err != nil {
+			return nil, err
+		}
+		result, err := sim.Run()
+		if err != nil {
+			return nil, fmt.Errorf("q=%g: %w", q, err)
+		}
+		p := QSweepPoint{Q: q, Rounds: len(result.Rounds), Stalled: result.Stalled, Result: result}
+		if n := len(result.Rounds); n > 0 {
+			last := result.Rounds[n-1]
+			p.Loss, p.Accuracy, p.WorstLoss, p.LossStd = last.Loss, last.Accuracy, last.WorstLoss, last.LossStd
+		}
+		points = append(points, p)
+	}
+	for i := range points {
+		points[i].Pareto = points[i].Rounds > 0
+		for _, o := range points {
+			if o.Rounds > 0 && o.Accuracy >= points[i].Accuracy && o.WorstLoss <= points[i].WorstLoss &&
+				(o.Accuracy > points[i].Accuracy || o.WorstLoss < points[i].WorstLoss) {
+				points[i].Pareto = false
+			}
+		}
+	}
+	return points, nil
+}
+
+// writeQSweep prints one line per q of a sweep.
+func writeQSweep(w io.Writer, points []QSweepPoint) {
+	fmt.Fprintf(w, "%6s %6s %8s %8s %10s %8s %6s\n", "q", "rounds", "loss", "accuracy", "worst_loss", "loss_std", "pareto")
+	for _, p := range points {
+		rounds := fmt.Sprint(p.Rounds)
+		if p.Stalled {
+			rounds += "!"
+		}
+		pareto := ""
+		if p.Pareto {
+			pareto = "*"
+		}
+		fmt.Fprintf(w, "%6.2f %6s %8.4f %8.4f %10.4f %8.4f %6s\n", p.Q, rounds, p.Loss, p.Accuracy, p.WorstLoss, p.LossStd, pareto)
+	}
+	fmt.Fprintln(w, "\n! stalled before all rounds; * not beaten on both accuracy and worst_loss by another q")
+}
+
+// parseQSweep parses -q-sweep, a comma-separated list of q values.
+func parseQSweep(list string) ([]float64, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var qs []float64
+	for _, f := range strings.Split(list, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || q < 0 || math.IsInf(q, 0) {
+			return nil, fmt.Errorf("-q-sweep: %q is not a finite q ≥ 0", f)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
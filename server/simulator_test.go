@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"step01/hospital"
+)
+
+// simData returns a small separable dataset; offset makes hospitals differ.
+func simData(offset float64) []hospital.Sample {
+	var data []hospital.Sample
+	for i := 0; i < 20; i++ {
+		x := float64(i)/20 + offset
+		label := 0.0
+		if i >= 10 {
+			label = 1
+		}
+		data = append(data, hospital.Sample{Features: []float64{x, 1 - x}, Label: label})
+	}
+	return data
+}
+
+func runSim(t *testing.T, cfg SimConfig) *SimResult {
+	t.Helper()
+	for i := range cfg.Hospitals {
+		cfg.Hospitals[i].Data = simData(float64(i) * 0.1)
+	}
+	sim, err := NewSimulation(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := sim.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func outcomes(result *SimResult, hospitalID string) []string {
+	var out []string
+	for _, e := range result.Events {
+		if e.Hospital == hospitalID {
+			out = append(out, e.Outcome)
+		}
+	}
+	return out
+}
+
+func TestSimulationIsDeterministic(t *testing.T) {
+	cfg := func() SimConfig {
+		return SimConfig{Rounds: 10, Quorum: 2, Seed: 3, Hospitals: []SimHospital{
+			{ID: "H1", LatencyS: 2, JitterS: 3, Dropout: 0.3},
+			{ID: "H2", LatencyS: 4, JitterS: 3, Dropout: 0.3},
+			{ID: "H3", LatencyS: 9, JitterS: 3, Staleness: 1},
+		}}
+	}
+	a, b := runSim(t, cfg()), runSim(t, cfg())
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("two runs with the same seed differ")
+	}
+	if len(a.Rounds) != 10 || a.Stalled {
+		t.Fatalf("expected 10 rounds, got %d (stalled %v)", len(a.Rounds), a.Stalled)
+	}
+	if a.Rounds[9].Loss >= a.Rounds[0].Loss {
+		t.Errorf("global loss did not improve: %.4f → %.4f", a.Rounds[0].Loss, a.Rounds[9].Loss)
+	}
+}
+
+func TestSimulationRoundTimeout(t *testing.T) {
+	// Quorum 3 but H3 always drops out. The timeout is only checked when an
+	// update arrives, so H2 arriving 20s into the round triggers aggregation.
+	late := runSim(t, SimConfig{Rounds: 1, Quorum: 3, Hospitals: []SimHospital{
+		{ID: "H1", LatencyS: 1},
+		{ID: "H2", LatencyS: 20},
+		{ID: "H3", Dropout: 1},
+	}})
+	if len(late.Rounds) != 1 || !late.Rounds[0].Timeout || late.Rounds[0].Updates != 2 || late.Rounds[0].TimeS != 20 {
+		t.Fatalf("expected a timeout aggregation of 2 updates at 20s, got %+v", late.Rounds)
+	}
+
+	// Both updates inside the 15s window: nothing else ever arrives, so the
+	// round stalls below quorum.
+	stalled := runSim(t, SimConfig{Rounds: 1, Quorum: 3, MaxTimeS: 600, Hospitals: []SimHospital{
+		{ID: "H1", LatencyS: 1},
+		{ID: "H2", LatencyS: 10},
+		{ID: "H3", Dropout: 1},
+	}})
+	if !stalled.Stalled || len(stalled.Rounds) != 0 {
+		t.Fatalf("expected a stalled round, got %+v", stalled)
+	}
+}
+
+func TestSimulationLateAndStaleUpdates(t *testing.T) {
+	// Round 0 closes at 2s with H1 and H2. H3's round-0 update lands at 2.5s
+	// and is accepted late into round 1, weighted by staleness 1.
+	result := runSim(t, SimConfig{Rounds: 2, Quorum: 2, Hospitals: []SimHospital{
+		{ID: "H1", LatencyS: 1},
+		{ID: "H2", LatencyS: 2},
+		{ID: "H3", LatencyS: 2.5},
+	}})
+	if got := outcomes(result, "H3"); len(got) == 0 || got[0] != SimLate {
+		t.Fatalf("H3 outcomes %v, want a late update first", got)
+	}
+	r1 := result.Rounds[1]
+	if r1.Late != 1 || r1.MeanStaleness != 0.5 || r1.TimeS != 3 {
+		t.Errorf("round 1: %+v", r1)
+	}
+
+	// A 40s network delay exceeds MaxTimestampAge, so the packet is refused.
+	stale := runSim(t, SimConfig{Rounds: 1, Quorum: 1, MaxTimeS: 100, Hospitals: []SimHospital{
+		{ID: "H1", LatencyS: 1, NetworkDelayS: 40},
+	}})
+	if got := outcomes(stale, "H1"); len(got) < 2 || got[0] != SimStaleTimestamp {
+		t.Errorf("H1 outcomes %v, want stale_timestamp rejections", got)
+	}
+}
+
+func TestQSweepComparesQValuesOnOneSeed(t *testing.T) {
+	cfg := SimConfig{Rounds: 4, Seed: 7, Hospitals: []SimHospital{{ID: "H1", LatencyS: 1}, {ID: "H2", LatencyS: 2}, {ID: "H3", LatencyS: 3}}}
+	for i := range cfg.Hospitals {
+		cfg.Hospitals[i].Data = simData(float64(i) * 0.3)
+	}
+	cfg.QSchedule = QScheduleConfig{Type: QAnneal, QEnd: 5, Rounds: 1} // ignored by the sweep
+	points, err := runQSweep(cfg, []float64{0, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Rounds != 4 || points[1].Result.Rounds[3].Q != 5 || points[0].Result.Rounds[3].Q != 0 {
+		t.Fatalf("points: %+v", points)
+	}
+	if points[0].WorstLoss == points[1].WorstLoss {
+		t.Error("q made no difference to the worst hospital")
+	}
+	if !points[0].Pareto && !points[1].Pareto {
+		t.Error("no point is on the Pareto front")
+	}
+
+	// Outside a sweep the schedule drives q.
+	cfg.QSweep = nil
+	annealed := runSim(t, cfg)
+	if annealed.Rounds[0].Q != 1 || annealed.Rounds[1].Q != 5 {
+		t.Errorf("scheduled q: %g, %g", annealed.Rounds[0].Q, annealed.Rounds[1].Q)
+	}
+}
+
+func TestSimulationRefusesTooStaleUpdates(t *testing.T) {
+	max := 1
+	result := runSim(t, SimConfig{Rounds: 4, Quorum: 1, Staleness: StalenessConfig{MaxStaleness: &max}, Hospitals: []SimHospital{
+		{ID: "H1", LatencyS: 1},
+		{ID: "H2", LatencyS: 1, Staleness: 2},
+	}})
+	h2 := outcomes(result, "H2")
+	if len(result.Rounds) != 4 || len(h2) == 0 || h2[len(h2)-1] != SimTooStale {
+		t.Errorf("H2 outcomes: %v", h2)
+	}
+}
+
+func TestSimulationUsesHospitalTrainConfig(t *testing.T) {
+	cfg := func(train hospital.TrainConfig) SimConfig {
+		return SimConfig{Rounds: 2, Quorum: 2, Seed: 1, Hospitals: []SimHospital{
+			{ID: "H1", LatencyS: 2, Train: train},
+			{ID: "H2", LatencyS: 3, Train: train},
+		}}
+	}
+	plain := runSim(t, cfg(hospital.TrainConfig{}))
+	adam := runSim(t, cfg(hospital.TrainConfig{Optimizer: hospital.OptimizerAdam, Epochs: 5}))
+	if reflect.DeepEqual(plain.Rounds, adam.Rounds) {
+		t.Error("the hospitals' train config did not change the federation's models")
+	}
+	if _, err := NewSimulation(cfg(hospital.TrainConfig{Optimizer: "rmsprop"})); err == nil {
+		t.Error("an unknown optimizer was accepted")
+	}
+}
+
+func TestSimulationProxMuReachesTheHospitals(t *testing.T) {
+	cfg := func(mu *float64) SimConfig {
+		return SimConfig{Rounds: 2, Quorum: 2, Seed: 1, ProxMu: mu, Hospitals: []SimHospital{
+			{ID: "H1", LatencyS: 2}, {ID: "H2", LatencyS: 3},
+		}}
+	}
+	strong, off := 5.0, 0.0
+	if reflect.DeepEqual(runSim(t, cfg(&strong)).Rounds, runSim(t, cfg(&off)).Rounds) {
+		t.Error("prox_mu did not change the federation's models")
+	}
+	// The server default is 0, so leaving prox_mu out matches setting it to 0.
+	if !reflect.DeepEqual(runSim(t, cfg(nil)).Rounds, runSim(t, cfg(&off)).Rounds) {
+		t.Error("an unset prox_mu did not fall back to aggregation.prox_mu")
+	}
+	bad := -1.0
+	if _, err := NewSimulation(cfg(&bad)); err == nil || !strings.Contains(err.Error(), "prox_mu") {
+		t.Errorf("a negative prox_mu was accepted: %v", err)
+	}
+}
+
+func TestSimulationRunsTheServersSubmitChecks(t *testing.T) {
+	cfg := SimConfig{Hospitals: []SimHospital{{ID: "H1", Data: simData(0)}}}
+	sim, err := NewSimulation(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A signed, fresh packet with no data_size, which the handler refuses.
+	packet := UpdatePacket{Weights: []float64{1, 2, 3}, Metadata: Metadata{HospitalID: "H1", Timestamp: sim.clock.Now().Unix()}}
+	if packet.Signature, err = signMetadata(packet.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.arrive(0, packet, 0); err == nil || !strings.Contains(err.Error(), "invalid_fields") {
+		t.Errorf("expected the invalid_fields check, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Staleness is how many model versions an update's base is behind the global
+// model it is aggregated into. The staleness policy discounts stale updates
+// and refuses, at submission, those too far behind to be worth aggregating.
+
+// Staleness functions (staleness.function); s is the staleness.
+const (
+	StalenessHyperbolic  = "hyperbolic"  // 1 / (1 + alpha·s)
+	StalenessPolynomial  = "polynomial"  // (1 + s)^−alpha
+	StalenessExponential = "exponential" // e^(−alpha·s)
+	StalenessHinge       = "hinge"       // 1 up to grace versions behind, then 1 / (1 + alpha·(s − grace))
+	StalenessConstant    = "constant"    // 1: stale updates count in full
+)
+
+// stalenessFunctions lists the accepted staleness.function values.
+var stalenessFunctions = []string{StalenessHyperbolic, StalenessPolynomial, StalenessExponential, StalenessHinge, StalenessConstant}
+
+// StalenessConfig is the staleness policy. The zero value is the default:
+// hyperbolic with alpha 1, accepting any staleness.
+type StalenessConfig struct {
+	Function     string  `json:"function"`                // see stalenessFunctions
+	Alpha        float64 `json:"alpha"`                   // strength of the discount (0 means 1)
+	Grace        int     `json:"grace,omitempty"`         // hinge: versions behind before the discount starts
+	MaxStaleness *int    `json:"max_staleness,omitempty"` // refuse updates more versions or rounds behind; absent accepts any
+}
+
+// discount is the factor an update s versions behind is weighted by.
+func (c StalenessConfig) discount(s int) float64 {
+	if s < 0 {
+		s = 0
+	}
+	alpha := c.Alpha
+	if alpha == 0 {
+		alpha = 1
+	}
+	switch c.Function {
+	case StalenessPolynomial:
+		return math.Pow(1+float64(s), -alpha)
+	case StalenessExponential:
+		return math.Exp(-alpha * float64(s))
+	case StalenessHinge:
+		if s <= c.Grace {
+			return 1
+		}
+		return 1.0 / (1.0 + alpha*float64(s-c.Grace))
+	case StalenessConstant:
+		return 1
+	default:
+		return 1.0 / (1.0 + alpha*float64(s))
+	}
+}
+
+// admit returns why an update versionsBehind the global model and
+// roundsBehind the open round is too stale to accept, or nil.
+func (c StalenessConfig) admit(versionsBehind, roundsBehind int) error {
+	if c.MaxStaleness == nil {
+		return nil
+	}
+	limit := *c.MaxStaleness
+	if versionsBehind > limit {
+		return fmt.Errorf("update was trained %d versions behind the global model; max_staleness is %d, so retrain on the current model", versionsBehind, limit)
+	}
+	if roundsBehind > limit {
+		return fmt.Errorf("update is for a round %d rounds behind the open one; max_staleness is %d, so retrain for the current round", roundsBehind, limit)
+	}
+	return nil
+}
+
+// validate reports problems under prefix (e.g. "staleness.").
+func (c StalenessConfig) validate(prefix string, bad func(string, ...interface{})) {
+	if c.Function != "" && !contains(stalenessFunctions, c.Function) {
+		bad("%sfunction %q must be one of %v", prefix, c.Function, stalenessFunctions)
+	}
+	if c.Alpha < 0 || math.IsNaN(c.Alpha) || math.IsInf(c.Alpha, 0) {
+		bad("%salpha must be a finite number ≥ 0, got %g", prefix, c.Alpha)
+	}
+	if c.Grace < 0 {
+		bad("%sgrace must be ≥ 0, got %d", prefix, c.Grace)
+	}
+	if c.MaxStaleness != nil && *c.MaxStaleness < 0 {
+		bad("%smax_staleness must be ≥ 0, got %d", prefix, *c.MaxStaleness)
+	}
+}
+
+// stalenessOf is how many versions packet's base is behind version.
+func stalenessOf(packet UpdatePacket, version int) int {
+	if s := version - packet.Metadata.ModelVersion; s > 0 {
+		return s
+	}
+	return 0
+}
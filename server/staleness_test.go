@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStalenessFunctions(t *testing.T) {
+	for _, c := range []struct {
+		policy StalenessConfig
+		want   [3]float64 // discount at staleness 0, 1, 3
+	}{
+		{StalenessConfig{}, [3]float64{1, 1.0 / 2, 1.0 / 4}},
+		{StalenessConfig{Function: StalenessHyperbolic, Alpha: 0.5}, [3]float64{1, 1 / 1.5, 1 / 2.5}},
+		{StalenessConfig{Function: StalenessPolynomial, Alpha: 2}, [3]float64{1, 1.0 / 4, 1.0 / 16}},
+		{StalenessConfig{Function: StalenessExponential, Alpha: 1}, [3]float64{1, math.Exp(-1), math.Exp(-3)}},
+		{StalenessConfig{Function: StalenessHinge, Alpha: 1, Grace: 1}, [3]float64{1, 1, 1.0 / 3}},
+		{StalenessConfig{Function: StalenessConstant}, [3]float64{1, 1, 1}},
+	} {
+		for i, s := range []int{0, 1, 3} {
+			if got := c.policy.discount(s); math.Abs(got-c.want[i]) > 1e-12 {
+				t.Errorf("%+v at staleness %d: %g, want %g", c.policy, s, got, c.want[i])
+			}
+		}
+	}
+
+	max := 1
+	var problems []string
+	StalenessConfig{Function: "linear", Alpha: -1, MaxStaleness: &max}.validate("staleness.", func(f string, a ...interface{}) {
+		problems = append(problems, f)
+	})
+	if len(problems) != 2 {
+		t.Errorf("problems: %v", problems)
+	}
+}
+
+func TestSubmitRefusesTooStaleUpdates(t *testing.T) {
+	f := setupAdmin(t)
+	max := 1
+	f.Config.Staleness = StalenessConfig{Function: StalenessConstant, MaxStaleness: &max}
+	f.roundManager.SetQuorum(3)
+	f.currentVersion = 3
+	submit := func(hospitalID string, version, round int) *httptest.ResponseRecorder {
+		packet := UpdatePacket{Weights: []float64{1, 2}, Metadata: Metadata{HospitalID: hospitalID, DataSize: 10, Loss: 1,
+			ModelVersion: version, RoundID: round, Timestamp: time.Now().Unix()}}
+		packet.Signature, _ = signMetadata(packet.Metadata)
+		b, _ := json.Marshal(packet)
+		return call(handleFederations, "POST", "/federations/default/submit_update", hospitalToken(hospitalID), string(b))
+	}
+
+	if rec := submit("H1", 1, 0); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "2 versions behind") {
+		t.Fatalf("too stale version: %d %s", rec.Code, rec.Body)
+	}
+	if rec := submit("H2", 2, 0); rec.Code != http.StatusOK {
+		t.Fatalf("one version behind: %d %s", rec.Code, rec.Body)
+	}
+	if rec := submit("H3", 3, 0); rec.Code != http.StatusOK {
+		t.Fatalf("current: %d %s", rec.Code, rec.Body)
+	}
+	f.aggregateUpdates()
+
+	// The aggregation opened round 1; in round 2 a round-0 update is two rounds behind.
+	f.roundManager.AdvanceRound()
+	if rec := submit("H1", 4, 0); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "2 rounds behind") {
+		t.Errorf("two rounds behind: %d %s", rec.Code, rec.Body)
+	}
+
+	var state struct {
+		History    []RoundRecord     `json:"history"`
+		Rejections []RejectionRecord `json:"rejections"`
+	}
+	rec := call(handleFederations, "GET", "/federations/default/dashboard_state", "op-token", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil || len(state.History) != 1 {
+		t.Fatalf("dashboard: %v %s", err, rec.Body)
+	}
+	round := state.History[0]
+	if round.StaleRejected != 1 || round.Late != 1 || round.MeanStaleness != 0.5 || round.StaleWeight != 0.5 ||
+		round.Updates[0].Staleness != 1 || round.Updates[0].Discount != 1 {
+		t.Errorf("round record: %+v", round)
+	}
+	if len(state.Rejections) != 2 || state.Rejections[0].Code != "too_stale" {
+		t.Errorf("rejections: %+v", state.Rejections)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"step01/hospital"
+)
+
+// /submit_update and /global_model speak JSON by default and step-01's
+// binary wire format (hospital.WireContentType) when the Content-Type or
+// Accept header asks for it. Either may be gzipped: requests with
+// Content-Encoding: gzip, responses when Accept-Encoding allows it.
+
+// Submission bodies are capped before they are parsed: a JSON packet spends
+// at most maxBytesPerWeight on each weight of the model, plus
+// maxMetadataBytes for everything else. Until the model's size is known the
+// cap is defaultMaxBody. It holds for the body as sent and, separately, for
+// the body after gzip, so a small compressed body cannot inflate without end.
+const (
+	maxBytesPerWeight = 32 // "-1.2345678901234567e-05," with room to spare
+	maxMetadataBytes  = 1 << 20
+	defaultMaxBody    = 64 << 20
+)
+
+// maxUpdateBody is the cap on a submission body for f's model.
+func (f *Federation) maxUpdateBody() int64 {
+	f.aggregationMutex.Lock()
+	n := len(f.globalWeights)
+	f.aggregationMutex.Unlock()
+	if n == 0 {
+		f.mu.Lock()
+		if len(f.receivedUpdates) > 0 {
+			n = len(f.receivedUpdates[0].Weights)
+		}
+		f.mu.Unlock()
+	}
+	if n == 0 {
+		return defaultMaxBody
+	}
+	return maxMetadataBytes + maxBytesPerWeight*int64(n)
+}
+
+// readPacket decodes a submission body read through body, allowing at most
+// limit bytes once decompressed. On failure reason and status are the
+// rejection's; a body over its cap is 413 body_too_large.
+func readPacket(r *http.Request, body io.Reader, limit int64, packet *UpdatePacket) (reason string, status int, err error) {
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			if tooLarge(err) {
+				return "body_too_large", http.StatusRequestEntityTooLarge, fmt.Errorf("Body exceeds %d bytes", limit)
+			}
+			return "invalid_body", http.StatusBadRequest, fmt.Errorf("Invalid gzip body: %v", err)
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return "invalid_body", http.StatusUnsupportedMediaType, fmt.Errorf("Content-Encoding %q is not supported; use gzip or none", enc)
+	}
+	// One byte past the limit tells a body of exactly limit bytes from a larger one.
+	decoded := &byteCounter{r: io.LimitReader(body, limit+1)}
+	overLimit := func(err error) bool { return tooLarge(err) || int64(decoded.n) > limit }
+
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != hospital.WireContentType {
+		if err := json.NewDecoder(decoded).Decode(packet); err != nil {
+			if overLimit(err) {
+				return "body_too_large", http.StatusRequestEntityTooLarge, fmt.Errorf("Body exceeds %d bytes", limit)
+			}
+			return "invalid_json", http.StatusBadRequest, fmt.Errorf("Invalid JSON body")
+		}
+		return "", 0, nil
+	}
+	b, err := io.ReadAll(decoded)
+	if overLimit(err) {
+		return "body_too_large", http.StatusRequestEntityTooLarge, fmt.Errorf("Body exceeds %d bytes", limit)
+	}
+	if err != nil {
+		return "invalid_body", http.StatusBadRequest, fmt.Errorf("Unreadable body: %v", err)
+	}
+	wp, err := hospital.DecodeWirePacket(b)
+	if err != nil {
+		return "invalid_body", http.StatusBadRequest, err
+	}
+	if err := json.Unmarshal(wp.Metadata, &packet.Metadata); err != nil {
+		return "invalid_body", http.StatusBadRequest, fmt.Errorf("wire: metadata: %v", err)
+	}
+	if wp.Version != packet.Metadata.ModelVersion {
+		return "invalid_body", http.StatusBadRequest, fmt.Errorf("wire: header is for model version %d, metadata for %d", wp.Version, packet.Metadata.ModelVersion)
+	}
+	packet.Weights, packet.Delta, packet.Signature = wp.Weights, wp.Delta, wp.Signature
+	return "", 0, nil
+}
+
+// tooLarge reports whether err comes from http.MaxBytesReader.
+func tooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// writeModel answers r with a model of version: info and the weights as
+// JSON, or info as the binary model's JSON and the weights in binary.
+func writeModel(w http.ResponseWriter, r *http.Request, version int, info map[string]interface{}, weights []float64) {
+	precision := wirePrecision(r.Header.Get("Accept"))
+	var body []byte
+	var err error
+	if precision == 0 {
+		info["weights"] = weights
+		body, err = json.Marshal(info)
+		body = append(body, '\n')
+	} else {
+		var raw []byte
+		if raw, err = json.Marshal(info); err == nil {
+			body, err = hospital.WireModel{Version: version, Info: raw, Weights: weights}.Encode(precision)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+	if precision == 0 {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", mime.FormatMediaType(hospital.WireContentType, map[string]string{"precision": fmt.Sprint(precision)}))
+	}
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write(body)
+	gz.Close()
+}
+
+// wirePrecision is the weight precision an Accept header asks the binary
+// format for (its precision parameter, 64 by default), or 0 for JSON.
+func wirePrecision(accept string) int {
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil || mt != hospital.WireContentType || params["q"] == "0" {
+			continue
+		}
+		if params["precision"] == "32" {
+			return 32
+		}
+		return 64
+	}
+	return 0
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
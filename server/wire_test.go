@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"step01/hospital"
+)
+
+// send runs one request with headers through the federation router.
+func send(method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handleFederations(rec, req)
+	return rec
+}
+
+func gzipped(b []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(b)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestSubmitAcceptsBinaryAndGzipBodies(t *testing.T) {
+	f := setupAdmin(t)
+	f.roundManager.SetQuorum(4)
+	spec := hospital.ModelSpec{Type: hospital.ModelMLP, InputSize: 2, Hidden: []int{4}}
+	global, _ := hospital.NewClassifier(spec)
+	wire := func(id string, c *hospital.Compression, precision int) ([]byte, *hospital.UpdatePacket) {
+		hp, err := hospital.BuildUpdatePacket(global, hospital.HospitalConfig{ID: id, Compression: c}, simData(0), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wp, _ := hp.WirePacket()
+		b, err := wp.Encode(precision)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b, hp
+	}
+	binary := func(id string) map[string]string {
+		return map[string]string{"Content-Type": hospital.WireContentType, "Authorization": "Bearer " + hospitalToken(id)}
+	}
+	gzipBinary := map[string]string{"Content-Type": hospital.WireContentType, "Content-Encoding": "gzip", "Authorization": "Bearer h2-token"}
+
+	b, h1 := wire("H1", nil, 64)
+	if rec := send("POST", "/federations/default/submit_update", b, binary("H1")); rec.Code != http.StatusOK {
+		t.Fatalf("binary: %d %s", rec.Code, rec.Body)
+	}
+	if got := f.receivedUpdates[0]; !reflect.DeepEqual(got.Weights, h1.Weights) || !verifySignature(got) {
+		t.Errorf("binary packet arrived changed: %+v", got.Metadata)
+	}
+	b, _ = wire("H2", &hospital.Compression{Codec: hospital.CodecQ8}, 32)
+	if rec := send("POST", "/federations/default/submit_update", gzipped(b), gzipBinary); rec.Code != http.StatusOK {
+		t.Fatalf("gzipped binary delta: %d %s", rec.Code, rec.Body)
+	}
+	_, h3 := wire("H3", nil, 64)
+	asJSON, _ := json.Marshal(h3)
+	if rec := send("POST", "/federations/default/submit_update", gzipped(asJSON), map[string]string{"Content-Encoding": "gzip", "Authorization": "Bearer h3-token"}); rec.Code != http.StatusOK {
+		t.Fatalf("gzipped JSON: %d %s", rec.Code, rec.Body)
+	}
+
+	b, _ = wire("H4", nil, 64)
+	b[len(b)-1] ^= 1
+	if rec := send("POST", "/federations/default/submit_update", b, binary("H4")); rec.Code != http.StatusBadRequest {
+		t.Errorf("damaged binary: %d %s", rec.Code, rec.Body)
+	}
+	if rec := send("POST", "/federations/default/submit_update", asJSON, map[string]string{"Content-Encoding": "br", "Authorization": "Bearer h3-token"}); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("brotli: %d %s", rec.Code, rec.Body)
+	}
+	if len(f.receivedUpdates) != 3 || f.rejections[len(f.rejections)-1].Code != "invalid_body" {
+		t.Errorf("%d updates buffered, rejections %+v", len(f.receivedUpdates), f.rejections)
+	}
+}
+
+func TestGlobalModelNegotiatesFormat(t *testing.T) {
+	f := setupAdmin(t)
+	f.globalWeights = []float64{0.1, -0.2, 1.0 / 3}
+	f.currentVersion = 4
+
+	rec := send("GET", "/federations/default/global_model", nil, map[string]string{"Authorization": "Bearer h1-token"})
+	var plain struct {
+		Weights []float64 `json:"weights"`
+		Version int       `json:"model_version"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &plain); err != nil || rec.Header().Get("Content-Type") != "application/json" ||
+		!reflect.DeepEqual(plain.Weights, f.globalWeights) || plain.Version != 4 {
+		t.Fatalf("JSON: %v %s", err, rec.Body)
+	}
+
+	rec = send("GET", "/federations/default/global_model", nil, map[string]string{
+		"Accept":          "application/json;q=0.5, " + hospital.WireContentType + ";precision=32",
+		"Accept-Encoding": "gzip, deflate",
+		"Authorization":   "Bearer h1-token",
+	})
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Type") != hospital.WireContentType+"; precision=32" {
+		t.Fatalf("headers: %v", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(gz)
+	m, err := hospital.DecodeWireModel(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info map[string]interface{}
+	json.Unmarshal(m.Info, &info)
+	if m.Version != 4 || info["model_version"] != 4.0 || info["weights"] != nil {
+		t.Errorf("binary model: version %d, info %v", m.Version, info)
+	}
+	for i, w := range f.globalWeights {
+		if m.Weights[i] != float64(float32(w)) {
+			t.Errorf("weight %d: %g, want float32 of %g", i, m.Weights[i], w)
+		}
+	}
+}
+
+func TestSubmitCapsBodySize(t *testing.T) {
+	f := setupAdmin(t)
+	f.globalWeights = make([]float64, 10)
+	limit := f.maxUpdateBody()
+
+	// Whitespace is valid JSON, so only the cap stops the decoder reading on.
+	bomb := append(bytes.Repeat([]byte(" "), int(limit)+1), "{}"...)
+	compressed := gzipped(bomb)
+	if int64(len(compressed)) >= limit/100 {
+		t.Fatalf("test body compresses to %d bytes", len(compressed))
+	}
+	if rec := send("POST", "/federations/default/submit_update", compressed, map[string]string{"Content-Encoding": "gzip", "Authorization": "Bearer h1-token"}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("inflating gzip body: %d %s", rec.Code, rec.Body)
+	}
+	if rec := send("POST", "/federations/default/submit_update", compressed, map[string]string{"Content-Encoding": "gzip", "Content-Type": hospital.WireContentType, "Authorization": "Bearer h1-token"}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("inflating gzip binary body: %d %s", rec.Code, rec.Body)
+	}
+	if rec := send("POST", "/federations/default/submit_update", bomb, map[string]string{"Authorization": "Bearer h1-token"}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized plain body: %d %s", rec.Code, rec.Body)
+	}
+	if len(f.rejections) != 3 || f.rejections[0].Code != "body_too_large" {
+		t.Errorf("rejections: %+v", f.rejections)
+	}
+
+	// Bodies within the cap still parse.
+	if rec := send("POST", "/federations/default/submit_update", gzipped(bomb[len(bomb)-100:]), map[string]string{"Content-Encoding": "gzip", "Authorization": "Bearer h1-token"}); rec.Code == http.StatusRequestEntityTooLarge {
+		t.Errorf("small body refused: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,136 @@
+// Command partition splits a CSV dataset across N simulated hospitals with a
+// chosen heterogeneity (IID, Dirichlet label skew, quantity skew or feature
+// skew) and writes one CSV per hospital plus partition_report.json.
+//
+//	go run ./cmd/partition -n 5 -mode dirichlet -alpha 0.3 -out partitions
+//	go run . -partitions partitions
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"step01/hospital"
+)
+
+func main() {
+	in := flag.String("in", "../Medicaldataset.csv", "CSV dataset to split")
+	out := flag.String("out", "partitions", "Output directory for H1.csv … Hn.csv and the report")
+	n := flag.Int("n", 3, "Number of hospitals")
+	mode := flag.String("mode", "iid", "Split: iid, dirichlet (label skew), quantity or feature")
+	alpha := flag.Float64("alpha", 0.5, "Dirichlet concentration for dirichlet/quantity modes (smaller = more skewed)")
+	seed := flag.Int64("seed", 42, "Random seed")
+	label := flag.String("label", "Result", "Label column")
+	feature := flag.String("feature", "Age", "Column banded by feature mode")
+	bands := flag.String("bands", "", "Comma-separated band cut points for feature mode, e.g. 40,55,70 (default: equal-sized bands)")
+	flag.Parse()
+
+	header, records, err := readCSV(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := hospital.PartitionConfig{
+		Mode:        hospital.PartitionMode(*mode),
+		Hospitals:   *n,
+		Alpha:       *alpha,
+		Seed:        *seed,
+		LabelColumn: *label,
+	}
+	if cfg.Mode == hospital.PartitionFeature {
+		cfg.Feature = *feature
+		if cfg.Bands, err = parseBands(*bands); err != nil {
+			log.Fatalf("-bands: %v", err)
+		}
+	}
+	if cfg.Mode != hospital.PartitionDirichlet && cfg.Mode != hospital.PartitionQuantity {
+		cfg.Alpha = 0
+	}
+
+	parts, report, err := hospital.Partition(header, records, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report.Source = *in
+	if err := hospital.WritePartitions(*out, header, records, parts, report); err != nil {
+		log.Fatal(err)
+	}
+	printReport(report)
+	fmt.Printf("\nWrote %d partitions and %s to %s\n", len(parts), hospital.PartitionReportFile, *out)
+}
+
+func readCSV(path string) ([]string, [][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if len(rows) < 2 {
+		return nil, nil, fmt.Errorf("%s has no records", path)
+	}
+	return rows[0], rows[1:], nil
+}
+
+func parseBands(s string) ([]float64, error) {
+	var cuts []float64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cut point %q", part)
+		}
+		cuts = append(cuts, v)
+	}
+	if !sort.Float64sAreSorted(cuts) {
+		return nil, fmt.Errorf("cut points must be ascending")
+	}
+	return cuts, nil
+}
+
+func printReport(r *hospital.PartitionReport) {
+	var labels []string
+	for l := range r.Labels {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	fmt.Printf("Mode: %s | hospitals: %d | seed: %d", r.Mode, r.Hospitals, r.Seed)
+	if r.Alpha > 0 {
+		fmt.Printf(" | alpha: %g", r.Alpha)
+	}
+	fmt.Printf(" | label skew (mean TV): %.3f\n\n", r.LabelSkew)
+	fmt.Printf("%-4s %6s", "ID", "rows")
+	for _, l := range labels {
+		fmt.Printf(" %10s", l)
+	}
+	fmt.Printf(" %8s", "TV")
+	if r.Feature != "" {
+		fmt.Printf("  %s range", r.Feature)
+	}
+	fmt.Println()
+	for _, p := range r.Parts {
+		fmt.Printf("%-4s %6d", p.ID, p.Rows)
+		for _, l := range labels {
+			fmt.Printf(" %10d", p.LabelCounts[l])
+		}
+		fmt.Printf(" %8.3f", p.LabelTV)
+		if p.FeatureMin != nil {
+			fmt.Printf("  %g–%g", *p.FeatureMin, *p.FeatureMax)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,217 @@
+package hospital
+
+import (
+	"fmt"
+)
+
+// Classifier is the model abstraction the trainer and packet builder work
+// against. Every implementation serialises to a single flat []float64 whose
+// layout is described by its ModelSpec, so the server can aggregate any
+// model type without knowing what the numbers mean.
+type Classifier interface {
+	// Spec describes the architecture and therefore the flat parameter layout.
+	Spec() ModelSpec
+	// Forward returns the predicted probability of the positive class for x
+	// (the first output unit for multi-class and multi-label models).
+	Forward(x []float64) float64
+	// Predict returns every output probability for x: one value for binary
+	// models, one per label in Spec().Labels otherwise.
+	Predict(x []float64) []float64
+	// Loss returns the mean loss over data.
+	Loss(data []Sample) float64
+	// Gradient writes the mean gradient of Loss over batch into grad,
+	// using the same layout as Flatten. len(grad) must equal Spec().NumParams().
+	Gradient(batch []Sample, grad []float64)
+	// Flatten returns a copy of all parameters in the layout given by Spec().Shapes().
+	Flatten() []float64
+	// Unflatten overwrites all parameters from flat.
+	Unflatten(flat []float64) error
+}
+
+// Model types understood by NewClassifier.
+const (
+	ModelLogistic = "logistic" // binary, linear
+	ModelSoftmax  = "softmax"  // multi-class, linear
+	ModelMLP      = "mlp"      // any task, with hidden layers
+)
+
+// Prediction tasks. The task fixes the output activation and loss:
+//
+//	binary     — 1 sigmoid unit, BCE on Sample.Label
+//	multiclass — len(Labels) softmax units, cross-entropy on one-hot Sample.Targets
+//	multilabel — len(Labels) sigmoid units, per-label BCE summed over labels on multi-hot Sample.Targets
+const (
+	TaskBinary     = "binary"
+	TaskMultiClass = "multiclass"
+	TaskMultiLabel = "multilabel"
+)
+
+// ModelSpec is the shape metadata shipped with every update packet.
+// It is enough to rebuild an empty model of the right shape on any hospital.
+type ModelSpec struct {
+	Type        string       `json:"type"`
+	InputSize   int          `json:"input_size"`
+	Hidden      []int        `json:"hidden,omitempty"`
+	Activations []Activation `json:"activations,omitempty"` // one per hidden layer, or a single entry for all
+	Task        string       `json:"task,omitempty"`        // "" means TaskBinary
+	Labels      []string     `json:"labels,omitempty"`      // shared label vocabulary (multiclass / multilabel)
+}
+
+// ParamShape names one contiguous block of the flat parameter vector.
+// A block holds Rows×Cols values in row-major order.
+type ParamShape struct {
+	Name string `json:"name"`
+	Rows int    `json:"rows"`
+	Cols int    `json:"cols"`
+	Bias bool   `json:"bias"` // bias blocks are exempt from L1/L2 penalties
+}
+
+// Size is the number of values in the block.
+func (p ParamShape) Size() int { return p.Rows * p.Cols }
+
+// TaskType returns the prediction task, defaulting to binary.
+func (s ModelSpec) TaskType() string {
+	if s.Task == "" {
+		return TaskBinary
+	}
+	return s.Task
+}
+
+// OutputSize is the number of output units: 1 for binary, len(Labels) otherwise.
+func (s ModelSpec) OutputSize() int {
+	if s.TaskType() == TaskBinary {
+		return 1
+	}
+	return len(s.Labels)
+}
+
+// layerSizes returns [input, hidden..., outputs].
+func (s ModelSpec) layerSizes() []int {
+	sizes := append([]int{s.InputSize}, s.Hidden...)
+	return append(sizes, s.OutputSize())
+}
+
+// activation returns the activation for hidden layer l.
+func (s ModelSpec) activation(l int) Activation {
+	switch {
+	case len(s.Activations) == 0:
+		return ActivationReLU
+	case l < len(s.Activations):
+		return s.Activations[l]
+	default:
+		return s.Activations[len(s.Activations)-1]
+	}
+}
+
+// Shapes lists the parameter blocks in flat order: for each layer, its
+// weight matrix (out×in) followed by its bias vector. A logistic model is an
+// MLP with no hidden layers, so its layout is the historic [w0..wN, bias].
+func (s ModelSpec) Shapes() []ParamShape {
+	sizes := s.layerSizes()
+	var shapes []ParamShape
+	for l := 1; l < len(sizes); l++ {
+		shapes = append(shapes,
+			ParamShape{Name: fmt.Sprintf("layer%d.weights", l-1), Rows: sizes[l], Cols: sizes[l-1]},
+			ParamShape{Name: fmt.Sprintf("layer%d.bias", l-1), Rows: sizes[l], Cols: 1, Bias: true},
+		)
+	}
+	return shapes
+}
+
+// NumParams is the length of the flat parameter vector.
+func (s ModelSpec) NumParams() int {
+	n := 0
+	for _, sh := range s.Shapes() {
+		n += sh.Size()
+	}
+	return n
+}
+
+// PenaltyMask reports, per flat parameter, whether L1/L2 regularisation applies.
+func (s ModelSpec) PenaltyMask() []bool {
+	mask := make([]bool, 0, s.NumParams())
+	for _, sh := range s.Shapes() {
+		for i := 0; i < sh.Size(); i++ {
+			mask = append(mask, !sh.Bias)
+		}
+	}
+	return mask
+}
+
+// Validate checks that the spec describes a buildable model.
+func (s ModelSpec) Validate() error {
+	if s.InputSize <= 0 {
+		return fmt.Errorf("model spec: input_size must be positive, got %d", s.InputSize)
+	}
+	switch s.TaskType() {
+	case TaskBinary:
+	case TaskMultiClass, TaskMultiLabel:
+		if len(s.Labels) < 2 {
+			return fmt.Errorf("model spec: %s task needs at least 2 labels, got %d", s.Task, len(s.Labels))
+		}
+	default:
+		return fmt.Errorf("model spec: unknown task %q", s.Task)
+	}
+
+	switch s.Type {
+	case ModelLogistic:
+		if len(s.Hidden) != 0 {
+			return fmt.Errorf("model spec: logistic model cannot have hidden layers")
+		}
+		if s.TaskType() != TaskBinary {
+			return fmt.Errorf("model spec: logistic model is binary only; use %q or %q", ModelSoftmax, ModelMLP)
+		}
+	case ModelSoftmax:
+		if len(s.Hidden) != 0 {
+			return fmt.Errorf("model spec: softmax model cannot have hidden layers")
+		}
+		if s.TaskType() != TaskMultiClass {
+			return fmt.Errorf("model spec: softmax model requires task %q", TaskMultiClass)
+		}
+	case ModelMLP:
+		for i, h := range s.Hidden {
+			if h <= 0 {
+				return fmt.Errorf("model spec: hidden layer %d has size %d", i, h)
+			}
+		}
+		for _, a := range s.Activations {
+			if _, ok := activations[a]; !ok {
+				return fmt.Errorf("model spec: unknown activation %q", a)
+			}
+		}
+	default:
+		return fmt.Errorf("model spec: unknown model type %q", s.Type)
+	}
+	return nil
+}
+
+// NewClassifier builds a reproducibly initialised model for spec.
+// All hospitals calling this with the same spec start from identical weights.
+func NewClassifier(spec ModelSpec) (Classifier, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if spec.Type == ModelLogistic {
+		return newLogisticModel(spec.InputSize), nil
+	}
+	return NewMLP(spec), nil // softmax regression is an MLP without hidden layers
+}
+
+// ClassifierFromWeights rebuilds a model of shape spec from a flat vector,
+// e.g. the global weights downloaded from the server.
+func ClassifierFromWeights(spec ModelSpec, flat []float64) (Classifier, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if len(flat) != spec.NumParams() {
+		return nil, fmt.Errorf("model spec %s expects %d parameters, got %d", spec.Type, spec.NumParams(), len(flat))
+	}
+	if spec.Type == ModelLogistic {
+		return NewModelFromWeights(flat), nil
+	}
+	m := NewMLP(spec)
+	if err := m.Unflatten(flat); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
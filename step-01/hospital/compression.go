@@ -0,0 +1,269 @@
+package hospital
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// A compressed update sends the hospital's delta (trained weights minus the
+// global model it trained on) through a codec instead of the full weights.
+// The codec is advertised, and signed, in Metadata.Compression; the encoded
+// delta travels in UpdatePacket.Delta and the server adds it back onto its
+// copy of that global model before aggregation.
+
+// Update codecs (Compression.Codec).
+const (
+	CodecFloat32 = "float32" // dense delta in 32-bit floats
+	CodecQ8      = "q8"      // dense delta, stochastically quantised to 8 bits
+	CodecQ4      = "q4"      // dense delta, stochastically quantised to 4 bits
+	CodecTopK    = "topk"    // the top_k fraction of largest-magnitude coordinates, as 32-bit floats
+)
+
+// Codecs lists the accepted Compression.Codec values.
+var Codecs = []string{CodecFloat32, CodecQ8, CodecQ4, CodecTopK}
+
+// defaultTopK is the fraction of coordinates topk keeps when TopK is 0.
+const defaultTopK = 0.1
+
+// Compression is the codec a hospital compresses its delta with.
+type Compression struct {
+	Codec string  `json:"codec"`
+	TopK  float64 `json:"top_k,omitempty"` // topk: fraction of coordinates kept, in (0, 1]; 0 means 0.1
+}
+
+// Validate reports an unknown codec or an out-of-range top_k.
+func (c Compression) Validate() error {
+	switch c.Codec {
+	case CodecFloat32, CodecQ8, CodecQ4:
+	case CodecTopK:
+		if !(c.TopK >= 0 && c.TopK <= 1) {
+			return fmt.Errorf("compression: top_k must be between 0 and 1, got %g", c.TopK)
+		}
+	default:
+		return fmt.Errorf("compression: codec %q must be one of %v", c.Codec, Codecs)
+	}
+	return nil
+}
+
+// EncodedDelta is a delta of Length weights after its codec. Quantised codecs
+// send one level per coordinate, decoded as Min + level × Step; topk sends
+// the kept coordinates' indices alongside their values.
+type EncodedDelta struct {
+	Length  int     `json:"length"`
+	Min     float64 `json:"min,omitempty"`     // q8, q4: value of level 0
+	Step    float64 `json:"step,omitempty"`    // q8, q4: spacing between levels
+	Indices []byte  `json:"indices,omitempty"` // topk: kept coordinates, uint32 little-endian, ascending
+	Data    []byte  `json:"data"`              // float32 little-endian, or packed levels
+}
+
+// ErrorFeedback carries what a lossy codec left out of a hospital's previous
+// updates into its next one, so compression error is sent late rather than
+// lost. Keep one per hospital across rounds; the zero value is empty.
+type ErrorFeedback struct {
+	residual []float64
+}
+
+// Residual is the part of the hospital's deltas not yet sent.
+func (e *ErrorFeedback) Residual() []float64 {
+	return append([]float64(nil), e.residual...)
+}
+
+// Encode compresses delta with c, first adding feedback's residual when
+// feedback is non-nil and then keeping what the codec dropped. It returns the
+// encoding and the delta the server will decode from it.
+func (c Compression) Encode(delta []float64, feedback *ErrorFeedback, rng *rand.Rand) (*EncodedDelta, []float64, error) {
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	target := append([]float64(nil), delta...)
+	if feedback != nil && len(feedback.residual) == len(target) {
+		for i, r := range feedback.residual {
+			target[i] += r
+		}
+	}
+
+	var enc *EncodedDelta
+	switch c.Codec {
+	case CodecFloat32:
+		enc = &EncodedDelta{Length: len(target), Data: packFloat32(target)}
+	case CodecQ8:
+		enc = quantise(target, 8, rng)
+	case CodecQ4:
+		enc = quantise(target, 4, rng)
+	case CodecTopK:
+		enc = topK(target, c.keep(len(target)))
+	}
+	sent, err := c.Decode(enc, len(target))
+	if err != nil {
+		return nil, nil, err
+	}
+	if feedback != nil {
+		feedback.residual = make([]float64, len(target))
+		for i := range target {
+			feedback.residual[i] = target[i] - sent[i]
+		}
+	}
+	return enc, sent, nil
+}
+
+// Decode reconstructs the delta of an encoding made with c for a model of
+// length weights. The encoding's own Length must agree before anything is
+// allocated, so a forged one cannot make the receiver reserve memory.
+func (c Compression) Decode(enc *EncodedDelta, length int) ([]float64, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if enc == nil || enc.Length <= 0 {
+		return nil, fmt.Errorf("compression: empty delta")
+	}
+	if enc.Length != length {
+		return nil, fmt.Errorf("compression: delta has %d weights, the model %d", enc.Length, length)
+	}
+	n := enc.Length
+	delta := make([]float64, n)
+	switch c.Codec {
+	case CodecFloat32:
+		if len(enc.Data) != 4*n {
+			return nil, fmt.Errorf("compression: float32 delta of %d weights has %d bytes, want %d", n, len(enc.Data), 4*n)
+		}
+		copy(delta, unpackFloat32(enc.Data))
+	case CodecQ8, CodecQ4:
+		bits := 8
+		if c.Codec == CodecQ4 {
+			bits = 4
+		}
+		if want := (n*bits + 7) / 8; len(enc.Data) != want {
+			return nil, fmt.Errorf("compression: %s delta of %d weights has %d bytes, want %d", c.Codec, n, len(enc.Data), want)
+		}
+		if math.IsNaN(enc.Min) || math.IsInf(enc.Min, 0) || !(enc.Step >= 0) || math.IsInf(enc.Step, 0) {
+			return nil, fmt.Errorf("compression: %s delta has invalid min %g or step %g", c.Codec, enc.Min, enc.Step)
+		}
+		for i := range delta {
+			delta[i] = enc.Min + float64(level(enc.Data, i, bits))*enc.Step
+		}
+	case CodecTopK:
+		k := len(enc.Indices) / 4
+		if len(enc.Indices) != 4*k || len(enc.Data) != 4*k || k > n {
+			return nil, fmt.Errorf("compression: topk delta has %d index and %d value bytes for %d weights", len(enc.Indices), len(enc.Data), n)
+		}
+		values := unpackFloat32(enc.Data)
+		prev := -1
+		for j := 0; j < k; j++ {
+			i := int(binary.LittleEndian.Uint32(enc.Indices[4*j:]))
+			if i <= prev || i >= n {
+				return nil, fmt.Errorf("compression: topk index %d is out of order or beyond %d weights", i, n)
+			}
+			delta[i] = values[j]
+			prev = i
+		}
+	}
+	for _, d := range delta {
+		if math.IsNaN(d) || math.IsInf(d, 0) {
+			return nil, fmt.Errorf("compression: %s delta decodes to a non-finite value", c.Codec)
+		}
+	}
+	return delta, nil
+}
+
+// keep is how many of n coordinates topk sends: at least one.
+func (c Compression) keep(n int) int {
+	frac := c.TopK
+	if frac == 0 {
+		frac = defaultTopK
+	}
+	k := int(math.Ceil(frac * float64(n)))
+	if k < 1 {
+		k = 1
+	}
+	if k > n {
+		k = n
+	}
+	return k
+}
+
+// quantise rounds every coordinate to one of 2^bits evenly spaced levels
+// between the delta's minimum and maximum, up or down at random with the
+// probabilities that make the result unbiased. Each coordinate moves by less
+// than one step.
+func quantise(delta []float64, bits int, rng *rand.Rand) *EncodedDelta {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, d := range delta {
+		lo, hi = math.Min(lo, d), math.Max(hi, d)
+	}
+	top := 1<<bits - 1
+	enc := &EncodedDelta{Length: len(delta), Min: lo, Step: (hi - lo) / float64(top)}
+	enc.Data = make([]byte, (len(delta)*bits+7)/8)
+	for i, d := range delta {
+		l := 0
+		if enc.Step > 0 {
+			l = int(math.Floor((d-lo)/enc.Step + rng.Float64()))
+			if l > top {
+				l = top
+			}
+		}
+		if bits == 8 {
+			enc.Data[i] = byte(l)
+		} else {
+			enc.Data[i/2] |= byte(l) << (4 * uint(i%2))
+		}
+	}
+	return enc
+}
+
+// level reads coordinate i's level from data packed at bits per coordinate.
+func level(data []byte, i, bits int) int {
+	if bits == 8 {
+		return int(data[i])
+	}
+	return int(data[i/2]>>(4*uint(i%2))) & 0x0f
+}
+
+// topK keeps the k largest-magnitude coordinates, ties going to the lower
+// index so an encoding is reproducible.
+func topK(delta []float64, k int) *EncodedDelta {
+	order := make([]int, len(delta))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return math.Abs(delta[order[a]]) > math.Abs(delta[order[b]])
+	})
+	kept := order[:k]
+	sort.Ints(kept)
+
+	values := make([]float64, k)
+	enc := &EncodedDelta{Length: len(delta), Indices: make([]byte, 4*k)}
+	for j, i := range kept {
+		binary.LittleEndian.PutUint32(enc.Indices[4*j:], uint32(i))
+		values[j] = delta[i]
+	}
+	enc.Data = packFloat32(values)
+	return enc
+}
+
+func packFloat32(v []float64) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(x)))
+	}
+	return b
+}
+
+func unpackFloat32(b []byte) []float64 {
+	v := make([]float64, len(b)/4)
+	for i := range v {
+		v[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return v
+}
+
+// compressionRand is the stochastic quantiser's randomness for one update,
+// fixed by the hospital, round and version so a packet can be rebuilt.
+func compressionRand(cfg HospitalConfig) *rand.Rand {
+	seed := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", cfg.ID, cfg.RoundID, cfg.ModelVersion)))
+	return rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:8]))))
+}
@@ -0,0 +1,235 @@
+package hospital
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomDelta returns n coordinates of a heavy-tailed delta, as local training
+// produces: most moves small, a few large.
+func randomDelta(n int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	d := make([]float64, n)
+	for i := range d {
+		d[i] = rng.NormFloat64() * 0.01
+		if i%17 == 0 {
+			d[i] *= 30
+		}
+	}
+	return d
+}
+
+func norm2(v []float64) float64 {
+	s := 0.0
+	for _, x := range v {
+		s += x * x
+	}
+	return s
+}
+
+func TestCodecsBoundReconstructionError(t *testing.T) {
+	const n = 1001 // odd, so q4's last byte is half used
+	delta := randomDelta(n, 1)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, d := range delta {
+		lo, hi = math.Min(lo, d), math.Max(hi, d)
+	}
+
+	for _, tc := range []struct {
+		c     Compression
+		bytes int     // Data + Indices
+		bound float64 // largest error of any coordinate; 0 checks topk's contraction instead
+	}{
+		{Compression{Codec: CodecFloat32}, 4 * n, 1e-6 * (hi - lo)},
+		{Compression{Codec: CodecQ8}, n, (hi - lo) / 255},
+		{Compression{Codec: CodecQ4}, (n + 1) / 2, (hi - lo) / 15},
+		{Compression{Codec: CodecTopK, TopK: 0.05}, 8 * 51, 0},
+	} {
+		t.Run(tc.c.Codec, func(t *testing.T) {
+			enc, sent, err := tc.c.Encode(delta, nil, rand.New(rand.NewSource(2)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(enc.Data) + len(enc.Indices); got != tc.bytes {
+				t.Errorf("encoded %d bytes, want %d", got, tc.bytes)
+			}
+			// The encoding survives the wire and decodes to what Encode reported.
+			b, _ := json.Marshal(enc)
+			var wire EncodedDelta
+			json.Unmarshal(b, &wire)
+			decoded, err := tc.c.Decode(&wire, len(delta))
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := make([]float64, n)
+			for i := range delta {
+				if decoded[i] != sent[i] {
+					t.Fatalf("coordinate %d decodes to %g, Encode reported %g", i, decoded[i], sent[i])
+				}
+				errs[i] = decoded[i] - delta[i]
+				if tc.bound > 0 && math.Abs(errs[i]) > tc.bound {
+					t.Fatalf("coordinate %d off by %g, bound %g", i, errs[i], tc.bound)
+				}
+			}
+			if tc.bound == 0 {
+				// Keeping the k largest of n coordinates loses at most (1 − k/n)
+				// of the squared norm; float32 adds rounding.
+				if limit := (1 - 51.0/n) * norm2(delta); norm2(errs) > limit {
+					t.Errorf("topk error %g exceeds (1 − k/n)‖Δ‖² = %g", norm2(errs), limit)
+				}
+				kept := 0
+				for _, d := range decoded {
+					if d != 0 {
+						kept++
+					}
+				}
+				if kept != 51 {
+					t.Errorf("topk kept %d coordinates, want 51", kept)
+				}
+			}
+		})
+	}
+}
+
+func TestStochasticQuantisationIsUnbiased(t *testing.T) {
+	delta := randomDelta(50, 3)
+	const trials = 4000
+	mean := make([]float64, len(delta))
+	rng := rand.New(rand.NewSource(4))
+	var step float64
+	for k := 0; k < trials; k++ {
+		enc, sent, err := Compression{Codec: CodecQ4}.Encode(delta, nil, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step = enc.Step
+		for i, s := range sent {
+			mean[i] += s / trials
+		}
+	}
+	// Each coordinate's error has standard deviation at most step/2, so the
+	// mean of trials encodings is within 5 standard errors of the truth.
+	for i, m := range mean {
+		if d := math.Abs(m - delta[i]); d > 5*step/2/math.Sqrt(trials) {
+			t.Errorf("coordinate %d: mean %g of %d encodings, true %g (step %g)", i, m, trials, delta[i], step)
+		}
+	}
+}
+
+func TestErrorFeedbackSendsDroppedCoordinatesLater(t *testing.T) {
+	delta := randomDelta(200, 5)
+	c := Compression{Codec: CodecTopK, TopK: 0.05}
+	const rounds = 200
+
+	run := func(feedback *ErrorFeedback) (total []float64) {
+		total = make([]float64, len(delta))
+		for r := 0; r < rounds; r++ {
+			_, sent, err := c.Encode(delta, feedback, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range sent {
+				total[i] += s
+			}
+		}
+		return total
+	}
+	want := make([]float64, len(delta))
+	for i, d := range delta {
+		want[i] = rounds * d
+	}
+	missing := func(total []float64) []float64 {
+		m := make([]float64, len(total))
+		for i := range total {
+			m[i] = want[i] - total[i]
+		}
+		return m
+	}
+
+	feedback := &ErrorFeedback{}
+	withEF := missing(run(feedback))
+	withoutEF := missing(run(nil))
+
+	// What has not been sent yet is exactly the residual, which stays bounded
+	// while the error without feedback grows with every round.
+	residual := feedback.Residual()
+	for i := range residual {
+		if math.Abs(residual[i]-withEF[i]) > 1e-5 {
+			t.Fatalf("coordinate %d: %g unsent but residual %g", i, withEF[i], residual[i])
+		}
+	}
+	if norm2(withEF) > norm2(withoutEF)/50 {
+		t.Errorf("error feedback left ‖%g‖² unsent after %d rounds, without it ‖%g‖²", norm2(withEF), rounds, norm2(withoutEF))
+	}
+}
+
+func TestDecodeRejectsMalformedDeltas(t *testing.T) {
+	enc, _, _ := Compression{Codec: CodecTopK, TopK: 0.5}.Encode([]float64{1, -2, 3, 0.5}, nil, nil)
+	swapped := *enc
+	swapped.Indices = append(append([]byte(nil), enc.Indices[4:8]...), enc.Indices[0:4]...)
+	short := *enc
+	short.Length = 2
+
+	for name, tc := range map[string]struct {
+		c      Compression
+		enc    *EncodedDelta
+		length int
+	}{
+		"unknown codec":      {Compression{Codec: "zip"}, enc, 4},
+		"top_k above 1":      {Compression{Codec: CodecTopK, TopK: 2}, enc, 4},
+		"indices unordered":  {Compression{Codec: CodecTopK}, &swapped, 4},
+		"index beyond shape": {Compression{Codec: CodecTopK}, &short, 2},
+		"forged length":      {Compression{Codec: CodecTopK}, &EncodedDelta{Length: 1 << 26}, 4},
+		"wrong length":       {Compression{Codec: CodecTopK}, enc, 5},
+		"truncated levels":   {Compression{Codec: CodecQ8}, &EncodedDelta{Length: 4, Step: 1, Data: []byte{1, 2}}, 4},
+		"excess levels":      {Compression{Codec: CodecQ4}, &EncodedDelta{Length: 4, Step: 1, Data: []byte{1, 2, 3}}, 4},
+		"excess floats":      {Compression{Codec: CodecFloat32}, &EncodedDelta{Length: 1, Data: make([]byte, 8)}, 1},
+		"empty":              {Compression{Codec: CodecFloat32}, nil, 4},
+	} {
+		if _, err := tc.c.Decode(tc.enc, tc.length); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
+
+func TestBuildUpdatePacketSendsCompressedDelta(t *testing.T) {
+	data := syntheticSamples(100)
+	spec := ModelSpec{Type: ModelMLP, InputSize: InputSize, Hidden: []int{8}}
+	global, err := NewClassifier(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := HospitalConfig{ID: "H1", RoundID: 2, ModelVersion: 2}
+	full, err := BuildUpdatePacket(global, cfg, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Compression = &Compression{Codec: CodecQ8}
+	cfg.Feedback = &ErrorFeedback{}
+	packet, err := BuildUpdatePacket(global, cfg, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := BuildUpdatePacket(global, HospitalConfig{ID: "H1", RoundID: 2, ModelVersion: 2, Compression: cfg.Compression}, data, nil)
+	if packet.Weights != nil || packet.Delta == nil || packet.Metadata.Compression.Codec != CodecQ8 ||
+		string(again.Delta.Data) != string(packet.Delta.Data) {
+		t.Fatalf("compressed packet: weights %d, delta %+v, metadata %+v", len(packet.Weights), packet.Delta, packet.Metadata.Compression)
+	}
+
+	delta, err := packet.Metadata.Compression.Decode(packet.Delta, len(full.Weights))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := global.Flatten()
+	for i, w := range full.Weights {
+		if d := math.Abs(base[i] + delta[i] - w); d > packet.Delta.Step {
+			t.Fatalf("weight %d reconstructed %g off, step %g", i, d, packet.Delta.Step)
+		}
+	}
+	if len(cfg.Feedback.Residual()) != len(base) {
+		t.Error("error feedback not kept")
+	}
+}
@@ -1,109 +1,374 @@
 package hospital
 
 import (
-	"encoding/csv"
 	"fmt"
 	"io"
-	"os"
-	"strconv"
+	"sort"
 )
 
 // Sample is a single patient record.
 // Features are min-max normalised to [0, 1] within the hospital's partition.
 // Label: 1.0 = positive (cardiac event), 0.0 = negative.
+// Targets is set instead of Label for multi-class (one-hot) and multi-label
+// (multi-hot) tasks, indexed by the shared LabelVocab.
 type Sample struct {
 	Features []float64
 	Label    float64
+	Targets  []float64
 }
 
-// CSV column layout (Medicaldataset.csv):
-//
-//	0 Age | 1 Gender | 2 Heart rate | 3 Systolic BP | 4 Diastolic BP
-//	5 Blood sugar | 6 CK-MB | 7 Troponin | 8 Result
-const numFeatures = 8
+// targets returns the sample's target vector: Targets if set, else [Label].
+func (s Sample) targets() []float64 {
+	if s.Targets != nil {
+		return s.Targets
+	}
+	return []float64{s.Label}
+}
+
+// RowError records why a single row was skipped. Loading continues past it.
+type RowError struct {
+	Row    int    `json:"row"` // 0-based data row index (header excluded)
+	Column string `json:"column"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d column %q value %q: %s", e.Row, e.Column, e.Value, e.Reason)
+}
 
-// LoadCSVPartition reads rows [startIdx, endIdx) from the dataset (0-based,
-// header excluded), normalises each feature per-partition, and returns samples.
+// LoadCSVPartition reads rows [startIdx, endIdx) from Medicaldataset.csv using
+// DefaultSchema. Rows with unparsable cells are skipped; use LoadPartition to
+// see which rows were dropped and why.
 // Raw data never leaves this function — callers receive only []Sample.
 func LoadCSVPartition(path string, startIdx, endIdx int) ([]Sample, error) {
-	f, err := os.Open(path)
+	samples, _, err := LoadPartition(path, startIdx, endIdx, DefaultSchema())
+	return samples, err
+}
+
+// LoadCSVPartitionLabels is LoadCSVPartition for multi-class and multi-label
+// tasks: the Result column is encoded into Sample.Targets using vocab, which
+// must be the vocabulary shared by every hospital in the federation.
+func LoadCSVPartitionLabels(path string, startIdx, endIdx int, vocab LabelVocab) ([]Sample, error) {
+	schema := DefaultSchema()
+	for i, c := range schema.Columns {
+		if c.Role == RoleLabel {
+			schema.Columns[i] = ColumnSchema{Name: c.Name, Role: RoleLabel, Type: TypeCategorical,
+				Categories: vocab.Labels, MultiLabel: vocab.MultiLabel}
+		}
+	}
+	samples, _, err := LoadPartition(path, startIdx, endIdx, schema)
+	return samples, err
+}
+
+// LoadPartition reads rows [startIdx, endIdx) (0-based, header excluded) of a
+// CSV export, mapping columns by header name according to schema, and
+// min-max normalises numeric features with the partition's own statistics.
+// See LoadPartitionNormalised for loading with federation-wide parameters.
+func LoadPartition(path string, startIdx, endIdx int, schema *Schema) ([]Sample, *LoadReport, error) {
+	return LoadPartitionNormalised(path, startIdx, endIdx, schema, nil)
+}
+
+// LoadPartitionNormalised is LoadPartition with explicit normalisation
+// parameters, normally the global ones the server derived from every
+// hospital's statistics. nil means per-partition min-max. Binary, one-hot
+// and indicator inputs are always left as 0/1.
+//
+// Empty or unparsable feature cells are imputed per the column's Missing
+// strategy; a cell that cannot be repaired skips only its row and is
+// recorded in the report. The load fails only if the file or header is
+// unusable or no rows survive.
+func LoadPartitionNormalised(path string, startIdx, endIdx int, schema *Schema, norm *Normaliser) ([]Sample, *LoadReport, error) {
+	return LoadSource(CSVSource{Path: path}, startIdx, endIdx, schema, norm)
+}
+
+// LoadSource is LoadPartitionNormalised for any DataSource: records
+// [startIdx, endIdx) of src are mapped through schema and normalised with norm
+// (nil means per-partition min-max).
+func LoadSource(src DataSource, startIdx, endIdx int, schema *Schema, norm *Normaliser) ([]Sample, *LoadReport, error) {
+	raw, labelled, report, err := loadRaw(src, startIdx, endIdx, schema)
 	if err != nil {
-		return nil, fmt.Errorf("open csv: %w", err)
+		return nil, report, err
+	}
+	if norm == nil {
+		local := ComputeStats(raw).Normaliser(NormMinMax)
+		norm = &local
+	}
+	if len(norm.Offset) != len(raw[0]) {
+		return nil, report, fmt.Errorf("normaliser covers %d inputs, schema produces %d", len(norm.Offset), len(raw[0]))
 	}
-	defer f.Close()
 
-	r := csv.NewReader(f)
-	if _, err := r.Read(); err != nil { // skip header
-		return nil, fmt.Errorf("read header: %w", err)
+	numeric := numericMask(schema.features())
+	samples := make([]Sample, len(raw))
+	for i, row := range raw {
+		samples[i] = labelled[i]
+		samples[i].Features = norm.Apply(row, numeric)
 	}
+	return samples, report, nil
+}
 
-	var rawFeatures [][]float64
-	var labels []float64
+// loadRaw parses, validates and imputes records [startIdx, endIdx) of src,
+// returning unnormalised input vectors alongside label-only samples.
+func loadRaw(src DataSource, startIdx, endIdx int, schema *Schema) ([][]float64, []Sample, *LoadReport, error) {
+	if err := schema.Validate(); err != nil {
+		return nil, nil, nil, fmt.Errorf("schema: %w", err)
+	}
 
+	columns := schema.sourceColumns()
+	r, err := src.Open(columns)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer r.Close()
+	cols := make(map[string]int, len(columns))
+	for i, name := range columns {
+		cols[name] = i
+	}
+
+	features := schema.features()
+	label := schema.Label()
+	var vocab LabelVocab
+	if label.Type == TypeCategorical {
+		vocab = LabelVocab{Labels: label.Categories, MultiLabel: label.MultiLabel}
+	}
+
+	report := &LoadReport{QualitySummary: QualitySummary{LabelBalance: make(map[string]int)}}
+	for _, c := range features {
+		report.column(c.Name)
+	}
+
+	// Pass 1: parse every row, counting problems per column.
+	var rows [][]parsedCell
+	var labelled []Sample
 	for idx := 0; ; idx++ {
 		record, err := r.Read()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			return nil, fmt.Errorf("row %d: %w", idx, err)
+		if idx >= endIdx {
+			break
 		}
 		if idx < startIdx {
 			continue
 		}
-		if idx >= endIdx {
-			break
+		report.RowsRead++
+		if err != nil {
+			report.Errors = append(report.Errors, RowError{Row: idx, Reason: err.Error()})
+			continue
+		}
+
+		row, rowErr := parseFeatures(record, features, cols, report)
+		sample, labelErr := encodeLabel(record, label, cols, vocab)
+		if rowErr == nil {
+			rowErr = labelErr
+		}
+		if rowErr != nil {
+			rowErr.Row = idx
+			report.Errors = append(report.Errors, *rowErr)
+			continue
+		}
+		rows = append(rows, row)
+		labelled = append(labelled, sample)
+		countLabel(report.LabelBalance, sample, label)
+	}
+
+	report.RowsLoaded = len(rows)
+	report.finish()
+	if len(rows) == 0 {
+		return nil, nil, report, fmt.Errorf("%v: no usable rows in range [%d, %d) (%d rejected)", src, startIdx, endIdx, len(report.Errors))
+	}
+
+	// Pass 2: impute missing cells from the loaded rows' local statistics.
+	return imputeRows(rows, features, report), labelled, report, nil
+}
+
+// parsedCell is one feature cell after parsing and range checks. values is
+// nil when the cell is missing and still has to be imputed.
+type parsedCell struct {
+	values []float64
+}
+
+// parseFeatures parses one record's feature cells, updating the per-column
+// counts in report. Every column is counted even after the first problem so
+// the quality report reflects the whole file. The returned error is the first
+// problem the column strategies cannot repair.
+func parseFeatures(record []string, features []ColumnSchema, cols map[string]int, report *LoadReport) ([]parsedCell, *RowError) {
+	row := make([]parsedCell, len(features))
+	var firstErr *RowError
+	fail := func(e RowError) {
+		if firstErr == nil {
+			firstErr = &e
 		}
+	}
 
-		feats := make([]float64, numFeatures)
-		for i := 0; i < numFeatures; i++ {
-			v, err := strconv.ParseFloat(record[i], 64)
-			if err != nil {
-				return nil, fmt.Errorf("row %d col %d: %w", idx, i, err)
+	for k, c := range features {
+		q := report.column(c.Name)
+		dropOnMissing := c.Missing == "" || c.Missing == MissingDrop
+
+		i := cols[c.Name]
+		cell := ""
+		if i < len(record) {
+			cell = record[i]
+		}
+		if isMissing(cell) {
+			q.Missing++
+			if dropOnMissing {
+				fail(RowError{Column: c.Name, Value: cell, Reason: "missing value"})
 			}
-			feats[i] = v
+			continue
 		}
 
-		label := 0.0
-		if record[numFeatures] == "positive" {
-			label = 1.0
+		values, err := c.parse(cell)
+		if err != nil {
+			q.Invalid++
+			if dropOnMissing {
+				fail(RowError{Column: c.Name, Value: cell, Reason: err.Error()})
+			}
+			continue
 		}
-		rawFeatures = append(rawFeatures, feats)
-		labels = append(labels, label)
+
+		if c.Type == TypeNumeric {
+			if clipped, reason := c.checkRange(values[0]); reason != "" {
+				q.OutOfRange++
+				if !c.Clip {
+					fail(RowError{Column: c.Name, Value: cell, Reason: reason})
+					continue
+				}
+				q.Clipped++
+				values[0] = clipped
+			}
+		}
+		row[k] = parsedCell{values: values}
 	}
+	return row, firstErr
+}
 
-	if len(rawFeatures) == 0 {
-		return nil, fmt.Errorf("no rows in range [%d, %d)", startIdx, endIdx)
+// imputeRows fills missing cells and flattens each row into the raw input
+// vector, appending indicator inputs where the schema asks for them.
+func imputeRows(rows [][]parsedCell, features []ColumnSchema, report *LoadReport) [][]float64 {
+	fills := make([][]float64, len(features))
+	for k, c := range features {
+		fills[k] = fillValue(c, rows, k)
 	}
 
-	// Per-partition min-max normalisation: each hospital scales its own data.
-	mins := make([]float64, numFeatures)
-	maxs := make([]float64, numFeatures)
-	copy(mins, rawFeatures[0])
-	copy(maxs, rawFeatures[0])
-	for _, row := range rawFeatures[1:] {
-		for j, v := range row {
-			if v < mins[j] {
-				mins[j] = v
+	raw := make([][]float64, len(rows))
+	for i, row := range rows {
+		var out []float64
+		for k, c := range features {
+			values, missing := row[k].values, 0.0
+			if values == nil {
+				values, missing = fills[k], 1.0
+				report.column(c.Name).Imputed++
 			}
-			if v > maxs[j] {
-				maxs[j] = v
+			out = append(out, values...)
+			if c.Missing == MissingIndicator {
+				out = append(out, missing)
 			}
 		}
+		raw[i] = out
 	}
+	return raw
+}
 
-	samples := make([]Sample, len(rawFeatures))
-	for i, row := range rawFeatures {
-		norm := make([]float64, numFeatures)
-		for j, v := range row {
-			span := maxs[j] - mins[j]
-			if span == 0 {
-				norm[j] = 0
-			} else {
-				norm[j] = (v - mins[j]) / span
+// fillValue computes the imputation value for feature k from the observed
+// cells of the loaded rows. Categorical indicator columns fill with all zeros.
+func fillValue(c ColumnSchema, rows [][]parsedCell, k int) []float64 {
+	width := 1
+	if c.Type == TypeCategorical {
+		width = len(c.Categories)
+	}
+	switch c.Missing {
+	case MissingConstant:
+		v, _ := c.parse(c.Fill) // validated with the schema
+		return v
+	case MissingMean, MissingIndicator, MissingMedian:
+		if c.Type == TypeCategorical {
+			return make([]float64, width)
+		}
+		var observed []float64
+		for _, row := range rows {
+			if row[k].values != nil {
+				observed = append(observed, row[k].values[0])
 			}
 		}
-		samples[i] = Sample{Features: norm, Label: labels[i]}
+		if len(observed) == 0 {
+			return []float64{0}
+		}
+		if c.Missing == MissingMedian {
+			return []float64{median(observed)}
+		}
+		sum := 0.0
+		for _, v := range observed {
+			sum += v
+		}
+		return []float64{sum / float64(len(observed))}
+	}
+	return make([]float64, width)
+}
+
+// median returns the median of v, reordering it in place.
+func median(v []float64) float64 {
+	sort.Float64s(v)
+	n := len(v)
+	if n%2 == 1 {
+		return v[n/2]
+	}
+	return (v[n/2-1] + v[n/2]) / 2
+}
+
+// encodeLabel fills Label (binary) or Targets (categorical) from the label cell.
+// Labels are never imputed: a missing label always skips the row.
+func encodeLabel(record []string, label ColumnSchema, cols map[string]int, vocab LabelVocab) (Sample, *RowError) {
+	i := cols[label.Name]
+	if i >= len(record) || isMissing(record[i]) {
+		return Sample{}, &RowError{Column: label.Name, Reason: "missing label"}
+	}
+	cell := record[i]
+	if label.Type == TypeCategorical {
+		targets, err := vocab.Encode(cell)
+		if err != nil {
+			return Sample{}, &RowError{Column: label.Name, Value: cell, Reason: err.Error()}
+		}
+		return Sample{Targets: targets}, nil
+	}
+	v, err := label.parseBinary(cell)
+	if err != nil {
+		return Sample{}, &RowError{Column: label.Name, Value: cell, Reason: err.Error()}
+	}
+	return Sample{Label: v}, nil
+}
+
+// countLabel adds a loaded sample to the label balance.
+func countLabel(balance map[string]int, s Sample, label ColumnSchema) {
+	if label.Type != TypeCategorical {
+		if s.Label == 1 {
+			balance["positive"]++
+		} else {
+			balance["negative"]++
+		}
+		return
+	}
+	for k, t := range s.Targets {
+		if t == 1 {
+			balance[label.Categories[k]]++
+		}
+	}
+}
+
+// numericMask reports, per encoded input, whether it came from a numeric column.
+func numericMask(features []ColumnSchema) []bool {
+	var mask []bool
+	for _, c := range features {
+		n := 1
+		if c.Type == TypeCategorical {
+			n = len(c.Categories)
+		}
+		for i := 0; i < n; i++ {
+			mask = append(mask, c.Type == TypeNumeric)
+		}
+		if c.Missing == MissingIndicator {
+			mask = append(mask, false)
+		}
 	}
-	return samples, nil
+	return mask
 }
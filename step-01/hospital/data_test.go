@@ -0,0 +1,282 @@
+package hospital
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCSV writes lines to a temporary CSV file and returns its path.
+func writeCSV(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPartitionMapsColumnsByHeader(t *testing.T) {
+	max := 200.0
+	schema := &Schema{Columns: []ColumnSchema{
+		{Name: "Heart rate", Role: RoleFeature, Type: TypeNumeric, Max: &max},
+		{Name: "Smoker", Role: RoleFeature, Type: TypeBinary},
+		{Name: "Region", Role: RoleFeature, Type: TypeCategorical, Categories: []string{"north", "south"}},
+		{Name: "Outcome", Role: RoleLabel, Type: TypeBinary, Positive: []string{"event"}, Negative: []string{"none"}},
+	}}
+	// Columns in a different order, with different casing and an unlisted extra column.
+	path := writeCSV(t,
+		"patient_id,outcome,REGION,smoker, heart rate ",
+		"p1,event,north,yes,60",
+		"p2,none,south,no,100",
+		"p3,event,west,yes,80",   // unknown category
+		"p4,none,north,maybe,70", // not binary
+		"p5,none,south,no,1111",  // above max
+		"p6,event,south,1,80",
+		"p7,unclear,north,no,70", // neither positive nor negative
+		"p8,no,north,no,90",      // a default false value
+	)
+
+	samples, report, err := LoadPartition(path, 0, 100, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RowsRead != 8 || report.RowsLoaded != 4 || len(report.Errors) != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	wantRows := []int{2, 3, 4, 6}
+	for i, e := range report.Errors {
+		if e.Row != wantRows[i] {
+			t.Errorf("error %d: row %d, want %d (%v)", i, e.Row, wantRows[i], e)
+		}
+	}
+
+	if got := schema.FeatureNames(); strings.Join(got, "|") != "Heart rate|Smoker|Region=north|Region=south" {
+		t.Errorf("feature names %v", got)
+	}
+	// p2: heart rate 100 is the partition max -> 1, smoker 0, region south.
+	want := []float64{1, 0, 0, 1}
+	for j, v := range samples[1].Features {
+		if v != want[j] {
+			t.Fatalf("p2 features %v, want %v", samples[1].Features, want)
+		}
+	}
+	if samples[0].Label != 1 || samples[1].Label != 0 || samples[3].Label != 0 {
+		t.Errorf("labels %v %v %v", samples[0].Label, samples[1].Label, samples[3].Label)
+	}
+}
+
+func TestLoadPartitionMissingColumnFails(t *testing.T) {
+	path := writeCSV(t, "Age,Result", "50,positive")
+	if _, _, err := LoadPartition(path, 0, 10, DefaultSchema()); err == nil {
+		t.Fatal("expected error for export missing schema columns")
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	bad := []*Schema{
+		{Columns: []ColumnSchema{{Name: "x", Role: RoleFeature, Type: TypeNumeric}}},
+		{Columns: []ColumnSchema{{Name: "x", Role: RoleFeature, Type: TypeCategorical}, {Name: "y", Role: RoleLabel, Type: TypeBinary}}},
+		{Columns: []ColumnSchema{{Name: "x", Role: RoleFeature, Type: TypeNumeric}, {Name: "y", Role: RoleLabel, Type: TypeNumeric}}},
+		{Columns: []ColumnSchema{{Name: "x", Role: RoleFeature, Type: TypeNumeric}, {Name: "y", Role: RoleLabel, Type: TypeBinary, Positive: []string{"Event"}, Negative: []string{"event "}}}},
+	}
+	for i, s := range bad {
+		if err := s.Validate(); err == nil {
+			t.Errorf("schema %d: expected validation error", i)
+		}
+	}
+	if err := DefaultSchema().Validate(); err != nil {
+		t.Errorf("default schema invalid: %v", err)
+	}
+}
+
+func TestLoadPartitionImputesAndReportsQuality(t *testing.T) {
+	lo, hi := 0.0, 10.0
+	schema := &Schema{Columns: []ColumnSchema{
+		{Name: "a", Role: RoleFeature, Type: TypeNumeric, Missing: MissingMean},
+		{Name: "b", Role: RoleFeature, Type: TypeNumeric, Missing: MissingMedian},
+		{Name: "c", Role: RoleFeature, Type: TypeNumeric, Missing: MissingConstant, Fill: "7", Min: &lo, Max: &hi, Clip: true},
+		{Name: "d", Role: RoleFeature, Type: TypeBinary, Missing: MissingIndicator},
+		{Name: "e", Role: RoleFeature, Type: TypeNumeric}, // default: drop
+		{Name: "y", Role: RoleLabel, Type: TypeBinary},
+	}}
+	path := writeCSV(t,
+		"a,b,c,d,e,y",
+		"1,1,1,1,0,1",
+		",2,NA,0,0,0", // a missing -> mean 2; c missing -> 7
+		"3,x,99,,0,1", // b invalid -> median 1.5; c clipped to 10; d missing -> indicator
+		"2,9,5,1,?,1", // e missing with drop -> row skipped
+		"2,2,5,1,0,",  // missing label -> row skipped
+	)
+
+	samples, report, err := LoadPartition(path, 0, 10, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RowsRead != 5 || report.RowsLoaded != 3 || report.RowsDropped != 2 {
+		t.Fatalf("row counts %+v", report.QualitySummary)
+	}
+	if got := len(schema.FeatureNames()); got != 6 || len(samples[0].Features) != 6 {
+		t.Fatalf("expected 6 inputs (d adds an indicator), got %d / %d", got, len(samples[0].Features))
+	}
+
+	q := map[string]ColumnQuality{}
+	for _, c := range report.Columns {
+		q[c.Name] = c
+	}
+	if q["a"].Missing != 1 || q["a"].Imputed != 1 {
+		t.Errorf("column a: %+v", q["a"])
+	}
+	if q["b"].Invalid != 1 || q["b"].Imputed != 1 {
+		t.Errorf("column b: %+v", q["b"])
+	}
+	if q["c"].OutOfRange != 1 || q["c"].Clipped != 1 || q["c"].Imputed != 1 {
+		t.Errorf("column c: %+v", q["c"])
+	}
+	if q["e"].Missing != 1 || q["e"].MissingRate != 0.2 {
+		t.Errorf("column e: %+v", q["e"])
+	}
+	if report.LabelBalance["positive"] != 2 || report.LabelBalance["negative"] != 1 {
+		t.Errorf("label balance %v", report.LabelBalance)
+	}
+
+	// Raw values before min-max: a = [1, 2, 3], b = [1, 2, 1.5], c = [1, 7, 10].
+	// Row 2 (index 1) therefore normalises to a=0.5, b=1, c=6/9.
+	row := samples[1].Features
+	if row[0] != 0.5 || row[1] != 1 || row[2] != 6.0/9.0 {
+		t.Errorf("imputed row %v", row)
+	}
+	// Indicator input follows d: only the third loaded row was missing d.
+	if samples[0].Features[4] != 0 || samples[2].Features[4] != 1 {
+		t.Errorf("indicator inputs %v / %v", samples[0].Features[4], samples[2].Features[4])
+	}
+
+	summary := report.Summary()
+	summary.LabelBalance["positive"] = 0
+	if report.LabelBalance["positive"] != 2 {
+		t.Error("Summary shares its label balance map with the report")
+	}
+}
+
+func TestMaskedStatsAggregateToGlobalNormaliser(t *testing.T) {
+	path := writeCSV(t,
+		"x,y",
+		"1,1", "3,0", // H1
+		"5,1", "7,0", // H2
+		"9,1", "11,0", // H3
+	)
+	schema := &Schema{Columns: []ColumnSchema{
+		{Name: "x", Role: RoleFeature, Type: TypeNumeric},
+		{Name: "y", Role: RoleLabel, Type: TypeBinary},
+	}}
+	roster := []string{"H1", "H2", "H3"}
+	keys, peers := maskKeys(t, roster)
+
+	var masked []FeatureStats
+	for i, id := range roster {
+		stats, _, err := ComputeLocalStats(CSVSource{Path: path}, 2*i, 2*i+2, schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := MaskStats(stats, id, keys[id], peers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(m.Sum[0]-stats.Sum[0]) < 1 {
+			t.Errorf("%s: masked sum %f reveals the local sum %f", id, m.Sum[0], stats.Sum[0])
+		}
+		masked = append(masked, m)
+	}
+
+	total, err := AggregateStats(masked...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(total.Count[0]-6) > 1e-6 || math.Abs(total.Sum[0]-36) > 1e-6 {
+		t.Fatalf("masks did not cancel: count %f sum %f", total.Count[0], total.Sum[0])
+	}
+
+	norm := total.Normaliser(NormMinMax)
+	samples, _, err := LoadPartitionNormalised(path, 2, 4, schema, &norm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Global range [1, 11]: H2's raw 5 and 7 map to 0.4 and 0.6, not 0 and 1.
+	if math.Abs(samples[0].Features[0]-0.4) > 1e-9 || math.Abs(samples[1].Features[0]-0.6) > 1e-9 {
+		t.Errorf("global min-max not applied: %v %v", samples[0].Features, samples[1].Features)
+	}
+}
+
+func TestServerCannotUnmaskStats(t *testing.T) {
+	roster := []string{"H1", "H2", "H3"}
+	stats := FeatureStats{Count: []float64{40}, Sum: []float64{212}, SumSq: []float64{1300}, Min: []float64{1}, Max: []float64{9}}
+
+	// Everything the server holds: the shared signing key, every public mask
+	// key it relayed, and H2's submission.
+	keys, peers := maskKeys(t, roster)
+	submitted, err := MaskStats(stats, "H2", keys["H2"], peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(submitted.Sum[0]-stats.Sum[0]) < 1 {
+		t.Fatalf("masked sum %f reveals the local sum", submitted.Sum[0])
+	}
+
+	// Masks seeded from SecretKey, which the server knows, do not undo it...
+	guess := FeatureStats{Sum: []float64{submitted.Sum[0]}}
+	for _, other := range []string{"H1", "H3"} {
+		sign, lo, hi := 1.0, "H2", other
+		if other < "H2" {
+			sign, lo, hi = -1.0, other, "H2"
+		}
+		seed := sha256.Sum256([]byte(SecretKey + "|" + lo + "|" + hi))
+		rng := mathrand.New(mathrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:8]))))
+		rng.Float64() // Count's mask comes first.
+		guess.Sum[0] -= sign * (rng.Float64()*2 - 1) * maskScale
+	}
+	if math.Abs(guess.Sum[0]-stats.Sum[0]) < 1 {
+		t.Errorf("SecretKey-derived masks recover the local sum %f", guess.Sum[0])
+	}
+	// ...nor does a key pair of the server's own against the relayed keys.
+	spy, _ := NewMaskKey()
+	spied := map[string][]byte{"H2": spy.PublicKey(), "H1": peers["H1"], "H3": peers["H3"]}
+	unmask, err := MaskStats(FeatureStats{Count: []float64{0}, Sum: []float64{0}, SumSq: []float64{0}}, "H2", spy, spied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(submitted.Sum[0]-unmask.Sum[0]-stats.Sum[0]) < 1 {
+		t.Error("a server-generated key recovers the local sum")
+	}
+
+	// The same statistics under fresh keys give an unrelated submission: the
+	// mask depends on private keys, not on anything the server keeps.
+	keys, peers = maskKeys(t, roster)
+	again, _ := MaskStats(stats, "H2", keys["H2"], peers)
+	if math.Abs(again.Sum[0]-submitted.Sum[0]) < 1 {
+		t.Errorf("fresh keys reproduce the submission %f", again.Sum[0])
+	}
+
+	if _, err := MaskStats(stats, "H2", spy, peers); err == nil {
+		t.Error("a key that is not H2's published one was accepted")
+	}
+}
+
+// maskKeys draws a mask key per roster member and the public roster.
+func maskKeys(t *testing.T, roster []string) (map[string]*MaskKey, map[string][]byte) {
+	t.Helper()
+	keys := make(map[string]*MaskKey)
+	peers := make(map[string][]byte)
+	for _, id := range roster {
+		key, err := NewMaskKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[id], peers[id] = key, key.PublicKey()
+	}
+	return keys, peers
+}
@@ -0,0 +1,309 @@
+package hospital
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// FHIRMapping tells FHIRSource how to flatten an R4 Bundle into schema columns.
+//
+//	{
+//	  "reference_date": "2024-01-01",
+//	  "patient": {"age": "Age", "gender": "Gender", "gender_values": {"male": "1", "female": "0"}},
+//	  "observations": [
+//	    {"system": "http://loinc.org", "code": "6598-7", "column": "Troponin"},
+//	    {"system": "http://loinc.org", "code": "13969-1", "column": "CK-MB"}
+//	  ]
+//	}
+//
+// The label is mapped like any other observation column.
+type FHIRMapping struct {
+	// ReferenceDate (YYYY-MM-DD) is the date ages are computed at; "" means today.
+	ReferenceDate string             `json:"reference_date,omitempty"`
+	Patient       FHIRPatientMapping `json:"patient"`
+	Observations  []FHIRCodeMapping  `json:"observations"`
+}
+
+// FHIRPatientMapping maps Patient resource fields to columns. Empty names are unused.
+type FHIRPatientMapping struct {
+	Age          string            `json:"age,omitempty"`           // years between birthDate and the reference date
+	Gender       string            `json:"gender,omitempty"`        // the administrative gender, via GenderValues
+	GenderValues map[string]string `json:"gender_values,omitempty"` // e.g. "male" → "1"; unmapped values pass through
+}
+
+// FHIRCodeMapping maps one Observation code to a column. An empty System
+// matches the code in any coding system.
+type FHIRCodeMapping struct {
+	System string `json:"system,omitempty"`
+	Code   string `json:"code"`
+	Column string `json:"column"`
+}
+
+// LoadFHIRMapping reads a mapping file.
+func LoadFHIRMapping(path string) (*FHIRMapping, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fhir mapping: %w", err)
+	}
+	var m FHIRMapping
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse fhir mapping %s: %w", path, err)
+	}
+	for i, o := range m.Observations {
+		if o.Code == "" || o.Column == "" {
+			return nil, fmt.Errorf("fhir mapping %s: observation %d needs code and column", path, i)
+		}
+	}
+	return &m, nil
+}
+
+// FHIRSource reads a FHIR R4 Bundle (JSON) of Patient and Observation
+// resources. Each patient becomes one record, in order of first appearance in
+// the bundle. An Observation's subject may reference its patient as
+// "Patient/<id>" or by the entry's fullUrl (the urn:uuid: references of
+// transaction bundles); both name the same record. When a patient has several observations for the same code, the
+// one with the latest effectiveDateTime wins (ISO 8601 strings compare in time
+// order); without dates the later entry wins. Observations whose code is not
+// in the mapping are ignored.
+type FHIRSource struct {
+	Path    string
+	Mapping *FHIRMapping
+}
+
+func (s FHIRSource) String() string { return "fhir:" + s.Path }
+
+type fhirCoding struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display"`
+}
+
+type fhirConcept struct {
+	Coding []fhirCoding `json:"coding"`
+	Text   string       `json:"text"`
+}
+
+type fhirResource struct {
+	ResourceType string `json:"resourceType"`
+	ID           string `json:"id"`
+
+	// Patient
+	BirthDate string `json:"birthDate"`
+	Gender    string `json:"gender"`
+
+	// Observation
+	Subject struct {
+		Reference string `json:"reference"`
+	} `json:"subject"`
+	Code              fhirConcept `json:"code"`
+	EffectiveDateTime string      `json:"effectiveDateTime"`
+	ValueQuantity     *struct {
+		Value *float64 `json:"value"`
+	} `json:"valueQuantity"`
+	ValueString          *string      `json:"valueString"`
+	ValueBoolean         *bool        `json:"valueBoolean"`
+	ValueInteger         *int64       `json:"valueInteger"`
+	ValueCodeableConcept *fhirConcept `json:"valueCodeableConcept"`
+}
+
+type fhirBundle struct {
+	ResourceType string `json:"resourceType"`
+	Entry        []struct {
+		FullURL  string       `json:"fullUrl"`
+		Resource fhirResource `json:"resource"`
+	} `json:"entry"`
+}
+
+// fhirCell is a column value with the date it was observed.
+type fhirCell struct {
+	value     string
+	effective string
+}
+
+// Open flattens the whole bundle. Every requested column must be produced by
+// the mapping; values a patient lacks are missing cells.
+func (s FHIRSource) Open(columns []string) (RecordReader, error) {
+	if s.Mapping == nil {
+		return nil, fmt.Errorf("fhir source %s: no mapping", s.Path)
+	}
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open fhir bundle: %w", err)
+	}
+	var bundle fhirBundle
+	if err := json.Unmarshal(b, &bundle); err != nil {
+		return nil, fmt.Errorf("parse fhir bundle %s: %w", s.Path, err)
+	}
+	if bundle.ResourceType != "Bundle" {
+		return nil, fmt.Errorf("%s: expected resourceType Bundle, got %q", s.Path, bundle.ResourceType)
+	}
+
+	m := s.Mapping
+	provided := map[string]bool{}
+	for _, name := range []string{m.Patient.Age, m.Patient.Gender} {
+		if name != "" {
+			provided[normaliseHeader(name)] = true
+		}
+	}
+	for _, o := range m.Observations {
+		provided[normaliseHeader(o.Column)] = true
+	}
+	var missing []string
+	for _, name := range columns {
+		if !provided[normaliseHeader(name)] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("fhir mapping does not provide schema columns %v", missing)
+	}
+
+	ref := time.Now()
+	if m.ReferenceDate != "" {
+		if ref, err = time.Parse("2006-01-02", m.ReferenceDate); err != nil {
+			return nil, fmt.Errorf("fhir mapping: reference_date: %w", err)
+		}
+	}
+
+	// aliases maps each Patient entry's fullUrl to its "Patient/<id>"
+	// reference, so a subject may use either. A Patient without an id is
+	// known by its fullUrl alone.
+	aliases := map[string]string{}
+	for _, e := range bundle.Entry {
+		if e.Resource.ResourceType == "Patient" && e.FullURL != "" && e.Resource.ID != "" {
+			aliases[e.FullURL] = "Patient/" + e.Resource.ID
+		}
+	}
+
+	var order []string
+	patients := map[string]map[string]fhirCell{}
+	patient := func(ref string) map[string]fhirCell {
+		if canonical, ok := aliases[ref]; ok {
+			ref = canonical
+		}
+		p, ok := patients[ref]
+		if !ok {
+			p = map[string]fhirCell{}
+			patients[ref] = p
+			order = append(order, ref)
+		}
+		return p
+	}
+	for _, e := range bundle.Entry {
+		r := e.Resource
+		switch r.ResourceType {
+		case "Patient":
+			key := "Patient/" + r.ID
+			if r.ID == "" && e.FullURL != "" {
+				key = e.FullURL
+			}
+			p := patient(key)
+			if m.Patient.Age != "" && r.BirthDate != "" {
+				p[normaliseHeader(m.Patient.Age)] = fhirCell{value: fhirAge(r.BirthDate, ref)}
+			}
+			if m.Patient.Gender != "" && r.Gender != "" {
+				v, ok := m.Patient.GenderValues[r.Gender]
+				if !ok {
+					v = r.Gender
+				}
+				p[normaliseHeader(m.Patient.Gender)] = fhirCell{value: v}
+			}
+		case "Observation":
+			column, ok := m.column(r.Code)
+			if !ok || r.Subject.Reference == "" {
+				continue
+			}
+			p := patient(r.Subject.Reference)
+			key := normaliseHeader(column)
+			if prev, ok := p[key]; ok && r.EffectiveDateTime < prev.effective {
+				continue
+			}
+			p[key] = fhirCell{value: r.value(), effective: r.EffectiveDateTime}
+		}
+	}
+
+	rows := make([][]string, len(order))
+	for i, ref := range order {
+		row := make([]string, len(columns))
+		for k, name := range columns {
+			row[k] = patients[ref][normaliseHeader(name)].value
+		}
+		rows[i] = row
+	}
+	return &fhirReader{rows: rows}, nil
+}
+
+// column returns the column mapped to the first matching coding of code.
+func (m *FHIRMapping) column(code fhirConcept) (string, bool) {
+	for _, c := range code.Coding {
+		for _, o := range m.Observations {
+			if o.Code == c.Code && (o.System == "" || o.System == c.System) {
+				return o.Column, true
+			}
+		}
+	}
+	return "", false
+}
+
+// value renders the observation's value[x] as a cell; unsupported or absent
+// values are missing.
+func (r fhirResource) value() string {
+	switch {
+	case r.ValueQuantity != nil && r.ValueQuantity.Value != nil:
+		return strconv.FormatFloat(*r.ValueQuantity.Value, 'g', -1, 64)
+	case r.ValueString != nil:
+		return *r.ValueString
+	case r.ValueBoolean != nil:
+		return strconv.FormatBool(*r.ValueBoolean)
+	case r.ValueInteger != nil:
+		return strconv.FormatInt(*r.ValueInteger, 10)
+	case r.ValueCodeableConcept != nil:
+		if len(r.ValueCodeableConcept.Coding) > 0 {
+			c := r.ValueCodeableConcept.Coding[0]
+			if c.Code != "" {
+				return c.Code
+			}
+			return c.Display
+		}
+		return r.ValueCodeableConcept.Text
+	}
+	return ""
+}
+
+// fhirAge returns whole years from birthDate (YYYY, YYYY-MM or YYYY-MM-DD) to ref.
+func fhirAge(birthDate string, ref time.Time) string {
+	var born time.Time
+	var err error
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if born, err = time.Parse(layout, birthDate); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "" // reported by the loader as a missing value
+	}
+	age := ref.Year() - born.Year()
+	if ref.Month() < born.Month() || (ref.Month() == born.Month() && ref.Day() < born.Day()) {
+		age--
+	}
+	return strconv.Itoa(age)
+}
+
+type fhirReader struct {
+	rows [][]string
+	next int
+}
+
+func (f *fhirReader) Read() ([]string, error) {
+	if f.next >= len(f.rows) {
+		return nil, io.EOF
+	}
+	f.next++
+	return f.rows[f.next-1], nil
+}
+
+func (f *fhirReader) Close() error { return nil }
@@ -0,0 +1,117 @@
+package hospital
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// LabelSeparator splits a multi-label cell, e.g. "arrhythmia;ischaemia".
+const LabelSeparator = ";"
+
+// LabelVocab is the ordered set of outcome labels shared by every hospital.
+// Output unit k of a multi-class or multi-label model predicts Labels[k], so
+// all hospitals must use the exact same vocabulary — the server distributes it.
+type LabelVocab struct {
+	Labels     []string `json:"labels"`
+	MultiLabel bool     `json:"multi_label,omitempty"`
+}
+
+// Index returns the position of label in the vocabulary.
+func (v LabelVocab) Index(label string) (int, bool) {
+	for i, l := range v.Labels {
+		if l == label {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Encode turns a label cell into a one-hot (multi-class) or multi-hot
+// (multi-label) target vector. Unknown labels are an error rather than being
+// silently dropped, since they mean the hospital's vocabulary is out of date.
+func (v LabelVocab) Encode(cell string) ([]float64, error) {
+	targets := make([]float64, len(v.Labels))
+	// Split even for a multi-class task, so "a;b" is reported as two labels
+	// rather than as the unknown label "a;b".
+	parts := strings.Split(cell, LabelSeparator)
+
+	n := 0
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if n > 0 && !v.MultiLabel {
+			return nil, fmt.Errorf("multiple labels in %q for a multi-class task", cell)
+		}
+		i, ok := v.Index(p)
+		if !ok {
+			return nil, fmt.Errorf("label %q not in shared vocabulary %v", p, v.Labels)
+		}
+		targets[i] = 1
+		n++
+	}
+	if n == 0 && !v.MultiLabel {
+		return nil, fmt.Errorf("empty label")
+	}
+	return targets, nil
+}
+
+// Spec returns a ModelSpec of the given type whose outputs follow this vocabulary.
+func (v LabelVocab) Spec(modelType string, inputSize int) ModelSpec {
+	task := TaskMultiClass
+	if v.MultiLabel {
+		task = TaskMultiLabel
+	}
+	return ModelSpec{Type: modelType, InputSize: inputSize, Task: task, Labels: v.Labels}
+}
+
+// LocalLabels returns the sorted distinct labels in records [startIdx, endIdx)
+// of the schema's label column. Hospitals send this (and nothing else about their
+// data) to the server, which merges every hospital's set into the shared vocabulary.
+func LocalLabels(src DataSource, startIdx, endIdx int, schema *Schema) ([]string, error) {
+	label := schema.Label()
+	r, err := src.Open([]string{label.Name})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var sets [][]string
+	for idx := 0; idx < endIdx; idx++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || idx < startIdx {
+			continue // unreadable rows are reported by LoadSource
+		}
+		cell := record[0]
+		if label.MultiLabel {
+			sets = append(sets, strings.Split(cell, LabelSeparator))
+		} else {
+			sets = append(sets, []string{cell})
+		}
+	}
+	return MergeLabels(sets...), nil
+}
+
+// MergeLabels returns the sorted union of the given label sets, ignoring blanks.
+func MergeLabels(sets ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, set := range sets {
+		for _, l := range set {
+			l = strings.TrimSpace(l)
+			if l == "" || seen[l] {
+				continue
+			}
+			seen[l] = true
+			merged = append(merged, l)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,117 @@
+package hospital
+
+// ClassMetrics are one-vs-rest statistics for a single label.
+type ClassMetrics struct {
+	Label     string  `json:"label"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	Support   int     `json:"support"` // samples whose true label set contains Label
+}
+
+// Metrics summarises a classifier's predictions over a dataset.
+//
+// Accuracy is the fraction of correct predictions for binary and multi-class
+// tasks, and the fraction of correct per-label decisions (1 - Hamming loss)
+// for multi-label tasks. MacroF1 is the unweighted mean of per-class F1.
+type Metrics struct {
+	Accuracy float64        `json:"accuracy"`
+	MacroF1  float64        `json:"macro_f1"`
+	PerClass []ClassMetrics `json:"per_class"`
+}
+
+// Evaluate scores c on data. Binary models report the classes
+// "negative" and "positive"; other tasks report one entry per vocabulary label.
+// Sigmoid outputs are thresholded at 0.5; softmax outputs use argmax.
+func Evaluate(c Classifier, data []Sample) Metrics {
+	spec := c.Spec()
+	labels := spec.Labels
+	if spec.TaskType() == TaskBinary {
+		labels = []string{"negative", "positive"}
+	}
+	k := len(labels)
+	tp := make([]int, k)
+	fp := make([]int, k)
+	fn := make([]int, k)
+	correct, decisions := 0, 0
+
+	for _, s := range data {
+		probs := c.Predict(s.Features)
+		switch spec.TaskType() {
+		case TaskMultiLabel:
+			for j, p := range probs {
+				pred, truth := p >= 0.5, s.Targets[j] >= 0.5
+				countDecision(j, pred, truth, tp, fp, fn)
+				if pred == truth {
+					correct++
+				}
+				decisions++
+			}
+		default:
+			var pred, truth int
+			if spec.TaskType() == TaskMultiClass {
+				pred, truth = argmax(probs), argmax(s.Targets)
+			} else {
+				pred, truth = 0, 0
+				if probs[0] >= 0.5 {
+					pred = 1
+				}
+				if s.Label >= 0.5 {
+					truth = 1
+				}
+			}
+			for j := 0; j < k; j++ {
+				countDecision(j, pred == j, truth == j, tp, fp, fn)
+			}
+			if pred == truth {
+				correct++
+			}
+			decisions++
+		}
+	}
+
+	m := Metrics{PerClass: make([]ClassMetrics, k)}
+	if decisions > 0 {
+		m.Accuracy = float64(correct) / float64(decisions)
+	}
+	for j, label := range labels {
+		cm := ClassMetrics{Label: label, Support: tp[j] + fn[j]}
+		cm.Precision = ratio(tp[j], tp[j]+fp[j])
+		cm.Recall = ratio(tp[j], tp[j]+fn[j])
+		if cm.Precision+cm.Recall > 0 {
+			cm.F1 = 2 * cm.Precision * cm.Recall / (cm.Precision + cm.Recall)
+		}
+		m.PerClass[j] = cm
+		m.MacroF1 += cm.F1 / float64(k)
+	}
+	return m
+}
+
+// countDecision updates the confusion counts for class j.
+func countDecision(j int, pred, truth bool, tp, fp, fn []int) {
+	switch {
+	case pred && truth:
+		tp[j]++
+	case pred:
+		fp[j]++
+	case truth:
+		fn[j]++
+	}
+}
+
+func argmax(v []float64) int {
+	best := 0
+	for i, x := range v {
+		if x > v[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+func ratio(num, den int) float64 {
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
@@ -0,0 +1,210 @@
+package hospital
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Activation names a hidden-layer non-linearity.
+type Activation string
+
+const (
+	ActivationReLU    Activation = "relu"
+	ActivationTanh    Activation = "tanh"
+	ActivationSigmoid Activation = "sigmoid"
+)
+
+// activationFn holds f(z) and f'(z) expressed in terms of a = f(z),
+// which is all backprop needs once the forward pass is cached.
+type activationFn struct {
+	apply func(z float64) float64
+	deriv func(a float64) float64
+}
+
+var activations = map[Activation]activationFn{
+	ActivationReLU: {
+		apply: func(z float64) float64 { return math.Max(0, z) },
+		deriv: func(a float64) float64 {
+			if a > 0 {
+				return 1
+			}
+			return 0
+		},
+	},
+	ActivationTanh: {
+		apply: math.Tanh,
+		deriv: func(a float64) float64 { return 1 - a*a },
+	},
+	ActivationSigmoid: {
+		apply: sigmoid,
+		deriv: func(a float64) float64 { return a * (1 - a) },
+	},
+}
+
+// MLP is a fully connected network with configurable hidden layers. Its output
+// layer follows the spec's task: one sigmoid unit (binary), softmax over the
+// labels (multiclass), or one sigmoid per label (multilabel). With no hidden
+// layers and a multiclass task it is softmax regression.
+// Weights[l] is row-major (out×in); Biases[l] has length out.
+type MLP struct {
+	spec    ModelSpec
+	Weights [][]float64
+	Biases  [][]float64
+}
+
+// NewMLP returns an MLP with Xavier-uniform initial weights drawn from seed 42,
+// so every hospital building the same spec starts from the same point.
+func NewMLP(spec ModelSpec) *MLP {
+	rng := rand.New(rand.NewSource(42))
+	sizes := spec.layerSizes()
+	m := &MLP{spec: spec}
+	for l := 1; l < len(sizes); l++ {
+		in, out := sizes[l-1], sizes[l]
+		limit := math.Sqrt(6.0 / float64(in+out))
+		w := make([]float64, out*in)
+		for i := range w {
+			w[i] = (rng.Float64()*2 - 1) * limit
+		}
+		m.Weights = append(m.Weights, w)
+		m.Biases = append(m.Biases, make([]float64, out))
+	}
+	return m
+}
+
+// Spec implements Classifier.
+func (m *MLP) Spec() ModelSpec { return m.spec }
+
+// activations runs the forward pass and returns the output of every layer,
+// starting with the input itself.
+func (m *MLP) activations(x []float64) [][]float64 {
+	outs := [][]float64{x}
+	last := len(m.Weights) - 1
+	for l, w := range m.Weights {
+		in := outs[l]
+		b := m.Biases[l]
+		a := make([]float64, len(b))
+		for j := range a {
+			z := b[j]
+			row := w[j*len(in) : (j+1)*len(in)]
+			for i, xi := range in {
+				z += row[i] * xi
+			}
+			a[j] = z
+		}
+		switch {
+		case l < last:
+			act := activations[m.spec.activation(l)]
+			for j, z := range a {
+				a[j] = act.apply(z)
+			}
+		case m.spec.TaskType() == TaskMultiClass:
+			softmax(a)
+		default:
+			for j, z := range a {
+				a[j] = sigmoid(z)
+			}
+		}
+		outs = append(outs, a)
+	}
+	return outs
+}
+
+// Forward implements Classifier.
+func (m *MLP) Forward(x []float64) float64 {
+	return m.Predict(x)[0]
+}
+
+// Predict implements Classifier.
+func (m *MLP) Predict(x []float64) []float64 {
+	outs := m.activations(x)
+	return outs[len(outs)-1]
+}
+
+// Loss implements Classifier: BCE, categorical cross-entropy, or summed
+// per-label BCE depending on the task.
+func (m *MLP) Loss(data []Sample) float64 {
+	return taskLoss(m, data)
+}
+
+// Gradient implements Classifier via backpropagation.
+// Sigmoid+BCE and softmax+cross-entropy both give an output delta of (p - y).
+func (m *MLP) Gradient(batch []Sample, grad []float64) {
+	for i := range grad {
+		grad[i] = 0
+	}
+	offsets := m.offsets()
+
+	for _, s := range batch {
+		outs := m.activations(s.Features)
+		y := s.targets()
+		delta := make([]float64, len(y))
+		for k, p := range outs[len(outs)-1] {
+			delta[k] = p - y[k]
+		}
+
+		for l := len(m.Weights) - 1; l >= 0; l-- {
+			in := outs[l]
+			wOff, bOff := offsets[2*l], offsets[2*l+1]
+			for j, d := range delta {
+				for i, xi := range in {
+					grad[wOff+j*len(in)+i] += d * xi
+				}
+				grad[bOff+j] += d
+			}
+			if l == 0 {
+				break
+			}
+			// Propagate to the previous hidden layer: δ_in = (Wᵀ·δ) ⊙ f'(a_in).
+			act := activations[m.spec.activation(l-1)]
+			prev := make([]float64, len(in))
+			for i := range prev {
+				sum := 0.0
+				for j, d := range delta {
+					sum += m.Weights[l][j*len(in)+i] * d
+				}
+				prev[i] = sum * act.deriv(in[i])
+			}
+			delta = prev
+		}
+	}
+
+	n := float64(len(batch))
+	for i := range grad {
+		grad[i] /= n
+	}
+}
+
+// offsets returns the flat start index of every block in Spec().Shapes().
+func (m *MLP) offsets() []int {
+	var offs []int
+	pos := 0
+	for _, sh := range m.spec.Shapes() {
+		offs = append(offs, pos)
+		pos += sh.Size()
+	}
+	return offs
+}
+
+// Flatten implements Classifier.
+func (m *MLP) Flatten() []float64 {
+	flat := make([]float64, 0, m.spec.NumParams())
+	for l := range m.Weights {
+		flat = append(flat, m.Weights[l]...)
+		flat = append(flat, m.Biases[l]...)
+	}
+	return flat
+}
+
+// Unflatten implements Classifier.
+func (m *MLP) Unflatten(flat []float64) error {
+	if len(flat) != m.spec.NumParams() {
+		return fmt.Errorf("mlp: expected %d parameters, got %d", m.spec.NumParams(), len(flat))
+	}
+	pos := 0
+	for l := range m.Weights {
+		pos += copy(m.Weights[l], flat[pos:])
+		pos += copy(m.Biases[l], flat[pos:])
+	}
+	return nil
+}
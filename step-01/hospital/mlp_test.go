@@ -0,0 +1,175 @@
+package hospital
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// checkGradient compares backprop against a central finite-difference
+// estimate of the loss gradient for every parameter of m.
+func checkGradient(t *testing.T, m Classifier, data []Sample) {
+	t.Helper()
+	params := m.Flatten()
+	grad := make([]float64, len(params))
+	m.Gradient(data, grad)
+
+	const h = 1e-6
+	for i := range params {
+		orig := params[i]
+		params[i] = orig + h
+		m.Unflatten(params)
+		up := m.Loss(data)
+		params[i] = orig - h
+		m.Unflatten(params)
+		down := m.Loss(data)
+		params[i] = orig
+		m.Unflatten(params)
+
+		numeric := (up - down) / (2 * h)
+		if math.Abs(numeric-grad[i]) > 1e-5 {
+			t.Fatalf("param %d: backprop %g, numeric %g", i, grad[i], numeric)
+		}
+	}
+}
+
+func TestMLPGradientMatchesFiniteDifference(t *testing.T) {
+	data := syntheticSamples(12)
+	for _, act := range []Activation{ActivationTanh, ActivationSigmoid} {
+		t.Run(string(act), func(t *testing.T) {
+			spec := ModelSpec{Type: ModelMLP, InputSize: InputSize, Hidden: []int{5, 3}, Activations: []Activation{act}}
+			checkGradient(t, NewMLP(spec), data)
+		})
+	}
+}
+
+// multiClassSamples relabels syntheticSamples into three classes by the
+// first feature, and into two overlapping labels for the multi-label case.
+func multiClassSamples(n int, multiLabel bool) []Sample {
+	data := syntheticSamples(n)
+	for i := range data {
+		x := data[i].Features
+		if multiLabel {
+			data[i].Targets = []float64{0, 0}
+			if x[0] > 0.5 {
+				data[i].Targets[0] = 1
+			}
+			if x[1] > 0.5 {
+				data[i].Targets[1] = 1
+			}
+			continue
+		}
+		data[i].Targets = make([]float64, 3)
+		data[i].Targets[int(x[0]*3)] = 1
+	}
+	return data
+}
+
+func TestMultiClassAndMultiLabelGradients(t *testing.T) {
+	vocab := LabelVocab{Labels: []string{"emergent", "routine", "urgent"}}
+	t.Run("softmax", func(t *testing.T) {
+		m, err := NewClassifier(vocab.Spec(ModelSoftmax, InputSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGradient(t, m, multiClassSamples(12, false))
+	})
+	t.Run("mlp multiclass", func(t *testing.T) {
+		spec := vocab.Spec(ModelMLP, InputSize)
+		spec.Hidden = []int{4}
+		spec.Activations = []Activation{ActivationTanh}
+		checkGradient(t, NewMLP(spec), multiClassSamples(12, false))
+	})
+	t.Run("mlp multilabel", func(t *testing.T) {
+		spec := LabelVocab{Labels: []string{"a", "b"}, MultiLabel: true}.Spec(ModelMLP, InputSize)
+		spec.Hidden = []int{4}
+		spec.Activations = []Activation{ActivationTanh}
+		checkGradient(t, NewMLP(spec), multiClassSamples(12, true))
+	})
+}
+
+func TestSoftmaxRegressionLearnsAndEvaluatesPerClass(t *testing.T) {
+	data := multiClassSamples(300, false)
+	vocab := LabelVocab{Labels: []string{"emergent", "routine", "urgent"}}
+	m, err := NewClassifier(vocab.Spec(ModelSoftmax, InputSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trained, _ := TrainClassifier(m, data, TrainConfig{Epochs: 60, LearningRate: 0.5, BatchSize: 16, Shuffle: true, Seed: 1})
+
+	metrics := Evaluate(trained, data)
+	if len(metrics.PerClass) != 3 {
+		t.Fatalf("expected 3 per-class entries, got %d", len(metrics.PerClass))
+	}
+	support := 0
+	for i, cm := range metrics.PerClass {
+		if cm.Label != vocab.Labels[i] {
+			t.Errorf("class %d labelled %q, want %q", i, cm.Label, vocab.Labels[i])
+		}
+		support += cm.Support
+	}
+	if support != len(data) {
+		t.Errorf("supports sum to %d, want %d", support, len(data))
+	}
+	if metrics.Accuracy < 0.6 {
+		t.Errorf("softmax regression accuracy %.2f is too low", metrics.Accuracy)
+	}
+}
+
+func TestLabelVocabEncode(t *testing.T) {
+	vocab := LabelVocab{Labels: []string{"a", "b", "c"}}
+	if got, err := vocab.Encode("b"); err != nil || got[1] != 1 || got[0]+got[2] != 0 {
+		t.Errorf("one-hot encode: got %v, %v", got, err)
+	}
+	if _, err := vocab.Encode("z"); err == nil {
+		t.Error("expected unknown label to be rejected")
+	}
+	for _, cell := range []string{"a;b", "a; z"} {
+		if _, err := vocab.Encode(cell); err == nil || !strings.Contains(err.Error(), "multiple labels") {
+			t.Errorf("%q in a multi-class vocabulary: %v, want the multiple-labels error", cell, err)
+		}
+	}
+	if got, err := vocab.Encode("c;"); err != nil || got[2] != 1 {
+		t.Errorf("one label with a trailing separator: got %v, %v", got, err)
+	}
+	vocab.MultiLabel = true
+	if got, err := vocab.Encode("a; c"); err != nil || got[0] != 1 || got[1] != 0 || got[2] != 1 {
+		t.Errorf("multi-hot encode: got %v, %v", got, err)
+	}
+}
+
+func TestLogisticLayoutMatchesSpec(t *testing.T) {
+	m := NewModel()
+	spec := m.Spec()
+	if spec.NumParams() != len(m.FlatWeights()) {
+		t.Fatalf("spec reports %d params, FlatWeights has %d", spec.NumParams(), len(m.FlatWeights()))
+	}
+	rebuilt, err := ClassifierFromWeights(spec, m.Flatten())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range rebuilt.Flatten() {
+		if w != m.FlatWeights()[i] {
+			t.Fatalf("param %d changed on round trip", i)
+		}
+	}
+}
+
+func TestTrainClassifierMLPReducesLoss(t *testing.T) {
+	data := syntheticSamples(200)
+	m, err := NewClassifier(ModelSpec{Type: ModelMLP, InputSize: InputSize, Hidden: []int{8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.Loss(data)
+	trained, after := TrainClassifier(m, data, TrainConfig{Epochs: 30, LearningRate: 0.1, BatchSize: 16, Shuffle: true, Seed: 1})
+	if after >= before {
+		t.Errorf("loss did not decrease: %f -> %f", before, after)
+	}
+	if m.Loss(data) != before {
+		t.Error("TrainClassifier mutated the input model")
+	}
+	if trained.Spec().NumParams() != len(trained.Flatten()) {
+		t.Error("trained model layout disagrees with its spec")
+	}
+}
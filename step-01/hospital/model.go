@@ -1,6 +1,7 @@
 package hospital
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
 )
@@ -18,8 +19,13 @@ type Model struct {
 // NewModel returns a reproducibly initialised model (seed 42).
 // All hospitals start from identical weights at round 0.
 func NewModel() *Model {
+	return newLogisticModel(InputSize)
+}
+
+// newLogisticModel is NewModel for an arbitrary number of inputs.
+func newLogisticModel(inputs int) *Model {
 	rng := rand.New(rand.NewSource(42))
-	weights := make([]float64, InputSize)
+	weights := make([]float64, inputs)
 	for i := range weights {
 		weights[i] = rng.Float64()*0.1 - 0.05
 	}
@@ -58,11 +64,113 @@ func (m *Model) Forward(x []float64) float64 {
 	return sigmoid(z)
 }
 
+// Predict implements Classifier.
+func (m *Model) Predict(x []float64) []float64 {
+	return []float64{m.Forward(x)}
+}
+
 // BinaryCrossEntropyLoss computes mean BCE loss: -mean( y·log(p) + (1-y)·log(1-p) ).
 func (m *Model) BinaryCrossEntropyLoss(data []Sample) float64 {
+	return binaryCrossEntropy(m, data)
+}
+
+// Spec implements Classifier.
+func (m *Model) Spec() ModelSpec {
+	return ModelSpec{Type: ModelLogistic, InputSize: len(m.Weights)}
+}
+
+// Loss implements Classifier.
+func (m *Model) Loss(data []Sample) float64 {
+	return m.BinaryCrossEntropyLoss(data)
+}
+
+// Gradient implements Classifier: dL/dw_i = mean((p-y)·x_i), dL/db = mean(p-y).
+func (m *Model) Gradient(batch []Sample, grad []float64) {
+	n := len(m.Weights)
+	for i := range grad {
+		grad[i] = 0
+	}
+	for _, s := range batch {
+		err := m.Forward(s.Features) - s.Label
+		for i, xi := range s.Features {
+			grad[i] += err * xi
+		}
+		grad[n] += err
+	}
+	batchLen := float64(len(batch))
+	for i := range grad {
+		grad[i] /= batchLen
+	}
+}
+
+// Flatten implements Classifier; identical to FlatWeights.
+func (m *Model) Flatten() []float64 {
+	return m.FlatWeights()
+}
+
+// Unflatten implements Classifier.
+func (m *Model) Unflatten(flat []float64) error {
+	if len(flat) != len(m.Weights)+1 {
+		return fmt.Errorf("logistic: expected %d parameters, got %d", len(m.Weights)+1, len(flat))
+	}
+	copy(m.Weights, flat)
+	m.Bias = flat[len(m.Weights)]
+	return nil
+}
+
+// taskLoss is the mean loss of c over data for its spec's task.
+func taskLoss(c Classifier, data []Sample) float64 {
+	switch c.Spec().TaskType() {
+	case TaskMultiClass:
+		// -mean( Σ_k y_k·log(p_k) )
+		total := 0.0
+		for _, s := range data {
+			for k, p := range c.Predict(s.Features) {
+				if y := s.Targets[k]; y > 0 {
+					total -= y * math.Log(clamp(p, 1e-9, 1))
+				}
+			}
+		}
+		return total / float64(len(data))
+	case TaskMultiLabel:
+		// mean over samples of the per-label BCE summed across labels
+		total := 0.0
+		for _, s := range data {
+			for k, p := range c.Predict(s.Features) {
+				p = clamp(p, 1e-9, 1-1e-9)
+				y := s.Targets[k]
+				total += -(y*math.Log(p) + (1-y)*math.Log(1-p))
+			}
+		}
+		return total / float64(len(data))
+	default:
+		return binaryCrossEntropy(c, data)
+	}
+}
+
+// softmax replaces z with exp(z_k) / Σ exp(z_j), shifted by max(z) for stability.
+func softmax(z []float64) {
+	max := z[0]
+	for _, v := range z[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	sum := 0.0
+	for i, v := range z {
+		z[i] = math.Exp(v - max)
+		sum += z[i]
+	}
+	for i := range z {
+		z[i] /= sum
+	}
+}
+
+// binaryCrossEntropy is the mean BCE of any single-output classifier over data.
+func binaryCrossEntropy(c Classifier, data []Sample) float64 {
 	total := 0.0
 	for _, s := range data {
-		p := m.Forward(s.Features)
+		p := c.Forward(s.Features)
 		p = clamp(p, 1e-9, 1-1e-9)
 		total += -(s.Label*math.Log(p) + (1-s.Label)*math.Log(1-p))
 	}
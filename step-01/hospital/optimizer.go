@@ -0,0 +1,183 @@
+package hospital
+
+import "math"
+
+// ScheduleType selects how the learning rate evolves across local epochs.
+type ScheduleType string
+
+const (
+	ScheduleConstant    ScheduleType = "constant"    // lr_e = lr
+	ScheduleStep        ScheduleType = "step"        // lr_e = lr · gamma^floor(e / step_size)
+	ScheduleExponential ScheduleType = "exponential" // lr_e = lr · gamma^e
+	ScheduleCosine      ScheduleType = "cosine"      // lr_e = min + ½(lr-min)(1 + cos(π·e/(E-1)))
+)
+
+// Default decay factors for a zero LRSchedule.Gamma, which would otherwise
+// drop the rate to 0 after the first decay.
+const (
+	defaultStepGamma        = 0.1
+	defaultExponentialGamma = 0.9
+)
+
+// LRSchedule configures the per-epoch learning rate.
+// The zero value is a constant schedule.
+type LRSchedule struct {
+	Type     ScheduleType `json:"type,omitempty"`
+	StepSize int          `json:"step_size,omitempty"` // epochs between decays (step)
+	Gamma    float64      `json:"gamma,omitempty"`     // decay factor (step: default 0.1, exponential: default 0.9)
+	MinLR    float64      `json:"min_lr,omitempty"`    // floor reached at the final epoch, e = E-1 (cosine)
+}
+
+// Rate returns the learning rate for the given 0-based epoch out of totalEpochs.
+func (s LRSchedule) Rate(base float64, epoch, totalEpochs int) float64 {
+	switch s.Type {
+	case ScheduleStep:
+		if s.StepSize <= 0 {
+			return base
+		}
+		return base * math.Pow(s.gamma(defaultStepGamma), float64(epoch/s.StepSize))
+	case ScheduleExponential:
+		return base * math.Pow(s.gamma(defaultExponentialGamma), float64(epoch))
+	case ScheduleCosine:
+		if totalEpochs <= 1 {
+			return base
+		}
+		progress := float64(epoch) / float64(totalEpochs-1)
+		return s.MinLR + 0.5*(base-s.MinLR)*(1+math.Cos(math.Pi*progress))
+	default:
+		return base
+	}
+}
+
+// gamma is s.Gamma, or def when it is unset.
+func (s LRSchedule) gamma(def float64) float64 {
+	if s.Gamma == 0 {
+		return def
+	}
+	return s.Gamma
+}
+
+// OptimizerType selects the local update rule applied to each mini-batch gradient.
+type OptimizerType string
+
+const (
+	OptimizerSGD      OptimizerType = "sgd"
+	OptimizerMomentum OptimizerType = "momentum"
+	OptimizerAdam     OptimizerType = "adam"
+)
+
+// optimizer applies one update step to params in place given the batch gradient.
+// State (velocity, moments) lives for a single TrainLocalModel call only —
+// it is never shipped to the server.
+type optimizer interface {
+	step(params, grad []float64, lr float64)
+}
+
+// newOptimizer builds the optimizer described by cfg for a parameter vector of length n.
+func newOptimizer(cfg TrainConfig, n int) optimizer {
+	switch cfg.Optimizer {
+	case OptimizerMomentum:
+		beta := cfg.Momentum
+		if beta == 0 {
+			beta = 0.9
+		}
+		return &momentumOptimizer{beta: beta, velocity: make([]float64, n)}
+	case OptimizerAdam:
+		a := &adamOptimizer{
+			beta1:   cfg.Momentum,
+			beta2:   cfg.Beta2,
+			epsilon: cfg.Epsilon,
+			m:       make([]float64, n),
+			v:       make([]float64, n),
+		}
+		if a.beta1 == 0 {
+			a.beta1 = 0.9
+		}
+		if a.beta2 == 0 {
+			a.beta2 = 0.999
+		}
+		if a.epsilon == 0 {
+			a.epsilon = 1e-8
+		}
+		return a
+	default:
+		return sgdOptimizer{}
+	}
+}
+
+// sgdOptimizer: θ ← θ - lr·g
+type sgdOptimizer struct{}
+
+func (sgdOptimizer) step(params, grad []float64, lr float64) {
+	for i, g := range grad {
+		params[i] -= lr * g
+	}
+}
+
+// momentumOptimizer: v ← β·v + g,  θ ← θ - lr·v
+type momentumOptimizer struct {
+	beta     float64
+	velocity []float64
+}
+
+func (o *momentumOptimizer) step(params, grad []float64, lr float64) {
+	for i, g := range grad {
+		o.velocity[i] = o.beta*o.velocity[i] + g
+		params[i] -= lr * o.velocity[i]
+	}
+}
+
+// adamOptimizer: bias-corrected first and second moment estimates (Kingma & Ba, 2015).
+type adamOptimizer struct {
+	beta1, beta2, epsilon float64
+	m, v                  []float64
+	t                     int
+}
+
+func (o *adamOptimizer) step(params, grad []float64, lr float64) {
+	o.t++
+	c1 := 1 - math.Pow(o.beta1, float64(o.t))
+	c2 := 1 - math.Pow(o.beta2, float64(o.t))
+	for i, g := range grad {
+		o.m[i] = o.beta1*o.m[i] + (1-o.beta1)*g
+		o.v[i] = o.beta2*o.v[i] + (1-o.beta2)*g*g
+		mHat := o.m[i] / c1
+		vHat := o.v[i] / c2
+		params[i] -= lr * mHat / (math.Sqrt(vHat) + o.epsilon)
+	}
+}
+
+// regularise adds the L1/L2 penalty gradients to grad for every parameter
+// whose mask entry is true. Bias terms are masked out and never penalised.
+//
+//	d/dw [ l1·|w| + ½·l2·w² ] = l1·sign(w) + l2·w
+func regularise(grad, params []float64, mask []bool, l1, l2 float64) {
+	if l1 == 0 && l2 == 0 {
+		return
+	}
+	for i, penalised := range mask {
+		if !penalised {
+			continue
+		}
+		w := params[i]
+		grad[i] += l2 * w
+		if w > 0 {
+			grad[i] += l1
+		} else if w < 0 {
+			grad[i] -= l1
+		}
+	}
+}
+
+// proximal adds the FedProx term gradient to grad. Unlike regularise it covers
+// every parameter, bias included, since the anchor is the full global model.
+//
+//	d/dθ [ mu/2 · ||θ - θ_global||² ] = mu · (θ - θ_global)
+func proximal(grad, params, global []float64, mu float64) {
+	if mu == 0 {
+		return
+	}
+	for i := range grad {
+		grad[i] += mu * (params[i] - global[i])
+	}
+}
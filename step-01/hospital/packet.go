@@ -16,31 +16,81 @@ const SecretKey = "federated_secret_2024"
 // a hospital's update without seeing any raw patient data.
 type Metadata struct {
 	HospitalID   string  `json:"hospital_id"`
+	FederationID string  `json:"federation_id,omitempty"` // "" means the server's default federation
 	DataSize     int     `json:"data_size"`
 	Loss         float64 `json:"loss"`
 	RoundID      int     `json:"round_id"`
 	ModelVersion int     `json:"model_version"`
 	Timestamp    int64   `json:"timestamp"`
+
+	// ModelSpec describes the layout of Weights so the server can check that
+	// every update in a round has the same shape without interpreting it.
+	ModelSpec *ModelSpec `json:"model_spec,omitempty"`
+
+	// DataQuality is the hospital's aggregate-only load summary, included
+	// only when the hospital opts in with HospitalConfig.ShareQuality.
+	DataQuality *QualitySummary `json:"data_quality,omitempty"`
+
+	// GlobalMetrics scores the global model the hospital received on its
+	// local data, before training, so the server can track how well the
+	// federation serves each hospital.
+	GlobalMetrics *Metrics `json:"global_metrics,omitempty"`
+
+	// GlobalLoss is the loss of that same global model on the local data,
+	// which the server's qffl aggregation scales each update by.
+	GlobalLoss float64 `json:"global_loss,omitempty"`
+
+	// Compression names the codec of Delta when the packet carries a
+	// compressed delta instead of Weights.
+	Compression *Compression `json:"compression,omitempty"`
 }
 
 // UpdatePacket is the complete hand-off from a hospital to the server.
-// Weights is the flat serialisation produced by Model.FlatWeights().
-// Raw patient data is never included.
+// Weights is the flat serialisation produced by Classifier.Flatten(); a
+// compressed packet sends Delta, against the global model of ModelVersion,
+// instead. Raw patient data is never included.
 type UpdatePacket struct {
-	Weights   []float64 `json:"weights"`
-	Metadata  Metadata  `json:"metadata"`
-	Signature string    `json:"signature"`
+	Weights   []float64     `json:"weights,omitempty"`
+	Delta     *EncodedDelta `json:"delta,omitempty"`
+	Metadata  Metadata      `json:"metadata"`
+	Signature string        `json:"signature"`
 }
 
 // HospitalConfig describes a hospital's identity and its dataset partition.
-// StartIdx/EndIdx are 0-based row indices into the CSV (header excluded).
+// StartIdx/EndIdx are 0-based record indices into the data (CSV header excluded).
+// Source selects the export format; nil reads CSVPath as CSV.
+// Schema maps the hospital's own export columns; nil means DefaultSchema.
+// Normaliser holds the federation-wide scaling parameters distributed by the
+// server; nil falls back to per-partition min-max.
+// Train sets local training; the zero value trains with DefaultTrainConfig.
 type HospitalConfig struct {
 	ID           string
+	FederationID string // federation the update is for; "" = the server's default
 	RoundID      int
 	ModelVersion int
 	CSVPath      string // absolute or relative path to Medicaldataset.csv
-	StartIdx     int    // first row index for this hospital's partition
-	EndIdx       int    // one-past-last row index
+	Source       DataSource
+	StartIdx     int     // first row index for this hospital's partition
+	EndIdx       int     // one-past-last row index
+	ProxMu       *float64 // FedProx mu distributed by the server with the global model; when set, even to 0, it replaces Train.ProxMu
+	Train        TrainConfig
+	Schema       *Schema
+	Normaliser   *Normaliser
+	ShareQuality bool // attach the aggregate-only data-quality summary to the packet
+
+	// Compression sends the update as a compressed delta; nil sends the full
+	// weights. Feedback, kept by the caller across rounds, carries what a
+	// lossy codec dropped into the next update.
+	Compression *Compression
+	Feedback    *ErrorFeedback
+}
+
+// DataSource returns the hospital's configured source, defaulting to CSVPath.
+func (cfg HospitalConfig) DataSource() DataSource {
+	if cfg.Source != nil {
+		return cfg.Source
+	}
+	return CSVSource{Path: cfg.CSVPath}
 }
 
 // SignPacket computes a SHA256 signature over the metadata and stores it
@@ -57,29 +107,89 @@ func (p *UpdatePacket) SignPacket() error {
 
 // GenerateUpdatePacket runs a full local training cycle and returns an UpdatePacket.
 // Raw patient data never leaves this function.
-func GenerateUpdatePacket(globalModel *Model, cfg HospitalConfig) (*UpdatePacket, error) {
-	if len(globalModel.Weights) == 0 {
-		return nil, fmt.Errorf("hospital %s: global model has no weights", cfg.ID)
+func GenerateUpdatePacket(globalModel Classifier, cfg HospitalConfig) (*UpdatePacket, error) {
+	data, report, err := LoadHospitalData(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return BuildUpdatePacket(globalModel, cfg, data, report)
+}
 
-	data, err := LoadCSVPartition(cfg.CSVPath, cfg.StartIdx, cfg.EndIdx)
+// LoadHospitalData loads the hospital's partition through its schema and
+// returns the load report for local review alongside the samples.
+func LoadHospitalData(cfg HospitalConfig) ([]Sample, *LoadReport, error) {
+	schema := cfg.Schema
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+	data, report, err := LoadSource(cfg.DataSource(), cfg.StartIdx, cfg.EndIdx, schema, cfg.Normaliser)
 	if err != nil {
-		return nil, fmt.Errorf("hospital %s: load data: %w", cfg.ID, err)
+		return nil, report, fmt.Errorf("hospital %s: load data: %w", cfg.ID, err)
 	}
+	return data, report, nil
+}
 
-	trainedModel, loss := TrainLocalModel(globalModel, data, DefaultTrainConfig())
+// BuildUpdatePacket trains globalModel on already-loaded data and returns the
+// signed UpdatePacket. report may be nil; it is only read when cfg.ShareQuality is set.
+func BuildUpdatePacket(globalModel Classifier, cfg HospitalConfig, data []Sample, report *LoadReport) (*UpdatePacket, error) {
+	if len(globalModel.Flatten()) == 0 {
+		return nil, fmt.Errorf("hospital %s: global model has no weights", cfg.ID)
+	}
+	if n := globalModel.Spec().InputSize; len(data) > 0 && len(data[0].Features) != n {
+		return nil, fmt.Errorf("hospital %s: data has %d features, model expects %d", cfg.ID, len(data[0].Features), n)
+	}
+
+	if err := cfg.Train.Validate(); err != nil {
+		return nil, fmt.Errorf("hospital %s: %w", cfg.ID, err)
+	}
+	trainCfg := cfg.Train.WithDefaults()
+	if cfg.ProxMu != nil {
+		if *cfg.ProxMu < 0 {
+			return nil, fmt.Errorf("hospital %s: prox_mu must be ≥ 0, got %g", cfg.ID, *cfg.ProxMu)
+		}
+		trainCfg.ProxMu = *cfg.ProxMu
+	}
+	trainedModel, loss := TrainClassifier(globalModel, data, trainCfg)
+	spec := trainedModel.Spec()
 
 	packet := &UpdatePacket{
-		Weights: trainedModel.FlatWeights(),
+		Weights: trainedModel.Flatten(),
 		Metadata: Metadata{
 			HospitalID:   cfg.ID,
+			FederationID: cfg.FederationID,
 			DataSize:     len(data),
 			Loss:         loss,
 			RoundID:      cfg.RoundID,
 			ModelVersion: cfg.ModelVersion,
 			Timestamp:    time.Now().Unix(),
+			ModelSpec:    &spec,
 		},
 	}
+	if len(data) > 0 {
+		received := Evaluate(globalModel, data) // training works on a copy, so this is still the global model
+		packet.Metadata.GlobalMetrics = &received
+		packet.Metadata.GlobalLoss = globalModel.Loss(data)
+	}
+
+	if cfg.ShareQuality && report != nil {
+		summary := report.Summary()
+		packet.Metadata.DataQuality = &summary
+	}
+
+	if cfg.Compression != nil {
+		global := globalModel.Flatten()
+		delta := make([]float64, len(global))
+		for i, w := range packet.Weights {
+			delta[i] = w - global[i]
+		}
+		enc, _, err := cfg.Compression.Encode(delta, cfg.Feedback, compressionRand(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("hospital %s: %w", cfg.ID, err)
+		}
+		codec := *cfg.Compression
+		packet.Weights, packet.Delta = nil, enc
+		packet.Metadata.Compression = &codec
+	}
 
 	// Sign the packet before returning.
 	if err := packet.SignPacket(); err != nil {
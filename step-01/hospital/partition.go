@@ -0,0 +1,424 @@
+package hospital
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// PartitionMode selects how a dataset is split across simulated hospitals.
+type PartitionMode string
+
+const (
+	PartitionIID       PartitionMode = "iid"       // uniform shuffle, equal sizes
+	PartitionDirichlet PartitionMode = "dirichlet" // label skew: each class split by p ~ Dir(alpha)
+	PartitionQuantity  PartitionMode = "quantity"  // size skew: hospital sizes ∝ p ~ Dir(alpha), labels IID
+	PartitionFeature   PartitionMode = "feature"   // feature skew: each hospital holds one band of a feature (e.g. age)
+)
+
+// PartitionConfig describes one split. Smaller Alpha means more heterogeneity;
+// as Alpha → ∞ both Dirichlet modes approach IID.
+type PartitionConfig struct {
+	Mode        PartitionMode
+	Hospitals   int
+	Alpha       float64
+	Seed        int64
+	LabelColumn string // needed by every mode for the report; "" means the last column
+
+	// Feature names the column used by PartitionFeature. Bands are ascending
+	// cut points: band b holds values in [Bands[b-1], Bands[b]) and goes to
+	// hospital b mod Hospitals. Without Bands, records are sorted by the
+	// feature and cut into Hospitals equal-sized bands. Records whose value is
+	// missing or not numeric are spread uniformly at random.
+	Feature string
+	Bands   []float64
+}
+
+// PartitionSummary describes one hospital's share of the data.
+type PartitionSummary struct {
+	ID          string         `json:"id"`
+	File        string         `json:"file"` // relative to the report's directory
+	Rows        int            `json:"rows"`
+	LabelCounts map[string]int `json:"label_counts"`
+	FeatureMin  *float64       `json:"feature_min,omitempty"` // feature mode only
+	FeatureMax  *float64       `json:"feature_max,omitempty"`
+	LabelTV     float64        `json:"label_tv"` // total-variation distance from the global label distribution
+}
+
+// PartitionReport records how a dataset was split, so experiments can be
+// reproduced and heterogeneity compared across runs.
+type PartitionReport struct {
+	Mode      PartitionMode      `json:"mode"`
+	Hospitals int                `json:"hospitals"`
+	Alpha     float64            `json:"alpha,omitempty"`
+	Seed      int64              `json:"seed"`
+	Feature   string             `json:"feature,omitempty"`
+	Source    string             `json:"source,omitempty"`
+	Labels    map[string]int     `json:"labels"`
+	Parts     []PartitionSummary `json:"parts"`
+	// LabelSkew is the mean LabelTV over hospitals: 0 for identical label
+	// mixes, approaching 1 when every hospital sees a single class.
+	LabelSkew float64 `json:"label_skew"`
+}
+
+// PartitionReportFile is the report's file name inside a partition directory.
+const PartitionReportFile = "partition_report.json"
+
+// Partition assigns every record to exactly one hospital and returns the
+// record indices per hospital (each in ascending order) and the report.
+// The split is deterministic for a given Seed.
+func Partition(header []string, records [][]string, cfg PartitionConfig) ([][]int, *PartitionReport, error) {
+	if cfg.Hospitals < 1 {
+		return nil, nil, fmt.Errorf("partition: need at least 1 hospital, got %d", cfg.Hospitals)
+	}
+	if len(records) < cfg.Hospitals {
+		return nil, nil, fmt.Errorf("partition: %d records cannot fill %d hospitals", len(records), cfg.Hospitals)
+	}
+	labelCol, err := columnIndex(header, cfg.LabelColumn, len(header)-1)
+	if err != nil {
+		return nil, nil, err
+	}
+	labels := make([]string, len(records))
+	for i, rec := range records {
+		if labelCol < len(rec) {
+			labels[i] = rec[labelCol]
+		}
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	var parts [][]int
+	switch cfg.Mode {
+	case PartitionIID, "":
+		cfg.Mode = PartitionIID
+		parts = splitBySizes(rng.Perm(len(records)), equalProportions(cfg.Hospitals))
+	case PartitionDirichlet:
+		if cfg.Alpha <= 0 {
+			return nil, nil, fmt.Errorf("partition: dirichlet mode needs alpha > 0")
+		}
+		parts = dirichletLabelSplit(labels, cfg.Hospitals, cfg.Alpha, rng)
+	case PartitionQuantity:
+		if cfg.Alpha <= 0 {
+			return nil, nil, fmt.Errorf("partition: quantity mode needs alpha > 0")
+		}
+		parts = splitBySizes(rng.Perm(len(records)), dirichlet(rng, cfg.Alpha, cfg.Hospitals))
+		ensureNonEmpty(parts)
+	case PartitionFeature:
+		col, err := columnIndex(header, cfg.Feature, -1)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = featureSplit(records, col, cfg.Hospitals, cfg.Bands, rng)
+	default:
+		return nil, nil, fmt.Errorf("partition: unknown mode %q", cfg.Mode)
+	}
+	for _, p := range parts {
+		sort.Ints(p)
+	}
+
+	report := &PartitionReport{
+		Mode: cfg.Mode, Hospitals: cfg.Hospitals, Alpha: cfg.Alpha, Seed: cfg.Seed,
+		Feature: cfg.Feature, Labels: make(map[string]int),
+	}
+	for _, l := range labels {
+		report.Labels[l]++
+	}
+	featureCol := -1
+	if cfg.Mode == PartitionFeature {
+		featureCol, _ = columnIndex(header, cfg.Feature, -1)
+	}
+	for h, idx := range parts {
+		s := PartitionSummary{
+			ID:          fmt.Sprintf("H%d", h+1),
+			Rows:        len(idx),
+			LabelCounts: make(map[string]int),
+		}
+		s.File = s.ID + ".csv"
+		for _, i := range idx {
+			s.LabelCounts[labels[i]]++
+			if featureCol < 0 || featureCol >= len(records[i]) {
+				continue
+			}
+			if v, err := strconv.ParseFloat(records[i][featureCol], 64); err == nil {
+				if s.FeatureMin == nil || v < *s.FeatureMin {
+					lo := v
+					s.FeatureMin = &lo
+				}
+				if s.FeatureMax == nil || v > *s.FeatureMax {
+					hi := v
+					s.FeatureMax = &hi
+				}
+			}
+		}
+		s.LabelTV = labelTV(s.LabelCounts, report.Labels)
+		report.LabelSkew += s.LabelTV / float64(len(parts))
+		report.Parts = append(report.Parts, s)
+	}
+	return parts, report, nil
+}
+
+// WritePartitions writes one CSV per hospital (with the original header) and
+// the report into dir, creating it if needed.
+func WritePartitions(dir string, header []string, records [][]string, parts [][]int, report *PartitionReport) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("write partitions: %w", err)
+	}
+	for h, idx := range parts {
+		f, err := os.Create(filepath.Join(dir, report.Parts[h].File))
+		if err != nil {
+			return fmt.Errorf("write partitions: %w", err)
+		}
+		w := csv.NewWriter(f)
+		w.Write(header)
+		for _, i := range idx {
+			w.Write(records[i])
+		}
+		w.Flush()
+		err = w.Error()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("write partition %s: %w", report.Parts[h].ID, err)
+		}
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("write partition report: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, PartitionReportFile), b, 0644)
+}
+
+// LoadPartitionReport reads dir/partition_report.json.
+func LoadPartitionReport(dir string) (*PartitionReport, error) {
+	b, err := os.ReadFile(filepath.Join(dir, PartitionReportFile))
+	if err != nil {
+		return nil, fmt.Errorf("read partition report: %w", err)
+	}
+	var r PartitionReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("parse partition report: %w", err)
+	}
+	return &r, nil
+}
+
+// HospitalConfigs returns one config per partition file in dir, each covering
+// the whole file. Callers fill in the schema, round and model version.
+func (r *PartitionReport) HospitalConfigs(dir string) []HospitalConfig {
+	var cfgs []HospitalConfig
+	for _, p := range r.Parts {
+		path := filepath.Join(dir, p.File)
+		cfgs = append(cfgs, HospitalConfig{ID: p.ID, CSVPath: path, StartIdx: 0, EndIdx: p.Rows})
+	}
+	return cfgs
+}
+
+// columnIndex finds name in header (case- and space-insensitive). An empty
+// name returns def, or an error when def is negative.
+func columnIndex(header []string, name string, def int) (int, error) {
+	if name == "" {
+		if def < 0 {
+			return 0, fmt.Errorf("partition: no column given")
+		}
+		return def, nil
+	}
+	for i, h := range header {
+		if normaliseHeader(h) == normaliseHeader(name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("partition: column %q not in header %v", name, header)
+}
+
+// dirichletLabelSplit shuffles each class and splits it across hospitals by
+// its own proportions p ~ Dir(alpha). Classes are processed in sorted order so
+// the result only depends on the seed.
+func dirichletLabelSplit(labels []string, hospitals int, alpha float64, rng *rand.Rand) [][]int {
+	byClass := make(map[string][]int)
+	for i, l := range labels {
+		byClass[l] = append(byClass[l], i)
+	}
+	var classes []string
+	for c := range byClass {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+
+	parts := make([][]int, hospitals)
+	for _, c := range classes {
+		idx := byClass[c]
+		rng.Shuffle(len(idx), func(i, j int) { idx[i], idx[j] = idx[j], idx[i] })
+		for h, share := range splitBySizes(idx, dirichlet(rng, alpha, hospitals)) {
+			parts[h] = append(parts[h], share...)
+		}
+	}
+	ensureNonEmpty(parts)
+	return parts
+}
+
+// featureSplit assigns records to hospitals by band of a numeric column.
+func featureSplit(records [][]string, col, hospitals int, bands []float64, rng *rand.Rand) [][]int {
+	type keyed struct {
+		idx int
+		v   float64
+	}
+	var numeric []keyed
+	var other []int
+	for i, rec := range records {
+		if col < len(rec) {
+			if v, err := strconv.ParseFloat(rec[col], 64); err == nil && !math.IsNaN(v) {
+				numeric = append(numeric, keyed{i, v})
+				continue
+			}
+		}
+		other = append(other, i)
+	}
+
+	parts := make([][]int, hospitals)
+	if len(bands) == 0 {
+		sort.SliceStable(numeric, func(a, b int) bool { return numeric[a].v < numeric[b].v })
+		order := make([]int, len(numeric))
+		for k, r := range numeric {
+			order[k] = r.idx
+		}
+		copy(parts, splitBySizes(order, equalProportions(hospitals)))
+	} else {
+		for _, r := range numeric {
+			band := sort.Search(len(bands), func(b int) bool { return r.v < bands[b] })
+			h := band % hospitals
+			parts[h] = append(parts[h], r.idx)
+		}
+	}
+	for _, i := range other {
+		h := rng.Intn(hospitals)
+		parts[h] = append(parts[h], i)
+	}
+	ensureNonEmpty(parts)
+	return parts
+}
+
+// splitBySizes cuts order into len(props) consecutive pieces whose sizes are
+// proportional to props, using largest-remainder rounding so every record is used.
+func splitBySizes(order []int, props []float64) [][]int {
+	n := len(order)
+	sizes := make([]int, len(props))
+	type rem struct {
+		h int
+		r float64
+	}
+	var rems []rem
+	used := 0
+	for h, p := range props {
+		exact := p * float64(n)
+		sizes[h] = int(exact)
+		used += sizes[h]
+		rems = append(rems, rem{h, exact - float64(sizes[h])})
+	}
+	sort.SliceStable(rems, func(a, b int) bool { return rems[a].r > rems[b].r })
+	for k := 0; used < n; k++ {
+		sizes[rems[k%len(rems)].h]++
+		used++
+	}
+
+	parts := make([][]int, len(props))
+	pos := 0
+	for h, s := range sizes {
+		parts[h] = append([]int(nil), order[pos:pos+s]...)
+		pos += s
+	}
+	return parts
+}
+
+// ensureNonEmpty moves one record from the largest part into every empty
+// part, so extreme skew never produces a hospital with no data.
+func ensureNonEmpty(parts [][]int) {
+	for h := range parts {
+		if len(parts[h]) > 0 {
+			continue
+		}
+		largest := 0
+		for k := range parts {
+			if len(parts[k]) > len(parts[largest]) {
+				largest = k
+			}
+		}
+		if len(parts[largest]) < 2 {
+			return
+		}
+		last := len(parts[largest]) - 1
+		parts[h] = append(parts[h], parts[largest][last])
+		parts[largest] = parts[largest][:last]
+	}
+}
+
+func equalProportions(n int) []float64 {
+	p := make([]float64, n)
+	for i := range p {
+		p[i] = 1 / float64(n)
+	}
+	return p
+}
+
+// dirichlet draws p ~ Dir(alpha, ..., alpha) as normalised Gamma(alpha, 1) variates.
+func dirichlet(rng *rand.Rand, alpha float64, n int) []float64 {
+	p := make([]float64, n)
+	sum := 0.0
+	for i := range p {
+		p[i] = gamma(rng, alpha)
+		sum += p[i]
+	}
+	if sum == 0 { // every draw underflowed (tiny alpha): put all mass on one hospital
+		p[rng.Intn(n)] = 1
+		return p
+	}
+	for i := range p {
+		p[i] /= sum
+	}
+	return p
+}
+
+// gamma samples Gamma(shape, 1) with Marsaglia–Tsang; shape < 1 uses the
+// boost Gamma(a) = Gamma(a+1) · U^(1/a).
+func gamma(rng *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		return gamma(rng, shape+1) * math.Pow(rng.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// labelTV is the total-variation distance ½·Σ|p_local(c) − p_global(c)|.
+func labelTV(local, global map[string]int) float64 {
+	nl, ng := 0, 0
+	for _, c := range local {
+		nl += c
+	}
+	for _, c := range global {
+		ng += c
+	}
+	if nl == 0 || ng == 0 {
+		return 0
+	}
+	tv := 0.0
+	for class, c := range global {
+		tv += math.Abs(float64(local[class])/float64(nl) - float64(c)/float64(ng))
+	}
+	return tv / 2
+}
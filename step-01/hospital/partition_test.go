@@ -0,0 +1,83 @@
+package hospital
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// syntheticRecords returns n records of [age, label] with a 70/30 label mix.
+func syntheticRecords(n int) ([]string, [][]string) {
+	var records [][]string
+	for i := 0; i < n; i++ {
+		label := "negative"
+		if i%10 < 3 {
+			label = "positive"
+		}
+		records = append(records, []string{strconv.Itoa(20 + i%60), label})
+	}
+	return []string{"Age", "Result"}, records
+}
+
+// checkCover fails unless every record index appears in exactly one part.
+func checkCover(t *testing.T, parts [][]int, n int) {
+	t.Helper()
+	seen := make([]int, n)
+	for _, p := range parts {
+		if len(p) == 0 {
+			t.Errorf("empty partition")
+		}
+		for _, i := range p {
+			seen[i]++
+		}
+	}
+	for i, c := range seen {
+		if c != 1 {
+			t.Fatalf("record %d assigned %d times", i, c)
+		}
+	}
+}
+
+func TestPartitionModesCoverEveryRecordOnce(t *testing.T) {
+	header, records := syntheticRecords(600)
+	for _, cfg := range []PartitionConfig{
+		{Mode: PartitionIID, Hospitals: 4, Seed: 1},
+		{Mode: PartitionDirichlet, Hospitals: 4, Alpha: 0.1, Seed: 1},
+		{Mode: PartitionQuantity, Hospitals: 4, Alpha: 0.5, Seed: 1},
+		{Mode: PartitionFeature, Hospitals: 4, Feature: "age", Seed: 1},
+		{Mode: PartitionFeature, Hospitals: 2, Feature: "age", Bands: []float64{40, 60}, Seed: 1},
+	} {
+		t.Run(fmt.Sprintf("%s-%d", cfg.Mode, len(cfg.Bands)), func(t *testing.T) {
+			parts, report, err := Partition(header, records, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkCover(t, parts, len(records))
+			again, _, _ := Partition(header, records, cfg)
+			if !reflect.DeepEqual(parts, again) {
+				t.Errorf("same seed gave a different split")
+			}
+			if len(report.Parts) != cfg.Hospitals {
+				t.Errorf("report has %d parts, want %d", len(report.Parts), cfg.Hospitals)
+			}
+		})
+	}
+}
+
+func TestPartitionSkewFollowsAlphaAndBands(t *testing.T) {
+	header, records := syntheticRecords(600)
+
+	_, iid, _ := Partition(header, records, PartitionConfig{Mode: PartitionIID, Hospitals: 5, Seed: 3})
+	_, skewed, _ := Partition(header, records, PartitionConfig{Mode: PartitionDirichlet, Hospitals: 5, Alpha: 0.05, Seed: 3})
+	if iid.LabelSkew > 0.05 || skewed.LabelSkew < 3*iid.LabelSkew {
+		t.Errorf("label skew: iid %.3f, dirichlet(0.05) %.3f", iid.LabelSkew, skewed.LabelSkew)
+	}
+
+	_, bands, _ := Partition(header, records, PartitionConfig{Mode: PartitionFeature, Hospitals: 3, Feature: "Age", Seed: 3})
+	for h := 1; h < len(bands.Parts); h++ {
+		if *bands.Parts[h].FeatureMin < *bands.Parts[h-1].FeatureMax {
+			t.Errorf("age bands overlap: H%d max %g, H%d min %g", h, *bands.Parts[h-1].FeatureMax, h+1, *bands.Parts[h].FeatureMin)
+		}
+	}
+}
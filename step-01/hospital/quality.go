@@ -0,0 +1,90 @@
+package hospital
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ColumnQuality counts data problems found in one feature column.
+type ColumnQuality struct {
+	Name        string  `json:"name"`
+	Missing     int     `json:"missing"`      // empty cells or missing-value tokens
+	Invalid     int     `json:"invalid"`      // cells that could not be parsed for the column type
+	OutOfRange  int     `json:"out_of_range"` // numeric values outside [min, max]
+	Clipped     int     `json:"clipped"`      // out-of-range values replaced by the nearest bound
+	Imputed     int     `json:"imputed"`      // missing or invalid cells filled by the column strategy
+	MissingRate float64 `json:"missing_rate"` // (missing + invalid) / rows read
+}
+
+// QualitySummary is the aggregate-only part of a LoadReport. It holds counts
+// and rates but no cell values, so a hospital may share it with the server.
+type QualitySummary struct {
+	RowsRead     int             `json:"rows_read"`
+	RowsLoaded   int             `json:"rows_loaded"`
+	RowsDropped  int             `json:"rows_dropped"`
+	Columns      []ColumnQuality `json:"columns"`
+	LabelBalance map[string]int  `json:"label_balance"` // loaded rows per label
+}
+
+// LoadReport is the data-quality result of one partition load, for local review.
+// Errors quote raw cell values and must never leave the hospital; share
+// Summary() instead.
+type LoadReport struct {
+	QualitySummary
+	Errors []RowError `json:"errors,omitempty"`
+}
+
+// Summary returns a copy of the aggregate-only statistics.
+func (r *LoadReport) Summary() QualitySummary {
+	s := r.QualitySummary
+	s.Columns = append([]ColumnQuality(nil), r.Columns...)
+	s.LabelBalance = make(map[string]int, len(r.LabelBalance))
+	for k, v := range r.LabelBalance {
+		s.LabelBalance[k] = v
+	}
+	return s
+}
+
+// column returns the quality entry for name, creating it in schema order.
+func (r *LoadReport) column(name string) *ColumnQuality {
+	for i := range r.Columns {
+		if r.Columns[i].Name == name {
+			return &r.Columns[i]
+		}
+	}
+	r.Columns = append(r.Columns, ColumnQuality{Name: name})
+	return &r.Columns[len(r.Columns)-1]
+}
+
+// finish computes rates once all rows are counted.
+func (r *LoadReport) finish() {
+	r.RowsDropped = r.RowsRead - r.RowsLoaded
+	for i := range r.Columns {
+		c := &r.Columns[i]
+		if r.RowsRead > 0 {
+			c.MissingRate = float64(c.Missing+c.Invalid) / float64(r.RowsRead)
+		}
+	}
+}
+
+// Print writes a human-readable data-quality summary to w.
+func (r *LoadReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Rows: %d read, %d loaded, %d dropped\n", r.RowsRead, r.RowsLoaded, r.RowsDropped)
+	fmt.Fprintf(w, "  %-26s %8s %8s %8s %8s %8s %7s\n", "column", "missing", "invalid", "range", "clipped", "imputed", "rate")
+	for _, c := range r.Columns {
+		fmt.Fprintf(w, "  %-26s %8d %8d %8d %8d %8d %6.1f%%\n",
+			c.Name, c.Missing, c.Invalid, c.OutOfRange, c.Clipped, c.Imputed, 100*c.MissingRate)
+	}
+
+	labels := make([]string, 0, len(r.LabelBalance))
+	for l := range r.LabelBalance {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	fmt.Fprint(w, "  label balance:")
+	for _, l := range labels {
+		fmt.Fprintf(w, " %s=%d", l, r.LabelBalance[l])
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,364 @@
+package hospital
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ColumnRole says what the loader does with a column.
+type ColumnRole string
+
+const (
+	RoleFeature ColumnRole = "feature"
+	RoleLabel   ColumnRole = "label"
+	RoleIgnore  ColumnRole = "ignore"
+)
+
+// ColumnType says how a cell is parsed.
+type ColumnType string
+
+const (
+	TypeNumeric     ColumnType = "numeric"     // float, min-max normalised
+	TypeBinary      ColumnType = "binary"      // 0/1, true/false, yes/no or the Positive list
+	TypeCategorical ColumnType = "categorical" // one-hot over Categories
+)
+
+// MissingStrategy says how an empty or unparsable feature cell is handled.
+type MissingStrategy string
+
+const (
+	MissingDrop      MissingStrategy = "drop"      // skip the row (default)
+	MissingMean      MissingStrategy = "mean"      // local mean of the observed values
+	MissingMedian    MissingStrategy = "median"    // local median of the observed values
+	MissingConstant  MissingStrategy = "constant"  // the column's Fill value
+	MissingIndicator MissingStrategy = "indicator" // local mean, plus a 0/1 "<name> (missing)" input
+)
+
+// missingTokens are cell values treated as missing regardless of column type.
+var missingTokens = map[string]bool{"": true, "na": true, "n/a": true, "nan": true, "null": true, "?": true}
+
+// ColumnSchema describes one named column of a hospital export.
+// Columns are matched to the file by header name, so exports may list them
+// in any order and include extra columns, which are ignored.
+type ColumnSchema struct {
+	Name string     `json:"name"`
+	Role ColumnRole `json:"role"`
+	Type ColumnType `json:"type"`
+
+	// Min/Max bound the accepted raw value of a numeric column. A cell outside
+	// the range is reported as a row error, or clipped when Clip is set.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+
+	// Categories fixes the one-hot order of a categorical feature, or the
+	// label vocabulary of a categorical label. It must be identical across
+	// hospitals so that input and output units line up.
+	Categories []string `json:"categories,omitempty"`
+
+	// Positive lists the cell values mapped to 1 for a binary column
+	// (case-insensitive). Empty means "1", "true", "yes" and "positive".
+	Positive []string `json:"positive,omitempty"`
+
+	// Negative lists cell values mapped to 0 besides "0", "false", "no" and
+	// "negative". Any other value is a row error, with or without Positive.
+	Negative []string `json:"negative,omitempty"`
+
+	// MultiLabel lets a categorical label cell hold several labels separated by LabelSeparator.
+	MultiLabel bool `json:"multi_label,omitempty"`
+
+	// Missing is the imputation strategy for feature cells that are empty or
+	// cannot be parsed; "" means MissingDrop. Label cells are never imputed.
+	Missing MissingStrategy `json:"missing,omitempty"`
+
+	// Fill is the MissingConstant value, written as it would appear in the
+	// file ("0", "yes", or a category name).
+	Fill string `json:"fill,omitempty"`
+
+	// Clip replaces an out-of-range numeric value with the nearest bound
+	// instead of rejecting the row.
+	Clip bool `json:"clip,omitempty"`
+}
+
+// Schema maps a hospital's export onto the federation's feature vector.
+type Schema struct {
+	Columns []ColumnSchema `json:"columns"`
+}
+
+// DefaultSchema describes Medicaldataset.csv: eight numeric features and the
+// binary Result label. It reproduces the original hard-coded loader layout.
+func DefaultSchema() *Schema {
+	numeric := func(name string) ColumnSchema {
+		return ColumnSchema{Name: name, Role: RoleFeature, Type: TypeNumeric}
+	}
+	return &Schema{Columns: []ColumnSchema{
+		numeric("Age"),
+		numeric("Gender"),
+		numeric("Heart rate"),
+		numeric("Systolic blood pressure"),
+		numeric("Diastolic blood pressure"),
+		numeric("Blood sugar"),
+		numeric("CK-MB"),
+		numeric("Troponin"),
+		{Name: "Result", Role: RoleLabel, Type: TypeBinary, Positive: []string{"positive"}},
+	}}
+}
+
+// LoadSchema reads and validates a JSON schema file.
+func LoadSchema(path string) (*Schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+	var s Schema
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse schema %s: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("schema %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Validate checks that the schema has features, exactly one label, and
+// well-formed column definitions.
+func (s *Schema) Validate() error {
+	labels, features := 0, 0
+	seen := make(map[string]bool)
+	for _, c := range s.Columns {
+		if c.Name == "" {
+			return fmt.Errorf("column with empty name")
+		}
+		key := normaliseHeader(c.Name)
+		if seen[key] {
+			return fmt.Errorf("column %q listed twice", c.Name)
+		}
+		seen[key] = true
+
+		switch c.Role {
+		case RoleFeature:
+			features++
+		case RoleLabel:
+			labels++
+			if c.Type == TypeNumeric {
+				return fmt.Errorf("label column %q must be binary or categorical", c.Name)
+			}
+		case RoleIgnore:
+			continue
+		default:
+			return fmt.Errorf("column %q: unknown role %q", c.Name, c.Role)
+		}
+
+		switch c.Type {
+		case TypeNumeric, TypeBinary:
+		case TypeCategorical:
+			if len(c.Categories) == 0 {
+				return fmt.Errorf("categorical column %q needs a categories list", c.Name)
+			}
+		default:
+			return fmt.Errorf("column %q: unknown type %q", c.Name, c.Type)
+		}
+		for _, p := range c.Positive {
+			for _, n := range c.Negative {
+				if strings.EqualFold(strings.TrimSpace(p), strings.TrimSpace(n)) {
+					return fmt.Errorf("column %q: %q is both positive and negative", c.Name, p)
+				}
+			}
+		}
+		if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+			return fmt.Errorf("column %q: min %g > max %g", c.Name, *c.Min, *c.Max)
+		}
+		if c.Role == RoleFeature {
+			if err := c.validateMissing(); err != nil {
+				return err
+			}
+		}
+	}
+	if features == 0 {
+		return fmt.Errorf("no feature columns")
+	}
+	if labels != 1 {
+		return fmt.Errorf("expected exactly 1 label column, found %d", labels)
+	}
+	return nil
+}
+
+// features returns the feature columns in schema order.
+func (s *Schema) features() []ColumnSchema {
+	var out []ColumnSchema
+	for _, c := range s.Columns {
+		if c.Role == RoleFeature {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// sourceColumns lists the columns a DataSource must provide: every feature
+// and the label, in schema order.
+func (s *Schema) sourceColumns() []string {
+	var names []string
+	for _, c := range s.Columns {
+		if c.Role != RoleIgnore {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// Label returns the label column.
+func (s *Schema) Label() ColumnSchema {
+	for _, c := range s.Columns {
+		if c.Role == RoleLabel {
+			return c
+		}
+	}
+	return ColumnSchema{}
+}
+
+// validateMissing checks that the column's imputation strategy fits its type.
+func (c ColumnSchema) validateMissing() error {
+	switch c.Missing {
+	case "", MissingDrop, MissingIndicator:
+	case MissingMean, MissingMedian:
+		if c.Type == TypeCategorical {
+			return fmt.Errorf("column %q: %s imputation needs a numeric or binary column", c.Name, c.Missing)
+		}
+	case MissingConstant:
+		if _, err := c.parse(c.Fill); err != nil {
+			return fmt.Errorf("column %q: fill value %q: %v", c.Name, c.Fill, err)
+		}
+	default:
+		return fmt.Errorf("column %q: unknown missing strategy %q", c.Name, c.Missing)
+	}
+	return nil
+}
+
+// FeatureNames lists the model inputs in order. Categorical columns expand to
+// one input per category, named "Column=category". Columns using
+// MissingIndicator add a "Column (missing)" input after their own.
+func (s *Schema) FeatureNames() []string {
+	var names []string
+	for _, c := range s.features() {
+		if c.Type == TypeCategorical {
+			for _, cat := range c.Categories {
+				names = append(names, c.Name+"="+cat)
+			}
+		} else {
+			names = append(names, c.Name)
+		}
+		if c.Missing == MissingIndicator {
+			names = append(names, c.Name+" (missing)")
+		}
+	}
+	return names
+}
+
+// NumFeatures is the model input size implied by the schema.
+func (s *Schema) NumFeatures() int {
+	return len(s.FeatureNames())
+}
+
+// LabelVocab returns the vocabulary of a categorical label column.
+// ok is false for a binary label.
+func (s *Schema) LabelVocab() (vocab LabelVocab, ok bool) {
+	l := s.Label()
+	if l.Type != TypeCategorical {
+		return LabelVocab{}, false
+	}
+	return LabelVocab{Labels: l.Categories, MultiLabel: l.MultiLabel}, true
+}
+
+// ModelSpec returns a spec of the given type whose input and output sizes
+// follow the schema.
+func (s *Schema) ModelSpec(modelType string) ModelSpec {
+	if vocab, ok := s.LabelVocab(); ok {
+		return vocab.Spec(modelType, s.NumFeatures())
+	}
+	return ModelSpec{Type: modelType, InputSize: s.NumFeatures()}
+}
+
+// normaliseHeader makes header matching tolerant of case and stray spaces.
+func normaliseHeader(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// parseBinary maps a cell to 0 or 1. A Positive list replaces the default
+// true values; the false values are always the defaults plus Negative.
+func (c ColumnSchema) parseBinary(cell string) (float64, error) {
+	v := strings.ToLower(strings.TrimSpace(cell))
+	if len(c.Positive) > 0 {
+		for _, p := range c.Positive {
+			if v == strings.ToLower(strings.TrimSpace(p)) {
+				return 1, nil
+			}
+		}
+	} else {
+		switch v {
+		case "1", "true", "yes", "positive":
+			return 1, nil
+		}
+	}
+	switch v {
+	case "0", "false", "no", "negative":
+		return 0, nil
+	}
+	for _, n := range c.Negative {
+		if v == strings.ToLower(strings.TrimSpace(n)) {
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("not a binary value")
+}
+
+// parse encodes a non-missing feature cell: one value for numeric and
+// binary columns, a one-hot vector for categorical ones. Range is not checked.
+func (c ColumnSchema) parse(cell string) ([]float64, error) {
+	switch c.Type {
+	case TypeNumeric:
+		v, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+		if err != nil {
+			return nil, fmt.Errorf("not a number")
+		}
+		return []float64{v}, nil
+	case TypeBinary:
+		v, err := c.parseBinary(cell)
+		if err != nil {
+			return nil, err
+		}
+		return []float64{v}, nil
+	default:
+		return c.oneHot(cell)
+	}
+}
+
+// checkRange reports whether v lies outside [Min, Max] and the nearest bound.
+func (c ColumnSchema) checkRange(v float64) (clipped float64, reason string) {
+	if c.Min != nil && v < *c.Min {
+		return *c.Min, fmt.Sprintf("below minimum %g", *c.Min)
+	}
+	if c.Max != nil && v > *c.Max {
+		return *c.Max, fmt.Sprintf("above maximum %g", *c.Max)
+	}
+	return v, ""
+}
+
+// isMissing reports whether a cell is empty or a missing-value token.
+func isMissing(cell string) bool {
+	return missingTokens[strings.ToLower(strings.TrimSpace(cell))]
+}
+
+// oneHot encodes a categorical cell over the column's categories.
+func (c ColumnSchema) oneHot(cell string) ([]float64, error) {
+	v := strings.TrimSpace(cell)
+	out := make([]float64, len(c.Categories))
+	for i, cat := range c.Categories {
+		if strings.EqualFold(v, cat) {
+			out[i] = 1
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown category")
+}
@@ -0,0 +1,477 @@
+package hospital
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DataSource is a hospital's local export in some file format. Every source
+// yields plain string cells for the columns the schema asks for, so CSV,
+// JSON Lines, columnar binary and FHIR exports all feed the same schema
+// mapping, imputation and normalisation pipeline and end up as []Sample.
+type DataSource interface {
+	// Open starts reading records whose cells follow the given column order.
+	// It fails if the source cannot provide one of the columns at all.
+	Open(columns []string) (RecordReader, error)
+	// String names the source in error messages.
+	String() string
+}
+
+// RecordReader iterates the records of an opened DataSource.
+type RecordReader interface {
+	// Read returns the next record, one cell per requested column. A missing
+	// value is an empty cell. io.EOF ends the stream; any other error affects
+	// only that record and reading may continue.
+	Read() ([]string, error)
+	Close() error
+}
+
+// sourceFormats lists the -format values accepted by OpenSource.
+var sourceFormats = []string{"csv", "jsonl", "columnar", "fhir"}
+
+// OpenSource builds a DataSource for a file of the named format. mappingPath
+// is the FHIRMapping file and is required only for "fhir".
+func OpenSource(format, path, mappingPath string) (DataSource, error) {
+	switch strings.ToLower(format) {
+	case "", "csv":
+		return CSVSource{Path: path}, nil
+	case "jsonl":
+		return JSONLSource{Path: path}, nil
+	case "columnar":
+		return ColumnarSource{Path: path}, nil
+	case "fhir":
+		if mappingPath == "" {
+			return nil, fmt.Errorf("fhir format needs a mapping file")
+		}
+		m, err := LoadFHIRMapping(mappingPath)
+		if err != nil {
+			return nil, err
+		}
+		return FHIRSource{Path: path, Mapping: m}, nil
+	}
+	return nil, fmt.Errorf("unknown data format %q (want one of %v)", format, sourceFormats)
+}
+
+// CSVSource reads a CSV file with a header row (the original Medicaldataset.csv format).
+type CSVSource struct {
+	Path string
+}
+
+func (s CSVSource) String() string { return "csv:" + s.Path }
+
+// Open maps the requested columns onto the header by name (case- and
+// space-insensitive). Extra header columns are ignored.
+func (s CSVSource) Open(columns []string) (RecordReader, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open csv: %w", err)
+	}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1 // ragged rows surface as missing cells, not a fatal read error
+	header, err := r.Read()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		index[normaliseHeader(h)] = i
+	}
+	positions := make([]int, len(columns))
+	var missing []string
+	for k, name := range columns {
+		i, ok := index[normaliseHeader(name)]
+		if !ok {
+			missing = append(missing, name)
+		}
+		positions[k] = i
+	}
+	if len(missing) > 0 {
+		f.Close()
+		return nil, fmt.Errorf("header %v is missing schema columns %v", header, missing)
+	}
+	return &csvReader{f: f, r: r, positions: positions}, nil
+}
+
+type csvReader struct {
+	f         *os.File
+	r         *csv.Reader
+	positions []int
+}
+
+func (c *csvReader) Read() ([]string, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(c.positions))
+	for k, i := range c.positions {
+		if i < len(record) {
+			out[k] = record[i]
+		}
+	}
+	return out, nil
+}
+
+func (c *csvReader) Close() error { return c.f.Close() }
+
+// JSONLSource reads line-delimited JSON: one flat object per line, keyed by
+// column name. Absent keys and nulls are missing values; numbers, strings and
+// booleans are converted to their text form.
+type JSONLSource struct {
+	Path string
+}
+
+func (s JSONLSource) String() string { return "jsonl:" + s.Path }
+
+// Open never fails on column names: a key absent from every line simply
+// produces missing values, which the schema's imputation handles.
+func (s JSONLSource) Open(columns []string) (RecordReader, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open jsonl: %w", err)
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &jsonlReader{f: f, sc: sc, columns: columns}, nil
+}
+
+type jsonlReader struct {
+	f       *os.File
+	sc      *bufio.Scanner
+	columns []string
+}
+
+func (j *jsonlReader) Read() ([]string, error) {
+	for j.sc.Scan() {
+		line := strings.TrimSpace(j.sc.Text())
+		if line == "" {
+			continue // blank lines are not records
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			return nil, fmt.Errorf("invalid JSON object: %w", err)
+		}
+		byName := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			byName[normaliseHeader(k)] = v
+		}
+		out := make([]string, len(j.columns))
+		for k, name := range j.columns {
+			out[k] = cellText(byName[normaliseHeader(name)])
+		}
+		return out, nil
+	}
+	if err := j.sc.Err(); err != nil {
+		return nil, fmt.Errorf("read jsonl: %w", err)
+	}
+	return nil, io.EOF
+}
+
+func (j *jsonlReader) Close() error { return j.f.Close() }
+
+// cellText renders a decoded JSON value as a cell.
+func cellText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+// ColumnarSource reads the federation's Parquet-free columnar binary format,
+// written by WriteColumnar. All integers are little-endian:
+//
+//	magic "HFLC" | version uint16 | nCols uint32 | nRows uint32
+//	per column: nameLen uint16 | name | kind byte | values
+//	  kind 0 (float64): nRows × float64 bits, NaN = missing
+//	  kind 1 (string):  nRows × (len uint32 | bytes)
+//
+// Columns are stored contiguously; Open skips the ones it was not asked for
+// without decoding them. Every count and length is checked against the bytes
+// left in the file before anything is allocated, so a damaged or hostile
+// file is refused rather than exhausting memory.
+type ColumnarSource struct {
+	Path string
+}
+
+const (
+	columnarMagic   = "HFLC"
+	columnarVersion = 1
+	columnFloat     = 0
+	columnString    = 1
+)
+
+func (s ColumnarSource) String() string { return "columnar:" + s.Path }
+
+// Open loads the requested columns; a column absent from the file is an error.
+func (s ColumnarSource) Open(columns []string) (RecordReader, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open columnar: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("open columnar: %w", err)
+	}
+	d := &columnarDecoder{r: bufio.NewReader(f), left: info.Size()}
+
+	var hdr struct {
+		Magic   [4]byte
+		Version uint16
+		NCols   uint32
+		NRows   uint32
+	}
+	if err := d.read(&hdr, int64(binary.Size(hdr))); err != nil {
+		return nil, fmt.Errorf("read columnar header: %w", err)
+	}
+	if string(hdr.Magic[:]) != columnarMagic {
+		return nil, fmt.Errorf("%s is not a columnar file", s.Path)
+	}
+	if hdr.Version != columnarVersion {
+		return nil, fmt.Errorf("columnar version %d not supported", hdr.Version)
+	}
+	// Every column takes at least 3 bytes plus 4 per row.
+	if perColumn := 3 + 4*int64(hdr.NRows); int64(hdr.NCols) > d.left/perColumn {
+		return nil, fmt.Errorf("%s: header claims %d columns of %d rows, more than its %d remaining bytes hold", s.Path, hdr.NCols, hdr.NRows, d.left)
+	}
+
+	wanted := make(map[string]int, len(columns))
+	for k, name := range columns {
+		wanted[normaliseHeader(name)] = k
+	}
+	data := make([][]string, len(columns))
+	var names []string
+	for c := uint32(0); c < hdr.NCols; c++ {
+		name, err := d.columnName()
+		if err != nil {
+			return nil, fmt.Errorf("read column %d: %w", c, err)
+		}
+		names = append(names, name)
+		k, ok := wanted[normaliseHeader(name)]
+		cells, err := d.column(name, int(hdr.NRows), !ok)
+		if err != nil {
+			return nil, fmt.Errorf("read column %d: %w", c, err)
+		}
+		if ok {
+			data[k] = cells
+		}
+	}
+	var missing []string
+	for k, name := range columns {
+		if data[k] == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("columns %v are missing schema columns %v", names, missing)
+	}
+	return &columnarReader{data: data, rows: int(hdr.NRows)}, nil
+}
+
+// columnarDecoder reads a columnar file, tracking how many bytes are left so
+// that no size taken from the file is trusted beyond what the file holds.
+type columnarDecoder struct {
+	r    *bufio.Reader
+	left int64
+}
+
+// need fails unless n more bytes remain.
+func (d *columnarDecoder) need(n int64) error {
+	if n < 0 || n > d.left {
+		return fmt.Errorf("needs %d bytes, only %d remain", n, d.left)
+	}
+	return nil
+}
+
+// read decodes size bytes into v.
+func (d *columnarDecoder) read(v interface{}, size int64) error {
+	if err := d.need(size); err != nil {
+		return err
+	}
+	d.left -= size
+	return binary.Read(d.r, binary.LittleEndian, v)
+}
+
+// bytes reads the next n bytes, or discards them when skip is set.
+func (d *columnarDecoder) bytes(n int64, skip bool) ([]byte, error) {
+	if err := d.need(n); err != nil {
+		return nil, err
+	}
+	d.left -= n
+	if skip {
+		_, err := d.r.Discard(int(n))
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+// columnName reads the name that opens a column block.
+func (d *columnarDecoder) columnName() (string, error) {
+	var nameLen uint16
+	if err := d.read(&nameLen, 2); err != nil {
+		return "", err
+	}
+	name, err := d.bytes(int64(nameLen), false)
+	return string(name), err
+}
+
+// column decodes the rest of a column block into cells, or only steps over
+// it when skip is set.
+func (d *columnarDecoder) column(name string, rows int, skip bool) ([]string, error) {
+	var kind byte
+	if err := d.read(&kind, 1); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case columnFloat:
+		if skip {
+			_, err := d.bytes(8*int64(rows), true)
+			return nil, err
+		}
+		values := make([]float64, rows)
+		if err := d.read(values, 8*int64(rows)); err != nil {
+			return nil, err
+		}
+		cells := make([]string, rows)
+		for i, v := range values {
+			if !math.IsNaN(v) {
+				cells[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		}
+		return cells, nil
+	case columnString:
+		if err := d.need(4 * int64(rows)); err != nil {
+			return nil, err
+		}
+		var cells []string
+		if !skip {
+			cells = make([]string, rows)
+		}
+		for i := 0; i < rows; i++ {
+			var n uint32
+			if err := d.read(&n, 4); err != nil {
+				return nil, err
+			}
+			b, err := d.bytes(int64(n), skip)
+			if err != nil {
+				return nil, err
+			}
+			if !skip {
+				cells[i] = string(b)
+			}
+		}
+		return cells, nil
+	}
+	return nil, fmt.Errorf("column %q: unknown kind %d", name, kind)
+}
+
+type columnarReader struct {
+	data [][]string
+	rows int
+	next int
+}
+
+func (c *columnarReader) Read() ([]string, error) {
+	if c.next >= c.rows {
+		return nil, io.EOF
+	}
+	out := make([]string, len(c.data))
+	for k, col := range c.data {
+		out[k] = col[c.next]
+	}
+	c.next++
+	return out, nil
+}
+
+func (c *columnarReader) Close() error { return nil }
+
+// WriteColumnar converts tabular records (e.g. a CSV read with encoding/csv)
+// into the columnar format. A column is stored as float64 when every
+// non-missing cell parses as a number, otherwise as strings.
+func WriteColumnar(path string, header []string, records [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create columnar: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	le := binary.LittleEndian
+
+	write := func(v interface{}) {
+		if err == nil {
+			err = binary.Write(w, le, v)
+		}
+	}
+	write([]byte(columnarMagic))
+	write(uint16(columnarVersion))
+	write(uint32(len(header)))
+	write(uint32(len(records)))
+	for c, name := range header {
+		cells := make([]string, len(records))
+		for i, rec := range records {
+			if c < len(rec) {
+				cells[i] = rec[c]
+			}
+		}
+		write(uint16(len(name)))
+		write([]byte(name))
+		if values, ok := floatColumn(cells); ok {
+			write(byte(columnFloat))
+			write(values)
+			continue
+		}
+		write(byte(columnString))
+		for _, cell := range cells {
+			write(uint32(len(cell)))
+			write([]byte(cell))
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write columnar: %w", err)
+	}
+	return nil
+}
+
+// floatColumn parses cells as numbers, mapping missing tokens to NaN.
+func floatColumn(cells []string) ([]float64, bool) {
+	values := make([]float64, len(cells))
+	for i, cell := range cells {
+		if isMissing(cell) {
+			values[i] = math.NaN()
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
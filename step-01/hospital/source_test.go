@@ -0,0 +1,193 @@
+package hospital
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sourceSchema is the small schema every source fixture below is loaded through.
+func sourceSchema() *Schema {
+	return &Schema{Columns: []ColumnSchema{
+		{Name: "Age", Role: RoleFeature, Type: TypeNumeric},
+		{Name: "Troponin", Role: RoleFeature, Type: TypeNumeric, Missing: MissingMean},
+		{Name: "Result", Role: RoleLabel, Type: TypeBinary, Positive: []string{"positive"}},
+	}}
+}
+
+// writeFile writes body to name in a temporary directory and returns its path.
+func writeFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadAll loads every record of src and returns raw (unnormalised) rows and labels.
+func loadAll(t *testing.T, src DataSource) ([][]float64, []float64) {
+	t.Helper()
+	raw, samples, _, err := loadRaw(src, 0, 100, sourceSchema())
+	if err != nil {
+		t.Fatalf("%v: %v", src, err)
+	}
+	var labels []float64
+	for _, s := range samples {
+		labels = append(labels, s.Label)
+	}
+	return raw, labels
+}
+
+func TestSourcesFeedTheSamePipeline(t *testing.T) {
+	csvPath := writeFile(t, "export.csv", "result,troponin,age\npositive,0.5,60\nnegative,,40\n")
+	wantRaw, wantLabels := loadAll(t, CSVSource{Path: csvPath})
+	if !reflect.DeepEqual(wantRaw, [][]float64{{60, 0.5}, {40, 0.5}}) || !reflect.DeepEqual(wantLabels, []float64{1, 0}) {
+		t.Fatalf("csv baseline: raw %v labels %v", wantRaw, wantLabels)
+	}
+
+	jsonl := writeFile(t, "export.jsonl",
+		`{"Age": 60, "Troponin": 0.5, "Result": "positive", "extra": [1]}`+"\n\n"+
+			`{"age": 40, "troponin": null, "result": "negative"}`+"\n")
+
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	columnar := filepath.Join(t.TempDir(), "export.hflc")
+	if err := WriteColumnar(columnar, records[0], records[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []DataSource{JSONLSource{Path: jsonl}, ColumnarSource{Path: columnar}} {
+		raw, labels := loadAll(t, src)
+		if !reflect.DeepEqual(raw, wantRaw) || !reflect.DeepEqual(labels, wantLabels) {
+			t.Errorf("%v: raw %v labels %v, want %v %v", src, raw, labels, wantRaw, wantLabels)
+		}
+	}
+}
+
+func TestColumnarRefusesSizesBeyondTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.hflc")
+	header := []string{"Notes", "Age", "Troponin", "Result"}
+	records := [][]string{{"free text", "60", "0.5", "positive"}, {"", "40", "", "negative"}}
+	if err := WriteColumnar(path, header, records); err != nil {
+		t.Fatal(err)
+	}
+	// Notes is not in the schema and is skipped.
+	if raw, labels := loadAll(t, ColumnarSource{Path: path}); !reflect.DeepEqual(raw, [][]float64{{60, 0.5}, {40, 0.5}}) || !reflect.DeepEqual(labels, []float64{1, 0}) {
+		t.Errorf("raw %v labels %v", raw, labels)
+	}
+
+	good, _ := os.ReadFile(path)
+	for name, patch := range map[string]func(b []byte){
+		// nRows (bytes 10-13) claims four billion rows.
+		"row count": func(b []byte) { binary.LittleEndian.PutUint32(b[10:], 0xFFFFFFFF) },
+		// The first Notes cell (after the 14-byte header, name length, "Notes" and kind) claims 2 GiB.
+		"string length": func(b []byte) { binary.LittleEndian.PutUint32(b[14+2+5+1:], 1<<31) },
+	} {
+		b := append([]byte(nil), good...)
+		patch(b)
+		bad := filepath.Join(t.TempDir(), "bad.hflc")
+		os.WriteFile(bad, b, 0644)
+		if _, err := (ColumnarSource{Path: bad}).Open([]string{"Age"}); err == nil || !strings.Contains(err.Error(), "remain") {
+			t.Errorf("%s beyond the file: %v", name, err)
+		}
+	}
+}
+
+func TestFHIRSourceMapsObservationCodes(t *testing.T) {
+	bundle := writeFile(t, "bundle.json", `{
+  "resourceType": "Bundle",
+  "entry": [
+    {"resource": {"resourceType": "Patient", "id": "a", "birthDate": "1964-06-30", "gender": "male"}},
+    {"resource": {"resourceType": "Observation", "subject": {"reference": "Patient/a"},
+      "code": {"coding": [{"system": "http://loinc.org", "code": "6598-7"}]},
+      "effectiveDateTime": "2024-01-02T10:00:00Z", "valueQuantity": {"value": 0.9}}},
+    {"resource": {"resourceType": "Observation", "subject": {"reference": "Patient/a"},
+      "code": {"coding": [{"system": "http://loinc.org", "code": "6598-7"}]},
+      "effectiveDateTime": "2024-01-01T10:00:00Z", "valueQuantity": {"value": 0.1}}},
+    {"resource": {"resourceType": "Observation", "subject": {"reference": "Patient/a"},
+      "code": {"coding": [{"system": "urn:local", "code": "mi"}]}, "valueString": "positive"}},
+    {"resource": {"resourceType": "Observation", "subject": {"reference": "Patient/b"},
+      "code": {"coding": [{"system": "urn:local", "code": "mi"}]},
+      "valueCodeableConcept": {"coding": [{"code": "negative"}]}}},
+    {"resource": {"resourceType": "Observation", "subject": {"reference": "Patient/b"},
+      "code": {"coding": [{"system": "http://snomed.info/sct", "code": "6598-7"}]}, "valueQuantity": {"value": 99}}},
+    {"resource": {"resourceType": "Patient", "id": "b", "birthDate": "1984"}}
+  ]
+}`)
+	mappingPath := writeFile(t, "mapping.json", `{
+  "reference_date": "2024-06-01",
+  "patient": {"age": "Age"},
+  "observations": [
+    {"system": "http://loinc.org", "code": "6598-7", "column": "Troponin"},
+    {"code": "mi", "column": "Result"}
+  ]
+}`)
+
+	src, err := OpenSource("fhir", bundle, mappingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, labels := loadAll(t, src)
+	// Patient a: aged 59 (birthday not yet reached), latest troponin 0.9.
+	// Patient b: the SNOMED-coded 99 is not troponin, so the mean 0.9 is imputed.
+	if !reflect.DeepEqual(raw, [][]float64{{59, 0.9}, {40, 0.9}}) || !reflect.DeepEqual(labels, []float64{1, 0}) {
+		t.Errorf("raw %v labels %v", raw, labels)
+	}
+
+	incomplete := &FHIRMapping{Observations: []FHIRCodeMapping{{Code: "mi", Column: "Result"}}}
+	if _, err := (FHIRSource{Path: bundle, Mapping: incomplete}).Open([]string{"Age", "Result"}); err == nil ||
+		!strings.Contains(err.Error(), "Age") {
+		t.Errorf("expected an error naming the unmapped column, got %v", err)
+	}
+}
+
+func TestFHIRSourceResolvesFullURLReferences(t *testing.T) {
+	// A transaction bundle: subjects name patients by their entries' fullUrl,
+	// before and after the Patient entry, and one patient has no id at all.
+	bundle := writeFile(t, "transaction.json", `{
+  "resourceType": "Bundle",
+  "type": "transaction",
+  "entry": [
+    {"fullUrl": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000001",
+     "resource": {"resourceType": "Observation", "subject": {"reference": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000002"},
+      "code": {"coding": [{"system": "http://loinc.org", "code": "6598-7"}]}, "valueQuantity": {"value": 0.4}}},
+    {"fullUrl": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000002",
+     "resource": {"resourceType": "Patient", "id": "a", "birthDate": "1964-06-30"}},
+    {"fullUrl": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000003",
+     "resource": {"resourceType": "Observation", "subject": {"reference": "Patient/a"},
+      "code": {"coding": [{"code": "mi"}]}, "valueString": "positive"}},
+    {"fullUrl": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000004",
+     "resource": {"resourceType": "Patient", "birthDate": "1984"}},
+    {"fullUrl": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000005",
+     "resource": {"resourceType": "Observation", "subject": {"reference": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000004"},
+      "code": {"coding": [{"system": "http://loinc.org", "code": "6598-7"}]}, "valueQuantity": {"value": 0.2}}},
+    {"fullUrl": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000006",
+     "resource": {"resourceType": "Observation", "subject": {"reference": "urn:uuid:6f1c0e4a-0000-4000-8000-000000000004"},
+      "code": {"coding": [{"code": "mi"}]}, "valueString": "negative"}}
+  ]
+}`)
+	mapping := &FHIRMapping{
+		ReferenceDate: "2024-06-01",
+		Patient:       FHIRPatientMapping{Age: "Age"},
+		Observations: []FHIRCodeMapping{
+			{System: "http://loinc.org", Code: "6598-7", Column: "Troponin"},
+			{Code: "mi", Column: "Result"},
+		},
+	}
+	raw, labels := loadAll(t, FHIRSource{Path: bundle, Mapping: mapping})
+	if !reflect.DeepEqual(raw, [][]float64{{59, 0.4}, {40, 0.2}}) || !reflect.DeepEqual(labels, []float64{1, 0}) {
+		t.Errorf("each patient should be one record: raw %v labels %v", raw, labels)
+	}
+}
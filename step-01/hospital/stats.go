@@ -0,0 +1,298 @@
+package hospital
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sort"
+	"time"
+)
+
+// NormMethod selects how numeric inputs are scaled.
+type NormMethod string
+
+const (
+	NormMinMax NormMethod = "minmax" // (v - min) / (max - min)
+	NormZScore NormMethod = "zscore" // (v - mean) / std
+)
+
+// FeatureStats are the per-input sufficient statistics a hospital contributes
+// to the federation-wide normalisation. Count, Sum and SumSq add across
+// hospitals; Min and Max combine by min/max.
+type FeatureStats struct {
+	Count []float64 `json:"count"`
+	Sum   []float64 `json:"sum"`
+	SumSq []float64 `json:"sum_sq"`
+	Min   []float64 `json:"min"`
+	Max   []float64 `json:"max"`
+}
+
+// ComputeStats returns the statistics of raw (unnormalised) input rows.
+func ComputeStats(raw [][]float64) FeatureStats {
+	width := 0
+	if len(raw) > 0 {
+		width = len(raw[0])
+	}
+	s := emptyStats(width)
+	for _, row := range raw {
+		for j, v := range row {
+			s.Count[j]++
+			s.Sum[j] += v
+			s.SumSq[j] += v * v
+			s.Min[j] = math.Min(s.Min[j], v)
+			s.Max[j] = math.Max(s.Max[j], v)
+		}
+	}
+	return s
+}
+
+// emptyStats is the identity for AggregateStats.
+func emptyStats(width int) FeatureStats {
+	s := FeatureStats{
+		Count: make([]float64, width),
+		Sum:   make([]float64, width),
+		SumSq: make([]float64, width),
+		Min:   make([]float64, width),
+		Max:   make([]float64, width),
+	}
+	for j := 0; j < width; j++ {
+		s.Min[j], s.Max[j] = math.Inf(1), math.Inf(-1)
+	}
+	return s
+}
+
+// ComputeLocalStats loads records [startIdx, endIdx) of src through schema
+// (including imputation) and returns their statistics without normalising.
+func ComputeLocalStats(src DataSource, startIdx, endIdx int, schema *Schema) (FeatureStats, *LoadReport, error) {
+	raw, _, report, err := loadRaw(src, startIdx, endIdx, schema)
+	if err != nil {
+		return FeatureStats{}, report, err
+	}
+	return ComputeStats(raw), report, nil
+}
+
+// AggregateStats combines statistics from several hospitals. The server runs
+// the same computation over submitted (possibly masked) statistics.
+func AggregateStats(parts ...FeatureStats) (FeatureStats, error) {
+	if len(parts) == 0 {
+		return FeatureStats{}, fmt.Errorf("aggregate stats: no inputs")
+	}
+	width := len(parts[0].Count)
+	total := emptyStats(width)
+	for i, p := range parts {
+		if len(p.Count) != width || len(p.Sum) != width || len(p.SumSq) != width ||
+			len(p.Min) != width || len(p.Max) != width {
+			return FeatureStats{}, fmt.Errorf("aggregate stats: input %d covers a different number of features", i)
+		}
+		for j := 0; j < width; j++ {
+			total.Count[j] += p.Count[j]
+			total.Sum[j] += p.Sum[j]
+			total.SumSq[j] += p.SumSq[j]
+			total.Min[j] = math.Min(total.Min[j], p.Min[j])
+			total.Max[j] = math.Max(total.Max[j], p.Max[j])
+		}
+	}
+	return total, nil
+}
+
+// Normaliser maps raw numeric inputs to model inputs: (v - Offset) / Scale.
+// A zero scale (constant feature) maps every value to 0.
+type Normaliser struct {
+	Method NormMethod `json:"method"`
+	Offset []float64  `json:"offset"`
+	Scale  []float64  `json:"scale"`
+}
+
+// Normaliser derives scaling parameters from the statistics.
+// The z-score uses the population standard deviation sqrt(E[v²] - E[v]²).
+func (s FeatureStats) Normaliser(method NormMethod) Normaliser {
+	n := Normaliser{
+		Method: method,
+		Offset: make([]float64, len(s.Count)),
+		Scale:  make([]float64, len(s.Count)),
+	}
+	for j := range s.Count {
+		if method == NormZScore {
+			if s.Count[j] == 0 {
+				continue
+			}
+			mean := s.Sum[j] / s.Count[j]
+			variance := s.SumSq[j]/s.Count[j] - mean*mean
+			n.Offset[j] = mean
+			n.Scale[j] = math.Sqrt(math.Max(variance, 0))
+			continue
+		}
+		n.Offset[j] = s.Min[j]
+		n.Scale[j] = s.Max[j] - s.Min[j]
+	}
+	return n
+}
+
+// Apply normalises the inputs marked numeric and copies the rest unchanged.
+func (n Normaliser) Apply(row []float64, numeric []bool) []float64 {
+	out := make([]float64, len(row))
+	for j, v := range row {
+		switch {
+		case !numeric[j]:
+			out[j] = v
+		case n.Scale[j] == 0:
+			out[j] = 0
+		default:
+			out[j] = (v - n.Offset[j]) / n.Scale[j]
+		}
+	}
+	return out
+}
+
+// StatsPacket carries one hospital's statistics for the pre-training round.
+// It is signed like UpdatePacket: SHA256( json(packet without signature) + SecretKey ).
+type StatsPacket struct {
+	HospitalID string       `json:"hospital_id"`
+	Stats      FeatureStats `json:"stats"`
+	Masked     bool         `json:"masked"`
+	Timestamp  int64        `json:"timestamp"`
+	Signature  string       `json:"signature,omitempty"`
+}
+
+// NewStatsPacket builds and signs a statistics submission. With the roster's
+// public mask keys (peers, the hospital's own included) the additive
+// statistics are masked (see MaskStats) so the server only ever learns
+// their sum over the whole roster.
+func NewStatsPacket(hospitalID string, stats FeatureStats, key *MaskKey, peers map[string][]byte) (*StatsPacket, error) {
+	p := &StatsPacket{HospitalID: hospitalID, Stats: stats, Timestamp: time.Now().Unix()}
+	if len(peers) > 0 {
+		masked, err := MaskStats(stats, hospitalID, key, peers)
+		if err != nil {
+			return nil, err
+		}
+		p.Stats, p.Masked = masked, true
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("sign stats: %w", err)
+	}
+	hash := sha256.Sum256(append(body, []byte(SecretKey)...))
+	p.Signature = hex.EncodeToString(hash[:])
+	return p, nil
+}
+
+// MaskKey is a hospital's X25519 key pair for the statistics round. Only
+// the public half leaves the hospital, relayed by the server (/mask_keys) to
+// the rest of the roster.
+type MaskKey struct {
+	private *ecdh.PrivateKey
+}
+
+// NewMaskKey draws a fresh key pair; use a new one for every statistics round.
+func NewMaskKey() (*MaskKey, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("mask key: %w", err)
+	}
+	return &MaskKey{private: private}, nil
+}
+
+// PublicKey is the half sent to the other roster members.
+func (k *MaskKey) PublicKey() []byte {
+	return k.private.PublicKey().Bytes()
+}
+
+// pairSeed is the mask seed k shares with the holder of peer: a hash of
+// their Diffie-Hellman secret, which the server relaying the public keys
+// cannot compute.
+func (k *MaskKey) pairSeed(peer []byte, lo, hi string) (int64, error) {
+	public, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return 0, err
+	}
+	secret, err := k.private.ECDH(public)
+	if err != nil {
+		return 0, err
+	}
+	seed := sha256.Sum256(append(secret, []byte("|"+lo+"|"+hi)...))
+	return int64(binary.LittleEndian.Uint64(seed[:8])), nil
+}
+
+// MaskKeyPacket publishes a hospital's public mask key to the roster through
+// POST /mask_keys. It is signed like StatsPacket.
+type MaskKeyPacket struct {
+	HospitalID string `json:"hospital_id"`
+	PublicKey  []byte `json:"public_key"`
+	Timestamp  int64  `json:"timestamp"`
+	Signature  string `json:"signature,omitempty"`
+}
+
+// NewMaskKeyPacket builds and signs the publication of key's public half.
+func NewMaskKeyPacket(hospitalID string, key *MaskKey) (*MaskKeyPacket, error) {
+	p := &MaskKeyPacket{HospitalID: hospitalID, PublicKey: key.PublicKey(), Timestamp: time.Now().Unix()}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("sign mask key: %w", err)
+	}
+	hash := sha256.Sum256(append(body, []byte(SecretKey)...))
+	p.Signature = hex.EncodeToString(hash[:])
+	return p, nil
+}
+
+// MaskStats hides a hospital's Count, Sum and SumSq behind pairwise masks.
+//
+// peers maps every roster member, self included, to its public mask key. For
+// every other member j, both parties derive the same mask vector from the
+// X25519 secret of key and j's public key; the lexically smaller ID adds it
+// and the larger subtracts it. Summed over the full roster every mask
+// cancels, so the aggregate is exact, while a single submission is hidden
+// from anyone without one of the two private keys, the server included.
+// Min and Max cannot be masked additively and are sent in the clear.
+// Aggregation is only correct once every roster member has submitted.
+func MaskStats(stats FeatureStats, self string, key *MaskKey, peers map[string][]byte) (FeatureStats, error) {
+	if key == nil {
+		return FeatureStats{}, fmt.Errorf("mask stats: %s has no mask key", self)
+	}
+	if own, ok := peers[self]; !ok || string(own) != string(key.PublicKey()) {
+		return FeatureStats{}, fmt.Errorf("mask stats: the roster's key for %s is not its own", self)
+	}
+	members := make([]string, 0, len(peers))
+	for id := range peers {
+		members = append(members, id)
+	}
+	sort.Strings(members)
+
+	out := FeatureStats{
+		Count: append([]float64(nil), stats.Count...),
+		Sum:   append([]float64(nil), stats.Sum...),
+		SumSq: append([]float64(nil), stats.SumSq...),
+		Min:   stats.Min,
+		Max:   stats.Max,
+	}
+	for _, other := range members {
+		if other == self {
+			continue
+		}
+		sign := 1.0
+		lo, hi := self, other
+		if other < self {
+			sign, lo, hi = -1.0, other, self
+		}
+		seed, err := key.pairSeed(peers[other], lo, hi)
+		if err != nil {
+			return FeatureStats{}, fmt.Errorf("mask stats: key of %s: %w", other, err)
+		}
+		rng := mathrand.New(mathrand.NewSource(seed))
+		for _, vec := range [][]float64{out.Count, out.Sum, out.SumSq} {
+			for j := range vec {
+				vec[j] += sign * (rng.Float64()*2 - 1) * maskScale
+			}
+		}
+	}
+	return out, nil
+}
+
+// maskScale bounds the pairwise masks. Large enough to hide clinical values,
+// small enough that float64 cancellation error stays far below 1e-6.
+const maskScale = 1e6
@@ -1,10 +1,41 @@
 package hospital
 
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+)
+
 // TrainConfig holds local training hyperparameters.
+// Zero values for the optional fields reproduce plain constant-rate SGD
+// over the samples in file order.
 type TrainConfig struct {
-	Epochs       int
-	LearningRate float64
-	BatchSize    int
+	Epochs       int     `json:"epochs"`
+	LearningRate float64 `json:"learning_rate"`
+	BatchSize    int     `json:"batch_size"`
+
+	// Shuffle permutes the sample order at the start of every epoch.
+	// The permutation is drawn from Seed, so identical configs give identical models.
+	Shuffle bool  `json:"shuffle"`
+	Seed    int64 `json:"seed"`
+
+	Schedule LRSchedule `json:"schedule"`
+
+	Optimizer OptimizerType `json:"optimizer,omitempty"` // "" behaves as OptimizerSGD
+	Momentum  float64       `json:"momentum,omitempty"`  // momentum β, or Adam β1 (default 0.9)
+	Beta2     float64       `json:"beta2,omitempty"`     // Adam β2 (default 0.999)
+	Epsilon   float64       `json:"epsilon,omitempty"`   // Adam ε (default 1e-8)
+
+	// L1 and L2 penalise the weights (not the bias) during training.
+	// The reported loss remains the unregularised BCE.
+	L1 float64 `json:"l1,omitempty"`
+	L2 float64 `json:"l2,omitempty"`
+
+	// ProxMu enables FedProx when > 0: the local objective gains
+	// mu/2 · ||w - w_global||², pulling the local model back towards the
+	// global model it started from. The server distributes mu each round.
+	ProxMu float64 `json:"prox_mu,omitempty"`
 }
 
 func DefaultTrainConfig() TrainConfig {
@@ -12,51 +43,126 @@ func DefaultTrainConfig() TrainConfig {
 		Epochs:       50,
 		LearningRate: 0.05,
 		BatchSize:    32,
+		Seed:         42,
+	}
+}
+
+// WithDefaults returns cfg with DefaultTrainConfig filled in: entirely when
+// cfg is the zero value, otherwise only for an unset epoch count, learning
+// rate or batch size.
+func (cfg TrainConfig) WithDefaults() TrainConfig {
+	def := DefaultTrainConfig()
+	if cfg == (TrainConfig{}) {
+		return def
+	}
+	if cfg.Epochs == 0 {
+		cfg.Epochs = def.Epochs
+	}
+	if cfg.LearningRate == 0 {
+		cfg.LearningRate = def.LearningRate
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	return cfg
+}
+
+// Validate reports an unknown optimizer or schedule and out-of-range settings.
+func (cfg TrainConfig) Validate() error {
+	switch cfg.Optimizer {
+	case "", OptimizerSGD, OptimizerMomentum, OptimizerAdam:
+	default:
+		return fmt.Errorf("train: optimizer %q must be sgd, momentum or adam", cfg.Optimizer)
+	}
+	switch cfg.Schedule.Type {
+	case "", ScheduleConstant, ScheduleStep, ScheduleExponential, ScheduleCosine:
+	default:
+		return fmt.Errorf("train: schedule %q must be constant, step, exponential or cosine", cfg.Schedule.Type)
 	}
+	switch {
+	case cfg.Epochs < 0 || cfg.BatchSize < 0:
+		return fmt.Errorf("train: epochs and batch_size must not be negative")
+	case cfg.LearningRate < 0 || cfg.L1 < 0 || cfg.L2 < 0 || cfg.ProxMu < 0:
+		return fmt.Errorf("train: learning_rate, l1, l2 and prox_mu must not be negative")
+	}
+	return nil
+}
+
+// LoadTrainConfig reads a JSON TrainConfig. Unset fields take their defaults
+// (see WithDefaults).
+func LoadTrainConfig(path string) (TrainConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return TrainConfig{}, fmt.Errorf("read train config: %w", err)
+	}
+	var cfg TrainConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return TrainConfig{}, fmt.Errorf("parse train config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return TrainConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg.WithDefaults(), nil
 }
 
-// TrainLocalModel runs mini-batch SGD and returns the trained model and final BCE loss.
-// Gradients: dL/dw_i = (p-y)·x_i, dL/db = (p-y).
-// The global model is never mutated — training operates on a deep copy.
+// TrainLocalModel runs mini-batch gradient descent on a logistic model and
+// returns the trained model and final BCE loss. It is TrainClassifier
+// specialised to *Model so existing callers keep a concrete type.
 func TrainLocalModel(model *Model, data []Sample, cfg TrainConfig) (*Model, float64) {
-	// Deep copy so the original global model is unchanged.
-	trained := &Model{
-		Weights: make([]float64, len(model.Weights)),
-		Bias:    model.Bias,
+	trained, loss := TrainClassifier(model, data, cfg)
+	return trained.(*Model), loss
+}
+
+// TrainClassifier runs mini-batch gradient descent on any Classifier and returns
+// the trained model and its final (unregularised) loss.
+// Each step uses the model's own gradient plus any L1/L2 and FedProx penalty terms.
+// The global model is never mutated — training operates on a flat copy of its parameters.
+func TrainClassifier(model Classifier, data []Sample, cfg TrainConfig) (Classifier, float64) {
+	spec := model.Spec()
+	params := model.Flatten()
+	global := model.Flatten()
+	mask := spec.PenaltyMask()
+	opt := newOptimizer(cfg, len(params))
+
+	// Work on a private copy so the caller's model keeps its weights.
+	current, err := ClassifierFromWeights(spec, params)
+	if err != nil {
+		panic(fmt.Sprintf("train: %v", err)) // spec and params come from the same model
 	}
-	copy(trained.Weights, model.Weights)
 
 	n := len(data)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	grad := make([]float64, len(params))
+	batch := make([]Sample, 0, cfg.BatchSize)
 
 	for epoch := 0; epoch < cfg.Epochs; epoch++ {
+		if cfg.Shuffle {
+			rng.Shuffle(n, func(i, j int) { order[i], order[j] = order[j], order[i] })
+		}
+		lr := cfg.Schedule.Rate(cfg.LearningRate, epoch, cfg.Epochs)
+
 		for start := 0; start < n; start += cfg.BatchSize {
 			end := start + cfg.BatchSize
 			if end > n {
 				end = n
 			}
-			batch := data[start:end]
-
-			dw := make([]float64, len(trained.Weights))
-			db := 0.0
-
-			for _, s := range batch {
-				p := trained.Forward(s.Features)
-				err := p - s.Label
-
-				for i, xi := range s.Features {
-					dw[i] += err * xi
-				}
-				db += err
+			batch = batch[:0]
+			for _, idx := range order[start:end] {
+				batch = append(batch, data[idx])
 			}
 
-			batchLen := float64(len(batch))
-			for i := range trained.Weights {
-				trained.Weights[i] -= cfg.LearningRate * (dw[i] / batchLen)
-			}
-			trained.Bias -= cfg.LearningRate * (db / batchLen)
+			current.Unflatten(params)
+			current.Gradient(batch, grad)
+			regularise(grad, params, mask, cfg.L1, cfg.L2)
+			proximal(grad, params, global, cfg.ProxMu)
+			opt.step(params, grad, lr)
 		}
 	}
 
-	finalLoss := trained.BinaryCrossEntropyLoss(data)
-	return trained, finalLoss
+	current.Unflatten(params)
+	return current, current.Loss(data)
 }
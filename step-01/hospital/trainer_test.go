@@ -0,0 +1,180 @@
+package hospital
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// syntheticSamples returns a linearly separable-ish dataset drawn from a fixed seed.
+func syntheticSamples(n int) []Sample {
+	rng := rand.New(rand.NewSource(7))
+	data := make([]Sample, n)
+	for i := range data {
+		x := make([]float64, InputSize)
+		z := 0.0
+		for j := range x {
+			x[j] = rng.Float64()
+			if j%2 == 0 {
+				z += x[j]
+			} else {
+				z -= x[j]
+			}
+		}
+		label := 0.0
+		if z > 0 {
+			label = 1.0
+		}
+		data[i] = Sample{Features: x, Label: label}
+	}
+	return data
+}
+
+func TestTrainLocalModelDeterministicWithSeed(t *testing.T) {
+	data := syntheticSamples(200)
+	configs := map[string]TrainConfig{
+		"sgd+step":      {Epochs: 10, LearningRate: 0.1, BatchSize: 16, Shuffle: true, Seed: 3, Schedule: LRSchedule{Type: ScheduleStep, StepSize: 3, Gamma: 0.5}},
+		"momentum+cos":  {Epochs: 10, LearningRate: 0.1, BatchSize: 16, Shuffle: true, Seed: 3, Optimizer: OptimizerMomentum, Schedule: LRSchedule{Type: ScheduleCosine, MinLR: 0.001}},
+		"adam+exp+l1l2": {Epochs: 10, LearningRate: 0.01, BatchSize: 16, Shuffle: true, Seed: 3, Optimizer: OptimizerAdam, Schedule: LRSchedule{Type: ScheduleExponential, Gamma: 0.9}, L1: 1e-3, L2: 1e-2},
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			m1, l1 := TrainLocalModel(NewModel(), data, cfg)
+			m2, l2 := TrainLocalModel(NewModel(), data, cfg)
+			if l1 != l2 {
+				t.Fatalf("loss differs across identical runs: %v vs %v", l1, l2)
+			}
+			for i, w := range m1.FlatWeights() {
+				if w != m2.FlatWeights()[i] {
+					t.Fatalf("weight %d differs across identical runs", i)
+				}
+			}
+			if l1 >= NewModel().BinaryCrossEntropyLoss(data) {
+				t.Errorf("training did not reduce loss (final %f)", l1)
+			}
+		})
+	}
+}
+
+func TestShuffleSeedChangesOrder(t *testing.T) {
+	data := syntheticSamples(200)
+	cfg := TrainConfig{Epochs: 3, LearningRate: 0.1, BatchSize: 16, Shuffle: true, Seed: 1}
+	_, a := TrainLocalModel(NewModel(), data, cfg)
+	cfg.Seed = 2
+	_, b := TrainLocalModel(NewModel(), data, cfg)
+	if a == b {
+		t.Errorf("different seeds produced identical loss %f", a)
+	}
+}
+
+func TestFedProxKeepsModelNearGlobal(t *testing.T) {
+	data := syntheticSamples(200)
+	global := NewModel()
+	distance := func(m *Model) float64 {
+		d := 0.0
+		g := global.FlatWeights()
+		for i, w := range m.FlatWeights() {
+			d += (w - g[i]) * (w - g[i])
+		}
+		return math.Sqrt(d)
+	}
+
+	cfg := TrainConfig{Epochs: 20, LearningRate: 0.1, BatchSize: 16}
+	free, _ := TrainLocalModel(global, data, cfg)
+	cfg.ProxMu = 5.0
+	prox, _ := TrainLocalModel(global, data, cfg)
+
+	if distance(prox) >= distance(free) {
+		t.Errorf("FedProx model drifted further (%f) than unconstrained model (%f)", distance(prox), distance(free))
+	}
+}
+
+func TestLRScheduleRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        LRSchedule
+		epoch    int
+		expected float64
+	}{
+		{"constant", LRSchedule{}, 7, 0.1},
+		{"step", LRSchedule{Type: ScheduleStep, StepSize: 5, Gamma: 0.5}, 12, 0.025},
+		{"exponential", LRSchedule{Type: ScheduleExponential, Gamma: 0.5}, 2, 0.025},
+		{"cosine start", LRSchedule{Type: ScheduleCosine, MinLR: 0.01}, 0, 0.1},
+		{"cosine end", LRSchedule{Type: ScheduleCosine, MinLR: 0.01}, 10, 0.01},
+		// Progress is e/(E-1): epoch 5 of 11 is halfway.
+		{"cosine middle", LRSchedule{Type: ScheduleCosine, MinLR: 0.01}, 5, 0.055},
+		// An unset gamma decays by the default rather than to zero.
+		{"step default gamma", LRSchedule{Type: ScheduleStep, StepSize: 5}, 12, 0.001},
+		{"exponential default gamma", LRSchedule{Type: ScheduleExponential}, 2, 0.081},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.s.Rate(0.1, tt.epoch, 11)
+			if math.Abs(got-tt.expected) > 1e-12 {
+				t.Errorf("expected %f, got %f", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHospitalTrainConfigReachesPacket(t *testing.T) {
+	data := syntheticSamples(100)
+	build := func(train TrainConfig) []float64 {
+		t.Helper()
+		p, err := BuildUpdatePacket(NewModel(), HospitalConfig{ID: "H1", Train: train}, data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Weights
+	}
+	same := func(a, b []float64) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	def := build(TrainConfig{})
+	if !same(def, build(DefaultTrainConfig())) {
+		t.Error("a zero Train should train with DefaultTrainConfig")
+	}
+	if same(def, build(TrainConfig{Optimizer: OptimizerAdam})) {
+		t.Error("Train.Optimizer did not change the packet")
+	}
+	if same(def, build(TrainConfig{Schedule: LRSchedule{Type: ScheduleCosine}})) {
+		t.Error("Train.Schedule did not change the packet")
+	}
+	if _, err := BuildUpdatePacket(NewModel(), HospitalConfig{ID: "H1", Train: TrainConfig{Optimizer: "rmsprop"}}, data, nil); err == nil {
+		t.Error("an unknown optimizer was accepted")
+	}
+}
+
+func TestServerProxMuReplacesTheLocalOne(t *testing.T) {
+	data := syntheticSamples(100)
+	local := DefaultTrainConfig()
+	local.ProxMu = 5
+	build := func(mu *float64) []float64 {
+		t.Helper()
+		p, err := BuildUpdatePacket(NewModel(), HospitalConfig{ID: "H1", Train: local, ProxMu: mu}, data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Weights
+	}
+	free, err := BuildUpdatePacket(NewModel(), HospitalConfig{ID: "H1"}, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	off := 0.0
+	if !reflect.DeepEqual(build(&off), free.Weights) {
+		t.Error("a distributed mu of 0 did not switch FedProx off")
+	}
+	if reflect.DeepEqual(build(nil), free.Weights) {
+		t.Error("without a distributed mu the local Train.ProxMu was ignored")
+	}
+}
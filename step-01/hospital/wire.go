@@ -0,0 +1,307 @@
+package hospital
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// The binary wire format carries update packets and global models without
+// spelling every weight out in decimal. A message is a 20-byte header
+// followed by its body, all little-endian:
+//
+//	magic     [4]byte  "FLW\x00"
+//	schema    uint16   WireSchema, the layout of the body
+//	kind      uint8    WireKindPacket or WireKindModel
+//	precision uint8    bits per weight: 32 or 64
+//	version   uint32   model version the message is for
+//	length    uint32   body length in bytes
+//	checksum  uint32   CRC-32 (IEEE) of the body
+//
+// Byte strings in the body are a uint32 length and the bytes; weight vectors
+// are a uint32 count and the floats. A packet's body is its metadata JSON,
+// kept verbatim because the signature covers it, the signature, the weights
+// and, when present, the compressed delta. A model's body is a JSON object of
+// everything but the weights, then the weights.
+
+// WireContentType is the media type of the binary wire format. Its
+// "precision" parameter asks for 32- or 64-bit weights.
+const WireContentType = "application/x-fl-binary"
+
+// WireSchema is the body layout this package reads and writes.
+const WireSchema = 1
+
+// Message kinds (the header's kind byte).
+const (
+	WireKindPacket = 1
+	WireKindModel  = 2
+)
+
+const wireHeaderSize = 20
+
+var wireMagic = [4]byte{'F', 'L', 'W', 0}
+
+// WirePacket is an update packet as the wire format carries it. Metadata is
+// the metadata JSON exactly as signed.
+type WirePacket struct {
+	Version   int
+	Metadata  json.RawMessage
+	Signature string
+	Weights   []float64
+	Delta     *EncodedDelta
+}
+
+// WireModel is a global model as the wire format carries it. Info is a JSON
+// object of the model's other fields (spec, normalisation, ...).
+type WireModel struct {
+	Version int
+	Info    json.RawMessage
+	Weights []float64
+}
+
+// Encode writes p in the wire format with weights of precision bits;
+// 32 halves the size at the cost of float32 rounding.
+func (p WirePacket) Encode(precision int) ([]byte, error) {
+	var body wireWriter
+	body.bytes(p.Metadata)
+	body.bytes([]byte(p.Signature))
+	body.floats(p.Weights, precision)
+	if p.Delta == nil {
+		body.u8(0)
+	} else {
+		body.u8(1)
+		body.u32(uint32(p.Delta.Length))
+		body.f64(p.Delta.Min)
+		body.f64(p.Delta.Step)
+		body.bytes(p.Delta.Indices)
+		body.bytes(p.Delta.Data)
+	}
+	return wireMessage(WireKindPacket, precision, p.Version, body.buf.Bytes())
+}
+
+// DecodeWirePacket reads a packet written by WirePacket.Encode.
+func DecodeWirePacket(b []byte) (WirePacket, error) {
+	var p WirePacket
+	body, precision, version, err := wireBody(b, WireKindPacket)
+	if err != nil {
+		return p, err
+	}
+	r := wireReader{b: body, precision: precision}
+	p.Version = version
+	p.Metadata = r.bytes()
+	p.Signature = string(r.bytes())
+	p.Weights = r.floats()
+	if r.u8() == 1 {
+		p.Delta = &EncodedDelta{Length: int(r.u32()), Min: r.f64(), Step: r.f64()}
+		p.Delta.Indices = r.bytes()
+		p.Delta.Data = r.bytes()
+	}
+	if err := r.done(); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Encode writes m in the wire format with weights of precision bits.
+func (m WireModel) Encode(precision int) ([]byte, error) {
+	var body wireWriter
+	body.bytes(m.Info)
+	body.floats(m.Weights, precision)
+	return wireMessage(WireKindModel, precision, m.Version, body.buf.Bytes())
+}
+
+// DecodeWireModel reads a model written by WireModel.Encode.
+func DecodeWireModel(b []byte) (WireModel, error) {
+	var m WireModel
+	body, precision, version, err := wireBody(b, WireKindModel)
+	if err != nil {
+		return m, err
+	}
+	r := wireReader{b: body, precision: precision}
+	m.Version = version
+	m.Info = r.bytes()
+	m.Weights = r.floats()
+	if err := r.done(); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// WirePacket is p in the wire format's terms.
+func (p *UpdatePacket) WirePacket() (WirePacket, error) {
+	meta, err := json.Marshal(p.Metadata)
+	if err != nil {
+		return WirePacket{}, fmt.Errorf("wire: marshal metadata: %w", err)
+	}
+	return WirePacket{
+		Version:   p.Metadata.ModelVersion,
+		Metadata:  meta,
+		Signature: p.Signature,
+		Weights:   p.Weights,
+		Delta:     p.Delta,
+	}, nil
+}
+
+// wireMessage prefixes body with its header.
+func wireMessage(kind, precision, version int, body []byte) ([]byte, error) {
+	if precision != 32 && precision != 64 {
+		return nil, fmt.Errorf("wire: precision must be 32 or 64, got %d", precision)
+	}
+	if version < 0 || int64(version) > math.MaxUint32 {
+		return nil, fmt.Errorf("wire: model version %d does not fit the header", version)
+	}
+	out := make([]byte, wireHeaderSize, wireHeaderSize+len(body))
+	copy(out, wireMagic[:])
+	binary.LittleEndian.PutUint16(out[4:], WireSchema)
+	out[6] = byte(kind)
+	out[7] = byte(precision)
+	binary.LittleEndian.PutUint32(out[8:], uint32(version))
+	binary.LittleEndian.PutUint32(out[12:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(out[16:], crc32.ChecksumIEEE(body))
+	return append(out, body...), nil
+}
+
+// wireBody checks a message's header and checksum and returns its body.
+func wireBody(b []byte, kind int) (body []byte, precision, version int, err error) {
+	if len(b) < wireHeaderSize || !bytes.Equal(b[:4], wireMagic[:]) {
+		return nil, 0, 0, fmt.Errorf("wire: not a binary message")
+	}
+	if schema := binary.LittleEndian.Uint16(b[4:]); schema != WireSchema {
+		return nil, 0, 0, fmt.Errorf("wire: schema %d is not supported (want %d)", schema, WireSchema)
+	}
+	if int(b[6]) != kind {
+		return nil, 0, 0, fmt.Errorf("wire: message kind %d, want %d", b[6], kind)
+	}
+	precision = int(b[7])
+	if precision != 32 && precision != 64 {
+		return nil, 0, 0, fmt.Errorf("wire: precision %d is not 32 or 64", precision)
+	}
+	version = int(binary.LittleEndian.Uint32(b[8:]))
+	body = b[wireHeaderSize:]
+	if n := binary.LittleEndian.Uint32(b[12:]); int64(n) != int64(len(body)) {
+		return nil, 0, 0, fmt.Errorf("wire: body is %d bytes, header says %d", len(body), n)
+	}
+	if sum := crc32.ChecksumIEEE(body); sum != binary.LittleEndian.Uint32(b[16:]) {
+		return nil, 0, 0, fmt.Errorf("wire: checksum mismatch")
+	}
+	return body, precision, version, nil
+}
+
+type wireWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *wireWriter) u8(v uint8) { w.buf.WriteByte(v) }
+
+func (w *wireWriter) u32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *wireWriter) f64(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	w.buf.Write(b[:])
+}
+
+func (w *wireWriter) bytes(b []byte) {
+	w.u32(uint32(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *wireWriter) floats(v []float64, precision int) {
+	w.u32(uint32(len(v)))
+	for _, x := range v {
+		if precision == 32 {
+			w.u32(math.Float32bits(float32(x)))
+		} else {
+			w.f64(x)
+		}
+	}
+}
+
+// wireReader reads a body, remembering the first short read so callers can
+// check once at the end.
+type wireReader struct {
+	b         []byte
+	precision int
+	err       error
+}
+
+func (r *wireReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *wireReader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *wireReader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *wireReader) f64() float64 {
+	if b := r.take(8); b != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *wireReader) bytes() []byte {
+	b := r.take(int(r.u32()))
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (r *wireReader) floats() []float64 {
+	n := int(r.u32())
+	size := r.precision / 8
+	if r.err == nil && n > len(r.b)/size {
+		r.err = io.ErrUnexpectedEOF
+	}
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	v := make([]float64, n)
+	for i := range v {
+		if r.precision == 32 {
+			v[i] = float64(math.Float32frombits(r.u32()))
+		} else {
+			v[i] = r.f64()
+		}
+	}
+	return v
+}
+
+// done reports a truncated body or bytes left over after the last field.
+func (r *wireReader) done() error {
+	if r.err != nil {
+		return fmt.Errorf("wire: truncated body: %w", r.err)
+	}
+	if len(r.b) > 0 {
+		return fmt.Errorf("wire: %d unexpected bytes after the body", len(r.b))
+	}
+	return nil
+}
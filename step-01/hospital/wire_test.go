@@ -0,0 +1,102 @@
+package hospital
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWirePacketRoundTrips(t *testing.T) {
+	spec := ModelSpec{Type: ModelMLP, InputSize: InputSize, Hidden: []int{16}}
+	global, _ := NewClassifier(spec)
+	packet, err := BuildUpdatePacket(global, HospitalConfig{ID: "H1", RoundID: 3, ModelVersion: 3}, syntheticSamples(50), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp, err := packet.WirePacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asJSON, _ := json.Marshal(packet)
+
+	exact, err := wp.Encode(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeWirePacket(exact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wp) || got.Version != 3 {
+		t.Fatalf("float64 round trip changed the packet:\n%+v\n%+v", got, wp)
+	}
+	if len(exact) >= len(asJSON) {
+		t.Errorf("binary packet is %d bytes, JSON %d", len(exact), len(asJSON))
+	}
+
+	half, _ := wp.Encode(32)
+	got, err = DecodeWirePacket(half)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(wp.Weights); len(exact)-len(half) != 4*n {
+		t.Errorf("float32 saves %d bytes, want %d", len(exact)-len(half), 4*n)
+	}
+	for i, w := range wp.Weights {
+		if got.Weights[i] != float64(float32(w)) {
+			t.Fatalf("weight %d: %g, want float32 %g", i, got.Weights[i], float32(w))
+		}
+	}
+
+	// A compressed delta travels as its raw bytes.
+	compressed, _ := BuildUpdatePacket(global, HospitalConfig{ID: "H1", RoundID: 3, ModelVersion: 3, Compression: &Compression{Codec: CodecTopK}}, syntheticSamples(50), nil)
+	wp, _ = compressed.WirePacket()
+	b, _ := wp.Encode(64)
+	if got, err = DecodeWirePacket(b); err != nil || !reflect.DeepEqual(got.Delta, compressed.Delta) || got.Weights != nil {
+		t.Errorf("delta round trip: %v %+v", err, got.Delta)
+	}
+}
+
+func TestWireModelRoundTripsAndRejectsDamage(t *testing.T) {
+	m := WireModel{Version: 7, Info: json.RawMessage(`{"prox_mu":0.01}`), Weights: []float64{0.1, -2.5e-9, math.Pi, 0}}
+	b, err := m.Encode(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeWireModel(b)
+	if err != nil || !reflect.DeepEqual(got, m) {
+		t.Fatalf("round trip: %v %+v", err, got)
+	}
+
+	flipped := append([]byte(nil), b...)
+	flipped[len(flipped)-1] ^= 1
+	truncated := append([]byte(nil), b[:len(b)-8]...)
+	for name, tc := range map[string]struct {
+		b    []byte
+		want string
+	}{
+		"checksum":      {flipped, "checksum"},
+		"truncated":     {truncated, "header says"},
+		"json":          {[]byte(`{"weights":[1]}`), "not a binary message"},
+		"packet kind":   {mustEncode(t, WirePacket{Version: 1}), "kind"},
+		"future layout": {func() []byte { c := append([]byte(nil), b...); c[4] = 2; return c }(), "schema 2"},
+	} {
+		if _, err := DecodeWireModel(tc.b); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", name, err, tc.want)
+		}
+	}
+	if _, err := m.Encode(16); err == nil {
+		t.Error("16-bit precision accepted")
+	}
+}
+
+func mustEncode(t *testing.T, p WirePacket) []byte {
+	t.Helper()
+	b, err := p.Encode(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
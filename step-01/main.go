@@ -1,8 +1,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
 	"step01/hospital"
 )
@@ -12,26 +16,137 @@ import (
 const csvPath = "../Medicaldataset.csv"
 
 func main() {
+	modelFlag := flag.String("model", hospital.ModelLogistic, "Model type: logistic or mlp")
+	hiddenFlag := flag.String("hidden", "16,8", "Comma-separated hidden layer sizes (mlp only)")
+	activationFlag := flag.String("activation", "relu", "Hidden activation: relu, tanh or sigmoid (mlp only)")
+	schemaFlag := flag.String("schema", "", "JSON dataset schema (default: built-in Medicaldataset.csv layout)")
+	shareQuality := flag.Bool("share-quality", false, "Attach the aggregate-only data-quality summary to each packet")
+	normFlag := flag.String("norm", "local", "Normalisation: local (per-partition min-max), minmax or zscore (federation-wide)")
+	formatFlag := flag.String("format", "csv", "Data format: csv, jsonl, columnar or fhir")
+	dataFlag := flag.String("data", csvPath, "Hospital export to read, in -format")
+	fhirMapping := flag.String("fhir-mapping", "", "FHIR code-to-column mapping file (fhir format only)")
+	federation := flag.String("federation", "", "Federation the packets are signed for (default: the server's default federation)")
+	partitions := flag.String("partitions", "", "Directory written by ./cmd/partition; one hospital per partition file (overrides -data)")
+	compressFlag := flag.String("compress", "", "Send each update as a delta compressed with float32, q8, q4 or topk (default: full weights)")
+	topKFlag := flag.Float64("top-k", 0, "With -compress topk: fraction of coordinates sent (default 0.1)")
+	trainFlag := flag.String("train", "", "JSON local training config: epochs, learning_rate, batch_size, shuffle, seed, schedule, optimizer, l1, l2 (default: 50 epochs of SGD)")
+	epochsFlag := flag.Int("epochs", 0, "Local epochs (overrides -train)")
+	lrFlag := flag.Float64("lr", 0, "Learning rate (overrides -train)")
+	shuffleFlag := flag.Bool("shuffle", false, "Shuffle the samples every epoch (overrides -train)")
+	optimizerFlag := flag.String("optimizer", "", "Optimizer: sgd, momentum or adam (overrides -train)")
+	scheduleFlag := flag.String("schedule", "", "Learning-rate schedule: constant, step, exponential or cosine (overrides -train)")
+	flag.Parse()
+
+	train, err := trainConfig(*trainFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "epochs":
+			train.Epochs = *epochsFlag
+		case "lr":
+			train.LearningRate = *lrFlag
+		case "shuffle":
+			train.Shuffle = *shuffleFlag
+		case "optimizer":
+			train.Optimizer = hospital.OptimizerType(*optimizerFlag)
+		case "schedule":
+			train.Schedule.Type = hospital.ScheduleType(*scheduleFlag)
+		}
+	})
+	if err := train.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	var compression *hospital.Compression
+	if *compressFlag != "" {
+		compression = &hospital.Compression{Codec: *compressFlag, TopK: *topKFlag}
+		if err := compression.Validate(); err != nil {
+			log.Fatalf("-compress: %v", err)
+		}
+	}
+
+	source, err := hospital.OpenSource(*formatFlag, *dataFlag, *fhirMapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	schema := hospital.DefaultSchema()
+	if *schemaFlag != "" {
+		if schema, err = hospital.LoadSchema(*schemaFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	fmt.Println("=== Federated Hospital Learning System — Step 01 ===")
-	fmt.Println("Dataset: Medicaldataset.csv | Hospitals: 3 | Round: 0")
-	fmt.Println()
+	// 1320 records split into three equal partitions of 440 records each,
+	// unless a partition directory supplies its own (possibly skewed) split.
+	// Each hospital trains only on its own partition — no data is shared.
+	hospitals := []hospital.HospitalConfig{
+		{ID: "H1", RoundID: 0, ModelVersion: 0, Source: source, StartIdx: 0, EndIdx: 440},
+		{ID: "H2", RoundID: 0, ModelVersion: 0, Source: source, StartIdx: 440, EndIdx: 880},
+		{ID: "H3", RoundID: 0, ModelVersion: 0, Source: source, StartIdx: 880, EndIdx: 1320},
+	}
+	if *partitions != "" {
+		report, err := hospital.LoadPartitionReport(*partitions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hospitals = report.HospitalConfigs(*partitions)
+		fmt.Printf("Partitions: %s (%s, label skew %.3f)\n", *partitions, report.Mode, report.LabelSkew)
+	} else {
+		fmt.Printf("Dataset: %v\n", source)
+	}
+	for i := range hospitals {
+		hospitals[i].Schema = schema
+		hospitals[i].ShareQuality = *shareQuality
+		hospitals[i].FederationID = *federation
+		hospitals[i].Compression = compression
+		hospitals[i].Train = train
+	}
+	fmt.Printf("Hospitals: %d | Round: 0\n\n", len(hospitals))
 
 	// All hospitals start from the same global model weights.
 	// In later steps the server distributes this; here we construct it once.
-	globalModel := hospital.NewModel()
+	spec := schema.ModelSpec(*modelFlag)
+	if spec.Type == hospital.ModelMLP {
+		hidden, err := parseHidden(*hiddenFlag)
+		if err != nil {
+			log.Fatalf("-hidden: %v", err)
+		}
+		spec.Hidden = hidden
+		spec.Activations = []hospital.Activation{hospital.Activation(*activationFlag)}
+	}
+	globalModel, err := hospital.NewClassifier(spec)
+	if err != nil {
+		log.Fatalf("build model: %v", err)
+	}
+	fmt.Printf("Model: %s | parameters: %d\n\n", spec.Type, spec.NumParams())
 
-	// 1320 rows split into three equal partitions of 440 rows each.
-	// Each hospital trains only on its own partition — no data is shared.
-	hospitals := []hospital.HospitalConfig{
-		{ID: "H1", RoundID: 0, ModelVersion: 0, CSVPath: csvPath, StartIdx: 0, EndIdx: 440},
-		{ID: "H2", RoundID: 0, ModelVersion: 0, CSVPath: csvPath, StartIdx: 440, EndIdx: 880},
-		{ID: "H3", RoundID: 0, ModelVersion: 0, CSVPath: csvPath, StartIdx: 880, EndIdx: 1320},
+	if *normFlag != "local" {
+		norm, err := federatedNormaliser(hospitals, hospital.NormMethod(*normFlag))
+		if err != nil {
+			log.Fatalf("statistics round: %v", err)
+		}
+		for i := range hospitals {
+			hospitals[i].Normaliser = norm
+		}
 	}
 
 	for _, cfg := range hospitals {
 		fmt.Printf("--- Hospital %s (rows %d–%d) ---\n", cfg.ID, cfg.StartIdx, cfg.EndIdx-1)
 
-		packet, err := hospital.GenerateUpdatePacket(globalModel, cfg)
+		data, report, err := hospital.LoadHospitalData(cfg)
+		if err != nil {
+			log.Fatalf("hospital %s: %v", cfg.ID, err)
+		}
+		report.Print(os.Stdout)
+		for _, rowErr := range report.Errors {
+			fmt.Printf("  skipped %v\n", rowErr)
+		}
+
+		packet, err := hospital.BuildUpdatePacket(globalModel, cfg, data, report)
 		if err != nil {
 			log.Fatalf("hospital %s: %v", cfg.ID, err)
 		}
@@ -45,6 +160,69 @@ func main() {
 		fmt.Println()
 	}
 
-	fmt.Println("=== Checkpoint passed: 3 hospitals produced update packets ===")
-	fmt.Println("Confirm that 'loss' values differ across H1, H2, H3.")
+	fmt.Printf("=== Checkpoint passed: %d hospitals produced update packets ===\n", len(hospitals))
+	fmt.Println("Confirm that 'loss' values differ across hospitals.")
+}
+
+// federatedNormaliser runs the pre-training statistics round locally: every
+// hospital publishes a mask key, masks its statistics against its peers' keys,
+// and the sum — which is all the server would ever see — yields the global
+// normalisation parameters.
+func federatedNormaliser(hospitals []hospital.HospitalConfig, method hospital.NormMethod) (*hospital.Normaliser, error) {
+	keys := make(map[string]*hospital.MaskKey)
+	peers := make(map[string][]byte)
+	for _, cfg := range hospitals {
+		key, err := hospital.NewMaskKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[cfg.ID], peers[cfg.ID] = key, key.PublicKey()
+	}
+
+	var parts []hospital.FeatureStats
+	for _, cfg := range hospitals {
+		stats, _, err := hospital.ComputeLocalStats(cfg.DataSource(), cfg.StartIdx, cfg.EndIdx, cfg.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("hospital %s: %w", cfg.ID, err)
+		}
+		packet, err := hospital.NewStatsPacket(cfg.ID, stats, keys[cfg.ID], peers)
+		if err != nil {
+			return nil, fmt.Errorf("hospital %s: %w", cfg.ID, err)
+		}
+		parts = append(parts, packet.Stats)
+	}
+
+	total, err := hospital.AggregateStats(parts...)
+	if err != nil {
+		return nil, err
+	}
+	norm := total.Normaliser(method)
+	fmt.Printf("Global %s normalisation from %d hospitals (offset %.3g, scale %.3g)\n\n",
+		method, len(parts), norm.Offset, norm.Scale)
+	return &norm, nil
+}
+
+// trainConfig loads the -train file, or returns the defaults without one.
+func trainConfig(path string) (hospital.TrainConfig, error) {
+	if path == "" {
+		return hospital.DefaultTrainConfig(), nil
+	}
+	return hospital.LoadTrainConfig(path)
+}
+
+// parseHidden turns "16,8" into []int{16, 8}.
+func parseHidden(s string) ([]int, error) {
+	var sizes []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid layer size %q", part)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
 }